@@ -0,0 +1,90 @@
+package main
+
+// Accessible stations.
+//
+// ada=true on /api/departures/nearest (and nearest-multi) only considers stations a
+// wheelchair user can get into: those the MTA Stations.csv marks ADA accessible (its ADA
+// column, 1 for fully and 2 for partially accessible, with ADA Northbound and ADA
+// Southbound saying which platforms a partially accessible station reaches). A partially
+// accessible station counts when the platform for the requested direction is accessible,
+// or both are when no direction is given.
+//
+// There is no separate elevator status feed here, so live outages come from the alerts
+// feed (alerts_feed_url): a station with an active ACCESSIBILITY_ISSUE alert, which is how
+// the MTA reports an elevator out of service, is skipped while the alert lasts. Without
+// the alerts feed only the static ADA status is used.
+
+import (
+	"log"
+	"strings"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// Stations.csv ADA values
+const (
+	adaFull    = 1
+	adaPartial = 2
+)
+
+// parseADA reads a Stations.csv ADA flag ("0", "1" or "2")
+func parseADA(v string) int {
+	switch strings.TrimSpace(v) {
+	case "1":
+		return adaFull
+	case "2":
+		return adaPartial
+	}
+	return 0
+}
+
+// stationAccessible reports whether Stations.csv marks the station accessible for trains
+// in direction (N, S or empty for both)
+func stationAccessible(s Station, direction string) bool {
+	switch s.ADA {
+	case adaFull:
+		return true
+	case adaPartial:
+		switch direction {
+		case "N":
+			return s.ADANorthbound
+		case "S":
+			return s.ADASouthbound
+		}
+		return s.ADANorthbound && s.ADASouthbound
+	}
+	return false
+}
+
+// accessibilityOutages lists the parent stop IDs with an active ACCESSIBILITY_ISSUE alert
+// (best-effort: with no alerts feed, or on a feed error, none)
+func accessibilityOutages() map[string]bool {
+	all, err := currentAlerts()
+	if err != nil {
+		log.Printf("alerts feed error: %v", err)
+		return nil
+	}
+	effect := gtfs_realtime.Alert_ACCESSIBILITY_ISSUE.String()
+	var out map[string]bool
+	for _, a := range all {
+		if a.Effect != effect {
+			continue
+		}
+		for _, stop := range a.Stops {
+			if out == nil {
+				out = map[string]bool{}
+			}
+			out[stop] = true
+		}
+	}
+	return out
+}
+
+// accessibleFilter returns a station predicate for ada=true queries: accessible in
+// direction, with no elevator outage reported
+func accessibleFilter(direction string) func(Station) bool {
+	outages := accessibilityOutages()
+	return func(s Station) bool {
+		return stationAccessible(s, direction) && !outages[parentStopID(s.StopID)]
+	}
+}
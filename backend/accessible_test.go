@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+func TestStationAccessible(t *testing.T) {
+	partial := Station{ADA: adaPartial, ADANorthbound: true}
+	tests := []struct {
+		s         Station
+		direction string
+		want      bool
+	}{
+		{Station{ADA: adaFull}, "", true},
+		{Station{ADA: adaFull}, "S", true},
+		{Station{}, "", false},
+		{partial, "N", true},
+		{partial, "S", false},
+		{partial, "", false},
+		{Station{ADA: adaPartial, ADANorthbound: true, ADASouthbound: true}, "", true},
+	}
+	for _, tc := range tests {
+		if got := stationAccessible(tc.s, tc.direction); got != tc.want {
+			t.Errorf("stationAccessible(%+v, %q) = %v, want %v", tc.s, tc.direction, got, tc.want)
+		}
+	}
+}
+
+func TestLoadADAFromStationsCSV(t *testing.T) {
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "635"}, {StopID: "R20"}, {StopID: "L03"}})
+	path := filepath.Join(t.TempDir(), "Stations.csv")
+	os.WriteFile(path, []byte("GTFS Stop ID,Daytime Routes,ADA,ADA Northbound,ADA Southbound\n"+
+		"635,4 5 6,1,1,1\n"+
+		"R20,N Q R W,2,0,1\n"+
+		"L03,L,0,0,0\n"), 0o644)
+	original := mtaStationsCSV
+	mtaStationsCSV = path
+	t.Cleanup(func() { mtaStationsCSV = original })
+
+	if err := loadRouteMapping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]Station{}
+	for _, s := range data().Stations {
+		got[s.StopID] = s
+	}
+	if got["635"].ADA != adaFull || got["R20"].ADA != adaPartial || got["R20"].ADANorthbound || !got["R20"].ADASouthbound || got["L03"].ADA != 0 {
+		t.Errorf("unexpected ADA status %+v", got)
+	}
+}
+
+func TestNearestAccessible(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+	setTestStations([]Station{
+		{StopID: "L03", Name: "Union Sq (L)", Lat: 40.734789, Lon: -73.990730},
+		{StopID: "D17", Name: "34 St-Herald Sq", Lat: 40.749719, Lon: -73.987823, ADA: adaFull},
+		{StopID: "A28", Name: "34 St-Penn Station", Lat: 40.752287, Lon: -73.993391, ADA: adaFull},
+	})
+	useTestFeeds(t, newTestFeedServer(t).URL)
+	useTestOSRM(t, 240, 300)
+
+	nearest := func(query string) (int, NearestResponse) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleNearest(w, httptest.NewRequest("GET", "/api/departures/nearest?lat=40.7359&lon=-73.9911"+query, nil))
+		var resp NearestResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+	if _, resp := nearest(""); resp.Station.StopID != "L03" {
+		t.Errorf("expected the closest station without ada, got %s", resp.Station.StopID)
+	}
+	if _, resp := nearest("&ada=true"); resp.Station.StopID != "D17" {
+		t.Errorf("expected the closest accessible station, got %s", resp.Station.StopID)
+	}
+
+	// An elevator outage alert rules the station out while it lasts
+	useTestAlerts(t, testAlert("elev", "Elevator out of service", gtfs_realtime.Alert_ACCESSIBILITY_ISSUE, nil, [2]string{"", "D17"}))
+	transitFeedCache.Purge()
+	if _, resp := nearest("&ada=true"); resp.Station.StopID != "A28" {
+		t.Errorf("expected the outage to skip Herald Sq, got %s", resp.Station.StopID)
+	}
+
+	setTestStations([]Station{{StopID: "L03", Name: "Union Sq (L)", Lat: 40.734789, Lon: -73.990730}})
+	if code, _ := nearest("&ada=true"); code != http.StatusNotFound {
+		t.Errorf("expected 404 with no accessible station, got %d", code)
+	}
+}
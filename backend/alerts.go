@@ -0,0 +1,219 @@
+package main
+
+// Service alerts from the MTA GTFS-RT alerts feed.
+//
+//   GET /api/alerts                       active alerts
+//   GET /api/alerts?route=6&stop_id=635   only alerts affecting that route and/or station
+//
+// The feed is fetched through the same 30s cache as the trip feeds. Station responses
+// carry the alerts that affect the station itself or, for route-wide alerts, one of the
+// routes serving it.
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// alertsFeedURL is the GTFS-RT service alerts feed (empty disables alerts)
+var alertsFeedURL = "https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/camsys%2Fsubway-alerts"
+
+// ServiceAlert is one active alert, flattened for clients
+type ServiceAlert struct {
+	ID          string   `json:"id"`
+	Header      string   `json:"header"`
+	Description string   `json:"description,omitempty"`
+	Effect      string   `json:"effect,omitempty"` // GTFS-RT effect, e.g. NO_SERVICE, REDUCED_SERVICE
+	Routes      []string `json:"routes,omitempty"`
+	Stops       []string `json:"stops,omitempty"` // parent stop IDs
+	Start       int64    `json:"start,omitempty"` // unix time the current active period began
+	End         int64    `json:"end,omitempty"`   // unix time it ends (0 = until further notice)
+
+	entities []alertEntity
+}
+
+// alertEntity is one informed entity: a route, a stop, or a route at a stop
+type alertEntity struct {
+	route string
+	stop  string // parent stop ID
+}
+
+// parseAlerts extracts the alerts active at now from an alerts feed
+func parseAlerts(feed *gtfs_realtime.FeedMessage, now time.Time) []ServiceAlert {
+	return parseAlertsBetween(feed, now, now)
+}
+
+// parseAlertsBetween extracts the alerts active at any time in [from, to], reporting the
+// first active period overlapping it
+func parseAlertsBetween(feed *gtfs_realtime.FeedMessage, from, to time.Time) []ServiceAlert {
+	out := []ServiceAlert{}
+	for _, ent := range feed.GetEntity() {
+		a := ent.GetAlert()
+		if a == nil || ent.GetIsDeleted() {
+			continue
+		}
+		start, end, active := activePeriod(a.GetActivePeriod(), from.Unix(), to.Unix())
+		if !active {
+			continue
+		}
+		sa := ServiceAlert{
+			ID:          ent.GetId(),
+			Header:      translatedText(a.GetHeaderText()),
+			Description: translatedText(a.GetDescriptionText()),
+			Start:       start,
+			End:         end,
+		}
+		if a.Effect != nil {
+			sa.Effect = a.GetEffect().String()
+		}
+		routes, stops := map[string]bool{}, map[string]bool{}
+		for _, sel := range a.GetInformedEntity() {
+			e := alertEntity{route: sel.GetRouteId(), stop: parentStopID(sel.GetStopId())}
+			if e.route == "" && e.stop == "" {
+				continue
+			}
+			sa.entities = append(sa.entities, e)
+			if e.route != "" && !routes[e.route] {
+				routes[e.route] = true
+				sa.Routes = append(sa.Routes, e.route)
+			}
+			if e.stop != "" && !stops[e.stop] {
+				stops[e.stop] = true
+				sa.Stops = append(sa.Stops, e.stop)
+			}
+		}
+		out = append(out, sa)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// activePeriod finds the first period overlapping [from, to] (from == to asks about one
+// instant). Alerts without periods are always active.
+func activePeriod(periods []*gtfs_realtime.TimeRange, from, to int64) (start, end int64, active bool) {
+	if len(periods) == 0 {
+		return 0, 0, true
+	}
+	for _, p := range periods {
+		s, e := int64(p.GetStart()), int64(p.GetEnd())
+		if (s == 0 || s <= to) && (e == 0 || from < e) {
+			return s, e, true
+		}
+	}
+	return 0, 0, false
+}
+
+// translatedText picks the plain English translation ("en", or untagged); the MTA also
+// publishes an "en-html" variant
+func translatedText(ts *gtfs_realtime.TranslatedString) string {
+	var fallback string
+	for _, t := range ts.GetTranslation() {
+		switch strings.ToLower(t.GetLanguage()) {
+		case "en", "":
+			return t.GetText()
+		}
+		if fallback == "" {
+			fallback = t.GetText()
+		}
+	}
+	return fallback
+}
+
+// affects reports whether the alert applies to station s, either at the stop itself or
+// route-wide on a route serving it. A non-empty route only counts entities for that route
+// (stop-wide entities still apply to the station); a zero Station matches any stop.
+func (a ServiceAlert) affects(s Station, route string) bool {
+	base := parentStopID(s.StopID)
+	for _, e := range a.entities {
+		if route != "" && e.route != "" && !strings.EqualFold(e.route, route) {
+			continue
+		}
+		if base == "" {
+			if e.route != "" {
+				return true
+			}
+			continue // stop-only entity in a route-only query
+		}
+		if e.stop != "" {
+			if e.stop == base {
+				return true
+			}
+			continue
+		}
+		if containsString(s.Routes, e.route) {
+			return true
+		}
+	}
+	return false
+}
+
+// currentAlerts returns the active alerts, fetching the feed through the cache
+func currentAlerts() ([]ServiceAlert, error) {
+	if alertsFeedURL == "" {
+		return nil, nil
+	}
+	feed, err := fetchGTFS(alertsFeedURL)
+	if err != nil {
+		return nil, err
+	}
+	return parseAlerts(feed, nowFunc()), nil
+}
+
+// alertsForStation lists the active alerts affecting a station (best-effort: feed errors
+// are logged and yield no alerts)
+func alertsForStation(s Station) []ServiceAlert {
+	all, err := currentAlerts()
+	if err != nil {
+		log.Printf("alerts feed error: %v", err)
+		return nil
+	}
+	var out []ServiceAlert
+	for _, a := range all {
+		if a.affects(s, "") {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	if alertsFeedURL == "" {
+		httpError(w, http.StatusNotFound, "service alerts disabled")
+		return
+	}
+	all, err := currentAlerts()
+	if err != nil {
+		httpError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	route := strings.TrimSpace(r.URL.Query().Get("route"))
+	stopID := strings.TrimSpace(r.URL.Query().Get("stop_id"))
+	if route == "" && stopID == "" {
+		writeJSON(w, all)
+		return
+	}
+
+	s := Station{StopID: stopID}
+	if stopID != "" {
+		for _, st := range data().Stations {
+			if parentStopID(st.StopID) == parentStopID(stopID) {
+				s.Routes = st.Routes
+				break
+			}
+		}
+	}
+	out := []ServiceAlert{}
+	for _, a := range all {
+		if a.affects(s, route) {
+			out = append(out, a)
+		}
+	}
+	writeJSON(w, out)
+	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// testAlert builds an alert entity; each selector is a {route, stop} pair (either may be empty)
+func testAlert(id, header string, effect gtfs_realtime.Alert_Effect, periods [][2]int64, selectors ...[2]string) *gtfs_realtime.FeedEntity {
+	a := &gtfs_realtime.Alert{
+		Effect: effect.Enum(),
+		HeaderText: &gtfs_realtime.TranslatedString{Translation: []*gtfs_realtime.TranslatedString_Translation{
+			{Text: proto.String("<p>" + header + "</p>"), Language: proto.String("en-html")},
+			{Text: proto.String(header), Language: proto.String("en")},
+		}},
+	}
+	for _, p := range periods {
+		tr := &gtfs_realtime.TimeRange{}
+		if p[0] != 0 {
+			tr.Start = proto.Uint64(uint64(p[0]))
+		}
+		if p[1] != 0 {
+			tr.End = proto.Uint64(uint64(p[1]))
+		}
+		a.ActivePeriod = append(a.ActivePeriod, tr)
+	}
+	for _, sel := range selectors {
+		es := &gtfs_realtime.EntitySelector{}
+		if sel[0] != "" {
+			es.RouteId = proto.String(sel[0])
+		}
+		if sel[1] != "" {
+			es.StopId = proto.String(sel[1])
+		}
+		a.InformedEntity = append(a.InformedEntity, es)
+	}
+	return &gtfs_realtime.FeedEntity{Id: proto.String(id), Alert: a}
+}
+
+func TestParseAlerts(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	n := now.Unix()
+	feed := newTestFeed(
+		testAlert("b", "6 trains running local", gtfs_realtime.Alert_MODIFIED_SERVICE, [][2]int64{{n - 3600, n + 3600}}, [2]string{"6", ""}),
+		testAlert("a", "No trains at 14 St", gtfs_realtime.Alert_NO_SERVICE, nil, [2]string{"", "635N"}, [2]string{"6", "635"}),
+		testAlert("expired", "Old", gtfs_realtime.Alert_NO_SERVICE, [][2]int64{{n - 7200, n - 3600}}, [2]string{"6", ""}),
+		testAlert("later", "Weekend work", gtfs_realtime.Alert_REDUCED_SERVICE, [][2]int64{{n - 7200, n - 3600}, {n + 3600, 0}}, [2]string{"L", ""}),
+		testTripUpdate("6", "trip1", []string{"635N"}, []int64{60}),
+	)
+
+	got := parseAlerts(feed, now)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 active alerts, got %+v", got)
+	}
+	a, b := got[0], got[1]
+	if a.ID != "a" || a.Header != "No trains at 14 St" || a.Effect != "NO_SERVICE" || a.Start != 0 || a.End != 0 {
+		t.Errorf("unexpected alert %+v", a)
+	}
+	if len(a.Stops) != 1 || a.Stops[0] != "635" || len(a.Routes) != 1 || a.Routes[0] != "6" {
+		t.Errorf("expected deduplicated parent stop 635 and route 6, got stops %v routes %v", a.Stops, a.Routes)
+	}
+	if b.ID != "b" || b.Start != n-3600 || b.End != n+3600 {
+		t.Errorf("unexpected alert %+v", b)
+	}
+
+	// "b" has ended and the later period of "later" has begun
+	if got := parseAlerts(feed, now.Add(2*time.Hour)); len(got) != 2 || got[1].ID != "later" || got[1].Start != n+3600 {
+		t.Errorf("expected open-ended period to activate, got %+v", got)
+	}
+}
+
+func TestAlertAffects(t *testing.T) {
+	stopAlert := parseAlerts(newTestFeed(testAlert("s", "x", gtfs_realtime.Alert_NO_SERVICE, nil, [2]string{"", "635"})), time.Now())[0]
+	routeAlert := parseAlerts(newTestFeed(testAlert("r", "x", gtfs_realtime.Alert_NO_SERVICE, nil, [2]string{"6", ""})), time.Now())[0]
+	routeAtStop := parseAlerts(newTestFeed(testAlert("rs", "x", gtfs_realtime.Alert_NO_SERVICE, nil, [2]string{"4", "635"})), time.Now())[0]
+
+	union := Station{StopID: "635", Routes: []string{"4", "5", "6"}}
+	other := Station{StopID: "R20", Routes: []string{"N", "Q", "R", "W"}}
+	tests := []struct {
+		name  string
+		alert ServiceAlert
+		s     Station
+		route string
+		want  bool
+	}{
+		{"stop alert at stop", stopAlert, union, "", true},
+		{"stop alert elsewhere", stopAlert, other, "", false},
+		{"stop alert under route filter", stopAlert, union, "6", true},
+		{"stop alert route-only query", stopAlert, Station{}, "6", false},
+		{"route alert on serving route", routeAlert, union, "", true},
+		{"route alert not serving", routeAlert, other, "", false},
+		{"route alert other route filter", routeAlert, union, "4", false},
+		{"route alert route-only query", routeAlert, Station{}, "6", true},
+		{"route at stop", routeAtStop, union, "", true},
+		{"route at stop other route", routeAtStop, union, "6", false},
+		{"route at stop elsewhere", routeAtStop, Station{StopID: "631", Routes: []string{"4"}}, "", false},
+	}
+	for _, tt := range tests {
+		if got := tt.alert.affects(tt.s, tt.route); got != tt.want {
+			t.Errorf("%s: affects = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAPIAlerts(t *testing.T) {
+	initTestCaches()
+	useTestFeeds(t)
+	useTestAlerts(t,
+		testAlert("route-6", "6 delays", gtfs_realtime.Alert_SIGNIFICANT_DELAYS, nil, [2]string{"6", ""}),
+		testAlert("stop-r20", "Station closed", gtfs_realtime.Alert_NO_SERVICE, nil, [2]string{"", "R20"}),
+	)
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "635", Name: "14 St-Union Sq", Routes: []string{"4", "5", "6"}}})
+
+	get := func(url string) []ServiceAlert {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleAlerts(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", url, w.Code, w.Body.String())
+		}
+		var out []ServiceAlert
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	if got := get("/api/alerts"); len(got) != 2 {
+		t.Errorf("expected 2 alerts, got %+v", got)
+	}
+	if got := get("/api/alerts?stop_id=635"); len(got) != 1 || got[0].ID != "route-6" {
+		t.Errorf("expected the route-wide 6 alert at 635, got %+v", got)
+	}
+	if got := get("/api/alerts?route=N"); len(got) != 0 {
+		t.Errorf("expected no N alerts, got %+v", got)
+	}
+	if got := alertsForStation(Station{StopID: "R20", Routes: []string{"R"}}); len(got) != 1 || got[0].ID != "stop-r20" {
+		t.Errorf("expected station alert attached, got %+v", got)
+	}
+
+	alertsFeedURL = ""
+	w := httptest.NewRecorder()
+	handleAlerts(w, httptest.NewRequest("GET", "/api/alerts", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 with alerts disabled, got %d", w.Code)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+// Operator departure annotations.
+//
+// Operators can attach local knowledge to departures ("use the rear exit for the museum",
+// "last train to connect with the LIRR") without code changes, through a JSON file of
+// rules named by config key annotations_file:
+//
+//   [{"routes": ["4", "5", "6"], "direction": "N", "stations": ["631"],
+//     "from": "17:00", "until": "19:00", "text": "Expect crowding; the rear cars are emptier"}]
+//
+// Every condition is optional and an empty one matches anything. stations lists parent stop
+// IDs; from/until is a window of local time (America/New_York) at the departure, and may
+// wrap past midnight. Each matching rule's text is added to the departure's annotations,
+// in file order.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// AnnotationRule attaches Text to the departures it matches
+type AnnotationRule struct {
+	Routes    []string `json:"routes,omitempty"`
+	Direction string   `json:"direction,omitempty"` // N or S
+	Stations  []string `json:"stations,omitempty"`
+	From      string   `json:"from,omitempty"`  // HH:MM
+	Until     string   `json:"until,omitempty"` // HH:MM, exclusive
+	Text      string   `json:"text"`
+
+	from, until int // minutes after midnight; -1 when unset
+}
+
+type annotationStore struct {
+	mu    sync.RWMutex
+	rules []AnnotationRule
+}
+
+var annotations = &annotationStore{}
+
+// parseClockMinutes parses HH:MM as minutes after midnight, -1 for empty
+func parseClockMinutes(s string) (int, error) {
+	if s == "" {
+		return -1, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("bad time %q (want HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseAnnotationRules decodes and validates a JSON array of rules
+func parseAnnotationRules(data []byte) ([]AnnotationRule, error) {
+	var rules []AnnotationRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse annotations: %w", err)
+	}
+	for i := range rules {
+		r := &rules[i]
+		if r.Text == "" {
+			return nil, fmt.Errorf("parse annotations: rule %d has no text", i+1)
+		}
+		if r.Direction != "" && r.Direction != "N" && r.Direction != "S" {
+			return nil, fmt.Errorf("parse annotations: rule %d: direction must be N or S", i+1)
+		}
+		var err error
+		if r.from, err = parseClockMinutes(r.From); err != nil {
+			return nil, fmt.Errorf("parse annotations: rule %d: %w", i+1, err)
+		}
+		if r.until, err = parseClockMinutes(r.Until); err != nil {
+			return nil, fmt.Errorf("parse annotations: rule %d: %w", i+1, err)
+		}
+		for j, s := range r.Stations {
+			r.Stations[j] = parentStopID(s)
+		}
+	}
+	return rules, nil
+}
+
+// load replaces the rules from a JSON file
+func (a *annotationStore) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read annotations: %w", err)
+	}
+	rules, err := parseAnnotationRules(data)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.rules = rules
+	a.mu.Unlock()
+	log.Printf("Loaded %d departure annotation rules from %s", len(rules), path)
+	return nil
+}
+
+// matches reports whether a rule applies to a departure at a station
+func (r *AnnotationRule) matches(stationID string, d Departure) bool {
+	if len(r.Routes) > 0 && !containsString(r.Routes, d.RouteID) {
+		return false
+	}
+	if r.Direction != "" && r.Direction != d.Direction {
+		return false
+	}
+	if len(r.Stations) > 0 && !containsString(r.Stations, parentStopID(stationID)) {
+		return false
+	}
+	if r.from < 0 && r.until < 0 {
+		return true
+	}
+	local := time.Unix(d.UnixTime, 0).In(transitLocation)
+	m := local.Hour()*60 + local.Minute()
+	from, until := r.from, r.until
+	if from < 0 {
+		from = 0
+	}
+	if until < 0 {
+		until = 24 * 60
+	}
+	if from <= until {
+		return m >= from && m < until
+	}
+	return m >= from || m < until // wraps past midnight
+}
+
+// annotate sets each departure's annotations from the matching rules
+func (a *annotationStore) annotate(stationID string, deps []Departure) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if len(a.rules) == 0 {
+		return
+	}
+	for i := range deps {
+		for j := range a.rules {
+			if a.rules[j].matches(stationID, deps[i]) {
+				deps[i].Annotations = append(deps[i].Annotations, a.rules[j].Text)
+			}
+		}
+	}
+}
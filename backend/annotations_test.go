@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnnotationRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "annotations.json")
+	os.WriteFile(path, []byte(`[
+		{"stations": ["631"], "text": "Use the rear exit for the museum"},
+		{"routes": ["6"], "direction": "N", "from": "17:00", "until": "19:00", "text": "Rush hour crowding"},
+		{"routes": ["4"], "from": "23:00", "until": "05:00", "text": "Late night: local stops"}
+	]`), 0o644)
+	original := annotations
+	annotations = &annotationStore{}
+	t.Cleanup(func() { annotations = original })
+	if err := annotations.load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(hhmm string) int64 {
+		d, _ := time.ParseInLocation("2006-01-02 15:04", "2026-10-16 "+hhmm, transitLocation)
+		return d.Unix()
+	}
+	deps := []Departure{
+		{RouteID: "6", Direction: "N", UnixTime: at("17:30")},
+		{RouteID: "6", Direction: "S", UnixTime: at("17:30")},
+		{RouteID: "6", Direction: "N", UnixTime: at("19:00")},
+		{RouteID: "4", Direction: "S", UnixTime: at("01:15")},
+		{RouteID: "4", Direction: "S", UnixTime: at("12:00")},
+	}
+	annotations.annotate("631S", deps)
+	want := [][]string{
+		{"Use the rear exit for the museum", "Rush hour crowding"},
+		{"Use the rear exit for the museum"},
+		{"Use the rear exit for the museum"}, // until is exclusive
+		{"Use the rear exit for the museum", "Late night: local stops"},
+		{"Use the rear exit for the museum"},
+	}
+	for i, d := range deps {
+		if strings.Join(d.Annotations, "|") != strings.Join(want[i], "|") {
+			t.Errorf("departure %d: annotations %q, want %q", i, d.Annotations, want[i])
+		}
+	}
+
+	other := []Departure{{RouteID: "4", UnixTime: at("12:00")}}
+	annotations.annotate("640", other)
+	if len(other[0].Annotations) != 0 {
+		t.Errorf("expected no annotations at another station, got %q", other[0].Annotations)
+	}
+}
+
+func TestParseAnnotationRulesErrors(t *testing.T) {
+	for _, tt := range []struct{ data, want string }{
+		{`[{"routes": ["6"]}]`, "rule 1 has no text"},
+		{`[{"text": "x", "direction": "E"}]`, "direction must be N or S"},
+		{`[{"text": "x", "from": "5pm"}]`, "bad time"},
+		{`{"text": "x"}`, "parse annotations"},
+	} {
+		if _, err := parseAnnotationRules([]byte(tt.data)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected %q, got %v", tt.data, tt.want, err)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,10 +17,10 @@ func TestAPIStopsEndpoint(t *testing.T) {
 	initTestCaches()
 	
 	// Initialize some test stations
-	stations = []Station{
+	setTestStations([]Station{
 		{StopID: "R14N", Name: "14 St - Union Sq", Lat: 40.7359, Lon: -73.9906},
 		{StopID: "635S", Name: "Grand Central - 42 St", Lat: 40.7527, Lon: -73.9772},
-	}
+	})
 
 	// First request - should not be cached
 	req := httptest.NewRequest("GET", "/api/stops", nil)
@@ -70,10 +71,10 @@ func TestAPINearestEndpoint(t *testing.T) {
 	initTestCaches()
 	
 	// Initialize test stations
-	stations = []Station{
+	setTestStations([]Station{
 		{StopID: "R14N", Name: "14 St - Union Sq", Lat: 40.7359, Lon: -73.9906},
 		{StopID: "635S", Name: "Grand Central - 42 St", Lat: 40.7527, Lon: -73.9772},
-	}
+	})
 
 	// Mock the departuresForStation function to test the limiting behavior
 	// We'll test with a request near Grand Central
@@ -96,11 +97,11 @@ func TestAPIByIDEndpoint(t *testing.T) {
 	initTestCaches()
 	
 	// Initialize test stations
-	stations = []Station{
+	setTestStations([]Station{
 		{StopID: "R14N", Name: "14 St - Union Sq", Lat: 40.7359, Lon: -73.9906},
 		{StopID: "635S", Name: "Grand Central - 42 St", Lat: 40.7527, Lon: -73.9772},
 		{StopID: "635N", Name: "Grand Central - 42 St", Lat: 40.7527, Lon: -73.9772},
-	}
+	})
 
 	req := httptest.NewRequest("GET", "/api/departures/by-id?id=635", nil)
 	w := httptest.NewRecorder()
@@ -114,13 +115,217 @@ func TestAPIByIDEndpoint(t *testing.T) {
 	}
 }
 
+func TestAPINearestMultiRanking(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+
+	// 635 is closest but its next train leaves in 20 minutes; R14 is a short walk
+	// further with a train in 5 minutes, so it should rank first.
+	setTestStations([]Station{
+		{StopID: "635", Name: "14 St - Union Sq (4/5/6)", Lat: 40.7347, Lon: -73.9897},
+		{StopID: "R14", Name: "14 St - Union Sq (N/Q/R/W)", Lat: 40.7359, Lon: -73.9906},
+		{StopID: "R14N", Name: "14 St - Union Sq (N/Q/R/W)", Lat: 40.7359, Lon: -73.9906},
+		{StopID: "A31", Name: "14 St (A/C/E)", Lat: 40.7402, Lon: -74.0020},
+	})
+	server := newTestFeedServer(t,
+		testTripUpdate("6", "trip6", []string{"635N"}, []int64{1200}),
+		testTripUpdate("Q", "tripQ", []string{"R14N"}, []int64{300}),
+	)
+	useTestFeeds(t, server.URL)
+	useTestOSRM(t, 120, 150)
+
+	req := httptest.NewRequest("GET", "/api/departures/nearest-multi?lat=40.7347&lon=-73.9897&count=3", nil)
+	w := httptest.NewRecorder()
+	handleNearestMulti(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var result MultiNearestResponse
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	// Duplicate R14/R14N rows collapse into one candidate
+	if len(result.Stations) != 3 {
+		t.Fatalf("expected 3 stations, got %d", len(result.Stations))
+	}
+	if result.Stations[0].Station.StopID != "R14" || result.Stations[1].Station.StopID != "635" {
+		t.Errorf("unexpected ranking: %s, %s", result.Stations[0].Station.StopID, result.Stations[1].Station.StopID)
+	}
+	if result.Stations[0].TotalSeconds == nil || *result.Stations[0].TotalSeconds > 300 {
+		t.Errorf("expected total time of about 300s, got %v", result.Stations[0].TotalSeconds)
+	}
+	if result.Stations[2].TotalSeconds != nil {
+		t.Errorf("station without departures should have no total time")
+	}
+}
+
+func TestAPINearestSortTotalTime(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+
+	// As above: R14 is a little further than 635 but its train leaves 15 minutes sooner
+	setTestStations([]Station{
+		{StopID: "635", Name: "14 St - Union Sq (4/5/6)", Lat: 40.7347, Lon: -73.9897},
+		{StopID: "R14", Name: "14 St - Union Sq (N/Q/R/W)", Lat: 40.7359, Lon: -73.9906},
+		{StopID: "A31", Name: "14 St (A/C/E)", Lat: 40.7402, Lon: -74.0020},
+	})
+	server := newTestFeedServer(t,
+		testTripUpdate("6", "trip6", []string{"635N"}, []int64{1200}),
+		testTripUpdate("Q", "tripQ", []string{"R14N"}, []int64{300}),
+	)
+	useTestFeeds(t, server.URL)
+	useTestOSRM(t, 120, 150)
+
+	get := func(handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", target, nil))
+		return w
+	}
+	firstStation := func(w *httptest.ResponseRecorder) string {
+		var result MultiNearestResponse
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil || len(result.Stations) == 0 {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return result.Stations[0].Station.StopID
+	}
+
+	var single NearestResponse
+	json.NewDecoder(get(handleNearest, "/api/departures/nearest?lat=40.7347&lon=-73.9897").Body).Decode(&single)
+	if single.Station.StopID != "635" {
+		t.Errorf("expected the closest station by default, got %s", single.Station.StopID)
+	}
+	single = NearestResponse{}
+	json.NewDecoder(get(handleNearest, "/api/departures/nearest?lat=40.7347&lon=-73.9897&sort=total_time").Body).Decode(&single)
+	if single.Station.StopID != "R14" || len(single.Departures) != 1 {
+		t.Errorf("expected R14 and its departure with sort=total_time, got %s %+v", single.Station.StopID, single.Departures)
+	}
+
+	if got := firstStation(get(handleNearest, "/api/departures/nearest?lat=40.7347&lon=-73.9897&count=3&sort=total_time")); got != "R14" {
+		t.Errorf("expected count=3&sort=total_time to rank R14 first, got %s", got)
+	}
+	if got := firstStation(get(handleNearestMulti, "/api/departures/nearest-multi?lat=40.7347&lon=-73.9897&sort=distance")); got != "635" {
+		t.Errorf("expected nearest-multi with sort=distance to rank 635 first, got %s", got)
+	}
+	if w := get(handleNearest, "/api/departures/nearest?lat=40.7347&lon=-73.9897&sort=fastest"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown sort, got %d", w.Code)
+	}
+}
+
+func TestAPINearestCount(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+
+	setTestStations([]Station{
+		{StopID: "635", Name: "14 St - Union Sq", Lat: 40.7347, Lon: -73.9897},
+		{StopID: "R20", Name: "14 St - Union Sq", Lat: 40.7357, Lon: -73.9906},
+		{StopID: "A31", Name: "14 St", Lat: 40.7409, Lon: -74.0017},
+	})
+	server := newTestFeedServer(t,
+		testTripUpdate("6", "trip6", []string{"635N"}, []int64{1200}),
+		testTripUpdate("Q", "tripQ", []string{"R20S"}, []int64{300}),
+	)
+	useTestFeeds(t, server.URL)
+	useTestOSRM(t, 120, 150)
+
+	req := httptest.NewRequest("GET", "/api/departures/nearest?lat=40.7347&lon=-73.9897&count=2", nil)
+	w := httptest.NewRecorder()
+	handleNearest(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var result MultiNearestResponse
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	// Plain count keeps distance order; each station carries its own walk and departures
+	if len(result.Stations) != 2 || result.Stations[0].Station.StopID != "635" || result.Stations[1].Station.StopID != "R20" {
+		t.Fatalf("unexpected stations %+v", result.Stations)
+	}
+	for _, s := range result.Stations {
+		if s.Walking == nil || len(s.Departures) != 1 {
+			t.Errorf("station %s missing walk or departures: %+v", s.Station.StopID, s)
+		}
+	}
+
+	// Invalid count is rejected
+	w = httptest.NewRecorder()
+	handleNearest(w, httptest.NewRequest("GET", "/api/departures/nearest?lat=40.7347&lon=-73.9897&count=abc", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid count, got %d", w.Code)
+	}
+}
+
+func TestDoorToTrainSeconds(t *testing.T) {
+	deps := []Departure{{ETASeconds: 60}, {ETASeconds: 400}}
+	// 2 minute walk: the train in 60s is missed, so the rider boards the 400s train
+	if got := doorToTrainSeconds(0, &WalkResult{Seconds: 120}, deps); got == nil || *got != 400 {
+		t.Errorf("expected 400, got %v", got)
+	}
+	// Without OSRM, 130m at walking speed is 100s
+	if got := doorToTrainSeconds(130, nil, deps); got == nil || *got != 400 {
+		t.Errorf("expected 400 with estimated walk, got %v", got)
+	}
+	if got := doorToTrainSeconds(0, &WalkResult{Seconds: 500}, deps); got != nil {
+		t.Errorf("expected nil when no train is catchable, got %d", *got)
+	}
+}
+
+func TestAPINearestCatchable(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+
+	setTestStations([]Station{{StopID: "635", Name: "14 St - Union Sq", Lat: 40.7347, Lon: -73.9897}})
+	server := newTestFeedServer(t, testTripUpdate("6", "soon", []string{"635N"}, []int64{60}), testTripUpdate("6", "later", []string{"635N"}, []int64{400}))
+	useTestFeeds(t, server.URL)
+	useTestOSRM(t, 120, 150)
+
+	w := httptest.NewRecorder()
+	handleNearest(w, httptest.NewRequest("GET", "/api/departures/nearest?lat=40.7347&lon=-73.9897&catchable=true", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var result NearestResponse
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	// The train in a minute leaves before the two minute walk is over
+	if len(result.Departures) != 1 || result.Departures[0].TripID != "later" {
+		t.Fatalf("expected only the later train, got %+v", result.Departures)
+	}
+	if l := result.Departures[0].LeaveInSeconds; l == nil || *l < 270 || *l > 280 {
+		t.Errorf("expected about 280s to leave, got %v", l)
+	}
+
+	// Without the option nothing is dropped or annotated
+	w = httptest.NewRecorder()
+	handleNearest(w, httptest.NewRequest("GET", "/api/departures/nearest?lat=40.7347&lon=-73.9897", nil))
+	result = NearestResponse{}
+	json.NewDecoder(w.Body).Decode(&result)
+	if len(result.Departures) != 2 || result.Departures[0].LeaveInSeconds != nil {
+		t.Errorf("expected both trains without leave_in_seconds, got %+v", result.Departures)
+	}
+}
+
+func TestCatchableDepartures(t *testing.T) {
+	deps := []Departure{{TripID: "a", ETASeconds: 60}, {TripID: "b", ETASeconds: 120}, {TripID: "c", ETASeconds: 400}}
+	got := catchableDepartures(deps, 120)
+	if len(got) != 2 || got[0].TripID != "b" || *got[0].LeaveInSeconds != 0 || *got[1].LeaveInSeconds != 280 {
+		t.Errorf("unexpected catchable departures %+v", got)
+	}
+	if deps[1].LeaveInSeconds != nil {
+		t.Error("input departures should not be modified")
+	}
+}
+
 func TestAPIInvalidRequests(t *testing.T) {
 	// Initialize test caches
 	initTestCaches()
 	
-	stations = []Station{
+	setTestStations([]Station{
 		{StopID: "R14N", Name: "14 St - Union Sq", Lat: 40.7359, Lon: -73.9906},
-	}
+	})
 
 	tests := []struct {
 		name     string
@@ -132,8 +337,11 @@ func TestAPIInvalidRequests(t *testing.T) {
 		{"invalid lat", "/api/departures/nearest?lat=abc&lon=-73.9772", http.StatusBadRequest},
 		{"invalid lon", "/api/departures/nearest?lat=40.7527&lon=xyz", http.StatusBadRequest},
 		{"outside NYC", "/api/departures/nearest?lat=34.0522&lon=-118.2437", http.StatusBadRequest},
+		{"unknown place", "/api/departures/nearest?place=nowhere", http.StatusNotFound},
 		{"missing id", "/api/departures/by-id", http.StatusBadRequest},
 		{"no match", "/api/departures/by-id?id=NoSuchID", http.StatusNotFound},
+		{"multi invalid count", "/api/departures/nearest-multi?lat=40.7527&lon=-73.9772&count=0", http.StatusBadRequest},
+		{"multi outside NYC", "/api/departures/nearest-multi?lat=34.0522&lon=-118.2437", http.StatusBadRequest},
 	}
 
 	for _, tt := range tests {
@@ -143,6 +351,8 @@ func TestAPIInvalidRequests(t *testing.T) {
 			
 			if tt.endpoint[:21] == "/api/departures/by-id" {
 				handleByID(w, req)
+			} else if strings.HasPrefix(tt.endpoint, "/api/departures/nearest-multi") {
+				handleNearestMulti(w, req)
 			} else {
 				handleNearest(w, req)
 			}
@@ -250,11 +460,11 @@ func TestFeedOptimizationWithRealStations(t *testing.T) {
 	initTestCaches()
 	
 	// Initialize stations with route information
-	stations = []Station{
+	setTestStations([]Station{
 		{StopID: "L01", Name: "Bedford Av", Lat: 40.717304, Lon: -73.956872, Routes: []string{"L"}},
 		{StopID: "635", Name: "Times Sq-42 St", Lat: 40.754672, Lon: -73.986754, Routes: []string{"N", "Q", "R", "W", "1", "2", "3", "7"}},
 		{StopID: "A32", Name: "Penn Station", Lat: 40.750373, Lon: -73.991057, Routes: []string{"A", "C", "E"}},
-	}
+	})
 	
 	// Test the by-id endpoint with a station that has L train only
 	t.Run("by-id endpoint with L train station", func(t *testing.T) {
@@ -292,13 +502,13 @@ func TestFeedOptimizationWithRealStations(t *testing.T) {
 	// Test with a station without route info
 	t.Run("station without route info falls back to all feeds", func(t *testing.T) {
 		// Add a station without route info
-		stations = append(stations, Station{
+		setTestStations(append(data().Stations, Station{
 			StopID: "TEST",
 			Name:   "Test Station",
 			Lat:    40.760000,
 			Lon:    -73.990000,
 			Routes: []string{}, // No routes
-		})
+		}))
 		
 		req := httptest.NewRequest("GET", "/api/departures/by-id?id=TEST", nil)
 		w := httptest.NewRecorder()
@@ -319,14 +529,14 @@ func TestLastStopHeadsignFallback(t *testing.T) {
 	initTestCaches()
 	
 	// Mock stations with distinctive last stop name
-	stations = []Station{
+	setTestStations([]Station{
 		{StopID: "TEST", Name: "Test Station", Lat: 40.7, Lon: -73.9},
 		{StopID: "TERMINAL", Name: "Distinctive Terminal Station", Lat: 40.8, Lon: -74.0},
-	}
+	})
 	
 	// Don't mock trips arrays to ensure no headsign is found
-	trips = []Trip{}
-	supplementedTrips = []Trip{}
+	setTestTrips([]Trip{})
+	setTestSupplementedTrips([]Trip{})
 	
 	// Create mock server that returns GTFS-RT data with LastStop
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -378,7 +588,7 @@ func TestLastStopHeadsignFallback(t *testing.T) {
 	
 	// Test departuresForStation
 	station := Station{StopID: "TEST", Name: "Test Station", Lat: 40.7, Lon: -73.9}
-	deps, err := departuresForStation(station)
+	deps, err := departuresForStation(station, departureFilter{})
 	
 	if err != nil {
 		t.Fatalf("departuresForStation failed: %v", err)
@@ -0,0 +1,47 @@
+package main
+
+// MTA API keys.
+//
+// The MTA feeds are keyless today, but keyed access gets higher rate limits and may become
+// required. A key set with MTA_API_KEY (or mta_api_key in the config file) is sent as the
+// x-api-key header on every GTFS-RT fetch. feed_api_keys overrides it per feed, by proxy
+// feed name:
+//
+//   "mta_api_key": "...",
+//   "feed_api_keys": {"subway-alerts": "..."}
+//
+// Keys are never logged or returned by any endpoint.
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+var (
+	// mtaAPIKey is sent with every feed fetch unless feedAPIKeys has one for the feed
+	mtaAPIKey string
+	// feedAPIKeys are per-feed keys, by feed name (see feedName)
+	feedAPIKeys map[string]string
+)
+
+// feedAPIKey returns the key to send when fetching feedURL, or "" for none
+func feedAPIKey(feedURL string) string {
+	if key, ok := feedAPIKeys[feedName(feedURL)]; ok {
+		return key
+	}
+	return mtaAPIKey
+}
+
+// validateFeedAPIKeys checks feed_api_keys names known feeds
+func validateFeedAPIKeys(v json.RawMessage) string {
+	var keys map[string]string
+	if err := json.Unmarshal(v, &keys); err != nil {
+		return fmt.Sprintf("expected an object of feed name to API key, got %s", v)
+	}
+	for name := range keys {
+		if msg := validateFeedName(name); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFeedAPIKeyHeader(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("x-api-key"))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	oldKey, oldKeys := mtaAPIKey, feedAPIKeys
+	t.Cleanup(func() { mtaAPIKey, feedAPIKeys = oldKey, oldKeys })
+
+	aceURL := server.URL + "/nyct%2Fgtfs-ace"
+	alertsURL := server.URL + "/camsys%2Fsubway-alerts"
+	mtaAPIKey, feedAPIKeys = "", nil
+	downloadFeedConditional(aceURL)
+	mtaAPIKey, feedAPIKeys = "default", map[string]string{"subway-alerts": "alerts"}
+	downloadFeedConditional(aceURL)
+	downloadFeedConditional(alertsURL)
+
+	want := []string{"", "default", "alerts"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("sent keys %q, want %q", got, want)
+	}
+}
+
+func TestFeedAPIKeysConfig(t *testing.T) {
+	if errs := validateConfig([]byte(`{"mta_api_key": "k", "feed_api_keys": {"gtfs-ace": "a"}}`)); len(errs) != 0 {
+		t.Errorf("expected a valid config, got %v", errs)
+	}
+	errs := validateConfig([]byte(`{"feed_api_keys": {"gtfs-xyz": "a"}}`))
+	if len(errs) != 1 || !strings.Contains(errs[0], "gtfs-xyz") {
+		t.Errorf("expected an unknown feed error, got %v", errs)
+	}
+	if errs := validateConfig([]byte(`{"feed_api_keys": ["a"]}`)); len(errs) != 1 {
+		t.Errorf("expected a type error, got %v", errs)
+	}
+}
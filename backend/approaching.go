@@ -0,0 +1,58 @@
+package main
+
+// "Approaching" events for doorbells, lights and other one-shot triggers.
+//
+// The WebSocket API and the SSE stream both push full departure lists on every feed
+// refresh. Alongside them, each connection gets a single "approaching" event per trip
+// when that trip's ETA at a subscribed station drops to approaching_threshold (default
+// 2m) or less. Trips already inside the threshold when a station is first subscribed
+// don't fire: they didn't cross it while the client was watching.
+
+import (
+	"time"
+)
+
+// approachedRetention is how long a fired trip is remembered, long enough that a trip
+// briefly missing from the feed doesn't fire again when it reappears
+const approachedRetention = time.Hour
+
+// approachingThreshold is the configured ETA at which a trip counts as approaching
+func approachingThreshold() time.Duration {
+	return appConfig.ApproachingThreshold.orDefault(2 * time.Minute)
+}
+
+// approachTracker remembers which trips have fired, per connection. Not safe for
+// concurrent use.
+type approachTracker struct {
+	fired map[string]time.Time // station|stop|trip -> when it fired
+}
+
+func newApproachTracker() *approachTracker {
+	return &approachTracker{fired: map[string]time.Time{}}
+}
+
+// check returns the departures that just crossed the threshold. With initial set
+// (the station's first update) it only records trips already inside it.
+func (t *approachTracker) check(stationID string, deps []Departure, initial bool, now time.Time) []Departure {
+	for key, at := range t.fired {
+		if now.Sub(at) > approachedRetention {
+			delete(t.fired, key)
+		}
+	}
+	threshold := int64(approachingThreshold().Seconds())
+	var out []Departure
+	for _, d := range deps {
+		if d.TripID == "" || d.ETASeconds < 0 || d.ETASeconds > threshold {
+			continue
+		}
+		key := stationID + "|" + d.StopID + "|" + d.TripID
+		if _, ok := t.fired[key]; ok {
+			continue
+		}
+		t.fired[key] = now
+		if !initial {
+			out = append(out, d)
+		}
+	}
+	return out
+}
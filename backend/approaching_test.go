@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApproachTracker(t *testing.T) {
+	tr := newApproachTracker()
+	now := time.Now()
+	dep := func(trip string, eta int64) Departure {
+		return Departure{TripID: trip, StopID: "635N", ETASeconds: eta}
+	}
+
+	// Trips already close when the station is first seen don't fire
+	if got := tr.check("635", []Departure{dep("early", 60), dep("far", 300)}, true, now); len(got) != 0 {
+		t.Fatalf("initial update fired %+v", got)
+	}
+	got := tr.check("635", []Departure{dep("early", 30), dep("far", 120)}, false, now.Add(30*time.Second))
+	if len(got) != 1 || got[0].TripID != "far" {
+		t.Fatalf("expected far to fire at the threshold, got %+v", got)
+	}
+	if got := tr.check("635", []Departure{dep("far", 90)}, false, now.Add(time.Minute)); len(got) != 0 {
+		t.Errorf("fired twice for one trip: %+v", got)
+	}
+	// The same trip at another station is a separate event
+	if got := tr.check("631", []Departure{{TripID: "far", StopID: "631N", ETASeconds: 100}}, false, now.Add(time.Minute)); len(got) != 1 {
+		t.Errorf("expected the other station to fire, got %+v", got)
+	}
+	// Fired trips are forgotten after the retention period
+	tr.check("635", nil, false, now.Add(2*approachedRetention))
+	if len(tr.fired) != 0 {
+		t.Errorf("expected old trips to be dropped, got %v", tr.fired)
+	}
+}
+
+func TestApproachingThresholdConfig(t *testing.T) {
+	original := appConfig
+	t.Cleanup(func() { appConfig = original })
+	appConfig.ApproachingThreshold = Duration(30 * time.Second)
+
+	tr := newApproachTracker()
+	tr.check("635", nil, true, time.Now())
+	if got := tr.check("635", []Departure{{TripID: "a", StopID: "635N", ETASeconds: 60}}, false, time.Now()); len(got) != 0 {
+		t.Errorf("fired outside the configured threshold: %+v", got)
+	}
+	if errs := validateConfig([]byte(`{"approaching_threshold": "soon"}`)); len(errs) != 1 {
+		t.Errorf("expected an invalid duration error, got %v", errs)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+// Departures for several stations in one request.
+//
+//   GET /api/departures/bulk?ids=635,R14,L01           one entry per station, for dashboards
+//   GET /api/departures/any?ids=D25,R31&lat=..&lon=..  one merged list for riders who can
+//                                                       use either station
+//
+// Both accept the same filters as by-id. Each feed the stations need is fetched once per
+// request, however many stations share it.
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// maxBulkStations caps ids per bulk request
+const maxBulkStations = 20
+
+// BulkResponse is the /api/departures/bulk body
+type BulkResponse struct {
+	Stations                []NearestResponse `json:"stations"`
+	NotFound                []string          `json:"not_found,omitempty"` // requested IDs with no station
+	SuggestedRefreshSeconds int               `json:"suggested_refresh_seconds"`
+	Meta                    *ResponseMeta     `json:"meta,omitempty"` // see meta.go
+}
+
+// feedMemo wraps a fetch so each feed URL is fetched at most once, even by concurrent callers
+type feedMemo struct {
+	fetch func(string) (*gtfs_realtime.FeedMessage, error)
+	mu    sync.Mutex
+	calls map[string]*feedCall
+}
+
+type feedCall struct {
+	once sync.Once
+	feed *gtfs_realtime.FeedMessage
+	err  error
+}
+
+func newFeedMemo(fetch func(string) (*gtfs_realtime.FeedMessage, error)) *feedMemo {
+	return &feedMemo{fetch: fetch, calls: map[string]*feedCall{}}
+}
+
+func (m *feedMemo) get(url string) (*gtfs_realtime.FeedMessage, error) {
+	m.mu.Lock()
+	c, ok := m.calls[url]
+	if !ok {
+		c = &feedCall{}
+		m.calls[url] = c
+	}
+	m.mu.Unlock()
+	c.once.Do(func() { c.feed, c.err = m.fetch(url) })
+	return c.feed, c.err
+}
+
+// stationByID finds the station with the same parent stop ID (ignoring the N/S suffix)
+func stationByID(id string) (Station, bool) {
+	baseID := parentStopID(id)
+	for _, s := range data().Stations {
+		if parentStopID(s.StopID) == baseID {
+			return s, true
+		}
+	}
+	return Station{}, false
+}
+
+// parseStationIDs reads the comma-separated ids parameter, dropping blanks and repeats
+// of the same station
+func parseStationIDs(r *http.Request, max int) ([]string, error) {
+	var ids []string
+	seen := map[string]bool{}
+	for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[parentStopID(id)] {
+			continue
+		}
+		seen[parentStopID(id)] = true
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("missing ids")
+	}
+	if len(ids) > max {
+		return nil, fmt.Errorf("too many ids (max %d)", max)
+	}
+	return ids, nil
+}
+
+func handleBulk(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	ids, err := parseStationIDs(r, maxBulkStations)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter, err := parseDepartureFilter(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp := BulkResponse{Stations: []NearestResponse{}}
+	var matched []Station
+	for _, id := range ids {
+		if s, ok := stationByID(id); ok {
+			matched = append(matched, s)
+		} else {
+			resp.NotFound = append(resp.NotFound, id)
+		}
+	}
+	if len(matched) == 0 {
+		httpError(w, http.StatusNotFound, "no station matched by ids")
+		return
+	}
+
+	memo := newFeedMemo(fetchGTFS)
+	out := make([]NearestResponse, len(matched))
+	var wg sync.WaitGroup
+	for i, s := range matched {
+		wg.Add(1)
+		go func(i int, s Station) {
+			defer wg.Done()
+			stationUsage.mark(s.StopID)
+			deps, err := departuresFromSource(s, filter, memo.get)
+			if err != nil {
+				log.Printf("departuresFromSource error for %s: %v", s.StopID, err)
+			} else if shadowMode {
+				go shadowCompare(s, filter, deps)
+			}
+			warnings := feedWarnings(err)
+			out[i] = NearestResponse{Station: s, Photos: photosForStation(s), Transfers: transfersForStation(s), Alerts: alertsForStation(s), Cancellations: cancellationsForStation(s, filter, memo.get), Partial: len(warnings) > 0, Warnings: warnings, Departures: deps}
+			if cl, closed := closures.active(s.StopID, time.Now()); closed {
+				out[i].Closure = &cl
+			}
+		}(i, s)
+	}
+	wg.Wait()
+	resp.Stations = out
+	lists := make([][]Departure, len(out))
+	for i := range out {
+		lists[i] = out[i].Departures
+	}
+	resp.SuggestedRefreshSeconds = suggestedRefreshSeconds(lists...)
+	resp.Meta = departureMeta(filter, matched...)
+	log.Printf("handleBulk served %d stations from %d feed fetches", len(matched), len(memo.calls))
+	writeResponse(w, r, resp)
+	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+}
+
+// maxAnyStations caps ids per "either station" request
+const maxAnyStations = 5
+
+// AnyResponse is the /api/departures/any body
+type AnyResponse struct {
+	Stations                []AnyStation   `json:"stations"`
+	Departures              []AnyDeparture `json:"departures"` // every station's departures, soonest first
+	NotFound                []string       `json:"not_found,omitempty"`
+	Partial                 bool           `json:"partial,omitempty"`  // some feeds failed; see warnings
+	Warnings                []string       `json:"warnings,omitempty"` // e.g. "C/E data unavailable"
+	SuggestedRefreshSeconds int            `json:"suggested_refresh_seconds"`
+	Meta                    *ResponseMeta  `json:"meta,omitempty"` // see meta.go
+}
+
+// AnyStation is one of the stations in an "either station" response
+type AnyStation struct {
+	Station Station     `json:"station"`
+	Walking *WalkResult `json:"walking,omitempty"` // from lat/lon, when given
+	Closure *Closure    `json:"closure,omitempty"`
+}
+
+// AnyDeparture is a departure labeled with the station it leaves from
+type AnyDeparture struct {
+	Departure
+	Station     string `json:"station"` // gtfs_stop_id of the station
+	StationName string `json:"station_name"`
+	WalkSeconds *int64 `json:"walk_seconds,omitempty"` // walk to that station, when lat/lon given
+}
+
+func handleAny(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	ids, err := parseStationIDs(r, maxAnyStations)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter, err := parseDepartureFilter(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Walking times need an origin; without one the departures are still merged
+	var lat, lon float64
+	hasOrigin := r.URL.Query().Get("lat") != "" || r.URL.Query().Get("lon") != ""
+	if hasOrigin {
+		if lat, lon, err = parseLatLon(r); err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if outsideNYC(lat, lon) {
+			httpError(w, http.StatusBadRequest, "location outside NYC area")
+			return
+		}
+	}
+
+	resp := AnyResponse{Stations: []AnyStation{}, Departures: []AnyDeparture{}}
+	var matched []Station
+	for _, id := range ids {
+		if s, ok := stationByID(id); ok {
+			matched = append(matched, s)
+		} else {
+			resp.NotFound = append(resp.NotFound, id)
+		}
+	}
+	if len(matched) == 0 {
+		httpError(w, http.StatusNotFound, "no station matched by ids")
+		return
+	}
+
+	memo := newFeedMemo(fetchGTFS)
+	stationsOut := make([]AnyStation, len(matched))
+	perStation := make([][]AnyDeparture, len(matched))
+	warnings := make([][]string, len(matched))
+	var wg sync.WaitGroup
+	for i, s := range matched {
+		wg.Add(1)
+		go func(i int, s Station) {
+			defer wg.Done()
+			st := AnyStation{Station: s}
+			if cl, closed := closures.active(s.StopID, time.Now()); closed {
+				st.Closure = &cl
+			}
+			var walkSec *int64
+			if hasOrigin {
+				toLat, toLon, _ := walkDestination(s, filter.Direction, lat, lon)
+				walk := walkOrEstimate(modeWalk, lat, lon, toLat, toLon, false)
+				st.Walking = walk
+				sec := walkSeconds(haversine(lat, lon, toLat, toLon), walk)
+				walkSec = &sec
+			}
+			stationsOut[i] = st
+
+			stationUsage.mark(s.StopID)
+			deps, err := departuresFromSource(s, filter, memo.get)
+			if err != nil {
+				log.Printf("departuresFromSource error for %s: %v", s.StopID, err)
+			} else if shadowMode {
+				go shadowCompare(s, filter, deps)
+			}
+			warnings[i] = feedWarnings(err)
+			for _, d := range deps {
+				perStation[i] = append(perStation[i], AnyDeparture{Departure: d, Station: s.StopID, StationName: s.Name, WalkSeconds: walkSec})
+			}
+		}(i, s)
+	}
+	wg.Wait()
+
+	resp.Stations = stationsOut
+	for i, deps := range perStation {
+		resp.Departures = append(resp.Departures, deps...)
+		for _, w := range warnings[i] {
+			if !containsString(resp.Warnings, w) {
+				resp.Warnings = append(resp.Warnings, w)
+			}
+		}
+	}
+	resp.Partial = len(resp.Warnings) > 0
+	sort.SliceStable(resp.Departures, func(i, j int) bool { return resp.Departures[i].UnixTime < resp.Departures[j].UnixTime })
+	deps := make([]Departure, len(resp.Departures))
+	for i, d := range resp.Departures {
+		deps[i] = d.Departure
+	}
+	resp.SuggestedRefreshSeconds = suggestedRefreshSeconds(deps)
+	resp.Meta = departureMeta(filter, matched...)
+	writeJSON(w, resp)
+	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+}
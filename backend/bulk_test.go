@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestAPIBulk(t *testing.T) {
+	initTestCaches()
+	useTestFeeds(t)
+	keepTestData(t)
+	setTestStations([]Station{
+		{StopID: "635", Name: "14 St-Union Sq", Routes: []string{"4", "5", "6"}},
+		{StopID: "631", Name: "Grand Central-42 St", Routes: []string{"4", "5", "6"}},
+		{StopID: "L01", Name: "8 Av", Routes: []string{"L"}},
+	})
+
+	// One server stands in for the 4/5/6 feed and counts how often it is fetched
+	data, err := proto.Marshal(newTestFeed(
+		testTripUpdate("6", "trip6", []string{"631S", "635S"}, []int64{60, 300}),
+		testTripUpdate("4", "trip4", []string{"631N"}, []int64{120}),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write(data)
+	}))
+	defer server.Close()
+	lServer := newTestFeedServer(t, testTripUpdate("L", "tripL", []string{"L01S"}, []int64{90}))
+	originalRouteToFeed := routeToFeed
+	routeToFeed = map[string]string{"4": server.URL, "5": server.URL, "6": server.URL, "L": lServer.URL}
+	defer func() { routeToFeed = originalRouteToFeed }()
+
+	w := httptest.NewRecorder()
+	handleBulk(w, httptest.NewRequest("GET", "/api/departures/bulk?ids=635,631N,L01,635S,X99", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp BulkResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Stations) != 3 || resp.Stations[0].Station.StopID != "635" || resp.Stations[1].Station.StopID != "631" || resp.Stations[2].Station.StopID != "L01" {
+		t.Fatalf("expected stations in request order without duplicates, got %+v", resp.Stations)
+	}
+	if len(resp.NotFound) != 1 || resp.NotFound[0] != "X99" {
+		t.Errorf("expected X99 not found, got %v", resp.NotFound)
+	}
+	if len(resp.Stations[0].Departures) != 1 || len(resp.Stations[1].Departures) != 2 || len(resp.Stations[2].Departures) != 1 {
+		t.Errorf("unexpected departures %+v", resp.Stations)
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("expected the shared feed to be fetched once, got %d", n)
+	}
+
+	// Filters apply to every station
+	w = httptest.NewRecorder()
+	handleBulk(w, httptest.NewRequest("GET", "/api/departures/bulk?ids=635,631&direction=N", nil))
+	resp = BulkResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Stations[0].Departures) != 0 || len(resp.Stations[1].Departures) != 1 {
+		t.Errorf("expected only the northbound 4, got %+v", resp.Stations)
+	}
+
+	for _, tc := range []struct {
+		url  string
+		code int
+	}{
+		{"/api/departures/bulk", http.StatusBadRequest},
+		{"/api/departures/bulk?ids=,", http.StatusBadRequest},
+		{"/api/departures/bulk?ids=X98,X99", http.StatusNotFound},
+		{"/api/departures/bulk?ids=635&direction=E", http.StatusBadRequest},
+		{"/api/departures/bulk?ids=1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16,17,18,19,20,21", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		handleBulk(w, httptest.NewRequest("GET", tc.url, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s: expected %d, got %d", tc.url, tc.code, w.Code)
+		}
+	}
+}
+
+func TestAPIAny(t *testing.T) {
+	initTestCaches()
+	useTestFeeds(t)
+	useTestOSRM(t, 240, 300)
+	keepTestData(t)
+	setTestStations([]Station{
+		{StopID: "D25", Name: "7 Av", Lat: 40.67705, Lon: -73.972367, Routes: []string{"B", "Q"}},
+		{StopID: "R31", Name: "Atlantic Av-Barclays Ctr", Lat: 40.683666, Lon: -73.97881, Routes: []string{"D", "N", "R"}},
+	})
+	bServer := newTestFeedServer(t,
+		testTripUpdate("Q", "q1", []string{"D25N"}, []int64{400}),
+		testTripUpdate("B", "b1", []string{"D25N"}, []int64{100}),
+	)
+	nServer := newTestFeedServer(t, testTripUpdate("R", "r1", []string{"R31N"}, []int64{250}))
+	originalRouteToFeed := routeToFeed
+	routeToFeed = map[string]string{"B": bServer.URL, "Q": bServer.URL, "D": bServer.URL, "N": nServer.URL, "R": nServer.URL}
+	defer func() { routeToFeed = originalRouteToFeed }()
+
+	w := httptest.NewRecorder()
+	handleAny(w, httptest.NewRequest("GET", "/api/departures/any?ids=D25,R31&lat=40.6800&lon=-73.9750", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp AnyResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Stations) != 2 || resp.Stations[0].Walking == nil || resp.Stations[0].Walking.Seconds != 240 {
+		t.Errorf("expected both stations with walking times, got %+v", resp.Stations)
+	}
+	var got []string
+	for _, d := range resp.Departures {
+		got = append(got, d.TripID+"@"+d.Station)
+		if d.WalkSeconds == nil || *d.WalkSeconds != 240 {
+			t.Errorf("expected walk_seconds on %s, got %v", d.TripID, d.WalkSeconds)
+		}
+	}
+	if len(got) != 3 || got[0] != "b1@D25" || got[1] != "r1@R31" || got[2] != "q1@D25" {
+		t.Errorf("expected departures co-sorted across stations, got %v", got)
+	}
+
+	// Without an origin departures are still merged, just without walking times
+	w = httptest.NewRecorder()
+	handleAny(w, httptest.NewRequest("GET", "/api/departures/any?ids=D25,R31", nil))
+	resp = AnyResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Departures) != 3 || resp.Departures[0].WalkSeconds != nil || resp.Stations[0].Walking != nil {
+		t.Errorf("expected merged departures without walking, got %+v", resp)
+	}
+
+	for _, tc := range []struct {
+		url  string
+		code int
+	}{
+		{"/api/departures/any", http.StatusBadRequest},
+		{"/api/departures/any?ids=D25,R31&lat=40.68", http.StatusBadRequest},
+		{"/api/departures/any?ids=D25&lat=34.05&lon=-118.24", http.StatusBadRequest},
+		{"/api/departures/any?ids=A1,A2,A3,A4,A5,A6", http.StatusBadRequest},
+		{"/api/departures/any?ids=X99", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		handleAny(w, httptest.NewRequest("GET", tc.url, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s: expected %d, got %d", tc.url, tc.code, w.Code)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+// Departures by station name, with optional disambiguation:
+//
+//   GET /api/departures/by-name?name=23 St&route=F
+//   GET /api/departures/by-name?name=Canal St&borough=M
+//
+// Names match case-, space- and punctuation-insensitively against the stations CSV and
+// official GTFS names. route= keeps stations served by that route and borough= (M, Bk,
+// Q, Bx, SI or the full name) keeps stations in that borough. Rows of one complex count
+// as one station. If the name still matches several stations the response is 409 with the
+// candidates, so clients can ask the rider or retry with a qualifier.
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// boroughCodes maps accepted borough spellings to the stations CSV codes
+var boroughCodes = map[string]string{
+	"m": "M", "manhattan": "M",
+	"bk": "Bk", "brooklyn": "Bk",
+	"q": "Q", "queens": "Q",
+	"bx": "Bx", "bronx": "Bx", "the bronx": "Bx",
+	"si": "SI", "staten island": "SI",
+}
+
+// AmbiguousNameResponse is the 409 body when a name matches several stations
+type AmbiguousNameResponse struct {
+	Error      string         `json:"error"`
+	Candidates []RiderStation `json:"candidates"`
+}
+
+// nameKey folds a station name for matching: "14 St - Union Sq" and "14 st-union sq" agree
+func nameKey(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// stationsByName returns the station complexes matching name and the qualifiers
+func stationsByName(name, route, borough string) []RiderStation {
+	key := nameKey(name)
+	var rows []Station
+	for _, s := range data().Stations {
+		if nameKey(s.Name) != key && (s.OfficialName == "" || nameKey(s.OfficialName) != key) {
+			continue
+		}
+		if borough != "" && s.Borough != borough {
+			continue
+		}
+		rows = append(rows, s)
+	}
+	groups := mergeStations(rows)
+	if route == "" {
+		return groups
+	}
+	var out []RiderStation
+	for _, g := range groups {
+		if containsRouteFold(g.Routes, route) {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+func handleByName(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	q := r.URL.Query()
+	name := strings.TrimSpace(q.Get("name"))
+	if nameKey(name) == "" {
+		httpError(w, http.StatusBadRequest, "missing name")
+		return
+	}
+	route := strings.TrimSpace(q.Get("route"))
+	borough := ""
+	if v := strings.TrimSpace(q.Get("borough")); v != "" {
+		code, ok := boroughCodes[strings.ToLower(v)]
+		if !ok {
+			httpError(w, http.StatusBadRequest, "borough must be one of M, Bk, Q, Bx, SI")
+			return
+		}
+		borough = code
+	}
+	filter, err := parseDepartureFilter(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	matched := stationsByName(name, route, borough)
+	switch {
+	case len(matched) == 0:
+		httpError(w, http.StatusNotFound, "no station matched by name")
+		return
+	case len(matched) > 1:
+		log.Printf("handleByName: %q matches %d stations", name, len(matched))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(AmbiguousNameResponse{Error: "name matches several stations; add route= or borough=", Candidates: matched})
+		return
+	}
+
+	// Within the complex, prefer the platform serving the requested route
+	station := matched[0].Station
+	for _, id := range matched[0].StopIDs {
+		if s, ok := stationByID(id); ok && (route == "" || containsRouteFold(s.Routes, route)) {
+			station = s
+			break
+		}
+	}
+	deps, err := departuresForStation(station, filter)
+	warnings, err := splitPartial(err)
+	if err != nil {
+		httpError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	resp := NearestResponse{Station: station, Photos: photosForStation(station), Transfers: transfersForStation(station), Alerts: alertsForStation(station), Cancellations: cancellationsForStation(station, filter, fetchGTFS), Partial: len(warnings) > 0, Warnings: warnings, Departures: deps, SuggestedRefreshSeconds: suggestedRefreshSeconds(deps), Meta: departureMeta(filter, station)}
+	if cl, closed := closures.active(station.StopID, time.Now()); closed {
+		resp.Closure = &cl
+	}
+	writeResponse(w, r, resp)
+	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+}
+
+func containsRouteFold(routes []string, route string) bool {
+	for _, r := range routes {
+		if strings.EqualFold(r, route) {
+			return true
+		}
+	}
+	return false
+}
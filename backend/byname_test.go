@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStationsByName(t *testing.T) {
+	keepTestData(t)
+	setTestStations([]Station{
+		{StopID: "A30", Name: "23 St", Lat: 40.745906, Lon: -73.998041, Routes: []string{"C", "E"}, Borough: "M"},
+		{StopID: "D18", Name: "23 St", Lat: 40.742878, Lon: -73.992821, Routes: []string{"F", "M"}, Borough: "M"},
+		{StopID: "G22", Name: "Court Sq-23 St", Lat: 40.747023, Lon: -73.945264, Routes: []string{"E", "M"}, Borough: "Q"},
+		{StopID: "M18", Name: "Delancey St-Essex St", Lat: 40.718611, Lon: -73.988114, Routes: []string{"J", "M", "Z"}, Borough: "M", ComplexID: "625"},
+		{StopID: "F15", Name: "Delancey St - Essex St", Lat: 40.718315, Lon: -73.987437, Routes: []string{"F"}, Borough: "M", ComplexID: "625"},
+		{StopID: "R23", Name: "Canal St", Lat: 40.719527, Lon: -74.001775, Routes: []string{"N", "Q", "R", "W"}, Borough: "M"},
+		{StopID: "M20", Name: "Canal St", Lat: 40.718092, Lon: -73.999892, Routes: []string{"J", "Z"}, Borough: "M"},
+	})
+
+	if got := stationsByName("23 st", "", ""); len(got) != 2 {
+		t.Errorf("expected two 23 St stations, got %+v", got)
+	}
+	if got := stationsByName("23 St", "f", ""); len(got) != 1 || got[0].StopID != "D18" {
+		t.Errorf("expected route F to pick D18, got %+v", got)
+	}
+	if got := stationsByName("23 St", "", "Q"); len(got) != 0 {
+		t.Errorf("expected no 23 St in Queens, got %+v", got)
+	}
+	if got := stationsByName("Delancey St-Essex St", "", ""); len(got) != 1 || len(got[0].StopIDs) != 2 {
+		t.Errorf("expected the complex as one station, got %+v", got)
+	}
+	// Same-named rows close together merge into one station
+	if got := stationsByName("Canal St", "", "M"); len(got) != 1 {
+		t.Errorf("expected Canal St rows within the merge distance to merge, got %+v", got)
+	}
+}
+
+func TestAPIByName(t *testing.T) {
+	initTestCaches()
+	useTestFeeds(t, newTestFeedServer(t).URL)
+	keepTestData(t)
+	setTestStations([]Station{
+		{StopID: "A30", Name: "23 St", Lat: 40.745906, Lon: -73.998041, Routes: []string{"C", "E"}, Borough: "M"},
+		{StopID: "D18", Name: "23 St", Lat: 40.742878, Lon: -73.992821, Routes: []string{"F", "M"}, Borough: "M"},
+		{StopID: "M18", Name: "Delancey St-Essex St", Lat: 40.718611, Lon: -73.988114, Routes: []string{"J", "M", "Z"}, Borough: "M", ComplexID: "625"},
+		{StopID: "F15", Name: "Delancey St-Essex St", Lat: 40.718315, Lon: -73.987437, Routes: []string{"F"}, Borough: "M", ComplexID: "625"},
+	})
+	originalRouteToFeed := routeToFeed
+	routeToFeed = map[string]string{}
+	defer func() { routeToFeed = originalRouteToFeed }()
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handleByName(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	w := get("/api/departures/by-name?name=23%20St")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for an ambiguous name, got %d", w.Code)
+	}
+	var amb AmbiguousNameResponse
+	if err := json.NewDecoder(w.Body).Decode(&amb); err != nil || len(amb.Candidates) != 2 {
+		t.Errorf("expected two candidates, got %+v (%v)", amb, err)
+	}
+
+	w = get("/api/departures/by-name?name=23%20St&route=F")
+	var resp NearestResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || w.Code != http.StatusOK || resp.Station.StopID != "D18" {
+		t.Errorf("expected D18, got %d %+v", w.Code, resp.Station)
+	}
+
+	// Within a complex the platform serving the route is used
+	w = get("/api/departures/by-name?name=delancey%20st%20essex%20st&route=F&borough=manhattan")
+	resp = NearestResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Station.StopID != "F15" {
+		t.Errorf("expected the F platform, got %d %+v", w.Code, resp.Station)
+	}
+
+	for url, code := range map[string]int{
+		"/api/departures/by-name":                          http.StatusBadRequest,
+		"/api/departures/by-name?name=23%20St&borough=nj":  http.StatusBadRequest,
+		"/api/departures/by-name?name=23%20St&route=G":     http.StatusNotFound,
+		"/api/departures/by-name?name=Nowhere":             http.StatusNotFound,
+		"/api/departures/by-name?name=23%20St&borough=Bk":  http.StatusNotFound,
+		"/api/departures/by-name?name=23%20St&direction=X": http.StatusBadRequest,
+	} {
+		if w := get(url); w.Code != code {
+			t.Errorf("%s: expected %d, got %d", url, code, w.Code)
+		}
+	}
+}
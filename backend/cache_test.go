@@ -5,6 +5,7 @@ import (
 	"math"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -167,6 +168,125 @@ func TestWalkingTimeCache(t *testing.T) {
 	}
 }
 
+func TestWalkingRouteDirections(t *testing.T) {
+	initTestCaches()
+
+	var gotQuery string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"routes": [{
+				"duration": 300,
+				"distance": 400,
+				"geometry": "_p~iF~ps|U_ulLnnqC",
+				"legs": [{"steps": [
+					{"duration": 100, "distance": 150, "name": "W 42nd St", "maneuver": {"type": "depart"}},
+					{"duration": 200, "distance": 250, "name": "Broadway", "maneuver": {"type": "turn", "modifier": "left"}},
+					{"duration": 0, "distance": 0, "name": "", "maneuver": {"type": "arrive"}}
+				]}]
+			}]
+		}`))
+	}))
+	defer mockServer.Close()
+
+	originalBase := osrmBaseURL
+	osrmBaseURL = mockServer.URL
+	defer func() { osrmBaseURL = originalBase }()
+
+	result, err := walkingRoute(40.7580, -73.9855, 40.7527, -73.9772, true)
+	if err != nil {
+		t.Fatalf("walkingRoute failed: %v", err)
+	}
+	if !strings.Contains(gotQuery, "steps=true") || !strings.Contains(gotQuery, "overview=full") {
+		t.Errorf("expected full overview with steps, got query %q", gotQuery)
+	}
+	if result.Geometry != "_p~iF~ps|U_ulLnnqC" {
+		t.Errorf("unexpected geometry %q", result.Geometry)
+	}
+	if len(result.Steps) != 3 {
+		t.Fatalf("expected 3 steps, got %d", len(result.Steps))
+	}
+	if result.Steps[1].Instruction != "Turn left onto Broadway" {
+		t.Errorf("unexpected instruction %q", result.Steps[1].Instruction)
+	}
+	if got := describeManeuver("turn", "", "Broadway"); got != "Turn onto Broadway" {
+		t.Errorf("unexpected instruction without a modifier %q", got)
+	}
+
+	// Plain walking time must not reuse the directions cache entry
+	plain, err := walkingTime(40.7580, -73.9855, 40.7527, -73.9772)
+	if err != nil {
+		t.Fatalf("walkingTime failed: %v", err)
+	}
+	if !strings.Contains(gotQuery, "overview=false") {
+		t.Errorf("expected overview=false for plain request, got %q", gotQuery)
+	}
+	if plain.Geometry != "" || len(plain.Steps) != 0 {
+		t.Errorf("plain walking result should not include directions: %+v", plain)
+	}
+}
+
+func TestNearestRouteGeometry(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "635", Name: "14 St-Union Sq", Lat: 40.734673, Lon: -73.989951}})
+	useTestFeeds(t, newTestFeedServer(t, testTripUpdate("6", "trip6", []string{"635S"}, []int64{300})).URL)
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.RawQuery, "steps=true") {
+			w.Write([]byte(`{"routes": [{"duration": 300, "distance": 400}]}`))
+			return
+		}
+		w.Write([]byte(`{"routes": [{"duration": 300, "distance": 400, "geometry": "_p~iF~ps|U_ulLnnqC",
+			"legs": [{"steps": [{"duration": 300, "distance": 400, "name": "Broadway", "maneuver": {"type": "depart"}}]}]}]}`))
+	}))
+	defer mockServer.Close()
+	originalBase := osrmBaseURL
+	osrmBaseURL = mockServer.URL
+	defer func() { osrmBaseURL = originalBase }()
+
+	// geometry=true on nearest and nearest-multi; directions=true is the same thing
+	for _, tc := range []struct {
+		handler http.HandlerFunc
+		query   string
+	}{
+		{handleNearest, "/api/departures/nearest?lat=40.7375&lon=-73.9880&geometry=true"},
+		{handleNearest, "/api/departures/nearest?lat=40.7375&lon=-73.9880&directions=true"},
+		{handleNearestMulti, "/api/departures/nearest-multi?lat=40.7375&lon=-73.9880&count=1&geometry=true"},
+	} {
+		w := httptest.NewRecorder()
+		tc.handler(w, httptest.NewRequest("GET", tc.query, nil))
+		var walk *WalkResult
+		if strings.Contains(tc.query, "nearest-multi") {
+			var resp MultiNearestResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || len(resp.Stations) != 1 {
+				t.Fatalf("%s: %v %+v", tc.query, err, resp)
+			}
+			walk = resp.Stations[0].Walking
+		} else {
+			var resp NearestResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("%s: %v", tc.query, err)
+			}
+			walk = resp.Walking
+		}
+		if walk == nil || walk.Geometry != "_p~iF~ps|U_ulLnnqC" || len(walk.Steps) != 1 {
+			t.Errorf("%s: expected the route geometry and steps, got %+v", tc.query, walk)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleNearest(w, httptest.NewRequest("GET", "/api/departures/nearest?lat=40.7375&lon=-73.9880", nil))
+	var resp NearestResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Walking == nil || resp.Walking.Geometry != "" || len(resp.Walking.Steps) != 0 {
+		t.Errorf("expected no geometry by default, got %+v", resp.Walking)
+	}
+}
+
 func TestCacheKeyQuantization(t *testing.T) {
 	// Test that nearby coordinates generate the same cache key
 	lat1, lon1 := 40.7847782, -73.9711486
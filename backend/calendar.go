@@ -0,0 +1,193 @@
+package main
+
+// Service calendars from calendar.txt and calendar_dates.txt.
+//
+// A trip's service_id says which days it runs on. calendar.txt gives each service its
+// weekdays and date range, and calendar_dates.txt adds (exception_type 1) or removes
+// (exception_type 2) single dates, which is how the MTA runs Sunday service on holidays.
+// Headsign selection and the schedule use the calendar when it knows a service; services
+// it doesn't list, or feeds without a calendar, fall back to guessing from the
+// Weekday/Saturday/Sunday service ID naming.
+//
+// The supplemented GTFS carries its own calendar with the latest service changes, and is
+// consulted first.
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// gtfsDateLayout is the GTFS YYYYMMDD date format
+const gtfsDateLayout = "20060102"
+
+// serviceCalendar is the parsed calendar of one GTFS zip
+type serviceCalendar struct {
+	Services   map[string]calendarService
+	Exceptions map[string]map[string]bool // YYYYMMDD -> service ID -> added (true) or removed (false)
+}
+
+// calendarService is one calendar.txt row
+type calendarService struct {
+	Days       [7]bool // indexed by time.Weekday
+	Start, End string  // YYYYMMDD, inclusive
+}
+
+// runs reports whether a service operates on a service date, and whether the calendar
+// knows the service at all
+func (c *serviceCalendar) runs(serviceID string, date time.Time) (runs, known bool) {
+	if c == nil {
+		return false, false
+	}
+	day := date.Format(gtfsDateLayout)
+	if added, ok := c.Exceptions[day][serviceID]; ok {
+		return added, true
+	}
+	s, ok := c.Services[serviceID]
+	if !ok {
+		return false, false
+	}
+	return s.Days[date.Weekday()] && day >= s.Start && day <= s.End, true
+}
+
+// serviceRunsOn reports whether a service operates on a service date, from the
+// supplemented calendar, the static calendar, or failing both the service ID's name
+func serviceRunsOn(serviceID string, date time.Time) bool {
+	ds := data()
+	for _, c := range []*serviceCalendar{ds.SuppCalendar, ds.Calendar} {
+		if runs, known := c.runs(serviceID, date); known {
+			return runs
+		}
+	}
+	return matchServiceID(serviceID, serviceDayName(date))
+}
+
+// serviceKnown reports whether any loaded calendar lists a service
+func serviceKnown(serviceID string, date time.Time) bool {
+	ds := data()
+	for _, c := range []*serviceCalendar{ds.SuppCalendar, ds.Calendar} {
+		if _, known := c.runs(serviceID, date); known {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTripService picks the candidate trip whose service runs on date. Without calendar
+// entries for any candidate it falls back to findBestServiceMatch on the day's name.
+func matchTripService(matches []Trip, date time.Time, tripID string) (Trip, bool) {
+	calendared := false
+	for _, m := range matches {
+		if serviceKnown(m.ServiceID, date) {
+			calendared = true
+			break
+		}
+	}
+	if !calendared {
+		return findBestServiceMatch(matches, serviceDayName(date), tripID)
+	}
+	var running []Trip
+	for _, m := range matches {
+		if serviceRunsOn(m.ServiceID, date) {
+			running = append(running, m)
+		}
+	}
+	if len(running) == 0 {
+		return Trip{}, false
+	}
+	if len(running) > 1 {
+		log.Printf("Warning: Multiple services run trip %s on %s: %d matches", tripID, date.Format(gtfsDateLayout), len(running))
+	}
+	return running[0], true
+}
+
+// parseCalendar reads calendar.txt
+func parseCalendar(rd io.Reader, cal *serviceCalendar) error {
+	r := csv.NewReader(rd)
+	r.FieldsPerRecord = -1
+	days := []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+	idx, err := parseCSVHeaders(r, append([]string{"service_id", "start_date", "end_date"}, days...), "calendar")
+	if err != nil {
+		return err
+	}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read calendar row: %w", err)
+		}
+		var s calendarService
+		for i, d := range days {
+			s.Days[i] = row[idx[d]] == "1"
+		}
+		s.Start, s.End = row[idx["start_date"]], row[idx["end_date"]]
+		cal.Services[row[idx["service_id"]]] = s
+	}
+}
+
+// parseCalendarDates reads calendar_dates.txt. Services it lists that calendar.txt
+// doesn't run only on their added dates.
+func parseCalendarDates(rd io.Reader, cal *serviceCalendar) error {
+	r := csv.NewReader(rd)
+	r.FieldsPerRecord = -1
+	idx, err := parseCSVHeaders(r, []string{"service_id", "date", "exception_type"}, "calendar_dates")
+	if err != nil {
+		return err
+	}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read calendar_dates row: %w", err)
+		}
+		day, service := row[idx["date"]], row[idx["service_id"]]
+		if _, ok := cal.Services[service]; !ok {
+			cal.Services[service] = calendarService{}
+		}
+		if cal.Exceptions[day] == nil {
+			cal.Exceptions[day] = map[string]bool{}
+		}
+		switch row[idx["exception_type"]] {
+		case "1":
+			cal.Exceptions[day][service] = true
+		case "2":
+			cal.Exceptions[day][service] = false
+		}
+	}
+}
+
+// loadCalendar parses calendar.txt and calendar_dates.txt from a GTFS zip. Either may be
+// missing, but not both.
+func loadCalendar(zf *gtfsZip) (*serviceCalendar, error) {
+	cal := &serviceCalendar{Services: map[string]calendarService{}, Exceptions: map[string]map[string]bool{}}
+	found := false
+	for _, member := range []struct {
+		name  string
+		parse func(io.Reader, *serviceCalendar) error
+	}{
+		{"calendar.txt", parseCalendar},
+		{"calendar_dates.txt", parseCalendarDates},
+	} {
+		rc, err := zf.openMember(member.name)
+		if err != nil {
+			continue
+		}
+		err = member.parse(rc, cal)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("no calendar.txt or calendar_dates.txt in GTFS zip")
+	}
+	log.Printf("Loaded calendar with %d services and exceptions on %d dates", len(cal.Services), len(cal.Exceptions))
+	return cal, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const (
+	testCalendar = `service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date
+Weekday,1,1,1,1,1,0,0,20260101,20261231
+Sunday,0,0,0,0,0,0,1,20260101,20261231
+`
+	// Thanksgiving runs Sunday service, and a one-off service runs on the 27th
+	testCalendarDates = `service_id,date,exception_type
+Weekday,20261126,2
+Sunday,20261126,1
+Special,20261127,1
+`
+)
+
+func useTestCalendar(t *testing.T) {
+	keepTestData(t)
+
+	server := newTestGTFSServer(t, map[string]string{"calendar.txt": testCalendar, "calendar_dates.txt": testCalendarDates})
+	defer server.Close()
+	zf, err := openGTFSZip(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zf.Close()
+	cal, err := loadCalendar(zf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setTestCalendar(cal)
+	setTestSuppCalendar(nil)
+}
+
+func TestServiceRunsOn(t *testing.T) {
+	useTestCalendar(t)
+	day := func(s string) time.Time {
+		d, _ := time.ParseInLocation(gtfsDateLayout, s, transitLocation)
+		return d
+	}
+	for _, tt := range []struct {
+		service, date string
+		want          bool
+	}{
+		{"Weekday", "20261125", true},
+		{"Sunday", "20261125", false},
+		{"Weekday", "20261126", false}, // holiday
+		{"Sunday", "20261126", true},
+		{"Special", "20261127", true},
+		{"Special", "20261128", false},
+		{"Weekday", "20270104", false}, // past end_date
+		{"Saturday", "20261128", true}, // not in the calendar, matched by name
+	} {
+		if got := serviceRunsOn(tt.service, day(tt.date)); got != tt.want {
+			t.Errorf("serviceRunsOn(%s, %s) = %v, want %v", tt.service, tt.date, got, tt.want)
+		}
+	}
+
+	// The supplemented calendar wins where it knows the service
+	setTestSuppCalendar(&serviceCalendar{Services: map[string]calendarService{}, Exceptions: map[string]map[string]bool{"20261125": {"Weekday": false}}})
+	if serviceRunsOn("Weekday", day("20261125")) {
+		t.Error("expected the supplemented calendar's exception to apply")
+	}
+}
+
+func TestHeadsignOnHoliday(t *testing.T) {
+	useTestCalendar(t)
+	keepTestData(t)
+	originalNow := nowFunc
+	t.Cleanup(func() { nowFunc = originalNow })
+	setTestSupplementedTrips(nil)
+	setTestTrips([]Trip{
+		{TripID: "Weekday-063000_6..S", ServiceID: "Weekday", TripHeadsign: "Brooklyn Bridge"},
+		{TripID: "Sunday-063000_6..S", ServiceID: "Sunday", TripHeadsign: "Brooklyn Bridge-City Hall"},
+	})
+
+	at := func(s string) {
+		nowFunc = func() time.Time { t, _ := time.ParseInLocation("20060102 15:04", s, transitLocation); return t }
+	}
+	at("20261125 10:00")
+	if got := lookupHeadsignWithSupplemented("063000_6..S"); got != "Brooklyn Bridge" {
+		t.Errorf("weekday headsign = %q", got)
+	}
+	at("20261126 10:00")
+	if got := lookupHeadsignWithSupplemented("063000_6..S"); got != "Brooklyn Bridge-City Hall" {
+		t.Errorf("holiday headsign = %q, want the Sunday trip's", got)
+	}
+	if trip, _ := findStaticTrip("063000_6..S"); trip.ServiceID != "Sunday" {
+		t.Errorf("holiday static trip = %+v", trip)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+// Cancellations and reroutes.
+//
+// Trains that won't come just vanish from the departures list (see schedrel.go), which
+// leaves riders wondering where their train went. Station responses list them under
+// "cancellations", each with a reason:
+//
+//   - canceled: the trip update is marked CANCELED, or an alert naming the trip has
+//     effect NO_SERVICE
+//   - rerouted: the trip update marks the station SKIPPED; the trip stopped here in an
+//     earlier version of its feed and no longer does, though the train hadn't got here
+//     yet; or an alert naming the trip has effect DETOUR, STOP_MOVED or MODIFIED_SERVICE
+//
+// Reroutes seen between feed versions are remembered for as long as the trip stays in the
+// feed. A trip counts as at the station if its trip update or its static schedule stops
+// there, or an alert names the trip at this stop.
+
+import (
+	"log"
+	"sort"
+	"sync"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// Cancellation is a trip that was due at a station but won't stop there
+type Cancellation struct {
+	TripID    string `json:"trip_id"`
+	RouteID   string `json:"route_id,omitempty"`
+	Direction string `json:"direction,omitempty"`
+	Reason    string `json:"reason"`             // canceled or rerouted
+	AlertID   string `json:"alert_id,omitempty"` // the alert that reported it, if any
+}
+
+const (
+	reasonCanceled = "canceled"
+	reasonRerouted = "rerouted"
+)
+
+// alertTripReasons maps alert effects on a single trip to cancellation reasons
+var alertTripReasons = map[gtfs_realtime.Alert_Effect]string{
+	gtfs_realtime.Alert_NO_SERVICE:       reasonCanceled,
+	gtfs_realtime.Alert_DETOUR:           reasonRerouted,
+	gtfs_realtime.Alert_STOP_MOVED:       reasonRerouted,
+	gtfs_realtime.Alert_MODIFIED_SERVICE: reasonRerouted,
+}
+
+// feedTrips is one version of a feed: each trip's remaining stops, as parent stop IDs
+type feedTrips struct {
+	timestamp uint64
+	stops     map[string][]string
+}
+
+func tripStopsOf(feed *gtfs_realtime.FeedMessage) feedTrips {
+	out := feedTrips{timestamp: feed.GetHeader().GetTimestamp(), stops: map[string][]string{}}
+	for _, ent := range feed.GetEntity() {
+		tu := ent.GetTripUpdate()
+		if tu == nil || tu.GetTrip().GetTripId() == "" || tripCanceled(tu) {
+			continue
+		}
+		var stops []string
+		for _, stu := range tu.GetStopTimeUpdate() {
+			if stopServed(stu) {
+				stops = append(stops, parentStopID(stu.GetStopId()))
+			}
+		}
+		out.stops[tu.GetTrip().GetTripId()] = stops
+	}
+	return out
+}
+
+// droppedStops returns the stops of prev the train hadn't reached yet that cur no longer
+// serves
+func droppedStops(prev, cur []string) []string {
+	if len(cur) == 0 {
+		return nil // finished, or no predictions: nothing to compare
+	}
+	from := 0 // where the train is now along prev; 0 if it has left prev's path
+	for i, s := range prev {
+		if s == cur[0] {
+			from = i
+			break
+		}
+	}
+	serves := map[string]bool{}
+	for _, s := range cur {
+		serves[s] = true
+	}
+	var out []string
+	for _, s := range prev[from:] {
+		if !serves[s] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// rerouteTracker remembers, per feed, the stops each trip has dropped between versions
+type rerouteTracker struct {
+	mu     sync.Mutex
+	byFeed map[string]*feedReroutes
+}
+
+type feedReroutes struct {
+	last    feedTrips
+	dropped map[string]map[string]bool // trip ID -> parent stop IDs
+}
+
+var reroutes = &rerouteTracker{byFeed: map[string]*feedReroutes{}}
+
+// observe records a feed version, if it is new, and returns the trips' dropped stops
+func (r *rerouteTracker) observe(feedURL string, feed *gtfs_realtime.FeedMessage) map[string]map[string]bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fr, ok := r.byFeed[feedURL]
+	if !ok {
+		fr = &feedReroutes{last: tripStopsOf(feed), dropped: map[string]map[string]bool{}}
+		r.byFeed[feedURL] = fr
+		return fr.dropped
+	}
+	if feed.GetHeader().GetTimestamp() == fr.last.timestamp {
+		return fr.dropped
+	}
+	cur := tripStopsOf(feed)
+	for trip := range fr.dropped {
+		if _, ok := cur.stops[trip]; !ok {
+			delete(fr.dropped, trip)
+		}
+	}
+	for trip, stops := range cur.stops {
+		prev, ok := fr.last.stops[trip]
+		if !ok {
+			continue
+		}
+		for _, s := range droppedStops(prev, stops) {
+			if fr.dropped[trip] == nil {
+				fr.dropped[trip] = map[string]bool{}
+			}
+			fr.dropped[trip][s] = true
+		}
+	}
+	fr.last = cur
+	return fr.dropped
+}
+
+// feedCancellations lists the trips in feed canceled or rerouted at the station base
+// (a parent stop ID), given the feed's remembered reroutes
+func feedCancellations(feed *gtfs_realtime.FeedMessage, base string, dropped map[string]map[string]bool) []Cancellation {
+	var out []Cancellation
+	for _, ent := range feed.GetEntity() {
+		tu := ent.GetTripUpdate()
+		if tu == nil || tu.GetTrip().GetTripId() == "" {
+			continue
+		}
+		tripID := tu.GetTrip().GetTripId()
+		reason := ""
+		switch {
+		case tripCanceled(tu):
+			if tripStopsAt(tu, tripID, base) {
+				reason = reasonCanceled
+			}
+		case dropped[tripID][base]:
+			reason = reasonRerouted
+		default:
+			for _, stu := range tu.GetStopTimeUpdate() {
+				if parentStopID(stu.GetStopId()) == base && stu.GetScheduleRelationship() == gtfs_realtime.TripUpdate_StopTimeUpdate_SKIPPED {
+					reason = reasonRerouted
+					break
+				}
+			}
+		}
+		if reason != "" {
+			out = append(out, newCancellation(tripID, tu.GetTrip().GetRouteId(), reason, ""))
+		}
+	}
+	return out
+}
+
+// tripStopsAt reports whether a trip was due at the station base: its trip update lists
+// the stop, or its static trip is scheduled there
+func tripStopsAt(tu *gtfs_realtime.TripUpdate, tripID, base string) bool {
+	for _, stu := range tu.GetStopTimeUpdate() {
+		if parentStopID(stu.GetStopId()) == base {
+			return true
+		}
+	}
+	trip, ok := findStaticTrip(tripID)
+	return ok && data().StopTimes.callsAt(trip.TripID, base)
+}
+
+// alertCancellations lists the trips alerts report canceled or rerouted at the station base
+func alertCancellations(feed *gtfs_realtime.FeedMessage, base string, now int64) []Cancellation {
+	var out []Cancellation
+	for _, ent := range feed.GetEntity() {
+		a := ent.GetAlert()
+		if a == nil || ent.GetIsDeleted() {
+			continue
+		}
+		reason, ok := alertTripReasons[a.GetEffect()]
+		if !ok {
+			continue
+		}
+		if _, _, active := activePeriod(a.GetActivePeriod(), now, now); !active {
+			continue
+		}
+		for _, sel := range a.GetInformedEntity() {
+			tripID := sel.GetTrip().GetTripId()
+			if tripID == "" {
+				continue
+			}
+			if stop := sel.GetStopId(); stop != "" {
+				if parentStopID(stop) != base {
+					continue
+				}
+			} else if trip, ok := findStaticTrip(tripID); !ok || !data().StopTimes.callsAt(trip.TripID, base) {
+				continue
+			}
+			route := sel.GetTrip().GetRouteId()
+			if route == "" {
+				route = sel.GetRouteId()
+			}
+			out = append(out, newCancellation(tripID, route, reason, ent.GetId()))
+		}
+	}
+	return out
+}
+
+func newCancellation(tripID, routeID, reason, alertID string) Cancellation {
+	c := Cancellation{TripID: tripID, RouteID: routeID, Reason: reason, AlertID: alertID}
+	if id, ok := parseNYCTTripID(tripID); ok {
+		c.Direction = normalizeDirection(routeID, id.Direction)
+	}
+	return c
+}
+
+// cancellationsForStation lists the trips canceled or rerouted at a station on the routes
+// filter allows, fetching feeds with fetch (best-effort: feed errors are logged and
+// skipped). A trip both the feed and an alert report is listed once, with the alert.
+func cancellationsForStation(s Station, filter departureFilter, fetch func(string) (*gtfs_realtime.FeedMessage, error)) []Cancellation {
+	feedStation, ok := filter.feedStation(s)
+	if !ok {
+		return nil
+	}
+	base := parentStopID(s.StopID)
+	var out []Cancellation
+	for _, u := range getFeedsForStation(feedStation) {
+		feed, err := fetch(u)
+		if err != nil {
+			log.Printf("cancellations: feed %s: %v", u, err)
+			continue
+		}
+		out = append(out, feedCancellations(feed, base, reroutes.observe(u, feed))...)
+	}
+	if alertsFeedURL != "" {
+		if feed, err := fetchGTFS(alertsFeedURL); err != nil {
+			log.Printf("cancellations: alerts feed: %v", err)
+		} else {
+			out = append(alertCancellations(feed, base, nowFunc().Unix()), out...)
+		}
+	}
+	seen := map[string]bool{}
+	var uniq []Cancellation
+	for _, c := range out {
+		if !seen[c.TripID] && filter.allowsRoute(c.RouteID) {
+			seen[c.TripID] = true
+			uniq = append(uniq, c)
+		}
+	}
+	sort.SliceStable(uniq, func(i, j int) bool { return uniq[i].TripID < uniq[j].TripID })
+	return uniq
+}
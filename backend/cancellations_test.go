@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+func TestDroppedStops(t *testing.T) {
+	prev := []string{"A27", "A25", "A24", "A22"}
+	tests := []struct {
+		cur  []string
+		want []string
+	}{
+		{[]string{"A25", "A24", "A22"}, nil},      // moved on
+		{[]string{"A25", "A22"}, []string{"A24"}}, // skips 23 St
+		{[]string{"D14", "D13"}, prev},            // switched lines
+		{nil, nil},                                // no predictions
+		{[]string{"A24", "A22"}, nil},             // 34 St was behind the train
+	}
+	for _, tt := range tests {
+		if got := droppedStops(prev, tt.cur); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("droppedStops(%v) = %v, want %v", tt.cur, got, tt.want)
+		}
+	}
+}
+
+func TestCancellationsForStation(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+	station := Station{StopID: "A24", Name: "23 St", Routes: []string{"C"}}
+
+	rerouted := testTripUpdate("C", "048000_C..N04R", []string{"A27N", "A25N", "A24N"}, []int64{60, 120, 180})
+	canceled := testTripUpdate("C", "049000_C..N04R", []string{"A24N"}, []int64{300})
+	canceled.TripUpdate.Trip.ScheduleRelationship = gtfs_realtime.TripDescriptor_CANCELED.Enum()
+	skipping := testTripUpdate("C", "050000_C..N04R", []string{"A25N", "A24N"}, []int64{400, 460})
+	skipping.TripUpdate.StopTimeUpdate[1].ScheduleRelationship = gtfs_realtime.TripUpdate_StopTimeUpdate_SKIPPED.Enum()
+	running := testTripUpdate("C", "051000_C..N04R", []string{"A24N"}, []int64{500})
+
+	v1 := newTestFeed(rerouted, running)
+	v2 := newTestFeed(testTripUpdate("C", "048000_C..N04R", []string{"A27N", "A25N", "D14N"}, []int64{50, 110, 200}), canceled, skipping, running)
+	v2.Header.Timestamp = proto.Uint64(v1.GetHeader().GetTimestamp() + 30)
+	feed := v1
+	fetch := func(string) (*gtfs_realtime.FeedMessage, error) { return feed, nil }
+	useTestAlerts(t, &gtfs_realtime.FeedEntity{
+		Id: proto.String("alert-1"),
+		Alert: &gtfs_realtime.Alert{
+			Effect: gtfs_realtime.Alert_NO_SERVICE.Enum(),
+			InformedEntity: []*gtfs_realtime.EntitySelector{
+				{StopId: proto.String("A24"), Trip: &gtfs_realtime.TripDescriptor{TripId: proto.String("052000_C..N04R"), RouteId: proto.String("C")}},
+				{StopId: proto.String("A27"), Trip: &gtfs_realtime.TripDescriptor{TripId: proto.String("053000_C..N04R")}},
+			},
+		},
+	})
+
+	if got := cancellationsForStation(station, departureFilter{}, fetch); len(got) != 1 || got[0].AlertID != "alert-1" {
+		t.Fatalf("expected only the alert's trip before the feed changed, got %+v", got)
+	}
+	feed = v2
+	got := cancellationsForStation(station, departureFilter{}, fetch)
+	want := []Cancellation{
+		{TripID: "048000_C..N04R", RouteID: "C", Direction: "N", Reason: "rerouted"},
+		{TripID: "049000_C..N04R", RouteID: "C", Direction: "N", Reason: "canceled"},
+		{TripID: "050000_C..N04R", RouteID: "C", Direction: "N", Reason: "rerouted"},
+		{TripID: "052000_C..N04R", RouteID: "C", Direction: "N", Reason: "canceled", AlertID: "alert-1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cancellations = %+v\nwant %+v", got, want)
+	}
+	// The reroute is remembered while the trip stays in the feed
+	if again := cancellationsForStation(station, departureFilter{}, fetch); !reflect.DeepEqual(again, want) {
+		t.Errorf("expected the same cancellations on the same feed, got %+v", again)
+	}
+	if got := cancellationsForStation(station, departureFilter{Routes: map[string]bool{"E": true}}, fetch); len(got) != 0 {
+		t.Errorf("expected no C trips with route=E, got %+v", got)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+// Train length (car count) per departure.
+//
+// GTFS-RT can describe a consist as VehiclePosition.multi_carriage_details, one entry per
+// car. The MTA subway feeds don't publish it yet, and the NYCT extension only carries the
+// train ID, not its length. When a feed does report carriages, departures of that trip get
+// "car_count". Otherwise the car_counts config key supplies fixed lengths for routes that
+// always run the same consist, e.g. {"FS": 2, "GS": 6, "SI": 4}, so riders on short
+// platforms know where to stand. Routes not listed leave car_count out.
+
+import (
+	"encoding/json"
+	"fmt"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// maxCarCount is the longest consist in the system (11-car 7 trains) with room to spare
+const maxCarCount = 15
+
+// routeCarCounts is the configured consist length per route ID
+var routeCarCounts = map[string]int{}
+
+// vehicleCarCounts indexes a feed's reported consist lengths by trip ID
+func vehicleCarCounts(feed *gtfs_realtime.FeedMessage) map[string]int {
+	var out map[string]int
+	for _, ent := range feed.GetEntity() {
+		v := ent.GetVehicle()
+		if v == nil || len(v.GetMultiCarriageDetails()) == 0 || v.GetTrip().GetTripId() == "" {
+			continue
+		}
+		if out == nil {
+			out = map[string]int{}
+		}
+		out[v.GetTrip().GetTripId()] = len(v.GetMultiCarriageDetails())
+	}
+	return out
+}
+
+// carCount is a departure's consist length: the feed's report for the trip, else the
+// route's configured length, else 0 (unknown)
+func carCount(reported map[string]int, tripID, routeID string) int {
+	if n, ok := reported[tripID]; ok {
+		return n
+	}
+	return routeCarCounts[routeID]
+}
+
+// validateCarCounts checks the car_counts config value
+func validateCarCounts(v json.RawMessage) string {
+	var counts map[string]int
+	if err := json.Unmarshal(v, &counts); err != nil {
+		return fmt.Sprintf("expected an object of route ID to car count, got %s", v)
+	}
+	for route, n := range counts {
+		if n < 1 || n > maxCarCount {
+			return fmt.Sprintf("%s: car count must be between 1 and %d, got %d", route, maxCarCount, n)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+func testConsist(tripID string, cars int) *gtfs_realtime.FeedEntity {
+	v := &gtfs_realtime.VehiclePosition{Trip: &gtfs_realtime.TripDescriptor{TripId: proto.String(tripID)}}
+	for i := 0; i < cars; i++ {
+		v.MultiCarriageDetails = append(v.MultiCarriageDetails, &gtfs_realtime.VehiclePosition_CarriageDetails{CarriageSequence: proto.Uint32(uint32(i + 1))})
+	}
+	return &gtfs_realtime.FeedEntity{Id: proto.String("c-" + tripID), Vehicle: v}
+}
+
+func TestDepartureCarCount(t *testing.T) {
+	keepTestData(t)
+	originalCounts := routeCarCounts
+	defer func() { routeCarCounts = originalCounts }()
+	setTestStations([]Station{{StopID: "902", Name: "Times Sq - 42 St", Routes: []string{"GS", "1"}}})
+	routeCarCounts = map[string]int{"GS": 6}
+
+	feed := newTestFeed(
+		testTripUpdate("GS", "shuttle", []string{"902N"}, []int64{60}),
+		testTripUpdate("GS", "reported", []string{"902N"}, []int64{120}),
+		testTripUpdate("1", "local", []string{"902S"}, []int64{180}),
+		testConsist("reported", 4),
+		testConsist("empty", 0),
+	)
+	deps, err := departuresFromSource(data().Stations[0], departureFilter{}, func(string) (*gtfs_realtime.FeedMessage, error) { return feed, nil })
+	if err != nil || len(deps) != 3 {
+		t.Fatalf("expected three departures, got %+v (%v)", deps, err)
+	}
+	// Configured route length, the feed's report winning over it, and unknown
+	if deps[0].CarCount != 6 || deps[1].CarCount != 4 || deps[2].CarCount != 0 {
+		t.Errorf("unexpected car counts %d, %d, %d", deps[0].CarCount, deps[1].CarCount, deps[2].CarCount)
+	}
+}
+
+func TestValidateCarCounts(t *testing.T) {
+	errs := validateConfig([]byte(`{"car_counts": {"GS": 0}}`))
+	if len(errs) != 1 || !strings.Contains(errs[0], "GS: car count must be between 1 and 15") {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	if errs := validateConfig([]byte(`{"car_counts": {"FS": 2, "SI": 4}}`)); len(errs) != 0 {
+		t.Errorf("expected a valid config, got %v", errs)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+// Station closure overrides.
+//
+// GTFS keeps listing stations that are closed for long-term work (full station rehabs),
+// so operators can mark them closed in a JSON file (config key closures_file) or through
+// the admin API. Closed stations are skipped when picking nearest stations.
+//
+// A closure with an entrance closes only the station entrance at that point (matched
+// within entranceMatchRadius, see entrances.go): walks end at another entrance instead.
+//
+//   {"stop_id": "A12", "reason": "Station rehabilitation"}
+//   {"stop_id": "635", "entrance": {"lat": 40.7351, "lon": -73.9904}, "until": "2025-06-01T00:00:00Z"}
+//
+//   GET    /api/closures                                 list active closures
+//   POST   /api/closures                                 add/replace a closure (JSON body), admin token required
+//   DELETE /api/closures?stop_id=<id>[&entrance=<lat>,<lon>]  remove a closure, admin token required
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Closure marks a station, or one of its entrances, as temporarily closed
+type Closure struct {
+	StopID   string     `json:"stop_id"`
+	Entrance *Entrance  `json:"entrance,omitempty"` // closes just this entrance
+	Reason   string     `json:"reason,omitempty"`
+	Until    *time.Time `json:"until,omitempty"` // closure ends automatically at this time
+}
+
+// entranceMatchRadius is how close a closed entrance's point must be to an entrance
+const entranceMatchRadius = 25.0 // meters
+
+// key is where the closure is stored: the parent stop ID, plus the point for an entrance
+func (cl Closure) key() string {
+	key := parentStopID(cl.StopID)
+	if cl.Entrance != nil {
+		key += fmt.Sprintf("@%.6f,%.6f", cl.Entrance.Lat, cl.Entrance.Lon)
+	}
+	return key
+}
+
+func (cl Closure) activeAt(now time.Time) bool {
+	return cl.Until == nil || now.Before(*cl.Until)
+}
+
+type closureStore struct {
+	mu       sync.RWMutex
+	byStop   map[string]Closure // keyed by Closure.key
+	filePath string             // where edits are persisted (empty = memory only)
+}
+
+var closures = &closureStore{byStop: map[string]Closure{}}
+
+// adminToken guards mutating admin endpoints; when empty those endpoints are disabled
+var adminToken = ""
+
+// load replaces the store contents from a JSON array of closures
+func (c *closureStore) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read closures: %w", err)
+	}
+	var list []Closure
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("parse closures: %w", err)
+	}
+	byStop := make(map[string]Closure, len(list))
+	for _, cl := range list {
+		if cl.StopID == "" {
+			return fmt.Errorf("parse closures: entry without stop_id")
+		}
+		byStop[cl.key()] = cl
+	}
+	c.mu.Lock()
+	c.byStop = byStop
+	c.filePath = path
+	c.mu.Unlock()
+	log.Printf("Loaded %d station closures from %s", len(byStop), path)
+	return nil
+}
+
+// active returns the closure for a station if one is in effect at now
+func (c *closureStore) active(stopID string, now time.Time) (Closure, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	cl, ok := c.byStop[parentStopID(stopID)]
+	if !ok || !cl.activeAt(now) {
+		return Closure{}, false
+	}
+	return cl, true
+}
+
+// entranceClosed reports whether an entrance of a station is closed at now
+func (c *closureStore) entranceClosed(stopID string, e Entrance, now time.Time) bool {
+	parent := parentStopID(stopID)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, cl := range c.byStop {
+		if cl.Entrance != nil && parentStopID(cl.StopID) == parent && cl.activeAt(now) &&
+			haversine(e.Lat, e.Lon, cl.Entrance.Lat, cl.Entrance.Lon) <= entranceMatchRadius {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *closureStore) list(now time.Time) []Closure {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := []Closure{}
+	for _, cl := range c.byStop {
+		if cl.activeAt(now) {
+			out = append(out, cl)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].key() < out[j].key() })
+	return out
+}
+
+func (c *closureStore) set(cl Closure) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byStop[cl.key()] = cl
+	return c.saveLocked()
+}
+
+// remove deletes the closure stored under key (see Closure.key)
+func (c *closureStore) remove(key string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.byStop[key]; !ok {
+		return false, nil
+	}
+	delete(c.byStop, key)
+	return true, c.saveLocked()
+}
+
+// saveLocked writes the closures back to the overrides file so API edits survive restarts
+func (c *closureStore) saveLocked() error {
+	if c.filePath == "" {
+		return nil
+	}
+	list := make([]Closure, 0, len(c.byStop))
+	for _, cl := range c.byStop {
+		list = append(list, cl)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].key() < list[j].key() })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.filePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.filePath)
+}
+
+// isStationClosed reports whether a station is closed by an operator override
+func isStationClosed(s Station) bool {
+	_, closed := closures.active(s.StopID, nowFunc())
+	return closed
+}
+
+// isEntranceClosed reports whether a station entrance is closed by an operator override
+func isEntranceClosed(s Station, e Entrance) bool {
+	return closures.entranceClosed(s.StopID, e, nowFunc())
+}
+
+// parseEntrancePoint reads an entrance point given as "lat,lon"
+func parseEntrancePoint(v string) (*Entrance, error) {
+	latStr, lonStr, ok := strings.Cut(v, ",")
+	lat, latErr := strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+	lon, lonErr := strconv.ParseFloat(strings.TrimSpace(lonStr), 64)
+	if !ok || latErr != nil || lonErr != nil {
+		return nil, fmt.Errorf("invalid entrance %q (expected lat,lon)", v)
+	}
+	return &Entrance{Lat: lat, Lon: lon}, nil
+}
+
+// requireAdmin checks the bearer token for admin endpoints and writes the error response
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if adminToken == "" {
+		httpError(w, http.StatusForbidden, "admin API disabled (no admin token configured)")
+		return false
+	}
+	got := []byte(r.Header.Get("Authorization"))
+	if subtle.ConstantTimeCompare(got, []byte("Bearer "+adminToken)) != 1 {
+		httpError(w, http.StatusUnauthorized, "invalid admin token")
+		return false
+	}
+	return true
+}
+
+func handleClosures(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, closures.list(nowFunc()))
+	case http.MethodPost:
+		if !requireAdmin(w, r) {
+			return
+		}
+		var cl Closure
+		if err := json.NewDecoder(r.Body).Decode(&cl); err != nil {
+			httpError(w, http.StatusBadRequest, "invalid closure JSON")
+			return
+		}
+		cl.StopID = strings.TrimSpace(cl.StopID)
+		if cl.StopID == "" {
+			httpError(w, http.StatusBadRequest, "missing stop_id")
+			return
+		}
+		if cl.Entrance != nil && outsideNYC(cl.Entrance.Lat, cl.Entrance.Lon) {
+			httpError(w, http.StatusBadRequest, "entrance outside NYC area")
+			return
+		}
+		if err := closures.set(cl); err != nil {
+			httpError(w, http.StatusInternalServerError, "failed to save closures: "+err.Error())
+			return
+		}
+		if cl.Entrance != nil {
+			log.Printf("Entrance of %s at %.6f,%.6f marked closed: %s", cl.StopID, cl.Entrance.Lat, cl.Entrance.Lon, cl.Reason)
+		} else {
+			log.Printf("Station %s marked closed: %s", cl.StopID, cl.Reason)
+		}
+		writeJSON(w, cl)
+	case http.MethodDelete:
+		if !requireAdmin(w, r) {
+			return
+		}
+		id := strings.TrimSpace(r.URL.Query().Get("stop_id"))
+		if id == "" {
+			httpError(w, http.StatusBadRequest, "missing stop_id")
+			return
+		}
+		cl := Closure{StopID: id}
+		if v := r.URL.Query().Get("entrance"); v != "" {
+			e, err := parseEntrancePoint(v)
+			if err != nil {
+				httpError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			cl.Entrance = e
+		}
+		removed, err := closures.remove(cl.key())
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, "failed to save closures: "+err.Error())
+			return
+		}
+		if !removed {
+			httpError(w, http.StatusNotFound, "no closure for stop_id")
+			return
+		}
+		log.Printf("Closure %s lifted", cl.key())
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClosureStoreLoadAndExpiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "closures.json")
+	os.WriteFile(path, []byte(`[
+		{"stop_id": "A12", "reason": "Station rehabilitation"},
+		{"stop_id": "R14N", "reason": "Weekend work", "until": "2020-01-01T00:00:00Z"}
+	]`), 0o644)
+
+	store := &closureStore{byStop: map[string]Closure{}}
+	if err := store.load(path); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	now := time.Now()
+	if cl, ok := store.active("A12S", now); !ok || cl.Reason != "Station rehabilitation" {
+		t.Errorf("expected A12 to be closed via its platform id, got %+v %v", cl, ok)
+	}
+	if _, ok := store.active("R14", now); ok {
+		t.Error("expired closure should not be active")
+	}
+	if got := store.list(now); len(got) != 1 {
+		t.Errorf("expected 1 active closure, got %d", len(got))
+	}
+}
+
+func TestNearestSkipsClosedStations(t *testing.T) {
+	keepTestData(t)
+	originalClosures := closures
+	defer func() { closures = originalClosures }()
+
+	setTestStations([]Station{
+		{StopID: "635", Name: "14 St - Union Sq", Lat: 40.7347, Lon: -73.9897},
+		{StopID: "L03", Name: "14 St - Union Sq (L)", Lat: 40.7349, Lon: -73.9900},
+	})
+	closures = &closureStore{byStop: map[string]Closure{"635": {StopID: "635"}}}
+
+	if s := nearestStation(40.7347, -73.9897); s.StopID != "L03" {
+		t.Errorf("expected closed station to be skipped, got %s", s.StopID)
+	}
+	if got := nearestStations(40.7347, -73.9897, 5); len(got) != 1 || got[0].StopID != "L03" {
+		t.Errorf("expected only L03, got %+v", got)
+	}
+}
+
+func TestClosuresAPI(t *testing.T) {
+	originalClosures, originalToken := closures, adminToken
+	defer func() { closures, adminToken = originalClosures, originalToken }()
+
+	path := filepath.Join(t.TempDir(), "closures.json")
+	os.WriteFile(path, []byte(`[]`), 0o644)
+	closures = &closureStore{byStop: map[string]Closure{}}
+	if err := closures.load(path); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+
+	post := func(token string) int {
+		req := httptest.NewRequest("POST", "/api/closures", strings.NewReader(`{"stop_id": "A12", "reason": "Rehab"}`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handleClosures(w, req)
+		return w.Code
+	}
+
+	// Admin API is disabled until a token is configured
+	adminToken = ""
+	if code := post("secret"); code != http.StatusForbidden {
+		t.Errorf("expected 403 without configured token, got %d", code)
+	}
+	adminToken = "secret"
+	if code := post("wrong"); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 with wrong token, got %d", code)
+	}
+	if code := post("secret"); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+
+	// Edits are persisted to the overrides file
+	data, _ := os.ReadFile(path)
+	var saved []Closure
+	if err := json.Unmarshal(data, &saved); err != nil || len(saved) != 1 || saved[0].StopID != "A12" {
+		t.Errorf("expected closure persisted to file, got %s", data)
+	}
+
+	w := httptest.NewRecorder()
+	handleClosures(w, httptest.NewRequest("GET", "/api/closures", nil))
+	var listed []Closure
+	json.NewDecoder(w.Body).Decode(&listed)
+	if len(listed) != 1 {
+		t.Errorf("expected 1 listed closure, got %d", len(listed))
+	}
+
+	del := httptest.NewRequest("DELETE", "/api/closures?stop_id=A12", nil)
+	del.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	handleClosures(w, del)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected 204 on delete, got %d", w.Code)
+	}
+	if isStationClosed(Station{StopID: "A12"}) {
+		t.Error("station should be open after delete")
+	}
+}
+
+func TestEntranceClosures(t *testing.T) {
+	originalClosures, originalToken, originalEntrances, originalNow := closures, adminToken, stationEntrances, nowFunc
+	t.Cleanup(func() {
+		closures, adminToken, stationEntrances, nowFunc = originalClosures, originalToken, originalEntrances, originalNow
+	})
+	closures = &closureStore{byStop: map[string]Closure{}}
+	adminToken = "secret"
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	nowFunc = func() time.Time { return now }
+
+	s := Station{StopID: "M11", Lat: 40.697207, Lon: -73.935657}
+	stationEntrances = map[string][]Entrance{"M11": {
+		{Type: "Stair", Lat: 40.6977, Lon: -73.9347},
+		{Type: "Stair", Lat: 40.6967, Lon: -73.9366},
+	}}
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handleClosures(w, req)
+		return w
+	}
+
+	// Closing the southern entrance (matched within a few meters) sends the walk north
+	w := do("POST", "/api/closures", `{"stop_id": "M11S", "entrance": {"lat": 40.69671, "lon": -73.93662}, "until": "2025-06-01T00:00:00Z"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if isStationClosed(s) {
+		t.Error("an entrance closure must not close the station")
+	}
+	if lat, _, e := walkDestination(s, "S", 40.6960, -73.9370); e == nil || lat != 40.6977 {
+		t.Errorf("expected the open northern entrance, got %f %v", lat, e)
+	}
+
+	// Closures end on the service clock
+	now = time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)
+	if lat, _, _ := walkDestination(s, "S", 40.6960, -73.9370); lat != 40.6967 {
+		t.Errorf("expected the southern entrance once the closure ended, got %f", lat)
+	}
+	if got := closures.list(nowFunc()); len(got) != 0 {
+		t.Errorf("expected no active closures, got %+v", got)
+	}
+
+	if w := do("DELETE", "/api/closures?stop_id=M11&entrance=40.69671,-73.93662", ""); w.Code != http.StatusNoContent {
+		t.Errorf("expected 204 deleting the entrance closure, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("DELETE", "/api/closures?stop_id=M11&entrance=north", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a bad entrance point, got %d", w.Code)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+// Conditional feed requests.
+//
+// Some MTA feeds (the B Division lines overnight, the alerts feed) change far less often
+// than they are fetched. Each feed's last 200 response is kept with its ETag and
+// Last-Modified, and the next fetch sends them as If-None-Match / If-Modified-Since. On
+// 304 Not Modified the kept bytes, and the message already parsed from them, are reused,
+// saving both the download and the protobuf parse. One copy per feed URL is kept.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// feedValidator is the last full response of a feed
+type feedValidator struct {
+	etag         string
+	lastModified string
+	body         []byte
+	msg          *gtfs_realtime.FeedMessage // parsed from body, once someone has
+}
+
+type feedValidatorStore struct {
+	mu    sync.Mutex
+	byURL map[string]*feedValidator
+}
+
+var feedValidators = &feedValidatorStore{byURL: map[string]*feedValidator{}}
+
+func (s *feedValidatorStore) get(url string) *feedValidator {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.byURL[url]
+}
+
+func (s *feedValidatorStore) put(url string, v *feedValidator) {
+	s.mu.Lock()
+	s.byURL[url] = v
+	s.mu.Unlock()
+}
+
+// setMessage records the message parsed from v's body
+func (s *feedValidatorStore) setMessage(v *feedValidator, msg *gtfs_realtime.FeedMessage) {
+	s.mu.Lock()
+	v.msg = msg
+	s.mu.Unlock()
+}
+
+// message returns the message parsed from v's body, if any
+func (s *feedValidatorStore) message(v *feedValidator) *gtfs_realtime.FeedMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return v.msg
+}
+
+// downloadFeedConditional fetches a feed's bytes, revalidating the kept copy. It returns
+// the kept validator the bytes came from, so callers can reuse its parsed message.
+func downloadFeedConditional(url string) ([]byte, *feedValidator, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), feedFetchTimeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if key := feedAPIKey(url); key != "" {
+		req.Header.Set("x-api-key", key)
+	}
+	kept := feedValidators.get(url)
+	if kept != nil {
+		if kept.etag != "" {
+			req.Header.Set("If-None-Match", kept.etag)
+		}
+		if kept.lastModified != "" {
+			req.Header.Set("If-Modified-Since", kept.lastModified)
+		}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		recordFeedResult(url, err)
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && kept != nil {
+		recordFeedResult(url, nil)
+		return kept.body, kept, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("feed returned status %d", resp.StatusCode)
+		recordFeedResult(url, err)
+		return nil, nil, err
+	}
+	b, err := io.ReadAll(resp.Body)
+	recordFeedResult(url, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	v := &feedValidator{etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified"), body: b}
+	if v.etag != "" || v.lastModified != "" {
+		feedValidators.put(url, v)
+	}
+	return b, v, nil
+}
+
+// downloadFeedMessage fetches and parses a feed, skipping the parse when the feed answered
+// 304 and its kept copy was parsed before. DIFFERENTIAL messages are merged into the feed's
+// full dataset (see differential.go), and the bytes returned are the merged message's.
+// The message is shared and must not be modified.
+func downloadFeedMessage(url string) (*gtfs_realtime.FeedMessage, []byte, error) {
+	b, v, err := downloadFeedConditional(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg := feedValidators.message(v)
+	if msg == nil {
+		msg = &gtfs_realtime.FeedMessage{}
+		if err := proto.Unmarshal(b, msg); err != nil {
+			return nil, nil, err
+		}
+		feedValidators.setMessage(v, msg)
+	}
+	// A DIFFERENTIAL message only has the changes; callers get the merged dataset
+	msg, b, err = differentials.apply(url, msg, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	recordFeedMessage(url, msg)
+	return msg, b, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestConditionalFeedRequests(t *testing.T) {
+	body, err := proto.Marshal(newTestFeed(testTripUpdate("6", "trip6", []string{"635N"}, []int64{60})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var full, notModified int
+	var lastIfModifiedSince string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastIfModifiedSince = r.Header.Get("If-Modified-Since")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Thu, 15 Oct 2026 12:00:00 GMT")
+		w.Write(body)
+	}))
+	defer server.Close()
+	t.Cleanup(func() {
+		feedValidators.mu.Lock()
+		delete(feedValidators.byURL, server.URL)
+		feedValidators.mu.Unlock()
+	})
+
+	first, _, err := downloadFeedMessage(server.URL)
+	if err != nil || len(first.GetEntity()) != 1 {
+		t.Fatalf("expected the feed, got %v (%v)", first, err)
+	}
+	second, b, err := downloadFeedMessage(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full != 1 || notModified != 1 || lastIfModifiedSince != "Thu, 15 Oct 2026 12:00:00 GMT" {
+		t.Errorf("expected one full and one conditional fetch, got %d full, %d not modified (If-Modified-Since %q)", full, notModified, lastIfModifiedSince)
+	}
+	if second != first || len(b) != len(body) {
+		t.Error("expected the kept bytes and parsed message reused on 304")
+	}
+}
+
+func TestUnconditionalFeedWithoutValidators(t *testing.T) {
+	body, _ := proto.Marshal(newTestFeed())
+	var conditional bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional = conditional || r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != ""
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := downloadFeed(server.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if conditional || feedValidators.get(server.URL) != nil {
+		t.Error("expected nothing kept for a feed without ETag or Last-Modified")
+	}
+}
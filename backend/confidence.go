@@ -0,0 +1,106 @@
+package main
+
+// ETA confidence tiers.
+//
+// Predictions far from the station get revised a lot: a train "25 min" away is often
+// 20 or 32 by the time it arrives. Each departure carries a confidence tier so display
+// clients can de-emphasize (or hide) long-horizon ETAs consistently:
+//
+//   high     eta <= high_until (default 10m) and the feed reports no large uncertainty
+//   medium   eta <= medium_until (default 20m)
+//   low      anything later
+//
+// A feed-reported uncertainty over 2 minutes caps the tier at medium, over 5 at low.
+// Deployments can also set max_eta to drop departures beyond a hard cut-off. Config:
+//
+//   "eta_confidence": {"high_until": "10m", "medium_until": "20m", "max_eta": "45m"}
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Confidence tiers
+const (
+	confidenceHigh   = "high"
+	confidenceMedium = "medium"
+	confidenceLow    = "low"
+)
+
+// Feed-reported uncertainty (seconds) that lowers a departure's tier
+const (
+	uncertaintyMediumSec = 120
+	uncertaintyLowSec    = 300
+)
+
+// ETAConfidenceConfig is the eta_confidence config object
+type ETAConfidenceConfig struct {
+	HighUntil   Duration `json:"high_until"`
+	MediumUntil Duration `json:"medium_until"`
+	MaxETA      Duration `json:"max_eta"` // departures later than this are dropped (0 = no cap)
+}
+
+func (c ETAConfidenceConfig) highUntil() time.Duration {
+	return c.HighUntil.orDefault(10 * time.Minute)
+}
+
+func (c ETAConfidenceConfig) mediumUntil() time.Duration {
+	return c.MediumUntil.orDefault(20 * time.Minute)
+}
+
+// allows reports whether a departure etaSec away is within the max_eta cap
+func (c ETAConfidenceConfig) allows(etaSec int64) bool {
+	return c.MaxETA == 0 || time.Duration(etaSec)*time.Second <= time.Duration(c.MaxETA)
+}
+
+// tier grades a departure etaSec away whose prediction has the given feed uncertainty
+// (0 when the feed doesn't say)
+func (c ETAConfidenceConfig) tier(etaSec int64, uncertaintySec int32) string {
+	eta := time.Duration(etaSec) * time.Second
+	level := 2 // high
+	switch {
+	case eta > c.mediumUntil():
+		level = 0
+	case eta > c.highUntil():
+		level = 1
+	}
+	switch {
+	case uncertaintySec > uncertaintyLowSec:
+		level = 0
+	case uncertaintySec > uncertaintyMediumSec && level > 1:
+		level = 1
+	}
+	return []string{confidenceLow, confidenceMedium, confidenceHigh}[level]
+}
+
+// validateETAConfidence checks the "eta_confidence" config value
+func validateETAConfidence(v json.RawMessage) string {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(v, &raw); err != nil {
+		return `expected an object like {"high_until": "10m", "medium_until": "20m", "max_eta": "45m"}`
+	}
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	durations := map[string]time.Duration{}
+	for _, k := range keys {
+		if k != "high_until" && k != "medium_until" && k != "max_eta" {
+			return fmt.Sprintf("unknown key %q (expected high_until, medium_until and max_eta)", k)
+		}
+		if msg := validateConfigValue("", kindDuration, raw[k]); msg != "" {
+			return k + ": " + msg
+		}
+		var s string
+		_ = json.Unmarshal(raw[k], &s)
+		durations[k], _ = time.ParseDuration(s)
+	}
+	c := ETAConfidenceConfig{HighUntil: Duration(durations["high_until"]), MediumUntil: Duration(durations["medium_until"])}
+	if c.mediumUntil() < c.highUntil() {
+		return fmt.Sprintf("medium_until (%s) must not be shorter than high_until (%s)", c.mediumUntil(), c.highUntil())
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+func TestConfidenceTier(t *testing.T) {
+	var defaults ETAConfidenceConfig
+	custom := ETAConfidenceConfig{HighUntil: Duration(5 * time.Minute), MediumUntil: Duration(8 * time.Minute)}
+	tests := []struct {
+		cfg         ETAConfidenceConfig
+		eta         int64
+		uncertainty int32
+		want        string
+	}{
+		{defaults, 60, 0, confidenceHigh},
+		{defaults, 600, 0, confidenceHigh},
+		{defaults, 601, 0, confidenceMedium},
+		{defaults, 1200, 0, confidenceMedium},
+		{defaults, 1500, 0, confidenceLow},
+		{defaults, 60, 180, confidenceMedium},
+		{defaults, 900, 180, confidenceMedium},
+		{defaults, 60, 400, confidenceLow},
+		{custom, 400, 0, confidenceMedium},
+		{custom, 600, 0, confidenceLow},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.tier(tt.eta, tt.uncertainty); got != tt.want {
+			t.Errorf("tier(%d, %d) with %+v = %s, want %s", tt.eta, tt.uncertainty, tt.cfg, got, tt.want)
+		}
+	}
+
+	if !defaults.allows(24 * 3600) {
+		t.Error("no cap by default")
+	}
+	capped := ETAConfidenceConfig{MaxETA: Duration(30 * time.Minute)}
+	if !capped.allows(1800) || capped.allows(1801) {
+		t.Error("expected departures past max_eta to be dropped")
+	}
+}
+
+func TestValidateETAConfidence(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  string // substring of the error, empty for valid
+	}{
+		{`{"high_until": "5m", "medium_until": "15m", "max_eta": "40m"}`, ""},
+		{`{"medium_until": "30m"}`, ""},
+		{`{"high_until": "30m"}`, "must not be shorter"},
+		{`{"high_until": "soon"}`, "high_until: invalid duration"},
+		{`{"low_until": "5m"}`, `unknown key "low_until"`},
+		{`"10m"`, "expected an object"},
+	} {
+		got := validateETAConfidence([]byte(tc.value))
+		if (tc.want == "") != (got == "") || !strings.Contains(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestDepartureConfidence(t *testing.T) {
+	keepTestData(t)
+	originalConfig := appConfig
+	defer func() { appConfig = originalConfig }()
+	setTestStations([]Station{{StopID: "101", Name: "Van Cortlandt Park-242 St", Routes: []string{"1"}}})
+	appConfig = Config{ETAConfidence: ETAConfidenceConfig{MaxETA: Duration(40 * time.Minute)}}
+
+	uncertain := testTripUpdate("1", "uncertain", []string{"101S"}, []int64{120})
+	uncertain.TripUpdate.StopTimeUpdate[0].Departure.Uncertainty = proto.Int32(600)
+	feed := newTestFeed(
+		testTripUpdate("1", "soon", []string{"101S"}, []int64{60}),
+		uncertain,
+		testTripUpdate("1", "later", []string{"101S"}, []int64{1500}),
+		testTripUpdate("1", "beyond", []string{"101S"}, []int64{3000}),
+	)
+	deps, err := departuresFromSource(data().Stations[0], departureFilter{Limit: 10}, func(string) (*gtfs_realtime.FeedMessage, error) { return feed, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range deps {
+		got = append(got, d.TripID+":"+d.Confidence)
+	}
+	if strings.Join(got, ",") != "soon:high,uncertain:low,later:low" {
+		t.Errorf("unexpected departures %v", got)
+	}
+}
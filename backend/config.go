@@ -0,0 +1,505 @@
+package main
+
+// Optional JSON config file (CONFIG_FILE=/etc/nyc-subway.json).
+// Every key is optional; environment variables still override the file so existing
+// deployments keep working. The file is validated against configSchema at startup and
+// by `nyc-subway check-config <file>`, which reports every problem instead of silently
+// falling back to defaults.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Config holds the operator-tunable settings. Zero values mean "use the built-in default".
+type Config struct {
+	Port                        string               `json:"port"`
+	StationsCSV                 string               `json:"stations_csv"`
+	StationsSources             []string             `json:"stations_sources"`    // ordered failover chain; "embedded" = built-in snapshot
+	StationNameSource           string               `json:"station_name_source"` // stations_csv (default) or gtfs
+	AlertsFeedURL               string               `json:"alerts_feed_url"`
+	MTAStationsCSV              string               `json:"mta_stations_csv"`
+	GTFSZipURL                  string               `json:"gtfs_zip_url"`
+	SupplementedGTFSURL         string               `json:"supplemented_gtfs_url"`
+	StationPhotosCSV            string               `json:"station_photos_csv"`
+	PlacesCSV                   string               `json:"places_csv"`
+	StationTranslationsCSV      string               `json:"station_translations_csv"` // localized names, see translations.go
+	WalkCacheTTL                Duration             `json:"walk_cache_ttl"`
+	WalkingSpeed                float64              `json:"walking_speed"`    // m/s for straight-line walk estimates, see walkestimate.go
+	WalkGridFactor              float64              `json:"walk_grid_factor"` // walked / straight-line distance for estimates
+	FeedCacheTTL                Duration             `json:"feed_cache_ttl"`
+	FeedCacheTTLs               map[string]Duration  `json:"feed_cache_ttls"`     // feed name -> TTL overriding feed_cache_ttl, see feedttl.go
+	HeadsignPrecedence          string               `json:"headsign_precedence"` // supplemented, base or base_only, see supplemented.go
+	SupplementedRefreshInterval Duration             `json:"supplemented_refresh_interval"`
+	StopTimesIndexCache         string               `json:"stop_times_index_cache"`
+	DataDir                     string               `json:"data_dir"` // saved copies of static downloads, see datadir.go
+	MaxDepartures               int                  `json:"max_departures"`
+	DeparturesPerDirection      int                  `json:"departures_per_direction"` // default for the limit parameter
+	FairnessPolicy              string               `json:"fairness_policy"`
+	ClosuresFile                string               `json:"closures_file"`
+	GeofencesFile               string               `json:"geofences_file"`   // created on first save
+	RidershipCSV                string               `json:"ridership_csv"`    // ridership per station complex, see heatmap.go
+	EntrancesCSV                string               `json:"entrances_csv"`    // station entrances for walks, see entrances.go
+	AnnotationsFile             string               `json:"annotations_file"` // departure annotation rules, see annotations.go
+	AdminToken                  string               `json:"admin_token"`
+	MTAAPIKey                   string               `json:"mta_api_key"`           // x-api-key for feed fetches, see apikey.go
+	FeedAPIKeys                 map[string]string    `json:"feed_api_keys"`         // feed name -> key overriding mta_api_key
+	PollInterval                Duration             `json:"poll_interval"`         // enables the background feed poller
+	PollIntervals               map[string]Duration  `json:"poll_intervals"`        // feed name -> poll interval, see feedttl.go
+	PollDemand                  PollDemandConfig     `json:"poll_demand"`           // demand-driven poll rates, see demand.go
+	ShadowMode                  bool                 `json:"shadow_mode"`           // diff legacy responses against the poller store
+	ShutdownGracePeriod         Duration             `json:"shutdown_grace_period"` // time in-flight requests get after SIGTERM
+	SLOs                        map[string]SLOConfig `json:"slos"`                  // per-endpoint objectives, see slo.go
+	ETAConfidence               ETAConfidenceConfig  `json:"eta_confidence"`        // confidence tiers and ETA cap, see confidence.go
+	TLSCABundle                 string               `json:"tls_ca_bundle"`         // extra PEM roots for upstreams, see tls.go
+	TLSPins                     map[string][]string  `json:"tls_pins"`              // host -> SPKI SHA-256 pins
+	Notifier                    NotifierConfig       `json:"notifier"`              // webhook, slack or mqtt target, see notify.go
+	Geocoder                    GeocoderConfig       `json:"geocoder"`              // nominatim or pelias, see geocode.go
+	Digests                     []DigestConfig       `json:"digests"`               // scheduled alert digests, see digest.go
+	BoardURL                    string               `json:"board_url"`             // live-board link on posters, {id} = stop ID
+	Listen                      []string             `json:"listen"`                // explicit listen addresses, see network.go
+	TrustedProxies              []string             `json:"trusted_proxies"`       // CIDRs allowed to set X-Forwarded-For
+	CarCounts                   map[string]int       `json:"car_counts"`            // route -> fixed consist length, see carcount.go
+	ApproachingThreshold        Duration             `json:"approaching_threshold"` // ETA that fires "approaching" events, see approaching.go
+	StaleFeedThreshold          Duration             `json:"stale_feed_threshold"`  // feed header age that marks departures stale, see stale.go
+	MonitoredStations           []string             `json:"monitored_stations"`    // stop IDs with a freshness gauge, see freshness.go
+	Deprecations                []DeprecationConfig  `json:"deprecations"`          // see deprecations.go
+	MaxStreamsPerIP             int                  `json:"max_streams_per_ip"`    // concurrent SSE/WebSocket streams per client, see fanout.go
+}
+
+// Duration is a time.Duration written in config files as a Go duration string ("30s", "15m")
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// orDefault returns the configured duration, or def when unset
+func (d Duration) orDefault(def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return time.Duration(d)
+}
+
+type configKind int
+
+const (
+	kindString configKind = iota
+	kindDuration
+	kindSource // URL or local file path that must exist
+	kindInt
+	kindBool
+	kindSLOs           // endpoint -> SLOConfig object
+	kindSourceList     // array of sources (kindSource), tried in order
+	kindETAConfidence  // ETAConfidenceConfig object
+	kindCABundle       // local PEM file with at least one certificate
+	kindTLSPins        // host -> array of "sha256/<base64>" pins
+	kindNotifier       // NotifierConfig object
+	kindDigests        // array of DigestConfig objects
+	kindListen         // array of host:port listen addresses
+	kindCIDRList       // array of CIDRs or addresses
+	kindCarCounts      // route -> car count object
+	kindPollDemand     // PollDemandConfig object
+	kindStopIDList     // array of GTFS stop IDs
+	kindDeprecations   // array of DeprecationConfig objects
+	kindFeedAPIKeys    // feed name -> API key object
+	kindFeedDurations  // feed name -> duration object
+	kindPositiveNumber // number above zero
+	kindGeocoder       // GeocoderConfig object
+)
+
+// configSchema lists every accepted key. Keep in sync with the Config struct tags.
+var configSchema = map[string]configKind{
+	"port":                          kindString,
+	"stations_csv":                  kindSource,
+	"stations_sources":              kindSourceList,
+	"station_name_source":           kindString,
+	"alerts_feed_url":               kindSource,
+	"mta_stations_csv":              kindSource,
+	"gtfs_zip_url":                  kindSource,
+	"supplemented_gtfs_url":         kindSource,
+	"station_photos_csv":            kindSource,
+	"places_csv":                    kindSource,
+	"station_translations_csv":      kindSource,
+	"ridership_csv":                 kindSource,
+	"entrances_csv":                 kindSource,
+	"walk_cache_ttl":                kindDuration,
+	"walking_speed":                 kindPositiveNumber,
+	"walk_grid_factor":              kindPositiveNumber,
+	"feed_cache_ttl":                kindDuration,
+	"feed_cache_ttls":               kindFeedDurations,
+	"supplemented_refresh_interval": kindDuration,
+	"headsign_precedence":           kindString,
+	"stop_times_index_cache":        kindString,
+	"data_dir":                      kindString,
+	"max_departures":                kindInt,
+	"departures_per_direction":      kindInt,
+	"fairness_policy":               kindString,
+	"closures_file":                 kindSource,
+	"geofences_file":                kindString,
+	"annotations_file":              kindSource,
+	"admin_token":                   kindString,
+	"mta_api_key":                   kindString,
+	"feed_api_keys":                 kindFeedAPIKeys,
+	"poll_interval":                 kindDuration,
+	"poll_intervals":                kindFeedDurations,
+	"poll_demand":                   kindPollDemand,
+	"shadow_mode":                   kindBool,
+	"shutdown_grace_period":         kindDuration,
+	"slos":                          kindSLOs,
+	"eta_confidence":                kindETAConfidence,
+	"tls_ca_bundle":                 kindCABundle,
+	"tls_pins":                      kindTLSPins,
+	"notifier":                      kindNotifier,
+	"geocoder":                      kindGeocoder,
+	"digests":                       kindDigests,
+	"board_url":                     kindString,
+	"listen":                        kindListen,
+	"trusted_proxies":               kindCIDRList,
+	"car_counts":                    kindCarCounts,
+	"approaching_threshold":         kindDuration,
+	"stale_feed_threshold":          kindDuration,
+	"monitored_stations":            kindStopIDList,
+	"max_streams_per_ip":            kindInt,
+	"deprecations":                  kindDeprecations,
+}
+
+// configEnums restricts string keys to a fixed set of values
+var configEnums = map[string][]string{
+	"fairness_policy":     {fairnessChronological, fairnessPerRoute},
+	"station_name_source": {nameSourceStationsCSV, nameSourceGTFS},
+	"headsign_precedence": {headsignSupplemented, headsignBase, headsignBaseOnly},
+}
+
+// appConfig is the loaded configuration (zero value when no config file is used)
+var appConfig Config
+
+// ConfigErrors collects every validation problem so operators can fix them in one pass
+type ConfigErrors []string
+
+func (e ConfigErrors) Error() string {
+	return "invalid config:\n  - " + strings.Join(e, "\n  - ")
+}
+
+// loadConfig reads and validates a config file. An empty path returns the zero Config.
+func loadConfig(path string) (Config, error) {
+	var cfg Config
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("read config: %w", err)
+	}
+	if errs := validateConfig(data); len(errs) > 0 {
+		return cfg, errs
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("decode config: %w", err)
+	}
+	return cfg, nil
+}
+
+// validateConfig checks raw config JSON against configSchema and returns actionable errors
+func validateConfig(data []byte) ConfigErrors {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return ConfigErrors{fmt.Sprintf("config is not a JSON object: %v", err)}
+	}
+
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var errs ConfigErrors
+	for _, key := range keys {
+		kind, ok := configSchema[key]
+		if !ok {
+			msg := fmt.Sprintf("unknown key %q", key)
+			if s := suggestConfigKey(key); s != "" {
+				msg += fmt.Sprintf(" (did you mean %q?)", s)
+			}
+			errs = append(errs, msg)
+			continue
+		}
+		if msg := validateConfigValue(key, kind, raw[key]); msg != "" {
+			errs = append(errs, key+": "+msg)
+		}
+	}
+	return errs
+}
+
+func validateConfigValue(key string, kind configKind, v json.RawMessage) string {
+	switch kind {
+	case kindString, kindSource, kindDuration:
+		var s string
+		if err := json.Unmarshal(v, &s); err != nil {
+			return fmt.Sprintf("expected a string, got %s", v)
+		}
+		if kind == kindDuration {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return fmt.Sprintf("invalid duration %q (use Go syntax like \"90s\" or \"15m\")", s)
+			}
+			if d <= 0 {
+				return fmt.Sprintf("duration %q must be positive", s)
+			}
+		}
+		if allowed, ok := configEnums[key]; ok && !containsString(allowed, s) {
+			return fmt.Sprintf("invalid value %q (expected one of: %s)", s, strings.Join(allowed, ", "))
+		}
+		if kind == kindSource && s != "" && !isRemoteSource(s) {
+			if _, err := os.Stat(strings.TrimPrefix(s, "file://")); err != nil {
+				return fmt.Sprintf("referenced file %q does not exist", s)
+			}
+		}
+	case kindInt:
+		var n int
+		if err := json.Unmarshal(v, &n); err != nil {
+			return fmt.Sprintf("expected an integer, got %s", v)
+		}
+		if n < 0 {
+			return fmt.Sprintf("must not be negative, got %d", n)
+		}
+	case kindBool:
+		var b bool
+		if err := json.Unmarshal(v, &b); err != nil {
+			return fmt.Sprintf("expected true or false, got %s", v)
+		}
+	case kindSLOs:
+		return validateSLOs(v)
+	case kindETAConfidence:
+		return validateETAConfidence(v)
+	case kindCABundle:
+		return validateCABundle(v)
+	case kindTLSPins:
+		return validateTLSPins(v)
+	case kindNotifier:
+		return validateNotifier(v)
+	case kindGeocoder:
+		return validateGeocoder(v)
+	case kindDigests:
+		return validateDigests(v)
+	case kindListen:
+		return validateListen(v)
+	case kindCIDRList:
+		return validateTrustedProxies(v)
+	case kindCarCounts:
+		return validateCarCounts(v)
+	case kindPollDemand:
+		return validatePollDemand(v)
+	case kindStopIDList:
+		return validateMonitoredStations(v)
+	case kindDeprecations:
+		return validateDeprecations(v)
+	case kindFeedAPIKeys:
+		return validateFeedAPIKeys(v)
+	case kindFeedDurations:
+		return validateFeedDurations(v)
+	case kindPositiveNumber:
+		return validatePositiveNumber(v)
+	case kindSourceList:
+		var list []json.RawMessage
+		if err := json.Unmarshal(v, &list); err != nil || len(list) == 0 {
+			return fmt.Sprintf("expected a non-empty array of sources, got %s", v)
+		}
+		for i, item := range list {
+			if string(item) == `"`+embeddedStationsSource+`"` || string(item) == `"`+gtfsStationsSource+`"` {
+				continue
+			}
+			if msg := validateConfigValue(key, kindSource, item); msg != "" {
+				return fmt.Sprintf("[%d]: %s", i, msg)
+			}
+		}
+	}
+	return ""
+}
+
+// suggestConfigKey returns a known key within a small edit distance of key, if any
+func suggestConfigKey(key string) string {
+	best, bestDist := "", 3
+	for k := range configSchema {
+		if d := editDistance(key, k); d < bestDist || (d == bestDist && k < best) {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// applyConfig copies configured values into the package-level settings
+func applyConfig(cfg Config) {
+	if cfg.StationsCSV != "" {
+		stationsCSV = cfg.StationsCSV
+	}
+	if cfg.MTAStationsCSV != "" {
+		mtaStationsCSV = cfg.MTAStationsCSV
+	}
+	if cfg.GTFSZipURL != "" {
+		gtfsZipURL = cfg.GTFSZipURL
+	}
+	if cfg.SupplementedGTFSURL != "" {
+		supplementedGTFSURL = cfg.SupplementedGTFSURL
+	}
+	if cfg.StationPhotosCSV != "" {
+		stationPhotosCSV = cfg.StationPhotosCSV
+	}
+	if cfg.PlacesCSV != "" {
+		placesCSV = cfg.PlacesCSV
+	}
+	if cfg.StopTimesIndexCache != "" {
+		stopTimesCachePath = cfg.StopTimesIndexCache
+	}
+	if cfg.DataDir != "" {
+		dataDir = cfg.DataDir
+	}
+	if cfg.AdminToken != "" {
+		adminToken = cfg.AdminToken
+	}
+	if cfg.MTAAPIKey != "" {
+		mtaAPIKey = cfg.MTAAPIKey
+	}
+	if cfg.FeedAPIKeys != nil {
+		feedAPIKeys = cfg.FeedAPIKeys
+	}
+	if cfg.AlertsFeedURL != "" {
+		alertsFeedURL = cfg.AlertsFeedURL
+	}
+	if cfg.BoardURL != "" {
+		boardURL = cfg.BoardURL
+	}
+	if len(cfg.TrustedProxies) > 0 {
+		trustedProxies, _ = parseProxyCIDRs(cfg.TrustedProxies) // validated by loadConfig
+	}
+	if cfg.CarCounts != nil {
+		routeCarCounts = cfg.CarCounts
+	}
+	configureDeprecations(cfg.Deprecations)
+	slos = newSLOTrackers(cfg.SLOs)
+	stationFreshness.configure(cfg.MonitoredStations, time.Now())
+	appConfig = cfg
+}
+
+// setupSubcommand loads and applies the config for a subcommand that talks to the
+// upstreams (the file named in args, or CONFIG_FILE), including upstream TLS. Problems are
+// reported to out and make it return false.
+func setupSubcommand(args []string, out io.Writer) bool {
+	path := os.Getenv("CONFIG_FILE")
+	if len(args) > 0 {
+		path = args[0]
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		fmt.Fprintf(out, "%s: %v\n", path, err)
+		return false
+	}
+	applyConfig(cfg)
+	if err := configureUpstreamTLS(cfg.TLSCABundle, cfg.TLSPins); err != nil {
+		fmt.Fprintf(out, "%v\n", err)
+		return false
+	}
+	return true
+}
+
+// runCheckConfig implements `nyc-subway check-config [file]` and returns the exit code
+func runCheckConfig(args []string, out io.Writer) int {
+	path := os.Getenv("CONFIG_FILE")
+	if len(args) > 0 {
+		path = args[0]
+	}
+	if path == "" {
+		fmt.Fprintln(out, "usage: nyc-subway check-config <config.json> (or set CONFIG_FILE)")
+		return 2
+	}
+	if _, err := loadConfig(path); err != nil {
+		fmt.Fprintf(out, "%s: %v\n", path, err)
+		return 1
+	}
+	fmt.Fprintf(out, "%s: OK\n", path)
+	return 0
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func isRemoteSource(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
+
+// openDataSource opens a static data source, which may be an http(s) URL or a local file path
+func openDataSource(ctx context.Context, src string) (io.ReadCloser, error) {
+	if !isRemoteSource(src) {
+		return os.Open(strings.TrimPrefix(src, "file://"))
+	}
+	if dataDir != "" {
+		p, err := fetchToDataDir(ctx, src, maxGTFSZipBytes, nil)
+		if err != nil {
+			return nil, err
+		}
+		return os.Open(p)
+	}
+	req, _ := http.NewRequestWithContext(ctx, "GET", src, nil)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeTestConfig(t, `{
+		"port": "9090",
+		"stations_csv": "https://example.com/stations.csv",
+		"feed_cache_ttl": "45s"
+	}`)
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if cfg.Port != "9090" {
+		t.Errorf("port = %q, want 9090", cfg.Port)
+	}
+	if got := cfg.FeedCacheTTL.orDefault(30 * time.Second); got != 45*time.Second {
+		t.Errorf("feed_cache_ttl = %s, want 45s", got)
+	}
+	if got := cfg.WalkCacheTTL.orDefault(24 * time.Hour); got != 24*time.Hour {
+		t.Errorf("unset walk_cache_ttl should use default, got %s", got)
+	}
+
+	// No path means defaults
+	if _, err := loadConfig(""); err != nil {
+		t.Errorf("empty path should not fail: %v", err)
+	}
+}
+
+func TestValidateConfigErrors(t *testing.T) {
+	errs := validateConfig([]byte(`{
+		"feed_cache_tl": "30s",
+		"walk_cache_ttl": "5 minutes",
+		"places_csv": "/does/not/exist.csv",
+		"port": 8080,
+		"fairness_policy": "random",
+		"max_departures": -1
+	}`))
+
+	want := []string{
+		`fairness_policy: invalid value "random" (expected one of: chronological, per_route)`,
+		`unknown key "feed_cache_tl" (did you mean "feed_cache_ttl"?)`,
+		`max_departures: must not be negative`,
+		`places_csv: referenced file "/does/not/exist.csv" does not exist`,
+		`port: expected a string`,
+		`walk_cache_ttl: invalid duration "5 minutes"`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got %d: %v", len(want), len(errs), errs)
+	}
+	for i, w := range want {
+		if !strings.Contains(errs[i], w) {
+			t.Errorf("error[%d] = %q, want it to contain %q", i, errs[i], w)
+		}
+	}
+
+	if errs := validateConfig([]byte(`[1, 2]`)); len(errs) != 1 {
+		t.Errorf("expected a single error for non-object config, got %v", errs)
+	}
+}
+
+func TestRunCheckConfig(t *testing.T) {
+	good := writeTestConfig(t, `{"feed_cache_ttl": "1m"}`)
+	bad := writeTestConfig(t, `{"feed_cache_ttl": "soon"}`)
+
+	var out bytes.Buffer
+	if code := runCheckConfig([]string{good}, &out); code != 0 {
+		t.Errorf("expected exit 0 for valid config, got %d: %s", code, out.String())
+	}
+	out.Reset()
+	if code := runCheckConfig([]string{bad}, &out); code != 1 {
+		t.Errorf("expected exit 1 for invalid config, got %d", code)
+	}
+	if !strings.Contains(out.String(), "feed_cache_ttl") {
+		t.Errorf("expected report to name the bad key, got %q", out.String())
+	}
+}
+
+func TestLoadStationsFromLocalFile(t *testing.T) {
+	keepTestData(t)
+	originalMTA := mtaStationsCSV
+	defer func() {
+		mtaStationsCSV = originalMTA
+	}()
+
+	dir := t.TempDir()
+	stationsPath := filepath.Join(dir, "stations.csv")
+	os.WriteFile(stationsPath, []byte("GTFS Stop ID,Stop Name,GTFS Latitude,GTFS Longitude\nL08,Bedford Av,40.717304,-73.956872\n"), 0o644)
+	mtaStationsCSV = filepath.Join(dir, "missing.csv") // route mapping failure is non-fatal
+
+	if err := loadStations(context.Background(), stationsPath); err != nil {
+		t.Fatalf("loadStations from file failed: %v", err)
+	}
+	if len(data().Stations) != 1 || data().Stations[0].StopID != "L08" {
+		t.Errorf("unexpected stations %+v", data().Stations)
+	}
+}
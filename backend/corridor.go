@@ -0,0 +1,179 @@
+package main
+
+// Departure board for a stretch of a line, for "train is 3 stops away" displays.
+//
+//   GET /api/corridor?stops=L08,L10,L11&direction=N   (also routes, limit and horizon)
+//
+// stops lists consecutive stations in travel order for the direction. The response is a
+// matrix: one row per train heading through the corridor, one cell per stop with the
+// train's ETA there and how many corridor stops it still has to make before it (null once
+// the train has passed the stop, or when it doesn't call there). Trains furthest along
+// come first.
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCorridorStops caps stops per corridor request
+const maxCorridorStops = 20
+
+// CorridorCell is one train at one stop
+type CorridorCell struct {
+	ETASeconds int64 `json:"eta_seconds"`
+	UnixTime   int64 `json:"unix_time"`
+	StopsAway  int   `json:"stops_away"` // corridor stops the train makes before this one
+}
+
+// CorridorTrain is one row of the matrix
+type CorridorTrain struct {
+	TripID   string          `json:"trip_id"`
+	RouteID  string          `json:"route_id"`
+	HeadSign string          `json:"headsign,omitempty"`
+	Stops    []*CorridorCell `json:"stops"` // parallel to CorridorResponse.Stops
+}
+
+// CorridorResponse is the /api/corridor body
+type CorridorResponse struct {
+	Direction string          `json:"direction"`
+	Stops     []Station       `json:"stops"`
+	Trains    []CorridorTrain `json:"trains"`
+	// SuggestedRefreshSeconds follows the soonest arrival in the matrix, see refresh.go
+	SuggestedRefreshSeconds int           `json:"suggested_refresh_seconds"`
+	Partial                 bool          `json:"partial,omitempty"`
+	Warnings                []string      `json:"warnings,omitempty"`
+	Meta                    *ResponseMeta `json:"meta,omitempty"` // see meta.go
+}
+
+// buildCorridor merges per-stop departures (parallel to stops) into train rows
+func buildCorridor(stops []Station, perStop [][]Departure) []CorridorTrain {
+	byTrip := map[string]*CorridorTrain{}
+	var order []string
+	for i, deps := range perStop {
+		for _, d := range deps {
+			if d.TripID == "" {
+				continue
+			}
+			row, ok := byTrip[d.TripID]
+			if !ok {
+				row = &CorridorTrain{TripID: d.TripID, RouteID: d.RouteID, HeadSign: d.HeadSign, Stops: make([]*CorridorCell, len(stops))}
+				byTrip[d.TripID] = row
+				order = append(order, d.TripID)
+			}
+			if row.Stops[i] == nil {
+				row.Stops[i] = &CorridorCell{ETASeconds: d.ETASeconds, UnixTime: d.UnixTime}
+			}
+		}
+	}
+
+	trains := make([]CorridorTrain, 0, len(order))
+	for _, id := range order {
+		row := byTrip[id]
+		away := 0
+		for _, c := range row.Stops {
+			if c != nil {
+				c.StopsAway = away
+				away++
+			}
+		}
+		trains = append(trains, *row)
+	}
+	// Furthest along first: the fewer stops still ahead, the further the train has come
+	sort.SliceStable(trains, func(i, j int) bool {
+		a, b := firstCell(trains[i]), firstCell(trains[j])
+		if a != b {
+			return a > b
+		}
+		return trains[i].Stops[a].UnixTime < trains[j].Stops[b].UnixTime
+	})
+	return trains
+}
+
+// firstCell is the index of the first stop the train still makes
+func firstCell(t CorridorTrain) int {
+	for i, c := range t.Stops {
+		if c != nil {
+			return i
+		}
+	}
+	return len(t.Stops)
+}
+
+func handleCorridor(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	filter, err := parseDepartureFilter(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.Direction == "" {
+		httpError(w, http.StatusBadRequest, "missing direction (N or S)")
+		return
+	}
+	if filter.Limit == 0 {
+		filter.Limit = maxDeparturesPerDirection // keep trains from dropping out at busy stops
+	}
+	var ids []string
+	for _, id := range strings.Split(r.URL.Query().Get("stops"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < 2 {
+		httpError(w, http.StatusBadRequest, "stops must list at least two stations")
+		return
+	}
+	if len(ids) > maxCorridorStops {
+		httpError(w, http.StatusBadRequest, fmt.Sprintf("at most %d stops", maxCorridorStops))
+		return
+	}
+	stops := make([]Station, len(ids))
+	for i, id := range ids {
+		s, ok := stationByID(id)
+		if !ok {
+			httpError(w, http.StatusNotFound, fmt.Sprintf("no station matched %q", id))
+			return
+		}
+		stops[i] = s
+	}
+
+	memo := newFeedMemo(fetchGTFS)
+	perStop := make([][]Departure, len(stops))
+	errs := make([]error, len(stops))
+	var wg sync.WaitGroup
+	for i, s := range stops {
+		wg.Add(1)
+		go func(i int, s Station) {
+			defer wg.Done()
+			stationUsage.mark(s.StopID)
+			perStop[i], errs[i] = departuresFromSource(s, filter, memo.get)
+		}(i, s)
+	}
+	wg.Wait()
+
+	resp := CorridorResponse{Direction: filter.Direction, Stops: stops}
+	for _, err := range errs {
+		warnings, err := splitPartial(err)
+		if err != nil {
+			httpError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		for _, warning := range warnings {
+			if !containsString(resp.Warnings, warning) {
+				resp.Warnings = append(resp.Warnings, warning)
+			}
+		}
+	}
+	resp.Partial = len(resp.Warnings) > 0
+	resp.Trains = buildCorridor(stops, perStop)
+	resp.SuggestedRefreshSeconds = suggestedRefreshSeconds(perStop...)
+	resp.Meta = departureMeta(filter, stops...)
+	writeJSON(w, resp)
+	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCorridor(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+	setTestStations([]Station{
+		{StopID: "L08", Name: "Bedford Av"},
+		{StopID: "L10", Name: "Graham Av"},
+		{StopID: "L11", Name: "Grand St"},
+	})
+	// Northbound L trains run L11 -> L10 -> L08; "near" has already passed Grand St
+	server := newTestFeedServer(t,
+		testTripUpdate("L", "far", []string{"L11N", "L10N", "L08N"}, []int64{120, 240, 360}),
+		testTripUpdate("L", "near", []string{"L10N", "L08N"}, []int64{60, 180}),
+		testTripUpdate("L", "south", []string{"L08S", "L10S"}, []int64{60, 180}),
+	)
+	useTestFeeds(t, server.URL)
+	originalConfig := appConfig
+	t.Cleanup(func() { appConfig = originalConfig })
+	appConfig.FeedCacheTTL = Duration(time.Second)
+
+	w := httptest.NewRecorder()
+	handleCorridor(w, httptest.NewRequest("GET", "/api/corridor?stops=L11,L10,L08&direction=N", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp CorridorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Stops) != 3 || resp.Stops[0].StopID != "L11" || len(resp.Trains) != 2 {
+		t.Fatalf("unexpected corridor %+v", resp)
+	}
+	if resp.SuggestedRefreshSeconds != int(minSuggestedRefresh/time.Second) {
+		t.Errorf("expected the minimum refresh with a train a minute out, got %d", resp.SuggestedRefreshSeconds)
+	}
+	if resp.Meta == nil || len(resp.Meta.Feeds) == 0 {
+		t.Errorf("expected feed metadata, got %+v", resp.Meta)
+	}
+	near, far := resp.Trains[0], resp.Trains[1]
+	if near.TripID != "near" || far.TripID != "far" {
+		t.Fatalf("expected the train furthest along first, got %s, %s", near.TripID, far.TripID)
+	}
+	if near.Stops[0] != nil || near.Stops[2].StopsAway != 1 || near.Stops[1].StopsAway != 0 {
+		t.Errorf("unexpected cells for the near train %+v", near.Stops)
+	}
+	if far.Stops[2] == nil || far.Stops[2].StopsAway != 2 || far.Stops[2].ETASeconds < 350 {
+		t.Errorf("expected the far train two stops away from Bedford Av, got %+v", far.Stops[2])
+	}
+}
+
+func TestCorridorInvalidRequests(t *testing.T) {
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "L08"}, {StopID: "L10"}})
+
+	tests := map[string]int{
+		"/api/corridor?stops=L08,L10":                  http.StatusBadRequest,
+		"/api/corridor?stops=L08&direction=N":          http.StatusBadRequest,
+		"/api/corridor?stops=L08,L10&direction=E":      http.StatusBadRequest,
+		"/api/corridor?stops=L08,NoSuchID&direction=N": http.StatusNotFound,
+	}
+	for url, want := range tests {
+		w := httptest.NewRecorder()
+		handleCorridor(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != want {
+			t.Errorf("%s: expected %d, got %d", url, want, w.Code)
+		}
+	}
+}
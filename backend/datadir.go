@@ -0,0 +1,151 @@
+package main
+
+// On-disk copies of the static downloads.
+//
+// With config key data_dir set, every remote static source (the GTFS and supplemented
+// zips, the station, photo, places, translation and ridership CSVs) is saved there as it
+// is downloaded, next to a .sha256 file with its checksum. When a download fails (an MTA
+// outage at boot, an error page instead of the file), the saved copy is used instead,
+// provided its checksum still matches, so the server starts with stations and headsigns
+// rather than without them. Realtime feeds are not cached here.
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// dataDir is where static downloads are kept (empty disables the on-disk copies)
+var dataDir = ""
+
+// dataDirPath is the file a source is saved to: a hash of the URL keeps sources with the
+// same file name apart, and the name keeps the directory readable
+func dataDirPath(src string) string {
+	sum := sha256.Sum256([]byte(src))
+	name := "download"
+	if u, err := url.Parse(src); err == nil {
+		if base := path.Base(u.Path); base != "." && base != "/" {
+			name = base
+		}
+	}
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == '%' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+	return filepath.Join(dataDir, hex.EncodeToString(sum[:6])+"-"+name)
+}
+
+// fileSHA256 returns the hex SHA-256 of a file
+func fileSHA256(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifySavedCopy checks a saved file against its .sha256
+func verifySavedCopy(p string) error {
+	want, err := os.ReadFile(p + ".sha256")
+	if err != nil {
+		return fmt.Errorf("no checksum for %s: %w", p, err)
+	}
+	got, err := fileSHA256(p)
+	if err != nil {
+		return err
+	}
+	if got != strings.TrimSpace(string(want)) {
+		return fmt.Errorf("checksum mismatch for %s", p)
+	}
+	return nil
+}
+
+// downloadToDataDir downloads src into the data directory. validate, if set, must accept
+// the download before it replaces the saved copy.
+func downloadToDataDir(ctx context.Context, src string, limit int64, validate func(string) error) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download %s: status %d", src, resp.StatusCode)
+	}
+
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dataDir, ".download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(resp.Body, limit+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n > limit {
+		err = fmt.Errorf("download %s: larger than %d bytes", src, limit)
+	}
+	if err == nil && validate != nil {
+		err = validate(tmp.Name())
+	}
+	if err != nil {
+		return "", err
+	}
+
+	p := dataDirPath(src)
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(p+".sha256", []byte(hex.EncodeToString(h.Sum(nil))+"\n"), 0o644); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// fetchToDataDir downloads a remote source into the data directory, falling back to the
+// saved copy when the download fails, and returns the local path
+func fetchToDataDir(ctx context.Context, src string, limit int64, validate func(string) error) (string, error) {
+	p, err := downloadToDataDir(ctx, src, limit, validate)
+	if err == nil {
+		return p, nil
+	}
+	saved := dataDirPath(src)
+	if verr := verifySavedCopy(saved); verr != nil {
+		return "", fmt.Errorf("%w (no usable saved copy: %v)", err, verr)
+	}
+	log.Printf("Warning: download of %s failed (%v); using the copy saved in %s", src, err, dataDir)
+	return saved, nil
+}
+
+// validateZipFile checks that a download is a readable zip archive
+func validateZipFile(p string) error {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return fmt.Errorf("not a zip archive: %w", err)
+	}
+	return zr.Close()
+}
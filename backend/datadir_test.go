@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDataDirFallback(t *testing.T) {
+	original := dataDir
+	dataDir = t.TempDir()
+	t.Cleanup(func() { dataDir = original })
+
+	var down atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("stop_id,name\n635,14 St\n"))
+	}))
+	defer server.Close()
+	src := server.URL + "/stations.csv"
+
+	read := func() (string, error) {
+		rc, err := openDataSource(context.Background(), src)
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		b, err := io.ReadAll(rc)
+		return string(b), err
+	}
+	if got, err := read(); err != nil || !strings.Contains(got, "14 St") {
+		t.Fatalf("first download: %q, %v", got, err)
+	}
+
+	// The saved copy stands in for a failed download, never the error page
+	down.Store(true)
+	if got, err := read(); err != nil || !strings.Contains(got, "14 St") {
+		t.Fatalf("expected the saved copy, got %q, %v", got, err)
+	}
+
+	// A corrupted copy is refused
+	os.WriteFile(dataDirPath(src), []byte("truncated"), 0o644)
+	if _, err := read(); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected a checksum error, got %v", err)
+	}
+}
+
+func TestDataDirGTFSZip(t *testing.T) {
+	original := dataDir
+	dataDir = t.TempDir()
+	t.Cleanup(func() { dataDir = original })
+
+	server := newTestGTFSServer(t, map[string]string{"stops.txt": testGTFSStops})
+	zf, err := openGTFSZip(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zf.Close()
+
+	// Served from disk once the upstream is gone
+	server.Close()
+	zf, err = openGTFSZip(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zf.Close()
+	if _, err := zf.openMember("stops.txt"); err != nil {
+		t.Errorf("expected stops.txt in the saved zip: %v", err)
+	}
+
+	// A download that isn't a zip doesn't replace the saved copy
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("<html>")) }))
+	defer bad.Close()
+	if _, err := openGTFSZip(context.Background(), bad.URL); err == nil || !strings.Contains(err.Error(), "not a zip archive") {
+		t.Errorf("expected a zip validation error, got %v", err)
+	}
+}
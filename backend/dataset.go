@@ -0,0 +1,81 @@
+package main
+
+// The static dataset.
+//
+// Stations, trips and the indexes built from them are read by every request while loaders
+// replace them in the background: startup loads them while the server already answers
+// probes, and the supplemented GTFS is refreshed every 30 minutes. They live together in
+// an immutable dataset behind an atomic pointer, RCU-style. Readers take the current
+// generation with data() and keep it for the rest of the request; writers build the next
+// one with updateData, which copies the current dataset, lets the writer replace fields
+// and swaps the copy in. Nothing reachable from a published dataset is modified again, so
+// writers replace slices and maps rather than editing them (mutateStations copies the
+// stations first).
+//
+// The smaller lookup tables (routes, shapes, transfers, photos, places, entrances) are
+// still package variables, assigned once while loading.
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// dataset is one generation of the static data
+type dataset struct {
+	Stations          []Station
+	Trips             []Trip
+	TripServices      map[string]string // static trip ID -> service_id
+	SupplementedTrips []Trip
+	Calendar          *serviceCalendar
+	SuppCalendar      *serviceCalendar
+	StopTimes         *stopTimesIndex
+	RoutePatterns     map[string]map[string]routePattern // route ID -> terminal parent stop ID -> pattern
+	RouteStopOrders   map[string]map[string][]string     // route ID -> direction_id -> parent stop IDs
+	RunTimes          map[string]map[string]int32        // route ID -> hop -> shortest scheduled run, see etafloor.go
+	StopParents       map[string]string                  // stop ID -> parent station ID, see stopids.go
+	StopNames         map[string]string                  // parent stop ID -> stops.txt stop_name
+}
+
+var (
+	liveData atomic.Pointer[dataset]
+	updateMu sync.Mutex // serializes writers so one update can't undo another
+)
+
+// data returns the current dataset, which the caller must not modify
+func data() *dataset {
+	if ds := liveData.Load(); ds != nil {
+		return ds
+	}
+	return &dataset{}
+}
+
+// updateData publishes a copy of the current dataset with the changes fn makes to it
+func updateData(fn func(ds *dataset)) {
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	next := *data()
+	fn(&next)
+	liveData.Store(&next)
+}
+
+// mutateStations publishes a copy of the stations with the changes fn makes to them
+func mutateStations(fn func(list []Station)) {
+	updateData(func(ds *dataset) {
+		list := append([]Station(nil), ds.Stations...)
+		fn(list)
+		ds.Stations = list
+	})
+}
+
+// setTrips installs trips.txt and everything derived from it and the schedule index
+func (ds *dataset) setTrips(list []Trip, ix *stopTimesIndex) {
+	ds.Trips = list
+	ds.TripServices = indexTripServices(list)
+	ds.StopTimes = ix
+	ds.RoutePatterns, ds.RouteStopOrders, ds.RunTimes = nil, nil, nil
+	if ix != nil {
+		ds.RoutePatterns = detectRoutePatterns(list, ix)
+		ds.RouteStopOrders = buildRouteStopOrders(list, ix)
+		ds.RunTimes = buildRunTimes(list, ix)
+	}
+}
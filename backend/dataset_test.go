@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUpdateDataCopiesOnWrite(t *testing.T) {
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "635", Name: "14 St-Union Sq"}})
+	setTestTrips([]Trip{{TripID: "T1"}})
+	setTestSupplementedTrips(nil)
+
+	before := data()
+	mutateStations(func(list []Station) { list[0].Routes = []string{"4", "5", "6"} })
+	updateData(func(ds *dataset) { ds.SupplementedTrips = []Trip{{TripID: "S1"}} })
+
+	// A reader holding the earlier generation sees it unchanged
+	if len(before.Stations[0].Routes) != 0 || len(before.SupplementedTrips) != 0 {
+		t.Errorf("published dataset was modified: %+v", before)
+	}
+	after := data()
+	if len(after.Stations[0].Routes) != 3 || len(after.SupplementedTrips) != 1 || len(after.Trips) != 1 {
+		t.Errorf("unexpected dataset after updates: %+v", after)
+	}
+}
+
+func TestSetTripsDerivesIndexes(t *testing.T) {
+	ix, err := buildStopTimesIndex(strings.NewReader("trip_id,arrival_time,departure_time,stop_id,stop_sequence\nT1,08:00:00,08:00:00,635N,1\nT1,08:10:00,08:10:00,640N,2\n"), "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ds dataset
+	ds.setTrips([]Trip{{RouteID: "6", TripID: "T1", ServiceID: "Weekday", DirectionID: "0", TripHeadsign: "Pelham Bay Park"}}, ix)
+	if ds.TripServices["T1"] != "Weekday" || ds.StopTimes != ix || len(ds.RouteStopOrders["6"]["0"]) != 2 || ds.RoutePatterns["6"]["640"].Headsign != "Pelham Bay Park" {
+		t.Errorf("unexpected dataset %+v", ds)
+	}
+	ds.setTrips(nil, nil)
+	if ds.RoutePatterns != nil || ds.RouteStopOrders != nil {
+		t.Error("expected indexes cleared without a stop_times index")
+	}
+}
+
+// Readers and a refresher run concurrently; go test -race checks there is no data race
+func TestDatasetConcurrentRefresh(t *testing.T) {
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "635", Name: "14 St-Union Sq", Lat: 40.7347, Lon: -73.9897}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if s := nearestStation(40.7347, -73.9897); s.StopID != "635" {
+					t.Errorf("unexpected station %+v", s)
+					return
+				}
+			}
+		}()
+	}
+	for j := 0; j < 200; j++ {
+		mutateStations(func(list []Station) { list[0].Routes = []string{"6"} })
+		updateData(func(ds *dataset) { ds.SupplementedTrips = []Trip{{TripID: "S1"}} })
+	}
+	wg.Wait()
+}
@@ -0,0 +1,59 @@
+package main
+
+// Delay and uncertainty.
+//
+// A stop time event can carry, next to its predicted time, a delay (seconds behind the
+// schedule, negative when early) and an uncertainty (the expected error of the
+// prediction; 0 means exact). Departures pass them on as delay_seconds and
+// uncertainty_seconds so clients can say "running 4 min late" and show fuzzy ETAs as
+// such. Both are left out when the feed doesn't give them, since 0 is a real value. A
+// stop without its own delay takes the trip's.
+//
+// ETAs are measured from the feed's header timestamp, the moment the predictions were
+// made, rather than from the server clock; a feed without one falls back to the clock.
+
+import (
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// stopEvent returns the event predicting when the train leaves a stop: the departure,
+// else the arrival for stops (like terminals) with no departure time
+func stopEvent(stu *gtfs_realtime.TripUpdate_StopTimeUpdate) *gtfs_realtime.TripUpdate_StopTimeEvent {
+	if dep := stu.GetDeparture(); dep.GetTime() != 0 {
+		return dep
+	}
+	if arr := stu.GetArrival(); arr.GetTime() != 0 {
+		return arr
+	}
+	return nil
+}
+
+// eventDelay returns the delay of ev, else of its trip, or nil if neither has one
+func eventDelay(ev *gtfs_realtime.TripUpdate_StopTimeEvent, tu *gtfs_realtime.TripUpdate) *int32 {
+	if ev.Delay != nil {
+		d := *ev.Delay
+		return &d
+	}
+	if tu.Delay != nil {
+		d := *tu.Delay
+		return &d
+	}
+	return nil
+}
+
+// eventUncertainty returns the uncertainty of ev, or nil if it has none
+func eventUncertainty(ev *gtfs_realtime.TripUpdate_StopTimeEvent) *int32 {
+	if ev.Uncertainty == nil {
+		return nil
+	}
+	u := *ev.Uncertainty
+	return &u
+}
+
+// feedClock returns the feed's header timestamp, or fallback if it has none
+func feedClock(feed *gtfs_realtime.FeedMessage, fallback int64) int64 {
+	if ts := feed.GetHeader().GetTimestamp(); ts > 0 {
+		return int64(ts)
+	}
+	return fallback
+}
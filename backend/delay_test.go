@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+func TestDeparturesDelayAndUncertainty(t *testing.T) {
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "L08", Name: "Bedford Av", Routes: []string{"L"}}})
+
+	late := testTripUpdate("L", "late", []string{"L08N"}, []int64{300})
+	late.TripUpdate.StopTimeUpdate[0].Departure.Delay = proto.Int32(240)
+	late.TripUpdate.StopTimeUpdate[0].Departure.Uncertainty = proto.Int32(0)
+	tripDelay := testTripUpdate("L", "tripdelay", []string{"L08N"}, []int64{400})
+	tripDelay.TripUpdate.Delay = proto.Int32(-60)
+	unknown := testTripUpdate("L", "unknown", []string{"L08N"}, []int64{500})
+
+	feed := newTestFeed(late, tripDelay, unknown)
+	// The feed was made a minute ago: ETAs count from then, not from now
+	feed.Header.Timestamp = proto.Uint64(uint64(time.Now().Unix() - 60))
+	deps, err := departuresFromSource(data().Stations[0], departureFilter{Limit: 3}, func(string) (*gtfs_realtime.FeedMessage, error) { return feed, nil })
+	if err != nil || len(deps) != 3 {
+		t.Fatalf("expected three departures, got %+v (%v)", deps, err)
+	}
+	if d := deps[0]; d.DelaySeconds == nil || *d.DelaySeconds != 240 || d.UncertaintySeconds == nil || *d.UncertaintySeconds != 0 {
+		t.Errorf("expected the stop's delay and an exact prediction, got %+v", d)
+	}
+	if d := deps[0]; d.ETASeconds < 359 || d.ETASeconds > 361 {
+		t.Errorf("expected the ETA counted from the feed timestamp (360s), got %d", d.ETASeconds)
+	}
+	if d := deps[1]; d.DelaySeconds == nil || *d.DelaySeconds != -60 || d.UncertaintySeconds != nil {
+		t.Errorf("expected the trip's delay, got %+v", d)
+	}
+	if d := deps[2]; d.DelaySeconds != nil || d.UncertaintySeconds != nil {
+		t.Errorf("expected no delay or uncertainty, got %+v", d)
+	}
+
+	b := appendDeparturePB(nil, deps[0])
+	var sawUncertainty bool
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if num == 17 {
+			sawUncertainty = true
+		}
+		b = b[n:]
+	}
+	if !sawUncertainty {
+		t.Error("expected a zero uncertainty_seconds to be encoded")
+	}
+}
+
+func TestFeedClockFallback(t *testing.T) {
+	if got := feedClock(&gtfs_realtime.FeedMessage{}, 42); got != 42 {
+		t.Errorf("feedClock without a header = %d, want 42", got)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+// Demand-driven poll rates.
+//
+// With a fixed poll_interval every feed is refreshed at the same rate whether anyone is
+// looking at its routes or not. poll_demand instead polls feeds that served a request
+// (including WebSocket and stream updates) within the last window at hot_interval, and
+// the rest at idle_interval:
+//
+//   "poll_demand": {"hot_interval": "15s", "idle_interval": "60s", "window": "5m"}
+//
+// Setting poll_demand starts the poller on its own; poll_interval is then ignored.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PollDemandConfig is the poll_demand config object
+type PollDemandConfig struct {
+	HotInterval  Duration `json:"hot_interval"`
+	IdleInterval Duration `json:"idle_interval"`
+	Window       Duration `json:"window"` // how long a request keeps its feeds hot (default 5m)
+}
+
+func (c PollDemandConfig) enabled() bool {
+	return c.HotInterval > 0 && c.IdleInterval > 0
+}
+
+func (c PollDemandConfig) window() time.Duration {
+	return c.Window.orDefault(5 * time.Minute)
+}
+
+// demandTracker remembers when each feed last served a request
+type demandTracker struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+var feedDemand = &demandTracker{last: map[string]time.Time{}}
+
+func (d *demandTracker) mark(url string, at time.Time) {
+	d.mu.Lock()
+	d.last[url] = at
+	d.mu.Unlock()
+}
+
+// hot reports whether url served a request within window of now
+func (d *demandTracker) hot(url string, now time.Time, window time.Duration) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	last, ok := d.last[url]
+	return ok && now.Sub(last) <= window
+}
+
+// demandPoller decides which feeds are due on each hot_interval tick
+type demandPoller struct {
+	cfg        PollDemandConfig
+	lastPolled map[string]time.Time
+	wasHot     map[string]bool
+}
+
+func newDemandPoller(cfg PollDemandConfig) *demandPoller {
+	return &demandPoller{cfg: cfg, lastPolled: map[string]time.Time{}, wasHot: map[string]bool{}}
+}
+
+// due returns the feeds to poll now and records them as polled. Hot feeds poll every
+// tick; idle feeds once idle_interval has passed since their last poll.
+func (p *demandPoller) due(urls []string, now time.Time) []string {
+	var out []string
+	for _, u := range urls {
+		hot := feedDemand.hot(u, now, p.cfg.window())
+		if hot != p.wasHot[u] {
+			state := "idle"
+			if hot {
+				state = "hot"
+			}
+			log.Printf("poller: %s is now %s", u, state)
+			p.wasHot[u] = hot
+		}
+		last, polled := p.lastPolled[u]
+		// Ticks drift a little; allow a tenth of the hot interval of slack
+		slack := time.Duration(p.cfg.HotInterval) / 10
+		interval := time.Duration(p.cfg.IdleInterval)
+		if hot {
+			interval = 0
+		}
+		if own, ok := feedPollInterval(u); ok {
+			interval = own // the feed's own interval, hot or idle (see feedttl.go)
+		}
+		if !polled || now.Sub(last)+slack >= interval {
+			out = append(out, u)
+			p.lastPolled[u] = now
+		}
+	}
+	return out
+}
+
+// startDemandPoller polls urls at demand-dependent rates until ctx is cancelled
+func startDemandPoller(ctx context.Context, urls []string, cfg PollDemandConfig) {
+	log.Printf("Starting demand-driven feed poller for %d feeds (hot every %s, idle every %s)",
+		len(urls), time.Duration(cfg.HotInterval), time.Duration(cfg.IdleInterval))
+	p := newDemandPoller(cfg)
+	// Feeds serving a response are hot
+	pollerInterval, pollerFeedInterval = time.Duration(cfg.HotInterval), time.Duration(cfg.HotInterval)
+	atomic.StoreInt64(&pollerHeartbeat, time.Now().UnixNano())
+	go func() {
+		ticker := time.NewTicker(time.Duration(cfg.HotInterval))
+		defer ticker.Stop()
+		for {
+			pollFeedsOnce(p.due(urls, time.Now()))
+			atomic.StoreInt64(&pollerHeartbeat, time.Now().UnixNano())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// validatePollDemand checks the poll_demand config value
+func validatePollDemand(v json.RawMessage) string {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(v, &raw); err != nil {
+		return `expected an object like {"hot_interval": "15s", "idle_interval": "60s", "window": "5m"}`
+	}
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	durations := map[string]time.Duration{}
+	for _, k := range keys {
+		if k != "hot_interval" && k != "idle_interval" && k != "window" {
+			return fmt.Sprintf("unknown key %q (expected hot_interval, idle_interval and window)", k)
+		}
+		if msg := validateConfigValue("", kindDuration, raw[k]); msg != "" {
+			return k + ": " + msg
+		}
+		var s string
+		_ = json.Unmarshal(raw[k], &s)
+		durations[k], _ = time.ParseDuration(s)
+	}
+	hot, idle := durations["hot_interval"], durations["idle_interval"]
+	if hot == 0 || idle == 0 {
+		return "hot_interval and idle_interval are both required"
+	}
+	if idle < hot {
+		return fmt.Sprintf("idle_interval (%s) must not be shorter than hot_interval (%s)", idle, hot)
+	}
+	return ""
+}
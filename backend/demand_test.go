@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+func TestDemandPollerSchedule(t *testing.T) {
+	original := feedDemand
+	t.Cleanup(func() { feedDemand = original })
+	feedDemand = &demandTracker{last: map[string]time.Time{}}
+
+	cfg := PollDemandConfig{HotInterval: Duration(15 * time.Second), IdleInterval: Duration(60 * time.Second), Window: Duration(5 * time.Minute)}
+	p := newDemandPoller(cfg)
+	urls := []string{"l", "si"}
+	t0 := time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)
+	feedDemand.mark("l", t0)
+
+	// Everything is polled once at startup, then only the hot feed until idle_interval
+	var polled []string
+	for i := 0; i <= 8; i++ {
+		for _, u := range p.due(urls, t0.Add(time.Duration(i)*15*time.Second)) {
+			polled = append(polled, u)
+		}
+	}
+	want := []string{"l", "si", "l", "l", "l", "l", "si", "l", "l", "l", "l", "si"}
+	if !reflect.DeepEqual(polled, want) {
+		t.Errorf("polled %v, want %v", polled, want)
+	}
+
+	// Once demand expires the L drops to the idle rate
+	p.due(urls, t0.Add(5*time.Minute+15*time.Second))
+	if got := p.due(urls, t0.Add(5*time.Minute+30*time.Second)); len(got) != 0 {
+		t.Errorf("expected nothing due once the L went idle, got %v", got)
+	}
+}
+
+func TestDeparturesMarkFeedDemand(t *testing.T) {
+	original := feedDemand
+	t.Cleanup(func() { feedDemand = original })
+	feedDemand = &demandTracker{last: map[string]time.Time{}}
+	useTestFeeds(t, "test-feed")
+
+	s := Station{StopID: "L08", Name: "Bedford Av"}
+	departuresFromSource(s, departureFilter{}, func(string) (*gtfs_realtime.FeedMessage, error) { return newTestFeed(), nil })
+	if !feedDemand.hot("test-feed", time.Now(), time.Minute) {
+		t.Error("expected a request to mark its feed as in demand")
+	}
+}
+
+func TestValidatePollDemand(t *testing.T) {
+	tests := map[string]string{
+		`{"hot_interval": "15s", "idle_interval": "60s"}`:                 "",
+		`{"hot_interval": "15s", "idle_interval": "60s", "window": "2m"}`: "",
+		`{"hot_interval": "15s"}`:                                         "both required",
+		`{"hot_interval": "60s", "idle_interval": "15s"}`:                 "must not be shorter",
+		`{"hot_interval": "15s", "idle_interval": "60s", "cold": "1h"}`:   `unknown key "cold"`,
+		`{"hot_interval": "soon", "idle_interval": "60s"}`:                "hot_interval: invalid duration",
+	}
+	for in, want := range tests {
+		got := validatePollDemand([]byte(in))
+		if (want == "") != (got == "") || !strings.Contains(got, want) {
+			t.Errorf("validatePollDemand(%s) = %q, want %q", in, got, want)
+		}
+	}
+}
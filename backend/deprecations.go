@@ -0,0 +1,187 @@
+package main
+
+// Deprecation signaling for endpoints and response fields.
+//
+// Config key deprecations lists what is going away, so integrators learn about response
+// shape changes from the responses themselves:
+//
+//   "deprecations": [
+//     {"path": "/api/departures/nearest-multi", "since": "2026-11-01", "sunset": "2027-03-01",
+//      "link": "https://example.com/migrating-to-v2", "message": "use nearest?count=<n>"},
+//     {"path": "/api/departures/by-id", "field": "headsign", "since": "2026-11-01",
+//      "message": "replaced by destination in v2"}
+//   ]
+//
+// A path ending in "/" covers everything below it. Responses from a deprecated endpoint
+// (no field) carry the Deprecation (RFC 9745) and Sunset (RFC 8594) headers; every match
+// adds a Link with rel="deprecation" when a link is given. JSON object responses also list
+// the matching entries under meta.deprecations. Dates are YYYY-MM-DD or RFC 3339.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DeprecationConfig is one entry of the deprecations config key
+type DeprecationConfig struct {
+	Path    string `json:"path"`
+	Field   string `json:"field,omitempty"`  // a response field rather than the whole endpoint
+	Since   string `json:"since"`            // when it was deprecated
+	Sunset  string `json:"sunset,omitempty"` // when it stops working
+	Link    string `json:"link,omitempty"`   // migration notes
+	Message string `json:"message,omitempty"`
+}
+
+type deprecation struct {
+	DeprecationConfig
+	since, sunset time.Time
+}
+
+var deprecations []deprecation
+
+// parseDeprecationDate accepts YYYY-MM-DD (midnight UTC) or RFC 3339
+func parseDeprecationDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad date %q (want YYYY-MM-DD or RFC 3339)", s)
+	}
+	return t, nil
+}
+
+func parseDeprecation(c DeprecationConfig) (deprecation, error) {
+	d := deprecation{DeprecationConfig: c}
+	if !strings.HasPrefix(c.Path, "/") {
+		return d, fmt.Errorf("path must start with /, got %q", c.Path)
+	}
+	var err error
+	if d.since, err = parseDeprecationDate(c.Since); err != nil {
+		return d, fmt.Errorf("since: %w", err)
+	}
+	if c.Sunset != "" {
+		if d.sunset, err = parseDeprecationDate(c.Sunset); err != nil {
+			return d, fmt.Errorf("sunset: %w", err)
+		}
+		if !d.sunset.After(d.since) {
+			return d, fmt.Errorf("sunset must be after since")
+		}
+	}
+	return d, nil
+}
+
+func validateDeprecations(v json.RawMessage) string {
+	var list []DeprecationConfig
+	if err := json.Unmarshal(v, &list); err != nil {
+		return `expected an array like [{"path": "/api/departures/nearest-multi", "since": "2026-11-01", "sunset": "2027-03-01"}]`
+	}
+	for i, c := range list {
+		if _, err := parseDeprecation(c); err != nil {
+			return fmt.Sprintf("[%d]: %v", i, err)
+		}
+	}
+	return ""
+}
+
+// configureDeprecations installs the configured entries (validated by loadConfig)
+func configureDeprecations(list []DeprecationConfig) {
+	deprecations = nil
+	for _, c := range list {
+		if d, err := parseDeprecation(c); err == nil {
+			deprecations = append(deprecations, d)
+		}
+	}
+}
+
+func (d deprecation) matches(path string) bool {
+	if strings.HasSuffix(d.Path, "/") {
+		return strings.HasPrefix(path, d.Path)
+	}
+	return path == d.Path
+}
+
+// deprecationsFor lists the entries covering a request path
+func deprecationsFor(path string) []deprecation {
+	var out []deprecation
+	for _, d := range deprecations {
+		if d.matches(path) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// setDeprecationHeaders writes the Deprecation, Sunset and Link headers for the matches
+func setDeprecationHeaders(h http.Header, matched []deprecation) {
+	for _, d := range matched {
+		if d.Field == "" {
+			h.Set("Deprecation", fmt.Sprintf("@%d", d.since.Unix()))
+			if !d.sunset.IsZero() {
+				h.Set("Sunset", d.sunset.UTC().Format(http.TimeFormat))
+			}
+		}
+		if d.Link != "" {
+			h.Add("Link", fmt.Sprintf("<%s>; rel=\"deprecation\"", d.Link))
+		}
+	}
+}
+
+// addDeprecationMeta lists the matches under meta.deprecations of a JSON object body
+func addDeprecationMeta(body []byte, matched []deprecation) ([]byte, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return nil, err
+	}
+	meta := map[string]any{}
+	if raw, ok := obj["meta"]; ok {
+		if err := json.Unmarshal(raw, &meta); err != nil {
+			return nil, err
+		}
+	}
+	list := make([]DeprecationConfig, len(matched))
+	for i, d := range matched {
+		list[i] = d.DeprecationConfig
+	}
+	meta["deprecations"] = list
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	obj["meta"] = b
+	out, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// withDeprecations signals configured deprecations on matching responses
+func withDeprecations(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		matched := deprecationsFor(r.URL.Path)
+		if len(matched) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		setDeprecationHeaders(w.Header(), matched)
+		if r.URL.Path == "/api/departures/stream" || r.URL.Path == "/ws" {
+			h.ServeHTTP(w, r) // streams can't be buffered; headers only
+			return
+		}
+		buf := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		h.ServeHTTP(buf, r)
+		body := buf.body.Bytes()
+		if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") && bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+			if out, err := addDeprecationMeta(body, matched); err == nil {
+				body = out
+			}
+		}
+		w.WriteHeader(buf.status)
+		w.Write(body)
+	})
+}
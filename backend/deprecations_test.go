@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDeprecationSignals(t *testing.T) {
+	original := deprecations
+	t.Cleanup(func() { deprecations = original })
+	configureDeprecations([]DeprecationConfig{
+		{Path: "/old", Since: "2026-11-01", Sunset: "2027-03-01", Link: "https://example.com/v2", Message: "use /new"},
+		{Path: "/fields/", Field: "headsign", Since: "2026-11-01T12:00:00Z"},
+	})
+	handler := withDeprecations(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"departures": []string{"6"}, "meta": map[string]any{"version": 1}})
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/old", nil))
+	if got := w.Header().Get("Deprecation"); got != "@1793491200" {
+		t.Errorf("Deprecation = %q", got)
+	}
+	if got := w.Header().Get("Sunset"); got != "Mon, 01 Mar 2027 00:00:00 GMT" {
+		t.Errorf("Sunset = %q", got)
+	}
+	if got := w.Header().Get("Link"); got != `<https://example.com/v2>; rel="deprecation"` {
+		t.Errorf("Link = %q", got)
+	}
+	var body struct {
+		Departures []string `json:"departures"`
+		Meta       struct {
+			Version      int                 `json:"version"`
+			Deprecations []DeprecationConfig `json:"deprecations"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Departures) != 1 || body.Meta.Version != 1 || len(body.Meta.Deprecations) != 1 || body.Meta.Deprecations[0].Message != "use /new" {
+		t.Errorf("unexpected body %s", w.Body.String())
+	}
+
+	// A deprecated field is listed without deprecating the endpoint
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/fields/by-id", nil))
+	if w.Header().Get("Deprecation") != "" || !strings.Contains(w.Body.String(), `"field": "headsign"`) {
+		t.Errorf("unexpected field deprecation response: %v %s", w.Header(), w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/current", nil))
+	if w.Header().Get("Deprecation") != "" || strings.Contains(w.Body.String(), "deprecations") {
+		t.Errorf("unexpected signals on a current endpoint: %v %s", w.Header(), w.Body.String())
+	}
+}
+
+func TestValidateDeprecations(t *testing.T) {
+	for _, tt := range []struct{ config, want string }{
+		{`[{"path": "/old", "since": "2026-11-01"}]`, ""},
+		{`[{"path": "old", "since": "2026-11-01"}]`, "path must start with /"},
+		{`[{"path": "/old"}]`, "since: bad date"},
+		{`[{"path": "/old", "since": "2026-11-01", "sunset": "2026-10-01"}]`, "sunset must be after since"},
+		{`{"path": "/old"}`, "expected an array"},
+	} {
+		got := validateDeprecations(json.RawMessage(tt.config))
+		if (tt.want == "") != (got == "") || !strings.Contains(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.config, got, tt.want)
+		}
+	}
+}
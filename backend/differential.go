@@ -0,0 +1,99 @@
+package main
+
+// DIFFERENTIAL feeds.
+//
+// A feed whose header declares DIFFERENTIAL incrementality only sends what changed since
+// its previous message: new and updated entities, and is_deleted tombstones for removed
+// ones. Read as complete, such a message would lose every trip that didn't change. Each
+// differential message is therefore merged, by entity ID, into the dataset built from the
+// feed's earlier messages (starting from its last FULL_DATASET message, if any), and the
+// merged FULL_DATASET message is what callers get, cache and proxy. The subway feeds are
+// FULL_DATASET today; this keeps departures whole if one switches.
+
+import (
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// mergedFeed is the current full dataset of one feed
+type mergedFeed struct {
+	source *gtfs_realtime.FeedMessage // the last message merged in, as parsed
+	msg    *gtfs_realtime.FeedMessage // the full dataset after it
+	body   []byte                     // msg encoded
+}
+
+type differentialStore struct {
+	mu    sync.Mutex
+	byURL map[string]*mergedFeed
+}
+
+var differentials = &differentialStore{byURL: map[string]*mergedFeed{}}
+
+// apply folds a parsed message of url into the feed's dataset and returns the full
+// dataset. Applying the same message again (a 304 reusing it) returns the same result.
+func (s *differentialStore) apply(url string, msg *gtfs_realtime.FeedMessage, body []byte) (*gtfs_realtime.FeedMessage, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur := s.byURL[url]
+	if cur != nil && cur.source == msg {
+		return cur.msg, cur.body, nil
+	}
+	if msg.GetHeader().GetIncrementality() != gtfs_realtime.FeedHeader_DIFFERENTIAL {
+		s.byURL[url] = &mergedFeed{source: msg, msg: msg, body: body}
+		return msg, body, nil
+	}
+	var base *gtfs_realtime.FeedMessage
+	if cur != nil {
+		base = cur.msg
+	}
+	merged := mergeDifferential(base, msg)
+	b, err := proto.Marshal(merged)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.byURL[url] = &mergedFeed{source: msg, msg: merged, body: b}
+	return merged, b, nil
+}
+
+// mergeDifferential applies a differential message to a full dataset (nil for none),
+// keeping the base's entity order with new entities at the end
+func mergeDifferential(base, diff *gtfs_realtime.FeedMessage) *gtfs_realtime.FeedMessage {
+	updates := map[string]*gtfs_realtime.FeedEntity{}
+	var added []*gtfs_realtime.FeedEntity
+	for _, ent := range diff.GetEntity() {
+		if _, seen := updates[ent.GetId()]; !seen {
+			added = append(added, ent)
+		}
+		updates[ent.GetId()] = ent // a later entry for the same ID wins
+	}
+	var entities []*gtfs_realtime.FeedEntity
+	inBase := map[string]bool{}
+	for _, ent := range base.GetEntity() {
+		id := ent.GetId()
+		inBase[id] = true
+		if upd, ok := updates[id]; ok {
+			ent = upd
+		}
+		if !ent.GetIsDeleted() {
+			entities = append(entities, ent)
+		}
+	}
+	for _, ent := range added {
+		id := ent.GetId()
+		if inBase[id] {
+			continue
+		}
+		if ent = updates[id]; !ent.GetIsDeleted() {
+			entities = append(entities, ent)
+		}
+	}
+
+	header := proto.Clone(diff.GetHeader()).(*gtfs_realtime.FeedHeader)
+	if header == nil {
+		header = &gtfs_realtime.FeedHeader{GtfsRealtimeVersion: proto.String("2.0")}
+	}
+	header.Incrementality = gtfs_realtime.FeedHeader_FULL_DATASET.Enum()
+	return &gtfs_realtime.FeedMessage{Header: header, Entity: entities}
+}
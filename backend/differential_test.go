@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+func entityIDs(msg *gtfs_realtime.FeedMessage) []string {
+	var ids []string
+	for _, ent := range msg.GetEntity() {
+		ids = append(ids, ent.GetId())
+	}
+	return ids
+}
+
+func newDifferentialFeed(entities ...*gtfs_realtime.FeedEntity) *gtfs_realtime.FeedMessage {
+	msg := newTestFeed(entities...)
+	msg.Header.Incrementality = gtfs_realtime.FeedHeader_DIFFERENTIAL.Enum()
+	return msg
+}
+
+func TestMergeDifferential(t *testing.T) {
+	base := newTestFeed(
+		testTripUpdate("L", "a", []string{"L08N"}, []int64{60}),
+		testTripUpdate("L", "b", []string{"L08N"}, []int64{120}),
+		testTripUpdate("L", "c", []string{"L08N"}, []int64{180}),
+	)
+	updatedB := testTripUpdate("L", "b", []string{"L08N"}, []int64{150})
+	diff := newDifferentialFeed(
+		updatedB,
+		&gtfs_realtime.FeedEntity{Id: proto.String("c"), IsDeleted: proto.Bool(true)},
+		testTripUpdate("L", "d", []string{"L08N"}, []int64{240}),
+		&gtfs_realtime.FeedEntity{Id: proto.String("never-seen"), IsDeleted: proto.Bool(true)},
+	)
+	merged := mergeDifferential(base, diff)
+	if got := entityIDs(merged); !reflect.DeepEqual(got, []string{"a", "b", "d"}) {
+		t.Fatalf("merged entities = %v", got)
+	}
+	if merged.GetEntity()[1] != updatedB {
+		t.Error("expected the updated entity to replace the old one")
+	}
+	if merged.GetHeader().GetIncrementality() != gtfs_realtime.FeedHeader_FULL_DATASET || diff.GetHeader().GetIncrementality() != gtfs_realtime.FeedHeader_DIFFERENTIAL {
+		t.Error("expected a FULL_DATASET result without changing the differential message")
+	}
+	if got := entityIDs(mergeDifferential(nil, diff)); !reflect.DeepEqual(got, []string{"b", "d"}) {
+		t.Errorf("merged onto nothing = %v", got)
+	}
+}
+
+func TestDownloadDifferentialFeed(t *testing.T) {
+	messages := []*gtfs_realtime.FeedMessage{
+		newTestFeed(
+			testTripUpdate("L", "a", []string{"L08N"}, []int64{60}),
+			testTripUpdate("L", "b", []string{"L08N"}, []int64{120}),
+		),
+		newDifferentialFeed(testTripUpdate("L", "c", []string{"L08N"}, []int64{180})),
+		newDifferentialFeed(&gtfs_realtime.FeedEntity{Id: proto.String("a"), IsDeleted: proto.Bool(true)}),
+	}
+	served := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := proto.Marshal(messages[served])
+		served++
+		w.Write(b)
+	}))
+	defer server.Close()
+
+	want := [][]string{{"a", "b"}, {"a", "b", "c"}, {"b", "c"}}
+	for i, ids := range want {
+		msg, b, err := downloadFeedMessage(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := entityIDs(msg); !reflect.DeepEqual(got, ids) {
+			t.Errorf("fetch %d: entities = %v, want %v", i, got, ids)
+		}
+		var decoded gtfs_realtime.FeedMessage
+		if err := proto.Unmarshal(b, &decoded); err != nil || !reflect.DeepEqual(entityIDs(&decoded), ids) {
+			t.Errorf("fetch %d: expected the bytes of the merged feed, got %v (%v)", i, entityIDs(&decoded), err)
+		}
+	}
+
+	// Reapplying the last message (as after a 304) doesn't merge it twice
+	last := messages[2]
+	first, _, _ := differentials.apply(server.URL, last, nil)
+	again, _, _ := differentials.apply(server.URL, last, nil)
+	if first != again {
+		t.Error("expected the same merged feed for the same message")
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+// Scheduled alert digests: a commute briefing delivered through the notifier.
+//
+//   "digests": [{"name": "morning", "at": "07:00", "days": ["weekdays"], "routes": ["6", "L"]}],
+//   "notifier": {"type": "slack", "url": "https://hooks.slack.com/..."}
+//
+// At each configured time (America/New_York) the alerts feed is read and the alerts
+// affecting the chosen routes are sent: those active now, and planned ones starting within
+// horizon (default 12h). Days are mon..sun, "weekdays" or "weekends"; empty means daily.
+// Webhook and MQTT targets get the Digest as JSON, Slack gets the text rendering.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultDigestHorizon is how far ahead planned alerts are included
+const defaultDigestHorizon = 12 * time.Hour
+
+// DigestConfig is one scheduled digest
+type DigestConfig struct {
+	Name    string   `json:"name"`
+	At      string   `json:"at"`   // local time of day, "07:00"
+	Days    []string `json:"days"` // mon..sun, weekdays, weekends (empty = every day)
+	Routes  []string `json:"routes"`
+	Horizon Duration `json:"horizon"` // planned alerts starting within this window
+}
+
+var digestDayNames = map[string][]time.Weekday{
+	"sun": {time.Sunday}, "mon": {time.Monday}, "tue": {time.Tuesday}, "wed": {time.Wednesday},
+	"thu": {time.Thursday}, "fri": {time.Friday}, "sat": {time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends": {time.Saturday, time.Sunday},
+}
+
+// Digest is one delivered briefing
+type Digest struct {
+	Name        string         `json:"name"`
+	Routes      []string       `json:"routes"`
+	GeneratedAt int64          `json:"generated_at"`
+	Active      []ServiceAlert `json:"active"`
+	Planned     []ServiceAlert `json:"planned"` // starting within the horizon
+}
+
+// parseClock reads "HH:MM"
+func parseClock(at string) (hour, minute int, err error) {
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid time %q (expected HH:MM)", at)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// runsOn reports whether the digest is scheduled on the weekday
+func (d DigestConfig) runsOn(day time.Weekday) bool {
+	if len(d.Days) == 0 {
+		return true
+	}
+	for _, name := range d.Days {
+		for _, wd := range digestDayNames[strings.ToLower(name)] {
+			if wd == day {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// next returns the first scheduled time strictly after t
+func (d DigestConfig) next(t time.Time) time.Time {
+	hour, minute, err := parseClock(d.At)
+	if err != nil {
+		return time.Time{}
+	}
+	local := t.In(transitLocation)
+	for i := 0; i <= 7; i++ {
+		at := time.Date(local.Year(), local.Month(), local.Day()+i, hour, minute, 0, 0, transitLocation)
+		if at.After(t) && d.runsOn(at.Weekday()) {
+			return at
+		}
+	}
+	return time.Time{}
+}
+
+// buildDigest sorts the alerts (active through now+horizon) affecting the digest's routes
+// into active and planned
+func buildDigest(d DigestConfig, alerts []ServiceAlert, now time.Time) Digest {
+	out := Digest{Name: d.Name, Routes: d.Routes, GeneratedAt: now.Unix(), Active: []ServiceAlert{}, Planned: []ServiceAlert{}}
+	for _, a := range alerts {
+		affected := false
+		for _, route := range d.Routes {
+			if a.affects(Station{}, route) {
+				affected = true
+				break
+			}
+		}
+		switch {
+		case !affected:
+		case a.Start > now.Unix():
+			out.Planned = append(out.Planned, a)
+		default:
+			out.Active = append(out.Active, a)
+		}
+	}
+	sort.SliceStable(out.Planned, func(i, j int) bool { return out.Planned[i].Start < out.Planned[j].Start })
+	return out
+}
+
+// text renders the digest for chat targets
+func (d Digest) text() string {
+	routes := strings.Join(d.Routes, ", ")
+	if len(d.Active) == 0 && len(d.Planned) == 0 {
+		return fmt.Sprintf("No active or planned alerts for %s.", routes)
+	}
+	var b strings.Builder
+	if len(d.Active) > 0 {
+		fmt.Fprintf(&b, "Now on %s:\n", routes)
+		for _, a := range d.Active {
+			fmt.Fprintf(&b, "• [%s] %s\n", strings.Join(a.Routes, "/"), a.Header)
+		}
+	}
+	if len(d.Planned) > 0 {
+		fmt.Fprintf(&b, "Planned on %s:\n", routes)
+		for _, a := range d.Planned {
+			start := time.Unix(a.Start, 0).In(transitLocation).Format("Mon 3:04pm")
+			fmt.Fprintf(&b, "• %s [%s] %s\n", start, strings.Join(a.Routes, "/"), a.Header)
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// sendDigest reads the alerts feed and delivers one digest
+func sendDigest(ctx context.Context, d DigestConfig, n notifier) error {
+	feed, err := fetchGTFS(alertsFeedURL)
+	if err != nil {
+		return fmt.Errorf("alerts feed: %w", err)
+	}
+	now := nowFunc()
+	alerts := parseAlertsBetween(feed, now, now.Add(d.Horizon.orDefault(defaultDigestHorizon)))
+	digest := buildDigest(d, alerts, now)
+	return n.notify(ctx, notification{Title: "Subway digest: " + d.Name, Text: digest.text(), Data: digest})
+}
+
+// startDigests schedules every digest until ctx is done
+func startDigests(ctx context.Context, digests []DigestConfig, n notifier) {
+	for _, d := range digests {
+		go func(d DigestConfig) {
+			for {
+				at := d.next(nowFunc())
+				if at.IsZero() {
+					return
+				}
+				log.Printf("Digest %s scheduled for %s", d.Name, at.Format(time.RFC3339))
+				timer := time.NewTimer(time.Until(at))
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+				if err := sendDigest(ctx, d, n); err != nil {
+					log.Printf("Warning: digest %s not sent: %v", d.Name, err)
+				} else {
+					log.Printf("Digest %s sent", d.Name)
+				}
+			}
+		}(d)
+	}
+}
+
+// validateDigests checks the "digests" config value
+func validateDigests(v json.RawMessage) string {
+	var list []map[string]json.RawMessage
+	if err := json.Unmarshal(v, &list); err != nil {
+		return `expected an array like [{"name": "morning", "at": "07:00", "days": ["weekdays"], "routes": ["6"]}]`
+	}
+	for i, raw := range list {
+		for k := range raw {
+			if k != "name" && k != "at" && k != "days" && k != "routes" && k != "horizon" {
+				return fmt.Sprintf("[%d]: unknown key %q (expected name, at, days, routes and horizon)", i, k)
+			}
+		}
+		var d DigestConfig
+		if err := json.Unmarshal(raw["name"], &d.Name); err != nil || d.Name == "" {
+			return fmt.Sprintf("[%d]: name is required", i)
+		}
+		if err := json.Unmarshal(raw["at"], &d.At); err != nil {
+			return fmt.Sprintf("[%d] %s: at is required", i, d.Name)
+		}
+		if _, _, err := parseClock(d.At); err != nil {
+			return fmt.Sprintf("[%d] %s: %v", i, d.Name, err)
+		}
+		if raw["days"] != nil {
+			if err := json.Unmarshal(raw["days"], &d.Days); err != nil {
+				return fmt.Sprintf("[%d] %s: days must be an array of strings", i, d.Name)
+			}
+			for _, day := range d.Days {
+				if _, ok := digestDayNames[strings.ToLower(day)]; !ok {
+					return fmt.Sprintf("[%d] %s: unknown day %q (expected mon..sun, weekdays or weekends)", i, d.Name, day)
+				}
+			}
+		}
+		if err := json.Unmarshal(raw["routes"], &d.Routes); err != nil || len(d.Routes) == 0 {
+			return fmt.Sprintf("[%d] %s: routes must be a non-empty array", i, d.Name)
+		}
+		if raw["horizon"] != nil {
+			if msg := validateConfigValue("", kindDuration, raw["horizon"]); msg != "" {
+				return fmt.Sprintf("[%d] %s: horizon: %s", i, d.Name, msg)
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+func TestDigestNext(t *testing.T) {
+	d := DigestConfig{Name: "morning", At: "07:00", Days: []string{"weekdays"}}
+	// Friday 2024-03-08 08:00 New York: next run skips the weekend (and the DST change)
+	fri := time.Date(2024, 3, 8, 8, 0, 0, 0, transitLocation)
+	if got, want := d.next(fri), time.Date(2024, 3, 11, 7, 0, 0, 0, transitLocation); !got.Equal(want) {
+		t.Errorf("next after Friday 8am = %v, want %v", got, want)
+	}
+	// Before today's time it runs today
+	if got, want := d.next(fri.Add(-2*time.Hour)), time.Date(2024, 3, 8, 7, 0, 0, 0, transitLocation); !got.Equal(want) {
+		t.Errorf("next after Friday 6am = %v, want %v", got, want)
+	}
+	daily := DigestConfig{At: "18:30"}
+	if got, want := daily.next(fri), time.Date(2024, 3, 8, 18, 30, 0, 0, transitLocation); !got.Equal(want) {
+		t.Errorf("daily next = %v, want %v", got, want)
+	}
+}
+
+func TestSendDigest(t *testing.T) {
+	initTestCaches()
+	now := time.Date(2024, 3, 11, 7, 0, 0, 0, transitLocation)
+	originalNow := nowFunc
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = originalNow }()
+	hour := int64(3600)
+	useTestAlerts(t,
+		testAlert("active", "6 trains delayed", gtfs_realtime.Alert_SIGNIFICANT_DELAYS, nil, [2]string{"6", ""}),
+		testAlert("planned", "No L trains overnight", gtfs_realtime.Alert_NO_SERVICE, [][2]int64{{now.Unix() + 3*hour, now.Unix() + 6*hour}}, [2]string{"L", ""}),
+		testAlert("later", "Weekend L work", gtfs_realtime.Alert_DETOUR, [][2]int64{{now.Unix() + 48*hour, 0}}, [2]string{"L", ""}),
+		testAlert("other", "A train delays", gtfs_realtime.Alert_SIGNIFICANT_DELAYS, nil, [2]string{"A", ""}),
+	)
+	var got Digest
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer hook.Close()
+
+	d := DigestConfig{Name: "morning", At: "07:00", Routes: []string{"6", "L"}}
+	if err := sendDigest(context.Background(), d, webhookNotifier{url: hook.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Active) != 1 || got.Active[0].ID != "active" {
+		t.Errorf("expected only the 6 alert active, got %+v", got.Active)
+	}
+	if len(got.Planned) != 1 || got.Planned[0].ID != "planned" {
+		t.Errorf("expected only the L alert within the horizon planned, got %+v", got.Planned)
+	}
+}
+
+func TestDigestText(t *testing.T) {
+	now := time.Date(2024, 3, 11, 7, 0, 0, 0, transitLocation)
+	d := Digest{Routes: []string{"6", "L"}}
+	if got := d.text(); got != "No active or planned alerts for 6, L." {
+		t.Errorf("empty digest text = %q", got)
+	}
+	d.Active = []ServiceAlert{{Header: "6 trains delayed", Routes: []string{"6"}}}
+	d.Planned = []ServiceAlert{{Header: "No L trains", Routes: []string{"L"}, Start: now.Add(3 * time.Hour).Unix()}}
+	want := "Now on 6, L:\n• [6] 6 trains delayed\nPlanned on 6, L:\n• Mon 10:00am [L] No L trains"
+	if got := d.text(); got != want {
+		t.Errorf("digest text = %q, want %q", got, want)
+	}
+}
+
+func TestValidateDigests(t *testing.T) {
+	cases := map[string]string{
+		`[{"name": "am", "at": "07:00", "days": ["weekdays", "sat"], "routes": ["6"], "horizon": "6h"}]`: "",
+		`[{"name": "am", "at": "7am", "routes": ["6"]}]`:                                                 "expected HH:MM",
+		`[{"name": "am", "at": "07:00", "days": ["someday"], "routes": ["6"]}]`:                          "unknown day",
+		`[{"name": "am", "at": "07:00"}]`:                                                                "routes must be",
+		`[{"at": "07:00", "routes": ["6"]}]`:                                                             "name is required",
+		`{"name": "am"}`:                                                                                 "expected an array",
+	}
+	for in, want := range cases {
+		got := validateDigests(json.RawMessage(in))
+		if (want == "" && got != "") || !strings.Contains(got, want) {
+			t.Errorf("validateDigests(%s) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+// `nyc-subway doctor`: check the upstream data against what the service assumes.
+//
+//   CONFIG_FILE=/etc/nyc-subway.json nyc-subway doctor
+//
+// Downloads every configured upstream and runs it through the same parsers the service
+// uses at startup, so a renamed CSV column or an unparseable feed shows up as a named
+// check with the offending source instead of a cryptic runtime error. It also compares
+// the routes seen in the schedule and the realtime feeds with routeToFeed. Prints one line
+// per check and exits 1 when any check fails; warnings don't affect the exit code.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// Check outcomes
+const (
+	doctorOK   = "OK"
+	doctorWarn = "WARN"
+	doctorFail = "FAIL"
+)
+
+// doctorCheck is one line of the report
+type doctorCheck struct {
+	Name   string
+	Status string
+	Detail string
+}
+
+type doctorReport []doctorCheck
+
+func (r *doctorReport) add(name, status, format string, args ...any) {
+	*r = append(*r, doctorCheck{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+// check records err as a failure, or the formatted detail as a pass
+func (r *doctorReport) check(name string, err error, format string, args ...any) bool {
+	if err != nil {
+		r.add(name, doctorFail, "%v", err)
+		return false
+	}
+	r.add(name, doctorOK, format, args...)
+	return true
+}
+
+func (r doctorReport) failed() bool {
+	for _, c := range r {
+		if c.Status == doctorFail {
+			return true
+		}
+	}
+	return false
+}
+
+// runDoctor implements `nyc-subway doctor [config]` and returns the exit code
+func runDoctor(args []string, out io.Writer) int {
+	if !setupSubcommand(args, out) {
+		return 2
+	}
+	report := runDoctorChecks(context.Background())
+	for _, c := range report {
+		fmt.Fprintf(out, "%-4s %s: %s\n", c.Status, c.Name, c.Detail)
+	}
+	if report.failed() {
+		return 1
+	}
+	return 0
+}
+
+// runDoctorChecks checks the static data, the realtime feeds and the route mapping
+func runDoctorChecks(ctx context.Context) doctorReport {
+	var report doctorReport
+	scheduled := doctorStatic(ctx, &report)
+	realtime := doctorFeeds(&report)
+	doctorRoutes(&report, scheduled, realtime)
+	return report
+}
+
+// doctorStatic checks the GTFS zip and the station CSVs, returning the scheduled routes
+func doctorStatic(ctx context.Context, report *doctorReport) map[string]bool {
+	routes := map[string]bool{}
+	zf, err := openGTFSZip(ctx, gtfsZipURL)
+	if !report.check("gtfs zip", err, "%s", gtfsZipURL) {
+		return routes
+	}
+	defer zf.Close()
+
+	if rc, err := zf.openMember("stops.txt"); err != nil {
+		report.check("stops.txt", err, "")
+	} else {
+		list, err := parseGTFSStations(rc)
+		rc.Close()
+		if err == nil && len(list) == 0 {
+			err = fmt.Errorf("no parent stations")
+		}
+		report.check("stops.txt", err, "%d stations", len(list))
+	}
+	if rc, err := zf.openMember("trips.txt"); err != nil {
+		report.check("trips.txt", err, "")
+	} else {
+		list, err := parseTrips(rc)
+		rc.Close()
+		for _, t := range list {
+			routes[t.RouteID] = true
+		}
+		report.check("trips.txt", err, "%d trips on %d routes", len(list), len(routes))
+	}
+	// The service runs without these, so problems are warnings
+	for _, member := range []struct {
+		name string
+		load func(*gtfsZip) error
+	}{
+		{"stop_times.txt", func(zf *gtfsZip) error { _, err := loadStopTimesIndex(zf); return err }},
+		{"transfers.txt", loadTransfers},
+		{"routes.txt", loadRoutes},
+		{"translations.txt", loadGTFSTranslations},
+		{"calendar.txt", func(zf *gtfsZip) error { _, err := loadCalendar(zf); return err }},
+	} {
+		if err := member.load(zf); err != nil {
+			report.add(member.name, doctorWarn, "%v", err)
+		} else {
+			report.add(member.name, doctorOK, "parsed")
+		}
+	}
+
+	if stationsCSV != "" {
+		err := loadStations(ctx, stationsCSV)
+		report.check("stations_csv", err, "%d stations from %s", len(data().Stations), stationsCSV)
+	}
+	if mtaStationsCSV != "" {
+		report.check("mta_stations_csv", loadRouteMapping(ctx), "%s", mtaStationsCSV)
+	}
+	if stationPhotosCSV != "" {
+		report.check("station_photos_csv", loadStationPhotos(ctx, stationPhotosCSV), "%s", stationPhotosCSV)
+	}
+	if placesCSV != "" {
+		report.check("places_csv", loadPlaces(ctx, placesCSV), "%d places", len(places))
+	}
+	if src := appConfig.StationTranslationsCSV; src != "" {
+		report.check("station_translations_csv", loadStationTranslations(ctx, src), "%s", src)
+	}
+	if src := appConfig.EntrancesCSV; src != "" {
+		report.check("entrances_csv", loadEntrances(ctx, src), "%d stations with entrances", len(stationEntrances))
+	}
+	if src := appConfig.RidershipCSV; src != "" {
+		report.check("ridership_csv", loadRidership(ctx, src), "%d station complexes", len(stationRidership))
+	}
+	return routes
+}
+
+// doctorFeeds fetches and parses every realtime feed, returning route -> feeds it was seen in
+func doctorFeeds(report *doctorReport) map[string][]string {
+	seen := map[string][]string{}
+	for _, u := range feedURLs {
+		name := "feed " + feedName(u)
+		feed, err := doctorFetchFeed(u)
+		if err != nil {
+			report.add(name, doctorFail, "%v", err)
+			continue
+		}
+		routes := map[string]bool{}
+		for _, e := range feed.GetEntity() {
+			if r := e.GetTripUpdate().GetTrip().GetRouteId(); r != "" {
+				routes[r] = true
+			}
+		}
+		for r := range routes {
+			seen[r] = append(seen[r], u)
+		}
+		age := time.Since(time.Unix(int64(feed.GetHeader().GetTimestamp()), 0)).Round(time.Second)
+		if feed.GetHeader().GetTimestamp() == 0 || age > 5*time.Minute {
+			report.add(name, doctorWarn, "%d entities, %d routes, but the header timestamp is %s old", len(feed.GetEntity()), len(routes), age)
+			continue
+		}
+		report.add(name, doctorOK, "%d entities, %d routes, %s old", len(feed.GetEntity()), len(routes), age)
+	}
+	if alertsFeedURL != "" {
+		feed, err := doctorFetchFeed(alertsFeedURL)
+		if err != nil {
+			report.add("alerts feed", doctorFail, "%v", err)
+		} else {
+			report.add("alerts feed", doctorOK, "%d entities", len(feed.GetEntity()))
+		}
+	}
+	return seen
+}
+
+func doctorFetchFeed(u string) (*gtfs_realtime.FeedMessage, error) {
+	b, err := downloadFeed(u)
+	if err != nil {
+		return nil, err
+	}
+	var feed gtfs_realtime.FeedMessage
+	if err := proto.Unmarshal(b, &feed); err != nil {
+		return nil, fmt.Errorf("parse protobuf: %w", err)
+	}
+	return &feed, nil
+}
+
+// mappedFeed is the feed routeToFeed assigns a route, as getFeedsForStation resolves it
+func mappedFeed(route string) (string, bool) {
+	if u, ok := routeToFeed[route]; ok {
+		return u, true
+	}
+	if strings.HasSuffix(route, "X") { // express variants share their line's feed
+		u, ok := routeToFeed[strings.TrimSuffix(route, "X")]
+		return u, ok
+	}
+	return "", false
+}
+
+// doctorRoutes compares observed routes with routeToFeed: an unmapped route falls back
+// to fetching every feed, and a route mapped to the wrong feed never shows departures
+func doctorRoutes(report *doctorReport, scheduled map[string]bool, realtime map[string][]string) {
+	var unmapped, misplaced []string
+	for r := range scheduled {
+		if _, ok := mappedFeed(r); !ok {
+			unmapped = append(unmapped, r)
+		}
+	}
+	for r, feeds := range realtime {
+		mapped, ok := mappedFeed(r)
+		switch {
+		case !ok && !scheduled[r]:
+			unmapped = append(unmapped, r)
+		case ok && !containsString(feeds, mapped):
+			misplaced = append(misplaced, fmt.Sprintf("%s (in %s, mapped to %s)", r, feedName(feeds[0]), feedName(mapped)))
+		}
+	}
+	sort.Strings(unmapped)
+	sort.Strings(misplaced)
+	if len(unmapped) > 0 {
+		report.add("routeToFeed", doctorFail, "no feed mapped for routes %s", strings.Join(unmapped, ", "))
+	}
+	if len(misplaced) > 0 {
+		report.add("routeToFeed", doctorFail, "routes mapped to the wrong feed: %s", strings.Join(misplaced, "; "))
+	}
+	if len(unmapped)+len(misplaced) == 0 {
+		report.add("routeToFeed", doctorOK, "%d scheduled and %d realtime routes mapped", len(scheduled), len(realtime))
+	}
+}
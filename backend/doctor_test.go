@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDoctorChecks(t *testing.T) {
+	keepTestData(t)
+	originalRouteToFeed := routeToFeed
+	originalZip, originalCSV, originalMTA := gtfsZipURL, stationsCSV, mtaStationsCSV
+	originalPhotos, originalPlaces := stationPhotosCSV, placesCSV
+	t.Cleanup(func() {
+		routeToFeed = originalRouteToFeed
+		gtfsZipURL, stationsCSV, mtaStationsCSV = originalZip, originalCSV, originalMTA
+		stationPhotosCSV, placesCSV = originalPhotos, originalPlaces
+	})
+
+	zip := newTestGTFSServer(t, map[string]string{
+		"stops.txt":      testGTFSStops,
+		"trips.txt":      "route_id,trip_id,service_id,trip_headsign,direction_id\n6,T1,Weekday,Brooklyn Bridge,1\n6X,T2,Weekday,Brooklyn Bridge,1\nZ,T3,Weekday,Broad St,1\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nT1,08:00:00,08:00:00,635S,1\n",
+	})
+	defer zip.Close()
+	gtfsZipURL = zip.URL
+
+	// A stations CSV whose columns were renamed upstream
+	drifted := filepath.Join(t.TempDir(), "stations.csv")
+	os.WriteFile(drifted, []byte("Stop ID,Stop Name,Latitude,Longitude\n635,14 St,40.73,-73.99\n"), 0o644)
+	stationsCSV, mtaStationsCSV, stationPhotosCSV, placesCSV = drifted, "", "", ""
+
+	numbered := newTestFeedServer(t, testTripUpdate("6", "t1", []string{"635N"}, []int64{60}), testTripUpdate("L", "t2", []string{"L01N"}, []int64{60}))
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("<html>oops</html>")) }))
+	defer broken.Close()
+	useTestFeeds(t, numbered.URL+"/nyct%2Fgtfs", broken.URL+"/nyct%2Fgtfs-l")
+	routeToFeed = map[string]string{"6": numbered.URL + "/nyct%2Fgtfs", "L": broken.URL + "/nyct%2Fgtfs-l"}
+
+	report := runDoctorChecks(context.Background())
+	status := map[string]string{}
+	var lines []string
+	for _, c := range report {
+		status[c.Name] = c.Status
+		lines = append(lines, c.Status+" "+c.Name+": "+c.Detail)
+	}
+	text := strings.Join(lines, "\n")
+	for name, want := range map[string]string{
+		"gtfs zip":         doctorOK,
+		"stops.txt":        doctorOK,
+		"trips.txt":        doctorOK,
+		"transfers.txt":    doctorWarn, // optional and missing
+		"stations_csv":     doctorFail,
+		"feed gtfs":        doctorOK,
+		"feed gtfs-l":      doctorFail,
+		"routeToFeed":      doctorFail,
+		"translations.txt": doctorOK,
+		"stop_times.txt":   doctorOK,
+		"mta_stations_csv": "",
+		"feed gtfs-ace":    "",
+	} {
+		if status[name] != want {
+			t.Errorf("%s: status %q, want %q\n%s", name, status[name], want, text)
+		}
+	}
+	for _, want := range []string{
+		"stations csv missing column 'gtfsstopid'",
+		"parse protobuf",
+		"no feed mapped for routes Z", // 6X resolves to the 6's feed
+		"L (in gtfs, mapped to gtfs-l)",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("report missing %q:\n%s", want, text)
+		}
+	}
+	if !report.failed() {
+		t.Error("expected the report to fail")
+	}
+}
+
+func TestRunDoctorBadConfig(t *testing.T) {
+	var out bytes.Buffer
+	if code := runDoctor([]string{filepath.Join(t.TempDir(), "missing.json")}, &out); code != 2 || !strings.Contains(out.String(), "read config") {
+		t.Errorf("expected exit 2 for a missing config, got %d: %s", code, out.String())
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+// Station entrances.
+//
+// A station's point in the stations CSV is roughly the middle of its platforms, which at a
+// big station can be a block or more from the street entrance a rider actually uses. With
+// config key entrances_csv set to the NY Open Data "MTA Subway Entrances and Exits"
+// export (https://data.ny.gov/Transportation/MTA-Subway-Entrances-and-Exits-2024/i9wp-a4ja),
+// walks end at an entrance instead: the one that makes the shortest trip from the rider
+// to the platform (or station point) through it. Exit-only entrances are skipped.
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Entrance is a street entrance of a station
+type Entrance struct {
+	Type string  `json:"type,omitempty"` // e.g. Stair, Elevator, Escalator
+	Lat  float64 `json:"lat"`
+	Lon  float64 `json:"lon"`
+}
+
+// stationEntrances is keyed by parent stop ID
+var stationEntrances map[string][]Entrance
+
+// loadEntrances loads station entrances from a CSV export (columns: GTFS Stop ID, Entrance
+// Latitude, Entrance Longitude, and optionally Entrance Type and Entry Allowed)
+func loadEntrances(ctx context.Context, csvURL string) error {
+	body, err := openDataSource(ctx, csvURL)
+	if err != nil {
+		return fmt.Errorf("download entrances: %w", err)
+	}
+	defer body.Close()
+	r := csv.NewReader(body)
+	r.FieldsPerRecord = -1
+
+	need := []string{"gtfsstopid", "entrancelatitude", "entrancelongitude"}
+	idx, err := parseCSVHeaders(r, need, "entrances")
+	if err != nil {
+		return err
+	}
+	optional := func(row []string, name string) string {
+		if i, ok := idx[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	out := make(map[string][]Entrance)
+	count := 0
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read entrances row: %w", err)
+		}
+		stopID := strings.TrimSpace(row[idx["gtfsstopid"]])
+		lat, err1 := strconv.ParseFloat(strings.TrimSpace(row[idx["entrancelatitude"]]), 64)
+		lon, err2 := strconv.ParseFloat(strings.TrimSpace(row[idx["entrancelongitude"]]), 64)
+		if stopID == "" || err1 != nil || err2 != nil || (lat == 0 && lon == 0) {
+			continue
+		}
+		if strings.EqualFold(optional(row, "entryallowed"), "NO") {
+			continue // exit only
+		}
+		key := parentStopID(stopID)
+		out[key] = append(out[key], Entrance{Type: optional(row, "entrancetype"), Lat: lat, Lon: lon})
+		count++
+	}
+	stationEntrances = out
+	log.Printf("Loaded %d entrances for %d stations", count, len(out))
+	return nil
+}
+
+// walkDestination is where a walk from (fromLat, fromLon) to s should end: the entrance
+// on the shortest way to walkTarget, or walkTarget itself when no entrances are known
+func walkDestination(s Station, direction string, fromLat, fromLon float64) (lat, lon float64, entrance *Entrance) {
+	lat, lon = walkTarget(s, direction)
+	var best float64
+	for _, e := range stationEntrances[parentStopID(s.StopID)] {
+		if isEntranceClosed(s, e) {
+			continue
+		}
+		d := haversine(fromLat, fromLon, e.Lat, e.Lon) + haversine(e.Lat, e.Lon, lat, lon)
+		if entrance == nil || d < best {
+			e := e
+			entrance, best = &e, d
+		}
+	}
+	if entrance == nil {
+		return lat, lon, nil
+	}
+	return entrance.Lat, entrance.Lon, entrance
+}
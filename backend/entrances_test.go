@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLoadEntrances(t *testing.T) {
+	original := stationEntrances
+	t.Cleanup(func() { stationEntrances = original })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `Division,Line,Borough,Stop Name,Complex ID,GTFS Stop ID,Entrance Type,Entry Allowed,Exit Allowed,Entrance Latitude,Entrance Longitude
+IRT,Lexington Av,M,14 St-Union Sq,602,635,Stair,YES,YES,40.73471,-73.99028
+IRT,Lexington Av,M,14 St-Union Sq,602,635,Elevator,YES,YES,40.73530,-73.98990
+IRT,Lexington Av,M,14 St-Union Sq,602,635,Stair,NO,YES,40.73400,-73.99100
+IRT,Lexington Av,M,14 St-Union Sq,602,,Stair,YES,YES,40.73400,-73.99100
+BMT,Canarsie,M,14 St-Union Sq,602,L03,Stair,YES,YES,,
+`)
+	}))
+	defer server.Close()
+
+	if err := loadEntrances(context.Background(), server.URL); err != nil {
+		t.Fatal(err)
+	}
+	// Exit-only rows and rows without a stop or coordinates are skipped
+	if len(stationEntrances) != 1 || len(stationEntrances["635"]) != 2 {
+		t.Fatalf("unexpected entrances %v", stationEntrances)
+	}
+	if e := stationEntrances["635"][1]; e.Type != "Elevator" || e.Lat != 40.7353 {
+		t.Errorf("unexpected entrance %+v", e)
+	}
+}
+
+func TestWalkDestination(t *testing.T) {
+	original := stationEntrances
+	t.Cleanup(func() { stationEntrances = original })
+	s := Station{StopID: "M11", Lat: 40.697207, Lon: -73.935657, Platforms: []Platform{
+		{StopID: "M11N", Direction: "N", Lat: 40.6975, Lon: -73.935},
+		{StopID: "M11S", Direction: "S", Lat: 40.6969, Lon: -73.9363},
+	}}
+
+	stationEntrances = nil
+	if lat, lon, e := walkDestination(s, "S", 40.6960, -73.9370); e != nil || lat != 40.6969 || lon != -73.9363 {
+		t.Errorf("expected the platform without entrances, got %f,%f %v", lat, lon, e)
+	}
+
+	stationEntrances = map[string][]Entrance{"M11": {
+		{Type: "Stair", Lat: 40.6977, Lon: -73.9347}, // by the northbound platform
+		{Type: "Stair", Lat: 40.6967, Lon: -73.9366}, // by the southbound platform
+	}}
+	// From the south, either platform is reached through the nearer entrance
+	if lat, _, e := walkDestination(s, "S", 40.6960, -73.9370); e == nil || lat != 40.6967 {
+		t.Errorf("expected the southern entrance, got %f %v", lat, e)
+	}
+	// From the north, without a direction, the northern entrance is on the way to the station point
+	if lat, _, e := walkDestination(s, "", 40.6990, -73.9340); e == nil || lat != 40.6977 {
+		t.Errorf("expected the northern entrance, got %f %v", lat, e)
+	}
+}
+
+// A nearest request walks to the entrance closest to the rider and reports it
+func TestNearestWalksToEntrance(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+	originalEntrances := stationEntrances
+	t.Cleanup(func() { stationEntrances = originalEntrances })
+	setTestStations([]Station{{StopID: "635", Name: "14 St-Union Sq", Lat: 40.734673, Lon: -73.989951}})
+	stationEntrances = map[string][]Entrance{"635": {
+		{Type: "Stair", Lat: 40.7330, Lon: -73.9910},
+		{Type: "Elevator", Lat: 40.7360, Lon: -73.9890},
+	}}
+	server := newTestFeedServer(t, testTripUpdate("6", "trip6", []string{"635S"}, []int64{300}))
+	useTestFeeds(t, server.URL)
+
+	var mu sync.Mutex
+	var paths []string
+	osrm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		fmt.Fprint(w, `{"routes": [{"duration": 60, "distance": 80}]}`)
+	}))
+	defer osrm.Close()
+	original := osrmBaseURL
+	osrmBaseURL = osrm.URL
+	defer func() { osrmBaseURL = original }()
+
+	w := httptest.NewRecorder()
+	handleNearest(w, httptest.NewRequest("GET", "/api/departures/nearest?lat=40.7375&lon=-73.9880", nil))
+	var resp NearestResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Entrance == nil || resp.Entrance.Type != "Elevator" {
+		t.Errorf("expected the elevator entrance, got %+v", resp.Entrance)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 1 || !strings.HasSuffix(paths[0], ";-73.989000,40.736000") {
+		t.Errorf("expected a walk to the elevator, got %v", paths)
+	}
+}
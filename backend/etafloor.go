@@ -0,0 +1,83 @@
+package main
+
+// ETA floors from scheduled run times.
+//
+// Feed glitches occasionally predict a train arriving in 30 seconds from four stops away.
+// A realtime trip's stop time updates start at the stop it will reach next, so the train
+// is at least the run time between that stop and the rider's station away. That run time
+// is the sum of the fastest scheduled time for the route over each hop on the way (from
+// stop_times.txt), less etaFloorSlack for trains running ahead of schedule. ETAs below the
+// floor are raised to it and marked eta_clamped. Hops the schedule doesn't know (reroutes,
+// GO service) leave the ETA as the feed reported it.
+
+import (
+	"sort"
+)
+
+// etaFloorSlack is the share of the scheduled run time a train is assumed to need at least
+const etaFloorSlack = 0.8
+
+// runTimeKey identifies a hop between consecutive stops of a route, as parent stop IDs
+func runTimeKey(from, to string) string {
+	return parentStopID(from) + ">" + parentStopID(to)
+}
+
+// buildRunTimes finds, for each route, the shortest scheduled time between consecutive
+// stops (route ID -> runTimeKey -> seconds)
+func buildRunTimes(list []Trip, ix *stopTimesIndex) map[string]map[string]int32 {
+	if ix == nil {
+		return nil
+	}
+	routeOf := make(map[string]string, len(list))
+	for _, t := range list {
+		routeOf[t.TripID] = t.RouteID
+	}
+	type call struct {
+		stop    string
+		seconds int32
+	}
+	calls := make([][]call, len(ix.TripIDs))
+	for stop, deps := range ix.Departures {
+		for _, d := range deps {
+			calls[d.Trip] = append(calls[d.Trip], call{stop, d.Seconds})
+		}
+	}
+
+	out := map[string]map[string]int32{}
+	for trip, cs := range calls {
+		route := routeOf[ix.TripIDs[trip]]
+		if route == "" || len(cs) < 2 {
+			continue
+		}
+		sort.Slice(cs, func(i, j int) bool { return cs[i].seconds < cs[j].seconds })
+		if out[route] == nil {
+			out[route] = map[string]int32{}
+		}
+		for i := 1; i < len(cs); i++ {
+			key := runTimeKey(cs[i-1].stop, cs[i].stop)
+			run := cs[i].seconds - cs[i-1].seconds
+			if prev, ok := out[route][key]; !ok || run < prev {
+				out[route][key] = run
+			}
+		}
+	}
+	return out
+}
+
+// etaFloor is the shortest plausible time for a train of route to travel along stops, from
+// the first (where it is headed now) to the last, or false when a hop is unknown
+func etaFloor(runTimes map[string]map[string]int32, route string, stops []string) (int64, bool) {
+	hops := runTimes[route]
+	if hops == nil {
+		return 0, false
+	}
+	var total int32
+	for i := 1; i < len(stops); i++ {
+		run, ok := hops[runTimeKey(stops[i-1], stops[i])]
+		if !ok {
+			return 0, false
+		}
+		total += run
+	}
+	return int64(float64(total) * etaFloorSlack), true
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+const etaFloorStopTimes = `trip_id,arrival_time,departure_time,stop_id,stop_sequence
+slow,08:00:00,08:00:00,L11N,1
+slow,08:02:00,08:02:00,L10N,2
+slow,08:04:00,08:04:00,L08N,3
+fast,09:00:00,09:00:00,L11N,1
+fast,09:01:40,09:01:40,L10N,2
+fast,09:04:00,09:04:00,L08N,3
+`
+
+func TestBuildRunTimes(t *testing.T) {
+	ix, err := buildStopTimesIndex(strings.NewReader(etaFloorStopTimes), "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := []Trip{{TripID: "slow", RouteID: "L"}, {TripID: "fast", RouteID: "L"}}
+	got := buildRunTimes(list, ix)["L"]
+	// The fastest run over each hop, even when it comes from different trips
+	if got["L11>L10"] != 100 || got["L10>L08"] != 120 || len(got) != 2 {
+		t.Errorf("unexpected run times %v", got)
+	}
+	if buildRunTimes(list, nil) != nil {
+		t.Error("expected no run times without a schedule index")
+	}
+}
+
+func TestETAFloor(t *testing.T) {
+	runTimes := map[string]map[string]int32{"L": {"L11>L10": 100, "L10>L08": 150}}
+	if floor, ok := etaFloor(runTimes, "L", []string{"L11N", "L10N", "L08N"}); !ok || floor != 200 {
+		t.Errorf("expected a floor of 200s, got %d (%v)", floor, ok)
+	}
+	if floor, ok := etaFloor(runTimes, "L", []string{"L08N"}); !ok || floor != 0 {
+		t.Errorf("expected no floor at the next stop, got %d (%v)", floor, ok)
+	}
+	if _, ok := etaFloor(runTimes, "L", []string{"L11N", "L08N"}); ok {
+		t.Error("expected an unknown hop to give no floor")
+	}
+	if _, ok := etaFloor(runTimes, "M", []string{"L11N", "L10N"}); ok {
+		t.Error("expected an unknown route to give no floor")
+	}
+}
+
+func TestDeparturesClampImplausibleETA(t *testing.T) {
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "L08", Name: "Bedford Av", Routes: []string{"L"}}})
+	setTestRunTimes(map[string]map[string]int32{"L": {"L11>L10": 100, "L10>L08": 150}})
+
+	feed := newTestFeed(
+		testTripUpdate("L", "glitch", []string{"L11N", "L10N", "L08N"}, []int64{10, 20, 30}),
+		testTripUpdate("L", "plausible", []string{"L10N", "L08N"}, []int64{60, 300}),
+	)
+	deps, err := departuresFromSource(data().Stations[0], departureFilter{}, func(string) (*gtfs_realtime.FeedMessage, error) { return feed, nil })
+	if err != nil || len(deps) != 2 {
+		t.Fatalf("expected two departures, got %+v (%v)", deps, err)
+	}
+	glitch, plausible := deps[0], deps[1]
+	if glitch.TripID != "glitch" {
+		glitch, plausible = plausible, glitch
+	}
+	// Two hops away: at least (100+150)*0.8 = 200s
+	if !glitch.ETAClamped || glitch.ETASeconds < 199 || glitch.ETASeconds > 201 {
+		t.Errorf("expected the glitched ETA raised to 200s, got %+v", glitch)
+	}
+	if plausible.ETAClamped || plausible.ETASeconds < 299 {
+		t.Errorf("expected the plausible ETA left alone, got %+v", plausible)
+	}
+}
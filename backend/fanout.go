@@ -0,0 +1,112 @@
+package main
+
+// Limits and accounting for the streaming endpoints (/api/departures/stream and /ws).
+//
+// Each client address may hold max_streams_per_ip (default 10) streams at once; more get
+// a 429. A WebSocket client's send buffer holds wsSendBuffer messages; when a slow client
+// lets it fill, the oldest queued message is dropped to make room, so the hub's fanout
+// never waits on one connection and memory per client stays bounded. SSE streams compute
+// each event in their own goroutine from the latest data, so a slow reader only delays
+// itself and refreshes that arrive meanwhile are coalesced. Connection, rejection and drop
+// counts are exported on /metrics.
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Stream transports, as metric labels
+const (
+	transportSSE = "sse"
+	transportWS  = "ws"
+)
+
+// streamCounters tracks open streams per address and what was rejected or dropped
+type streamCounters struct {
+	mu       sync.Mutex
+	perIP    map[string]int
+	open     map[string]int64 // transport -> open streams
+	rejected map[string]int64 // transport -> connections refused over the per-IP limit
+	dropped  map[string]int64 // transport -> messages dropped for slow clients
+}
+
+var streamStats = &streamCounters{perIP: map[string]int{}, open: map[string]int64{}, rejected: map[string]int64{}, dropped: map[string]int64{}}
+
+// maxStreamsPerIP is the configured per-address stream limit
+func maxStreamsPerIP() int {
+	if n := appConfig.MaxStreamsPerIP; n > 0 {
+		return n
+	}
+	return 10
+}
+
+// streamAddr is the client address a stream counts against; RemoteAddr already holds
+// the forwarded client when the request came through a trusted proxy (see network.go)
+func streamAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// acquire registers a stream for addr, or reports false when addr is at the limit
+func (s *streamCounters) acquire(transport, addr string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.perIP[addr] >= maxStreamsPerIP() {
+		s.rejected[transport]++
+		return false
+	}
+	s.perIP[addr]++
+	s.open[transport]++
+	return true
+}
+
+func (s *streamCounters) release(transport, addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.perIP[addr]--; s.perIP[addr] <= 0 {
+		delete(s.perIP, addr)
+	}
+	s.open[transport]--
+}
+
+func (s *streamCounters) drop(transport string) {
+	s.mu.Lock()
+	s.dropped[transport]++
+	s.mu.Unlock()
+}
+
+// acquireStream admits a stream request or answers 429; callers must release admitted
+// streams
+func acquireStream(w http.ResponseWriter, r *http.Request, transport string) bool {
+	if streamStats.acquire(transport, streamAddr(r)) {
+		return true
+	}
+	httpError(w, http.StatusTooManyRequests, fmt.Sprintf("too many open streams from this address (max %d)", maxStreamsPerIP()))
+	return false
+}
+
+// writeMetrics writes the stream gauges and counters in Prometheus text format
+func (s *streamCounters) writeMetrics(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range []struct {
+		name, help, typ string
+		counts          map[string]int64
+	}{
+		{"nyc_subway_stream_connections", "Open streaming connections.", "gauge", s.open},
+		{"nyc_subway_stream_rejected_connections_total", "Streams refused over the per-address limit.", "counter", s.rejected},
+		{"nyc_subway_stream_dropped_events_total", "Messages dropped because a client's send buffer was full.", "counter", s.dropped},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+		for _, t := range []string{transportSSE, transportWS} {
+			fmt.Fprintf(w, "%s{transport=%q} %d\n", m.name, t, m.counts[t])
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func useTestStreamCounters(t *testing.T, limit int) {
+	original, originalLimit := streamStats, appConfig.MaxStreamsPerIP
+	streamStats = &streamCounters{perIP: map[string]int{}, open: map[string]int64{}, rejected: map[string]int64{}, dropped: map[string]int64{}}
+	appConfig.MaxStreamsPerIP = limit
+	t.Cleanup(func() { streamStats, appConfig.MaxStreamsPerIP = original, originalLimit })
+}
+
+func TestStreamsPerIPLimit(t *testing.T) {
+	useTestStreamCounters(t, 2)
+
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.RemoteAddr = "192.0.2.7:5000"
+	for i := 0; i < 2; i++ {
+		if !acquireStream(httptest.NewRecorder(), req, transportWS) {
+			t.Fatalf("stream %d rejected under the limit", i)
+		}
+	}
+	w := httptest.NewRecorder()
+	if acquireStream(w, req, transportSSE) || w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 over the limit, got %d", w.Code)
+	}
+
+	// Other addresses have their own allowance
+	other := httptest.NewRequest("GET", "/ws", nil)
+	other.RemoteAddr = "192.0.2.8:5000"
+	if !acquireStream(httptest.NewRecorder(), other, transportSSE) {
+		t.Error("expected a different address to be admitted")
+	}
+
+	// Closing a stream makes room
+	streamStats.release(transportWS, streamAddr(req))
+	if !acquireStream(httptest.NewRecorder(), req, transportWS) {
+		t.Error("expected a stream to be admitted after one closed")
+	}
+
+	var b strings.Builder
+	streamStats.writeMetrics(&b)
+	for _, want := range []string{
+		`nyc_subway_stream_connections{transport="ws"} 2`,
+		`nyc_subway_stream_connections{transport="sse"} 1`,
+		`nyc_subway_stream_rejected_connections_total{transport="sse"} 1`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, b.String())
+		}
+	}
+	if split := splitMetricFamily(b.String()); split != "" {
+		t.Errorf("family %s is not one group of HELP, TYPE and samples:\n%s", split, b.String())
+	}
+}
+
+// splitMetricFamily returns the first metric family whose HELP, TYPE and sample lines
+// aren't contiguous in a Prometheus text exposition, or "" when every family is grouped
+func splitMetricFamily(body string) string {
+	seen := map[string]bool{}
+	current := ""
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		name := line
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			name = line[len("# HELP "):]
+		}
+		if i := strings.IndexAny(name, "{ "); i >= 0 {
+			name = name[:i]
+		}
+		if name == current {
+			continue
+		}
+		if seen[name] {
+			return name
+		}
+		seen[name], current = true, name
+	}
+	return ""
+}
+
+func TestEnqueueDropsOldest(t *testing.T) {
+	useTestStreamCounters(t, 0)
+	c := &wsClient{send: make(chan []byte, 2), done: make(chan struct{})}
+	for _, typ := range []string{"a", "b", "c", "d"} {
+		c.enqueue(wsMessage{Type: typ})
+	}
+	select {
+	case <-c.done:
+		t.Fatal("a slow client should not be disconnected")
+	default:
+	}
+	var got []string
+	for len(c.send) > 0 {
+		got = append(got, string(<-c.send))
+	}
+	if len(got) != 2 || !strings.Contains(got[0], `"c"`) || !strings.Contains(got[1], `"d"`) {
+		t.Errorf("expected the newest messages to be kept, got %v", got)
+	}
+	if streamStats.dropped[transportWS] != 2 {
+		t.Errorf("expected 2 dropped messages, got %d", streamStats.dropped[transportWS])
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+// Concurrent feed fetching.
+//
+// A station served by several lines needs several feeds (Times Sq: 1/2/3, N/Q/R/W, 7 and
+// the shuttle), and fetched one after another a request waits for the sum of their
+// download times. departuresFromSource starts them all at once, at most
+// feedFetchWorkers at a time, and merges each feed as soon as it (and the ones before it,
+// so results stay in feed order) has arrived. Each network fetch gets its own
+// feedFetchTimeout, so one stalled feed costs a partial response, not the whole request.
+
+import (
+	"time"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+const (
+	feedFetchWorkers = 4               // concurrent fetches per request
+	feedFetchTimeout = 8 * time.Second // per feed download, within httpClient's timeout
+)
+
+// pendingFeed is a feed being fetched; done is closed once feed or err is set
+type pendingFeed struct {
+	done chan struct{}
+	feed *gtfs_realtime.FeedMessage
+	err  error
+}
+
+// wait blocks until the fetch has finished
+func (p *pendingFeed) wait() (*gtfs_realtime.FeedMessage, error) {
+	<-p.done
+	return p.feed, p.err
+}
+
+// fetchFeeds starts fetching urls, at most feedFetchWorkers at a time, and returns their
+// pending results in the same order
+func fetchFeeds(urls []string, fetch func(string) (*gtfs_realtime.FeedMessage, error)) []*pendingFeed {
+	out := make([]*pendingFeed, len(urls))
+	for i := range out {
+		out[i] = &pendingFeed{done: make(chan struct{})}
+	}
+	sem := make(chan struct{}, feedFetchWorkers)
+	for i, u := range urls {
+		go func(p *pendingFeed, u string) {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			p.feed, p.err = fetch(u)
+			close(p.done)
+		}(out[i], u)
+	}
+	return out
+}
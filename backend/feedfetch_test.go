@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+func TestFetchFeedsConcurrently(t *testing.T) {
+	var mu sync.Mutex
+	running, peak := 0, 0
+	fetch := func(u string) (*gtfs_realtime.FeedMessage, error) {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(30 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if u == "bad" {
+			return nil, fmt.Errorf("unavailable")
+		}
+		return newTestFeed(testTripUpdate("6", u, []string{"635N"}, []int64{60})), nil
+	}
+
+	urls := []string{"a", "b", "bad", "c", "d", "e", "f", "g"}
+	start := time.Now()
+	pending := fetchFeeds(urls, fetch)
+	for i, u := range urls {
+		feed, err := pending[i].wait()
+		if u == "bad" {
+			if err == nil {
+				t.Error("expected the failing feed's error")
+			}
+			continue
+		}
+		if err != nil || feed.GetEntity()[0].GetId() != u {
+			t.Errorf("result %d: expected feed %s, got %v (%v)", i, u, feed, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 6*30*time.Millisecond {
+		t.Errorf("expected concurrent fetches, took %v", elapsed)
+	}
+	if peak > feedFetchWorkers || peak < 2 {
+		t.Errorf("expected between 2 and %d fetches at once, got %d", feedFetchWorkers, peak)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+// Raw GTFS-RT feed proxy for other GTFS-RT consumers.
+//
+//   GET /api/feeds                 list of feed names
+//   GET /api/feeds/{name}          raw protobuf, e.g. /api/feeds/gtfs-ace
+//
+// Names are the MTA's own: the last segment of the feed URL ("gtfs", "gtfs-ace", ...,
+// "subway-alerts"). Bodies come from transitFeedCache, so however many clients poll, each
+// upstream feed is fetched at most once per cache TTL; Cache-Control advertises that TTL.
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// FeedInfo is one entry of the feed list
+type FeedInfo struct {
+	Name string `json:"name"`
+	Href string `json:"href"`
+}
+
+// feedName is the proxy name of a feed URL
+func feedName(feedURL string) string {
+	u, err := url.Parse(feedURL)
+	if err != nil {
+		return ""
+	}
+	p := u.Path // decoded, so "nyct%2Fgtfs-ace" is "nyct/gtfs-ace"
+	return p[strings.LastIndex(p, "/")+1:]
+}
+
+// validateFeedName checks a config key names a proxied feed
+func validateFeedName(name string) string {
+	feeds := proxiedFeeds()
+	if _, ok := feeds[name]; ok {
+		return ""
+	}
+	known := make([]string, 0, len(feeds))
+	for n := range feeds {
+		known = append(known, n)
+	}
+	sort.Strings(known)
+	return fmt.Sprintf("unknown feed %q (expected any of: %s)", name, strings.Join(known, ", "))
+}
+
+// proxiedFeeds maps names to the realtime and alerts feed URLs
+func proxiedFeeds() map[string]string {
+	out := map[string]string{}
+	for _, u := range append([]string{alertsFeedURL}, feedURLs...) {
+		if name := feedName(u); u != "" && name != "" {
+			out[name] = u
+		}
+	}
+	return out
+}
+
+// rawFeed returns a feed's protobuf bytes through transitFeedCache, and whether they
+// were cached already
+func rawFeed(feedURL string) ([]byte, bool, error) {
+	if cached, err := transitFeedCache.Get(feedURL); err == nil {
+		if b, ok := cached.([]byte); ok {
+			return b, true, nil
+		}
+	}
+	feed, err := fetchGTFSWithCache(feedURL)
+	if err != nil {
+		return nil, false, err
+	}
+	if cached, err := transitFeedCache.Get(feedURL); err == nil {
+		if b, ok := cached.([]byte); ok {
+			return b, false, nil
+		}
+	}
+	b, err := proto.Marshal(feed) // evicted straight away; re-encode the parsed copy
+	return b, false, err
+}
+
+func handleFeeds(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/feeds"), "/")
+	feeds := proxiedFeeds()
+	if name == "" {
+		list := make([]FeedInfo, 0, len(feeds))
+		for n := range feeds {
+			list = append(list, FeedInfo{Name: n, Href: "/api/feeds/" + n})
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		writeJSON(w, list)
+		log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+		return
+	}
+	feedURL, ok := feeds[name]
+	if !ok {
+		httpError(w, http.StatusNotFound, "unknown feed")
+		return
+	}
+	b, hit, err := rawFeed(feedURL)
+	if err != nil {
+		httpError(w, http.StatusBadGateway, fmt.Sprintf("feed %s unavailable: %v", name, err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(feedCacheTTL(feedURL).Seconds())))
+	if hit {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		w.Header().Set("X-Cache", "MISS")
+	}
+	w.Write(b)
+	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+}
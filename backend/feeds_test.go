@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+func TestFeedName(t *testing.T) {
+	tests := map[string]string{
+		"https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs":            "gtfs",
+		"https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-ace":        "gtfs-ace",
+		"https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/camsys%2Fsubway-alerts": "subway-alerts",
+		"http://127.0.0.1:8080": "",
+	}
+	for u, want := range tests {
+		if got := feedName(u); got != want {
+			t.Errorf("feedName(%q) = %q, want %q", u, got, want)
+		}
+	}
+}
+
+func TestFeedProxy(t *testing.T) {
+	initTestCaches()
+	data, _ := proto.Marshal(newTestFeed(testTripUpdate("A", "tripA", []string{"A32N"}, []int64{60})))
+	var fetches int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Write(data)
+	}))
+	defer upstream.Close()
+	useTestFeeds(t, upstream.URL+"/nyct%2Fgtfs-ace", upstream.URL+"/nyct%2Fgtfs-l")
+	mux := newRoutes()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/feeds", nil))
+	var list []FeedInfo
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil || len(list) != 2 || list[0].Name != "gtfs-ace" || list[1].Href != "/api/feeds/gtfs-l" {
+		t.Fatalf("unexpected feed list %+v (%v)", list, err)
+	}
+
+	// Repeated requests within the TTL hit the upstream once
+	for i, want := range []string{"MISS", "HIT", "HIT"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/feeds/gtfs-ace", nil))
+		if w.Code != http.StatusOK || w.Header().Get("X-Cache") != want || w.Header().Get("Content-Type") != "application/x-protobuf" {
+			t.Fatalf("request %d: got %d %v", i, w.Code, w.Header())
+		}
+		var feed gtfs_realtime.FeedMessage
+		if err := proto.Unmarshal(w.Body.Bytes(), &feed); err != nil || len(feed.GetEntity()) != 1 {
+			t.Fatalf("request %d: body is not the feed: %v", i, err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("expected one upstream fetch, got %d", n)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/feeds/gtfs-nope", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown feed, got %d", w.Code)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+// Feed status.
+//
+// /api/feeds/status reports each proxied feed's health in one place, so operators don't
+// have to grep the logs for the feed that's broken: when it was last fetched successfully,
+// how many fetches have failed since, the header timestamp and entity count of the last
+// message parsed from it, and how old the copy being served (the feed cache, or the
+// poller's store) is. A feed that is stale upstream shows a fresh last_success with an
+// old header_timestamp.
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// FeedStatus is one feed in the /api/feeds/status response
+type FeedStatus struct {
+	Name                string     `json:"name"`
+	Reachable           bool       `json:"reachable"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	HeaderTimestamp     *time.Time `json:"header_timestamp,omitempty"`
+	EntityCount         int        `json:"entity_count"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at,omitempty"`
+	CacheAgeSeconds     *float64   `json:"cache_age_seconds,omitempty"` // unset until a copy is cached
+}
+
+// updateFeedHealth applies fn to the health record of url, creating it if needed
+func updateFeedHealth(url string, fn func(rec *feedHealthRecord)) {
+	feedHealth.Lock()
+	defer feedHealth.Unlock()
+	rec, ok := feedHealth.byURL[url]
+	if !ok {
+		rec = &feedHealthRecord{}
+		feedHealth.byURL[url] = rec
+	}
+	fn(rec)
+}
+
+// recordFeedMessage notes the header timestamp and size of a message parsed from url
+func recordFeedMessage(url string, msg *gtfs_realtime.FeedMessage) {
+	updateFeedHealth(url, func(rec *feedHealthRecord) {
+		rec.headerTime = time.Time{}
+		if ts := msg.GetHeader().GetTimestamp(); ts > 0 {
+			rec.headerTime = time.Unix(int64(ts), 0)
+		}
+		rec.entities = len(msg.GetEntity())
+	})
+}
+
+// recordFeedCached notes that a copy of url was cached at t
+func recordFeedCached(url string, t time.Time) {
+	updateFeedHealth(url, func(rec *feedHealthRecord) { rec.cachedAt = t })
+}
+
+// feedStatuses reports every feed in feeds (name -> URL) as of now, sorted by name
+func feedStatuses(feeds map[string]string, now time.Time) []FeedStatus {
+	urls := make([]string, 0, len(feeds))
+	for _, u := range feeds {
+		urls = append(urls, u)
+	}
+	health, _ := feedHealthSnapshot(urls, now)
+
+	feedHealth.Lock()
+	defer feedHealth.Unlock()
+	out := make([]FeedStatus, 0, len(feeds))
+	for name, u := range feeds {
+		fh := health[u]
+		st := FeedStatus{Name: name, Reachable: fh.Reachable, LastSuccess: fh.LastOK, LastError: fh.LastError, LastErrorAt: fh.LastErrorAt}
+		if rec, ok := feedHealth.byURL[u]; ok {
+			st.ConsecutiveFailures = rec.failures
+			st.EntityCount = rec.entities
+			if !rec.headerTime.IsZero() {
+				t := rec.headerTime
+				st.HeaderTimestamp = &t
+			}
+			if !rec.cachedAt.IsZero() {
+				age := now.Sub(rec.cachedAt).Seconds()
+				st.CacheAgeSeconds = &age
+			}
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func handleFeedStatus(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	writeJSON(w, feedStatuses(proxiedFeeds(), time.Now()))
+	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+}
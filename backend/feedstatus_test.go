@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFeedStatus(t *testing.T) {
+	initTestCaches()
+	feed := newTestFeed(
+		testTripUpdate("A", "tripA", []string{"A32N"}, []int64{60}),
+		testTripUpdate("C", "tripC", []string{"A32S"}, []int64{120}),
+	)
+	data, _ := proto.Marshal(feed)
+	var failing int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(data)
+	}))
+	defer upstream.Close()
+	aceURL, lURL := upstream.URL+"/nyct%2Fgtfs-ace", upstream.URL+"/nyct%2Fgtfs-l"
+	useTestFeeds(t, aceURL, lURL)
+
+	if _, err := fetchGTFSWithCache(aceURL); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&failing, 1)
+	for i := 0; i < 2; i++ {
+		if _, err := downloadFeed(lURL); err == nil {
+			t.Fatal("expected the feed to fail")
+		}
+	}
+
+	w := httptest.NewRecorder()
+	newRoutes().ServeHTTP(w, httptest.NewRequest("GET", "/api/feeds/status", nil))
+	var got []FeedStatus
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil || len(got) != 2 {
+		t.Fatalf("expected two feeds, got %v (%v)", got, err)
+	}
+	ace, l := got[0], got[1]
+	if ace.Name != "gtfs-ace" || !ace.Reachable || ace.LastSuccess == nil || ace.ConsecutiveFailures != 0 {
+		t.Errorf("unexpected gtfs-ace status %+v", ace)
+	}
+	if ace.EntityCount != 2 || ace.HeaderTimestamp == nil || ace.HeaderTimestamp.Unix() != int64(feed.GetHeader().GetTimestamp()) {
+		t.Errorf("expected the message header and entity count, got %+v", ace)
+	}
+	if ace.CacheAgeSeconds == nil || *ace.CacheAgeSeconds < 0 {
+		t.Errorf("expected a cache age, got %+v", ace)
+	}
+	if l.Name != "gtfs-l" || l.Reachable || l.LastSuccess != nil || l.ConsecutiveFailures != 2 || l.LastError == "" || l.CacheAgeSeconds != nil {
+		t.Errorf("unexpected gtfs-l status %+v", l)
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	downloadFeed(lURL)
+	if st := feedStatuses(proxiedFeeds(), time.Now()); st[1].ConsecutiveFailures != 0 {
+		t.Errorf("expected a success to reset the failure count, got %+v", st[1])
+	}
+}
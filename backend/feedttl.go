@@ -0,0 +1,89 @@
+package main
+
+// Per-feed cache TTLs and poll intervals.
+//
+// Feeds change at different rates: the 1-7 feed with every train movement, the Staten
+// Island Railway far less often. feed_cache_ttls and poll_intervals override
+// feed_cache_ttl and the poller's interval for individual feeds, by proxy feed name:
+//
+//   "feed_cache_ttls": {"gtfs-si": "2m"},
+//   "poll_intervals":  {"gtfs-si": "2m", "gtfs": "10s"}
+//
+// With poll_interval the poller ticks at the shortest interval configured and polls each
+// feed once its own interval has passed; with poll_demand a feed's poll_intervals entry
+// replaces both hot_interval and idle_interval for it.
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// feedCacheTTL is how long a copy of feedURL stays in transitFeedCache
+func feedCacheTTL(feedURL string) time.Duration {
+	if d := appConfig.FeedCacheTTLs[feedName(feedURL)]; d > 0 {
+		return time.Duration(d)
+	}
+	return appConfig.FeedCacheTTL.orDefault(30 * time.Second)
+}
+
+// feedPollInterval returns feedURL's configured poll interval, if it has one
+func feedPollInterval(feedURL string) (time.Duration, bool) {
+	d := appConfig.PollIntervals[feedName(feedURL)]
+	return time.Duration(d), d > 0
+}
+
+// pollTick is the poller tick that serves every feed's interval: the shortest of them
+func pollTick(urls []string, def time.Duration) time.Duration {
+	tick := def
+	for _, u := range urls {
+		if d, ok := feedPollInterval(u); ok && d < tick {
+			tick = d
+		}
+	}
+	return tick
+}
+
+// pollSchedule decides which feeds are due on each tick of a fixed-interval poller
+type pollSchedule struct {
+	def        time.Duration // for feeds without their own interval
+	slack      time.Duration // ticks drift a little
+	lastPolled map[string]time.Time
+}
+
+func newPollSchedule(def, tick time.Duration) *pollSchedule {
+	return &pollSchedule{def: def, slack: tick / 10, lastPolled: map[string]time.Time{}}
+}
+
+// due returns the feeds to poll now and records them as polled
+func (p *pollSchedule) due(urls []string, now time.Time) []string {
+	var out []string
+	for _, u := range urls {
+		interval, ok := feedPollInterval(u)
+		if !ok {
+			interval = p.def
+		}
+		if last, polled := p.lastPolled[u]; !polled || now.Sub(last)+p.slack >= interval {
+			out = append(out, u)
+			p.lastPolled[u] = now
+		}
+	}
+	return out
+}
+
+// validateFeedDurations checks a feed name -> duration config value
+func validateFeedDurations(v json.RawMessage) string {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(v, &raw); err != nil {
+		return fmt.Sprintf(`expected an object of feed name to duration, like {"gtfs-si": "2m"}, got %s`, v)
+	}
+	for name, d := range raw {
+		if msg := validateFeedName(name); msg != "" {
+			return msg
+		}
+		if msg := validateConfigValue("", kindDuration, d); msg != "" {
+			return name + ": " + msg
+		}
+	}
+	return ""
+}
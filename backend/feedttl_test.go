@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testSIURL  = "https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-si"
+	testACEURL = "https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-ace"
+)
+
+func TestFeedCacheTTL(t *testing.T) {
+	original := appConfig
+	t.Cleanup(func() { appConfig = original })
+	appConfig.FeedCacheTTL = Duration(20 * time.Second)
+	appConfig.FeedCacheTTLs = map[string]Duration{"gtfs-si": Duration(2 * time.Minute)}
+
+	if got := feedCacheTTL(testSIURL); got != 2*time.Minute {
+		t.Errorf("SI TTL = %s, want 2m", got)
+	}
+	if got := feedCacheTTL(testACEURL); got != 20*time.Second {
+		t.Errorf("ACE TTL = %s, want the 20s default", got)
+	}
+}
+
+func TestPollScheduleFeedIntervals(t *testing.T) {
+	original := appConfig
+	t.Cleanup(func() { appConfig = original })
+	appConfig.PollIntervals = map[string]Duration{"gtfs-si": Duration(60 * time.Second), "gtfs-ace": Duration(10 * time.Second)}
+
+	urls := []string{testACEURL, testSIURL, "test-feed"}
+	tick := pollTick(urls, 30*time.Second)
+	if tick != 10*time.Second {
+		t.Fatalf("tick = %s, want the shortest interval, 10s", tick)
+	}
+	p := newPollSchedule(30*time.Second, tick)
+	t0 := time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)
+	counts := map[string]int{}
+	for i := 0; i < 6; i++ {
+		for _, u := range p.due(urls, t0.Add(time.Duration(i)*tick)) {
+			counts[u]++
+		}
+	}
+	// Over a minute: ACE every tick, the default feed every 30s, SI once
+	want := map[string]int{testACEURL: 6, "test-feed": 2, testSIURL: 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("polls %v, want %v", counts, want)
+	}
+}
+
+func TestDemandPollerFeedInterval(t *testing.T) {
+	originalDemand, originalConfig := feedDemand, appConfig
+	t.Cleanup(func() { feedDemand, appConfig = originalDemand, originalConfig })
+	feedDemand = &demandTracker{last: map[string]time.Time{}}
+	appConfig.PollIntervals = map[string]Duration{"gtfs-si": Duration(30 * time.Second)}
+
+	cfg := PollDemandConfig{HotInterval: Duration(15 * time.Second), IdleInterval: Duration(60 * time.Second), Window: Duration(5 * time.Minute)}
+	p := newDemandPoller(cfg)
+	t0 := time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)
+	feedDemand.mark(testSIURL, t0)
+
+	// The SI is hot but polls at its own 30s, not every tick
+	var polled int
+	for i := 0; i <= 4; i++ {
+		polled += len(p.due([]string{testSIURL}, t0.Add(time.Duration(i)*15*time.Second)))
+	}
+	if polled != 3 {
+		t.Errorf("polled the SI %d times in a minute, want 3", polled)
+	}
+}
+
+func TestFeedDurationsConfig(t *testing.T) {
+	if errs := validateConfig([]byte(`{"feed_cache_ttls": {"gtfs-si": "2m"}, "poll_intervals": {"gtfs": "10s"}}`)); len(errs) != 0 {
+		t.Errorf("expected a valid config, got %v", errs)
+	}
+	tests := map[string]string{
+		`{"poll_intervals": {"gtfs-xyz": "10s"}}`:  "gtfs-xyz",
+		`{"feed_cache_ttls": {"gtfs-si": "soon"}}`: "gtfs-si",
+		`{"feed_cache_ttls": ["2m"]}`:              "feed name to duration",
+	}
+	for cfg, want := range tests {
+		errs := validateConfig([]byte(cfg))
+		if len(errs) != 1 || !strings.Contains(errs[0], want) {
+			t.Errorf("%s: expected an error mentioning %q, got %v", cfg, want, errs)
+		}
+	}
+}
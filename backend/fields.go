@@ -0,0 +1,139 @@
+package main
+
+// Sparse departure fieldsets.
+//
+//   GET /api/departures/by-id?id=635&fields=route_id,eta_seconds,headsign
+//
+// fields trims every departure object in the response to the listed keys, for clients
+// with tiny JSON parsers. The rest of the response (station, alerts, ...) is unchanged.
+// Accepted names are the departure JSON keys (plus station, station_name and
+// walk_seconds on /any); anything else is a 400. The stream and WebSocket APIs always
+// send full departures.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// departureFields are the accepted field names, from the JSON tags of AnyDeparture
+// (which embeds Departure)
+var departureFields = jsonFieldNames(reflect.TypeOf(AnyDeparture{}))
+
+// jsonFieldNames lists the JSON keys of a struct type, descending into embedded structs
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	out := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		switch {
+		case f.Anonymous && name == "":
+			for n := range jsonFieldNames(f.Type) {
+				out[n] = true
+			}
+		case name != "" && name != "-":
+			out[name] = true
+		}
+	}
+	return out
+}
+
+// parseFields reads the fields parameter; nil means all fields
+func parseFields(r *http.Request) (map[string]bool, error) {
+	v := r.URL.Query().Get("fields")
+	if v == "" {
+		return nil, nil
+	}
+	keep := map[string]bool{}
+	for _, f := range strings.Split(v, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !departureFields[f] {
+			valid := make([]string, 0, len(departureFields))
+			for n := range departureFields {
+				valid = append(valid, n)
+			}
+			sort.Strings(valid)
+			return nil, fmt.Errorf("unknown field %q (expected any of: %s)", f, strings.Join(valid, ", "))
+		}
+		keep[f] = true
+	}
+	if len(keep) == 0 {
+		return nil, fmt.Errorf("invalid fields")
+	}
+	return keep, nil
+}
+
+// sparseDepartures trims the objects of every "departures" array in v to keep
+func sparseDepartures(v any, keep map[string]bool) {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, child := range t {
+			if deps, ok := child.([]any); ok && k == "departures" {
+				for _, d := range deps {
+					if obj, ok := d.(map[string]any); ok {
+						for field := range obj {
+							if !keep[field] {
+								delete(obj, field)
+							}
+						}
+					}
+				}
+				continue
+			}
+			sparseDepartures(child, keep)
+		}
+	case []any:
+		for _, child := range t {
+			sparseDepartures(child, keep)
+		}
+	}
+}
+
+// bufferedResponse captures a handler's response so it can be rewritten
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *bufferedResponse) WriteHeader(code int)        { b.status = code }
+
+// withFields applies the fields parameter to a departures handler's JSON response
+func withFields(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keep, err := parseFields(r)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if keep == nil {
+			h(w, r)
+			return
+		}
+		buf := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		h(buf, r)
+		body := buf.body.Bytes()
+		if buf.status == http.StatusOK {
+			var v any
+			dec := json.NewDecoder(bytes.NewReader(body))
+			dec.UseNumber()
+			if err := dec.Decode(&v); err == nil {
+				sparseDepartures(v, keep)
+				if out, err := json.MarshalIndent(v, "", "  "); err == nil {
+					body = append(out, '\n')
+				}
+			}
+		}
+		w.WriteHeader(buf.status)
+		w.Write(body)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSparseFieldsets(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "635", Name: "14 St - Union Sq"}})
+	server := newTestFeedServer(t, testTripUpdate("6", "trip6", []string{"635N"}, []int64{120}))
+	useTestFeeds(t, server.URL)
+	mux := newRoutes()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/departures/by-id?id=635&fields=route_id,eta_seconds", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected a JSON 200, got %d %v", w.Code, w.Header())
+	}
+	var resp struct {
+		Station    Station          `json:"station"`
+		Departures []map[string]any `json:"departures"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Station.StopID != "635" {
+		t.Errorf("expected the station untouched, got %+v", resp.Station)
+	}
+	if len(resp.Departures) != 1 || len(resp.Departures[0]) != 2 || resp.Departures[0]["route_id"] != "6" || resp.Departures[0]["eta_seconds"] == nil {
+		t.Errorf("expected only route_id and eta_seconds, got %v", resp.Departures)
+	}
+
+	// Merged departures on /any can keep their station labels
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/departures/any?ids=635&fields=station,eta_seconds", nil))
+	if !strings.Contains(w.Body.String(), `"station": "635"`) || strings.Contains(w.Body.String(), `"route_id"`) {
+		t.Errorf("unexpected /any body %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/departures/by-id?id=635&fields=route_id,platform", nil))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `unknown field \"platform\"`) {
+		t.Errorf("expected 400 for an unknown field, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDepartureFieldNames(t *testing.T) {
+	for _, name := range []string{"route_id", "eta_seconds", "headsign", "station", "walk_seconds"} {
+		if !departureFields[name] {
+			t.Errorf("expected %q to be a departure field", name)
+		}
+	}
+	if departureFields["LastStop"] || departureFields["-"] {
+		t.Error("unserialized fields must not be accepted")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+// Request-level departure filters shared by the departure endpoints.
+//
+//   routes=N,Q,R   keep only these routes; also limits which GTFS-RT feeds are fetched
+//   direction=N|S  keep only uptown (N) or downtown (S) departures
+//   limit=K        departures per route and direction (default from config, at most 10)
+//   horizon=30m    only departures within this window from now
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// departureFilter narrows the departures returned for a station. The zero value keeps
+// everything.
+type departureFilter struct {
+	Routes    map[string]bool // route IDs to keep, upper-case (empty = all routes)
+	Direction string          // N or S after normalizeDirection (empty = both)
+	Limit     int             // departures per route+direction (0 = configured default)
+	Horizon   time.Duration   // only departures leaving within this window (0 = no limit)
+}
+
+// parseDepartureFilter reads the filter query parameters of a departures request
+func parseDepartureFilter(r *http.Request) (departureFilter, error) {
+	var f departureFilter
+	if v := r.URL.Query().Get("routes"); v != "" {
+		f.Routes = map[string]bool{}
+		for _, route := range strings.Split(v, ",") {
+			route = strings.ToUpper(strings.TrimSpace(route))
+			if route == "" {
+				continue
+			}
+			f.Routes[route] = true
+		}
+		if len(f.Routes) == 0 {
+			return f, fmt.Errorf("invalid routes")
+		}
+	}
+	if v := r.URL.Query().Get("direction"); v != "" {
+		f.Direction = strings.ToUpper(strings.TrimSpace(v))
+		if f.Direction != "N" && f.Direction != "S" {
+			return f, fmt.Errorf("invalid direction (expected N or S)")
+		}
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return f, fmt.Errorf("invalid limit")
+		}
+		if n > maxDeparturesPerDirection {
+			n = maxDeparturesPerDirection
+		}
+		f.Limit = n
+	}
+	if v := r.URL.Query().Get("horizon"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return f, fmt.Errorf("invalid horizon (use a duration like 30m)")
+		}
+		f.Horizon = d
+	}
+	return f, nil
+}
+
+// allowsDirection reports whether a normalized direction passes the filter. Departures
+// with an unknown direction are dropped once a direction is requested.
+func (f departureFilter) allowsDirection(dir string) bool {
+	return f.Direction == "" || dir == f.Direction
+}
+
+// allowsRoute reports whether departures on routeID pass the filter. Express variants
+// (6X, 7X) match their base route.
+func (f departureFilter) allowsRoute(routeID string) bool {
+	if len(f.Routes) == 0 {
+		return true
+	}
+	routeID = strings.ToUpper(routeID)
+	return f.Routes[routeID] || (len(routeID) > 1 && f.Routes[strings.TrimSuffix(routeID, "X")])
+}
+
+// allowsETA reports whether a departure etaSeconds from now is inside the horizon
+func (f departureFilter) allowsETA(etaSeconds int64) bool {
+	return f.Horizon == 0 || etaSeconds <= int64(f.Horizon/time.Second)
+}
+
+// feedStation narrows a station's routes to the filtered ones so only their feeds are
+// fetched. ok is false when the station serves none of the requested routes.
+func (f departureFilter) feedStation(s Station) (Station, bool) {
+	if len(f.Routes) == 0 {
+		return s, true
+	}
+	if len(s.Routes) == 0 {
+		// Unknown routes: the requested routes still pick the feeds
+		for route := range f.Routes {
+			s.Routes = append(s.Routes, route)
+		}
+		return s, true
+	}
+	var routes []string
+	for _, route := range s.Routes {
+		if f.allowsRoute(route) {
+			routes = append(routes, route)
+		}
+	}
+	s.Routes = routes
+	return s, len(routes) > 0
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseDepartureFilter(t *testing.T) {
+	f, err := parseDepartureFilter(httptest.NewRequest("GET", "/x?routes=n,%20Q,,R", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Routes) != 3 || !f.Routes["N"] || !f.Routes["Q"] || !f.Routes["R"] {
+		t.Errorf("unexpected routes %v", f.Routes)
+	}
+	if _, err := parseDepartureFilter(httptest.NewRequest("GET", "/x?routes=,,", nil)); err == nil {
+		t.Error("expected error for empty routes list")
+	}
+	if f, _ := parseDepartureFilter(httptest.NewRequest("GET", "/x", nil)); !f.allowsRoute("A") || !f.allowsDirection("") {
+		t.Error("zero filter should allow every route and direction")
+	}
+	if f, err := parseDepartureFilter(httptest.NewRequest("GET", "/x?direction=s", nil)); err != nil || !f.allowsDirection("S") || f.allowsDirection("N") {
+		t.Errorf("direction=s should keep only S departures (err %v)", err)
+	}
+	if _, err := parseDepartureFilter(httptest.NewRequest("GET", "/x?direction=E", nil)); err == nil {
+		t.Error("expected error for direction=E")
+	}
+	if f, _ := parseDepartureFilter(httptest.NewRequest("GET", "/x?limit=50", nil)); f.Limit != maxDeparturesPerDirection {
+		t.Errorf("limit should be capped at %d, got %d", maxDeparturesPerDirection, f.Limit)
+	}
+	if _, err := parseDepartureFilter(httptest.NewRequest("GET", "/x?limit=0", nil)); err == nil {
+		t.Error("expected error for limit=0")
+	}
+	if f, err := parseDepartureFilter(httptest.NewRequest("GET", "/x?horizon=30m", nil)); err != nil || !f.allowsETA(1800) || f.allowsETA(1801) {
+		t.Errorf("horizon=30m should keep departures up to 1800s away (err %v)", err)
+	}
+	for _, bad := range []string{"30", "-5m", "soon"} {
+		if _, err := parseDepartureFilter(httptest.NewRequest("GET", "/x?horizon="+bad, nil)); err == nil {
+			t.Errorf("expected error for horizon=%s", bad)
+		}
+	}
+}
+
+func TestDepartureFilterRoutes(t *testing.T) {
+	f := departureFilter{Routes: map[string]bool{"6": true, "Q": true}}
+	for route, want := range map[string]bool{"6": true, "6X": true, "q": true, "4": false, "R": false} {
+		if got := f.allowsRoute(route); got != want {
+			t.Errorf("allowsRoute(%q) = %v, want %v", route, got, want)
+		}
+	}
+
+	s, ok := f.feedStation(Station{StopID: "635", Routes: []string{"4", "5", "6", "6X"}})
+	if !ok || len(s.Routes) != 2 {
+		t.Errorf("expected routes narrowed to 6/6X, got %v", s.Routes)
+	}
+	if _, ok := f.feedStation(Station{StopID: "A31", Routes: []string{"A", "C", "E"}}); ok {
+		t.Error("station serving none of the filtered routes should be skipped")
+	}
+	if s, ok := f.feedStation(Station{StopID: "X"}); !ok || len(s.Routes) != 2 {
+		t.Errorf("station without route data should use the filter's routes, got %v", s.Routes)
+	}
+}
+
+func TestAPIByIDRouteFilter(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "635", Name: "14 St - Union Sq", Lat: 40.7347, Lon: -73.9897, Routes: []string{"6", "Q"}}})
+
+	// Both routes appear in the Q feed; the 6 feed must not be fetched at all
+	qServer := newTestFeedServer(t,
+		testTripUpdate("6", "trip6", []string{"635N"}, []int64{120}),
+		testTripUpdate("Q", "tripQ", []string{"635S"}, []int64{300}),
+	)
+	sixFetched := false
+	sixServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sixFetched = true
+	}))
+	defer sixServer.Close()
+	originalRouteToFeed := routeToFeed
+	routeToFeed = map[string]string{"6": sixServer.URL, "Q": qServer.URL}
+	defer func() { routeToFeed = originalRouteToFeed }()
+
+	w := httptest.NewRecorder()
+	handleByID(w, httptest.NewRequest("GET", "/api/departures/by-id?id=635&routes=q", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp NearestResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Departures) != 1 || resp.Departures[0].RouteID != "Q" {
+		t.Errorf("expected only the Q departure, got %+v", resp.Departures)
+	}
+	if sixFetched {
+		t.Error("filtered-out route's feed was fetched")
+	}
+
+	// The direction filter applies to normalized directions
+	w = httptest.NewRecorder()
+	handleByID(w, httptest.NewRequest("GET", "/api/departures/by-id?id=635&routes=6,Q&direction=N", nil))
+	resp = NearestResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Departures) != 1 || resp.Departures[0].RouteID != "6" {
+		t.Errorf("expected only the northbound 6, got %+v", resp.Departures)
+	}
+
+	w = httptest.NewRecorder()
+	handleByID(w, httptest.NewRequest("GET", "/api/departures/by-id?id=635&routes=,", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid routes, got %d", w.Code)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+// Per-station data freshness for external monitoring.
+//
+// A feed can keep refreshing while a single station silently drops out of it (a signal
+// outage, a stuck trip). For each station listed in the "monitored_stations" config key,
+// GET /metrics exports
+//
+//   nyc_subway_station_data_age_seconds{station="635",name="14 St-Union Sq"} 42
+//
+// the seconds since a freshly fetched feed last carried a stop time update for the
+// station, using the trip update's own timestamp when the MTA sets one and the feed
+// header's otherwise. A station not seen since startup ages from startup.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// freshnessTracker remembers when each monitored station last had departure data
+type freshnessTracker struct {
+	mu    sync.Mutex
+	since time.Time            // when monitoring started
+	last  map[string]time.Time // parent stop ID -> newest update seen
+}
+
+var stationFreshness = &freshnessTracker{}
+
+// configure sets the monitored stations, keeping what is known about ones still listed
+func (f *freshnessTracker) configure(ids []string, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	last := make(map[string]time.Time, len(ids))
+	for _, id := range ids {
+		base := parentStopID(strings.TrimSpace(id))
+		last[base] = f.last[base]
+	}
+	f.since, f.last = now, last
+}
+
+// observe records the stations a freshly fetched feed has stop time updates for
+func (f *freshnessTracker) observe(feed *gtfs_realtime.FeedMessage, fetched time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.last) == 0 {
+		return
+	}
+	at := fetched
+	if ts := feed.GetHeader().GetTimestamp(); ts > 0 {
+		at = time.Unix(int64(ts), 0)
+	}
+	for _, e := range feed.GetEntity() {
+		tu := e.GetTripUpdate()
+		if tu == nil {
+			continue
+		}
+		updated := at
+		if ts := tu.GetTimestamp(); ts > 0 {
+			updated = time.Unix(int64(ts), 0)
+		}
+		for _, stu := range tu.GetStopTimeUpdate() {
+			base := parentStopID(stu.GetStopId())
+			if prev, ok := f.last[base]; ok && updated.After(prev) {
+				f.last[base] = updated
+			}
+		}
+	}
+}
+
+// writeMetrics writes the freshness gauge in Prometheus text format
+func (f *freshnessTracker) writeMetrics(w io.Writer, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.last) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(f.last))
+	for id := range f.last {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	fmt.Fprintln(w, "# HELP nyc_subway_station_data_age_seconds Seconds since the station's departure data was last updated.")
+	fmt.Fprintln(w, "# TYPE nyc_subway_station_data_age_seconds gauge")
+	for _, id := range ids {
+		last := f.last[id]
+		if last.IsZero() {
+			last = f.since
+		}
+		var name string
+		if s, ok := stationByID(id); ok {
+			name = s.Name
+		}
+		age := now.Sub(last).Seconds()
+		if age < 0 {
+			age = 0 // feed clock ahead of ours
+		}
+		fmt.Fprintf(w, "nyc_subway_station_data_age_seconds{station=%q,name=%q} %g\n", id, name, age)
+	}
+}
+
+// validateMonitoredStations checks the "monitored_stations" config value
+func validateMonitoredStations(v json.RawMessage) string {
+	var ids []string
+	if err := json.Unmarshal(v, &ids); err != nil {
+		return fmt.Sprintf("expected an array of GTFS stop IDs, got %s", v)
+	}
+	for i, id := range ids {
+		if strings.TrimSpace(id) == "" {
+			return fmt.Sprintf("[%d]: empty stop ID", i)
+		}
+	}
+	return ""
+}
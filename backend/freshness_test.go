@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestStationFreshness(t *testing.T) {
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "635", Name: "14 St - Union Sq"}})
+
+	f := &freshnessTracker{}
+	start := time.Unix(1760000000, 0)
+	f.configure([]string{"635N", "R14"}, start)
+
+	feed := newTestFeed(testTripUpdate("6", "t1", []string{"631N", "635N"}, []int64{60, 120}))
+	feed.Header.Timestamp = proto.Uint64(uint64(start.Add(30 * time.Second).Unix()))
+	f.observe(feed, start.Add(35*time.Second))
+
+	var buf bytes.Buffer
+	f.writeMetrics(&buf, start.Add(100*time.Second))
+	body := buf.String()
+	for _, want := range []string{
+		"# TYPE nyc_subway_station_data_age_seconds gauge",
+		`nyc_subway_station_data_age_seconds{station="635",name="14 St - Union Sq"} 70`,
+		`nyc_subway_station_data_age_seconds{station="R14",name=""} 100`, // never seen: ages from startup
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `station="631"`) {
+		t.Error("unmonitored stations should not be exported")
+	}
+
+	// Reconfiguring keeps what is known about stations still monitored
+	f.configure([]string{"635"}, start.Add(time.Hour))
+	if got := f.last["635"]; !got.Equal(start.Add(30 * time.Second)) {
+		t.Errorf("expected the last update to survive reconfiguration, got %v", got)
+	}
+}
+
+func TestValidateMonitoredStations(t *testing.T) {
+	if errs := validateConfig([]byte(`{"monitored_stations": ["635", "R14"]}`)); len(errs) != 0 {
+		t.Errorf("valid list rejected: %v", errs)
+	}
+	if errs := validateConfig([]byte(`{"monitored_stations": ["635", " "]}`)); len(errs) != 1 || !strings.Contains(errs[0], "[1]: empty stop ID") {
+		t.Errorf("unexpected errors %v", errs)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+// Geocoding.
+//
+//   GET /api/geocode?q=350 5th Ave
+//
+// resolves an address to coordinates in NYC, and /api/departures/nearest takes
+// address=<address> in place of lat/lon. The geocoder is set with the "geocoder" key:
+//
+//   "geocoder": {"type": "nominatim", "url": "https://nominatim.openstreetmap.org"}
+//   "geocoder": {"type": "pelias", "url": "https://pelias.example.com", "api_key": "..."}
+//
+// Without it the public Nominatim server is used. Searches are bounded to the NYC area and
+// results outside it are dropped. Results are cached for a day, which also keeps the
+// service within Nominatim's one request a second usage policy for repeated addresses.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bluele/gcache"
+)
+
+// Geocoder types
+const (
+	geocoderNominatim = "nominatim"
+	geocoderPelias    = "pelias"
+)
+
+const (
+	defaultNominatimURL = "https://nominatim.openstreetmap.org"
+	maxGeocodeResults   = 5
+)
+
+// GeocoderConfig is the "geocoder" config object
+type GeocoderConfig struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	APIKey string `json:"api_key,omitempty"` // pelias only
+}
+
+// GeocodeResult is one match for an address
+type GeocodeResult struct {
+	Label string  `json:"label"`
+	Lat   float64 `json:"lat"`
+	Lon   float64 `json:"lon"`
+}
+
+// GeocodeResponse is the /api/geocode response
+type GeocodeResponse struct {
+	Query   string          `json:"query"`
+	Results []GeocodeResult `json:"results"`
+}
+
+type geocoder interface {
+	geocode(ctx context.Context, q string) ([]GeocodeResult, error)
+}
+
+var (
+	addressGeocoder geocoder = nominatimGeocoder{url: defaultNominatimURL}
+	geocodeCache             = gcache.New(1000).LRU().Expiration(24 * time.Hour).Build()
+)
+
+// errAddressNotFound is returned when no result for an address is in NYC
+var errAddressNotFound = errors.New("address not found in NYC")
+
+// newGeocoder builds the configured geocoder (nil when none is configured)
+func newGeocoder(cfg GeocoderConfig) (geocoder, error) {
+	base := strings.TrimRight(cfg.URL, "/")
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case geocoderNominatim:
+		if base == "" {
+			base = defaultNominatimURL
+		}
+		return nominatimGeocoder{url: base}, nil
+	case geocoderPelias:
+		return peliasGeocoder{url: base, apiKey: cfg.APIKey}, nil
+	}
+	return nil, fmt.Errorf("unknown geocoder type %q", cfg.Type)
+}
+
+// validateGeocoder checks the "geocoder" config value
+func validateGeocoder(v json.RawMessage) string {
+	var cfg GeocoderConfig
+	dec := json.NewDecoder(bytes.NewReader(v))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return `expected an object like {"type": "nominatim", "url": "https://..."}`
+	}
+	switch cfg.Type {
+	case geocoderNominatim:
+		if cfg.URL == "" {
+			return ""
+		}
+	case geocoderPelias:
+	default:
+		return fmt.Sprintf("invalid type %q (expected one of: %s, %s)", cfg.Type, geocoderNominatim, geocoderPelias)
+	}
+	if !strings.HasPrefix(cfg.URL, "https://") && !strings.HasPrefix(cfg.URL, "http://") {
+		return fmt.Sprintf("%s geocoder needs an http(s) url, got %q", cfg.Type, cfg.URL)
+	}
+	return ""
+}
+
+// geocodeAddress resolves q to its matches in NYC, best first
+func geocodeAddress(ctx context.Context, q string) ([]GeocodeResult, error) {
+	key := strings.ToLower(strings.Join(strings.Fields(q), " "))
+	if cached, err := geocodeCache.Get(key); err == nil {
+		if results, ok := cached.([]GeocodeResult); ok {
+			return results, nil
+		}
+	}
+	found, err := addressGeocoder.geocode(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	var results []GeocodeResult
+	for _, res := range found {
+		if !outsideNYC(res.Lat, res.Lon) {
+			results = append(results, res)
+		}
+	}
+	if len(results) == 0 {
+		return nil, errAddressNotFound
+	}
+	geocodeCache.Set(key, results)
+	return results, nil
+}
+
+// getGeocoderJSON fetches a geocoder URL and decodes its JSON response into v
+func getGeocoderJSON(ctx context.Context, target string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "nyc-subway/1.0") // Nominatim requires one
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("geocoder returned status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type nominatimGeocoder struct{ url string }
+
+func (g nominatimGeocoder) geocode(ctx context.Context, q string) ([]GeocodeResult, error) {
+	params := url.Values{
+		"q":            {q},
+		"format":       {"jsonv2"},
+		"limit":        {fmt.Sprint(maxGeocodeResults)},
+		"countrycodes": {"us"},
+		"viewbox":      {fmt.Sprintf("%f,%f,%f,%f", minLon, maxLat, maxLon, minLat)},
+		"bounded":      {"1"},
+	}
+	var places []struct {
+		Lat         float64 `json:"lat,string"`
+		Lon         float64 `json:"lon,string"`
+		DisplayName string  `json:"display_name"`
+	}
+	if err := getGeocoderJSON(ctx, g.url+"/search?"+params.Encode(), &places); err != nil {
+		return nil, err
+	}
+	out := make([]GeocodeResult, 0, len(places))
+	for _, p := range places {
+		out = append(out, GeocodeResult{Label: p.DisplayName, Lat: p.Lat, Lon: p.Lon})
+	}
+	return out, nil
+}
+
+type peliasGeocoder struct{ url, apiKey string }
+
+func (g peliasGeocoder) geocode(ctx context.Context, q string) ([]GeocodeResult, error) {
+	params := url.Values{
+		"text":                  {q},
+		"size":                  {fmt.Sprint(maxGeocodeResults)},
+		"boundary.rect.min_lat": {fmt.Sprint(minLat)},
+		"boundary.rect.max_lat": {fmt.Sprint(maxLat)},
+		"boundary.rect.min_lon": {fmt.Sprint(minLon)},
+		"boundary.rect.max_lon": {fmt.Sprint(maxLon)},
+	}
+	if g.apiKey != "" {
+		params.Set("api_key", g.apiKey)
+	}
+	var fc struct {
+		Features []struct {
+			Geometry struct {
+				Coordinates []float64 `json:"coordinates"` // lon, lat
+			} `json:"geometry"`
+			Properties struct {
+				Label string `json:"label"`
+			} `json:"properties"`
+		} `json:"features"`
+	}
+	if err := getGeocoderJSON(ctx, g.url+"/v1/search?"+params.Encode(), &fc); err != nil {
+		return nil, err
+	}
+	out := make([]GeocodeResult, 0, len(fc.Features))
+	for _, f := range fc.Features {
+		if len(f.Geometry.Coordinates) < 2 {
+			continue
+		}
+		out = append(out, GeocodeResult{Label: f.Properties.Label, Lat: f.Geometry.Coordinates[1], Lon: f.Geometry.Coordinates[0]})
+	}
+	return out, nil
+}
+
+// geocodeError writes the response for a failed geocode: 404 when nothing matched in NYC,
+// 502 when the geocoder failed
+func geocodeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errAddressNotFound) {
+		httpError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	log.Printf("geocode error: %v", err)
+	httpError(w, http.StatusBadGateway, "geocoder unavailable")
+}
+
+func handleGeocode(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		httpError(w, http.StatusBadRequest, "missing q")
+		return
+	}
+	results, err := geocodeAddress(r.Context(), q)
+	if err != nil {
+		geocodeError(w, err)
+		return
+	}
+	writeJSON(w, GeocodeResponse{Query: q, Results: results})
+	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+}
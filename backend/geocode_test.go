@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluele/gcache"
+)
+
+// useTestGeocoder swaps in g as the geocoder, with an empty cache
+func useTestGeocoder(t *testing.T, g geocoder) {
+	t.Helper()
+	original, originalCache := addressGeocoder, geocodeCache
+	addressGeocoder = g
+	geocodeCache = gcache.New(10).LRU().Expiration(time.Hour).Build()
+	t.Cleanup(func() { addressGeocoder, geocodeCache = original, originalCache })
+}
+
+func TestGeocodeNominatim(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Get("q"))
+		if r.URL.Path != "/search" || r.URL.Query().Get("bounded") != "1" || r.Header.Get("User-Agent") == "" {
+			t.Errorf("unexpected request %s (User-Agent %q)", r.URL, r.Header.Get("User-Agent"))
+		}
+		w.Write([]byte(`[
+			{"lat": "40.7484", "lon": "-73.9857", "display_name": "Empire State Building, 350 5th Ave"},
+			{"lat": "38.9072", "lon": "-77.0369", "display_name": "Somewhere else"}
+		]`))
+	}))
+	defer server.Close()
+	useTestGeocoder(t, nominatimGeocoder{url: server.URL})
+
+	w := httptest.NewRecorder()
+	handleGeocode(w, httptest.NewRequest("GET", "/api/geocode?q=350+5th+Ave", nil))
+	var resp GeocodeResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].Lat != 40.7484 || !strings.HasPrefix(resp.Results[0].Label, "Empire State") {
+		t.Errorf("expected only the NYC result, got %+v", resp)
+	}
+
+	// Cached, whatever the spacing and case
+	handleGeocode(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/geocode?q=350++5TH+ave", nil))
+	if len(queries) != 1 {
+		t.Errorf("expected one geocoder request, got %v", queries)
+	}
+
+	w = httptest.NewRecorder()
+	handleGeocode(w, httptest.NewRequest("GET", "/api/geocode", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without q, got %d", w.Code)
+	}
+}
+
+func TestGeocodePelias(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/search" || r.URL.Query().Get("api_key") != "k" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Write([]byte(`{"features": [{"geometry": {"coordinates": [-73.9857, 40.7484]}, "properties": {"label": "350 5th Ave, Manhattan"}}]}`))
+	}))
+	defer server.Close()
+	g, err := newGeocoder(GeocoderConfig{Type: geocoderPelias, URL: server.URL + "/", APIKey: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	useTestGeocoder(t, g)
+
+	results, err := geocodeAddress(context.Background(), "350 5th Ave")
+	if err != nil || len(results) != 1 || results[0].Lon != -73.9857 || results[0].Label != "350 5th Ave, Manhattan" {
+		t.Errorf("unexpected results %+v, %v", results, err)
+	}
+}
+
+func TestNearestByAddress(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+	setTestStations([]Station{
+		{StopID: "D17", Name: "34 St-Herald Sq", Lat: 40.749719, Lon: -73.987823},
+		{StopID: "635", Name: "14 St-Union Sq", Lat: 40.734673, Lon: -73.989951},
+	})
+	useTestFeeds(t, newTestFeedServer(t).URL)
+	useTestOSRM(t, 240, 300)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Query().Get("q"), "Nowhere") {
+			w.Write([]byte(`[]`))
+			return
+		}
+		w.Write([]byte(`[{"lat": "40.7484", "lon": "-73.9857", "display_name": "350 5th Ave"}]`))
+	}))
+	defer server.Close()
+	useTestGeocoder(t, nominatimGeocoder{url: server.URL})
+
+	w := httptest.NewRecorder()
+	handleNearest(w, httptest.NewRequest("GET", "/api/departures/nearest?address=350+5th+Ave", nil))
+	var resp NearestResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Station.StopID != "D17" {
+		t.Errorf("expected Herald Sq for 350 5th Ave, got %+v", resp.Station)
+	}
+
+	w = httptest.NewRecorder()
+	handleNearest(w, httptest.NewRequest("GET", "/api/departures/nearest?address=1+Nowhere+Rd", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown address, got %d", w.Code)
+	}
+
+	server.Close()
+	geocodeCache.Purge()
+	w = httptest.NewRecorder()
+	handleNearest(w, httptest.NewRequest("GET", "/api/departures/nearest?address=350+5th+Ave", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected 502 when the geocoder is down, got %d", w.Code)
+	}
+}
+
+func TestValidateGeocoder(t *testing.T) {
+	tests := map[string]string{
+		`{"type": "nominatim"}`: "",
+		`{"type": "nominatim", "url": "https://nominatim.example.com"}`:           "",
+		`{"type": "pelias", "url": "https://pelias.example.com", "api_key": "k"}`: "",
+		`{"type": "pelias"}`:                "needs an http(s) url",
+		`{"type": "google"}`:                "invalid type",
+		`{"type": "nominatim", "key": "k"}`: "expected an object",
+	}
+	for in, want := range tests {
+		got := validateGeocoder(json.RawMessage(in))
+		if (want == "" && got != "") || !strings.Contains(got, want) {
+			t.Errorf("validateGeocoder(%s) = %q, want %q", in, got, want)
+		}
+	}
+}
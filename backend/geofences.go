@@ -0,0 +1,339 @@
+package main
+
+// Geofence station pinning.
+//
+// GPS drift near a building wall can flip "nearest" between two stations from one refresh
+// to the next. A client can pin a preferred station to a place it cares about (home,
+// office): whenever a nearest query from that client falls inside the fence, the pinned
+// station is used instead of the geometrically closest one.
+//
+//   GET    /api/geofences?client=<id>           list the client's fences
+//   POST   /api/geofences                       add a fence (JSON body), returns it with its id
+//   DELETE /api/geofences?client=<id>&id=<id>   remove a fence
+//   GET    /api/departures/nearest?lat=..&lon=..&client=<id>
+//
+// Clients identify themselves with an opaque ID they generate (there are no accounts).
+// A client's first fence comes back with a client_secret, which the client then sends as
+// "Authorization: Bearer <secret>" to list, add or remove its fences; without it a
+// guessed client ID can't be used to read or move someone else's pins. Fences live in
+// memory, or in the JSON file named by config key geofences_file.
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Geofence limits
+const (
+	minGeofenceRadius  = 25.0   // meters; smaller than GPS error is meaningless
+	maxGeofenceRadius  = 1000.0 // meters
+	maxClientGeofences = 10
+)
+
+var (
+	errTooManyGeofences = fmt.Errorf("at most %d geofences per client", maxClientGeofences)
+	errClientSecret     = errors.New("invalid client secret")
+)
+
+// Geofence pins a station to a circle around a client's place
+type Geofence struct {
+	ID      string  `json:"id"`
+	Client  string  `json:"client"`
+	Name    string  `json:"name,omitempty"` // e.g. "home"
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	RadiusM float64 `json:"radius_m"`
+	StopID  string  `json:"stop_id"` // the pinned station
+}
+
+// securedGeofence is a fence with its client's secret, as saved to the geofences file and
+// returned when the secret is first issued
+type securedGeofence struct {
+	Geofence
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+type geofenceStore struct {
+	mu       sync.RWMutex
+	byClient map[string][]Geofence
+	secrets  map[string]string // client -> secret, set by the client's first fence
+	filePath string            // where edits are persisted (empty = memory only)
+}
+
+var geofences = &geofenceStore{byClient: map[string][]Geofence{}}
+
+// load replaces the store contents from a JSON array of fences; a missing file starts empty
+func (g *geofenceStore) load(path string) error {
+	byClient, secrets := map[string][]Geofence{}, map[string]string{}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read geofences: %w", err)
+	}
+	if err == nil {
+		var list []securedGeofence
+		if err := json.Unmarshal(data, &list); err != nil {
+			return fmt.Errorf("parse geofences: %w", err)
+		}
+		for _, f := range list {
+			byClient[f.Client] = append(byClient[f.Client], f.Geofence)
+			if f.ClientSecret != "" {
+				secrets[f.Client] = f.ClientSecret
+			}
+		}
+	}
+	g.mu.Lock()
+	g.byClient = byClient
+	g.secrets = secrets
+	g.filePath = path
+	g.mu.Unlock()
+	log.Printf("Loaded geofences for %d clients from %s", len(byClient), path)
+	return nil
+}
+
+func (g *geofenceStore) list(client string) []Geofence {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return append([]Geofence{}, g.byClient[client]...)
+}
+
+// authorized reports whether secret is the client's; a client with no secret yet has
+// nothing to protect
+func (g *geofenceStore) authorized(client, secret string) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.authorizedLocked(client, secret)
+}
+
+func (g *geofenceStore) authorizedLocked(client, secret string) bool {
+	known, ok := g.secrets[client]
+	return !ok || subtle.ConstantTimeCompare([]byte(known), []byte(secret)) == 1
+}
+
+// add saves a fence for a client holding secret. A client's first fence issues its
+// secret, which is returned (and "" afterwards).
+func (g *geofenceStore) add(f Geofence, secret string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.authorizedLocked(f.Client, secret) {
+		return "", errClientSecret
+	}
+	if len(g.byClient[f.Client]) >= maxClientGeofences {
+		return "", errTooManyGeofences
+	}
+	var issued string
+	if _, ok := g.secrets[f.Client]; !ok {
+		issued = newClientSecret()
+		if g.secrets == nil {
+			g.secrets = map[string]string{}
+		}
+		g.secrets[f.Client] = issued
+	}
+	prev := g.byClient[f.Client]
+	g.byClient[f.Client] = append(prev, f)
+	if err := g.saveLocked(); err != nil {
+		g.setClientLocked(f.Client, prev)
+		if issued != "" {
+			delete(g.secrets, f.Client)
+		}
+		return "", err
+	}
+	return issued, nil
+}
+
+func (g *geofenceStore) remove(client, id, secret string) (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.authorizedLocked(client, secret) {
+		return false, errClientSecret
+	}
+	fences := g.byClient[client]
+	for i, f := range fences {
+		if f.ID == id {
+			g.setClientLocked(client, append(fences[:i:i], fences[i+1:]...))
+			if err := g.saveLocked(); err != nil {
+				g.setClientLocked(client, fences)
+				return false, err
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// setClientLocked sets a client's fences (dropping the client when there are none)
+func (g *geofenceStore) setClientLocked(client string, fences []Geofence) {
+	if len(fences) == 0 {
+		delete(g.byClient, client)
+		return
+	}
+	g.byClient[client] = fences
+}
+
+// saveLocked writes every fence back to the geofences file so they survive restarts. The
+// file holds the client secrets, so only the server's user can read it.
+func (g *geofenceStore) saveLocked() error {
+	if g.filePath == "" {
+		return nil
+	}
+	list := []securedGeofence{}
+	for client, fences := range g.byClient {
+		for _, f := range fences {
+			list = append(list, securedGeofence{Geofence: f, ClientSecret: g.secrets[client]})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Client != list[j].Client {
+			return list[i].Client < list[j].Client
+		}
+		return list[i].ID < list[j].ID
+	})
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := g.filePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, g.filePath)
+}
+
+// pinned returns the station pinned by the client's innermost fence containing the
+// point. Fences whose station is unknown or closed are ignored.
+func (g *geofenceStore) pinned(client string, lat, lon float64) (Station, Geofence, bool) {
+	if client == "" {
+		return Station{}, Geofence{}, false
+	}
+	var best Geofence
+	var bestStation Station
+	found := false
+	for _, f := range g.list(client) {
+		if haversine(lat, lon, f.Lat, f.Lon) > f.RadiusM || (found && f.RadiusM >= best.RadiusM) {
+			continue
+		}
+		s, ok := stationByID(f.StopID)
+		if !ok || isStationClosed(s) {
+			continue
+		}
+		best, bestStation, found = f, s, true
+	}
+	return bestStation, best, found
+}
+
+// withPinned puts a pinned station ahead of the other candidates (dropping it from among
+// them), keeping at most n
+func withPinned(pinned Station, candidates []Station, n int) []Station {
+	out := []Station{pinned}
+	for _, s := range candidates {
+		if len(out) < n && parentStopID(s.StopID) != parentStopID(pinned.StopID) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func newGeofenceID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func newClientSecret() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// clientSecret is the bearer token a geofence request was sent with
+func clientSecret(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+func handleGeofences(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	switch r.Method {
+	case http.MethodGet:
+		client := strings.TrimSpace(r.URL.Query().Get("client"))
+		if client == "" {
+			httpError(w, http.StatusBadRequest, "missing client")
+			return
+		}
+		if !geofences.authorized(client, clientSecret(r)) {
+			httpError(w, http.StatusUnauthorized, errClientSecret.Error())
+			return
+		}
+		writeJSON(w, geofences.list(client))
+	case http.MethodPost:
+		var f Geofence
+		if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+			httpError(w, http.StatusBadRequest, "invalid geofence JSON")
+			return
+		}
+		f.Client = strings.TrimSpace(f.Client)
+		f.StopID = strings.TrimSpace(f.StopID)
+		if f.Client == "" {
+			httpError(w, http.StatusBadRequest, "missing client")
+			return
+		}
+		if outsideNYC(f.Lat, f.Lon) {
+			httpError(w, http.StatusBadRequest, "geofence center outside NYC area")
+			return
+		}
+		if f.RadiusM < minGeofenceRadius || f.RadiusM > maxGeofenceRadius {
+			httpError(w, http.StatusBadRequest, fmt.Sprintf("radius_m must be between %g and %g", minGeofenceRadius, maxGeofenceRadius))
+			return
+		}
+		if _, ok := stationByID(f.StopID); !ok {
+			httpError(w, http.StatusBadRequest, "unknown stop_id")
+			return
+		}
+		f.ID = newGeofenceID()
+		issued, err := geofences.add(f, clientSecret(r))
+		switch {
+		case errors.Is(err, errClientSecret):
+			httpError(w, http.StatusUnauthorized, err.Error())
+			return
+		case errors.Is(err, errTooManyGeofences):
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		case err != nil:
+			httpError(w, http.StatusInternalServerError, "failed to save geofences: "+err.Error())
+			return
+		}
+		log.Printf("Geofence %s (%s) pins %s", f.ID, f.Name, f.StopID)
+		writeJSON(w, securedGeofence{Geofence: f, ClientSecret: issued})
+	case http.MethodDelete:
+		client := strings.TrimSpace(r.URL.Query().Get("client"))
+		id := strings.TrimSpace(r.URL.Query().Get("id"))
+		if client == "" || id == "" {
+			httpError(w, http.StatusBadRequest, "missing client or id")
+			return
+		}
+		removed, err := geofences.remove(client, id, clientSecret(r))
+		if errors.Is(err, errClientSecret) {
+			httpError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, "failed to save geofences: "+err.Error())
+			return
+		}
+		if !removed {
+			httpError(w, http.StatusNotFound, "no such geofence")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGeofencesAPI(t *testing.T) {
+	keepTestData(t)
+	originalGeofences := geofences
+	defer func() { geofences = originalGeofences }()
+	setTestStations([]Station{{StopID: "635", Name: "14 St-Union Sq", Lat: 40.734673, Lon: -73.989951}})
+
+	path := filepath.Join(t.TempDir(), "geofences.json")
+	geofences = &geofenceStore{byClient: map[string][]Geofence{}}
+	if err := geofences.load(path); err != nil {
+		t.Fatalf("missing file should load empty: %v", err)
+	}
+
+	var secret string
+	do := func(method, url, body, bearer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		w := httptest.NewRecorder()
+		handleGeofences(w, req)
+		return w
+	}
+	post := func(body string) *httptest.ResponseRecorder { return do("POST", "/api/geofences", body, secret) }
+	const home = `{"client": "c1", "name": "home", "lat": 40.7359, "lon": -73.9911, "radius_m": 150, "stop_id": "635N"}`
+	w := post(home)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var created securedGeofence
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil || created.ID == "" || created.ClientSecret == "" {
+		t.Fatalf("expected fence with an id and client secret, got %+v (%v)", created, err)
+	}
+	secret = created.ClientSecret
+
+	// Only the first fence issues the secret, and the client's fences need it from then on
+	if w := post(home); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "client_secret") {
+		t.Errorf("expected a second fence without a new secret, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, w := range []*httptest.ResponseRecorder{
+		do("POST", "/api/geofences", home, ""),
+		do("POST", "/api/geofences", home, "guess"),
+		do("GET", "/api/geofences?client=c1", "", ""),
+		do("DELETE", "/api/geofences?client=c1&id="+created.ID, "", "guess"),
+	} {
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401 without the client secret, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	if len(geofences.list("c1")) != 2 {
+		t.Fatalf("expected 2 fences, got %+v", geofences.list("c1"))
+	}
+
+	for _, body := range []string{
+		`{"lat": 40.7359, "lon": -73.9911, "radius_m": 150, "stop_id": "635"}`,
+		`{"client": "c1", "lat": 34.05, "lon": -118.24, "radius_m": 150, "stop_id": "635"}`,
+		`{"client": "c1", "lat": 40.7359, "lon": -73.9911, "radius_m": 5, "stop_id": "635"}`,
+		`{"client": "c1", "lat": 40.7359, "lon": -73.9911, "radius_m": 150, "stop_id": "X99"}`,
+		`not json`,
+	} {
+		if w := post(body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+
+	// Edits are persisted with the client's secret, and fences are private to their client
+	data, _ := os.ReadFile(path)
+	var saved []securedGeofence
+	if err := json.Unmarshal(data, &saved); err != nil || len(saved) != 2 || saved[0].ClientSecret != secret {
+		t.Errorf("expected fences persisted to file, got %s", data)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the geofences file private to the server, got %v (%v)", info.Mode(), err)
+	}
+	geofences = &geofenceStore{}
+	if err := geofences.load(path); err != nil || !geofences.authorized("c1", secret) || geofences.authorized("c1", "") {
+		t.Errorf("expected the client secret to survive a reload (%v)", err)
+	}
+	if w := do("GET", "/api/geofences?client=c2", "", ""); strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("expected no fences for another client, got %s", w.Body.String())
+	}
+	if w := do("GET", "/api/geofences?client=c1", "", secret); w.Code != http.StatusOK || strings.Contains(w.Body.String(), secret) {
+		t.Errorf("expected the fences listed without the secret, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := do("DELETE", "/api/geofences?client=c2&id="+created.ID, "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 deleting another client's fence, got %d", w.Code)
+	}
+	if w := do("DELETE", "/api/geofences?client=c1&id="+created.ID, "", secret); w.Code != http.StatusNoContent || len(geofences.list("c1")) != 1 {
+		t.Errorf("expected fence deleted, got %d", w.Code)
+	}
+}
+
+func TestGeofenceSaveFailureRollsBack(t *testing.T) {
+	keepTestData(t)
+	originalGeofences := geofences
+	defer func() { geofences = originalGeofences }()
+	setTestStations([]Station{{StopID: "635", Name: "14 St-Union Sq", Lat: 40.734673, Lon: -73.989951}})
+
+	// The file's directory doesn't exist, so every save fails
+	existing := Geofence{ID: "home", Client: "c1", Lat: 40.7359, Lon: -73.9911, RadiusM: 150, StopID: "635"}
+	geofences = &geofenceStore{
+		byClient: map[string][]Geofence{"c1": {existing}},
+		secrets:  map[string]string{"c1": "s1"},
+		filePath: filepath.Join(t.TempDir(), "missing", "geofences.json"),
+	}
+	fence := Geofence{ID: "work", Client: "c2", Lat: 40.7359, Lon: -73.9911, RadiusM: 150, StopID: "635"}
+	if _, err := geofences.add(fence, ""); err == nil {
+		t.Fatal("expected the save to fail")
+	}
+	if got := geofences.list("c2"); len(got) != 0 || !geofences.authorized("c2", "") {
+		t.Errorf("expected the failed add undone, got %+v", got)
+	}
+	if _, err := geofences.remove("c1", "home", "s1"); err == nil {
+		t.Fatal("expected the save to fail")
+	}
+	if got := geofences.list("c1"); len(got) != 1 || got[0].ID != "home" {
+		t.Errorf("expected the failed remove undone, got %+v", got)
+	}
+}
+
+func TestNearestGeofencePin(t *testing.T) {
+	initTestCaches()
+	useTestFeeds(t, newTestFeedServer(t).URL)
+	useTestOSRM(t, 120, 150)
+	keepTestData(t)
+	originalGeofences := geofences
+	defer func() { geofences = originalGeofences }()
+	setTestStations([]Station{
+		{StopID: "635", Name: "14 St-Union Sq", Lat: 40.734673, Lon: -73.989951},
+		{StopID: "D19", Name: "14 St", Lat: 40.738228, Lon: -73.996209},
+	})
+	geofences = &geofenceStore{byClient: map[string][]Geofence{
+		"c1": {
+			{ID: "wide", Client: "c1", Lat: 40.7366, Lon: -73.9930, RadiusM: 800, StopID: "635"},
+			{ID: "home", Client: "c1", Lat: 40.7366, Lon: -73.9930, RadiusM: 100, StopID: "D19"},
+		},
+	}}
+
+	get := func(url string) NearestResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleNearest(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", url, w.Code, w.Body.String())
+		}
+		var resp NearestResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Just inside the home fence but geometrically closer to Union Sq
+	const here = "lat=40.7362&lon=-73.9924"
+	if resp := get("/api/departures/nearest?" + here); resp.Station.StopID != "635" || resp.PinnedBy != nil {
+		t.Errorf("expected the nearest station without a client, got %+v", resp.Station)
+	}
+	if resp := get("/api/departures/nearest?" + here + "&client=c1"); resp.Station.StopID != "D19" || resp.PinnedBy == nil || resp.PinnedBy.ID != "home" {
+		t.Errorf("expected the innermost fence to pin D19, got %+v pinned by %+v", resp.Station, resp.PinnedBy)
+	}
+	if resp := get("/api/departures/nearest?" + here + "&client=c2"); resp.Station.StopID != "635" {
+		t.Errorf("other clients' fences must not apply, got %+v", resp.Station)
+	}
+	if resp := get("/api/departures/nearest?lat=40.7450&lon=-73.9880&client=c1"); resp.PinnedBy != nil {
+		t.Errorf("expected no pin outside every fence, got %+v", resp.PinnedBy)
+	}
+
+	// count=N ranks the pinned station first, once
+	w := httptest.NewRecorder()
+	handleNearest(w, httptest.NewRequest("GET", "/api/departures/nearest?"+here+"&count=3&client=c1&sort=total_time", nil))
+	var multi MultiNearestResponse
+	if err := json.NewDecoder(w.Body).Decode(&multi); err != nil {
+		t.Fatal(err)
+	}
+	if len(multi.Stations) != 2 || multi.Stations[0].Station.StopID != "D19" || multi.Stations[0].PinnedBy == nil || multi.Stations[1].Station.StopID != "635" {
+		t.Errorf("expected D19 pinned ahead of 635, got %+v", multi.Stations)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+// Station usage heatmap.
+//
+//   GET /api/stats/heatmap
+//
+// Returns a GeoJSON FeatureCollection with a Point per station, for operators deciding
+// where to put displays and which stations to cache-warm. Each feature's properties carry:
+//
+//   - requests: departure lookups for the station since the server started, from every
+//     endpoint (nearest, by-id, by-name, bulk, corridor, stream and WebSocket pushes)
+//   - ridership: total entries from the ridership dataset (config key ridership_csv, e.g.
+//     the MTA Subway Hourly Ridership export, summed per station_complex_id; every station
+//     of a complex carries the complex's total)
+//   - intensity: 0-1, the average of requests and ridership each scaled by its busiest
+//     station; with only one of them available, that one alone
+//
+// Stations with neither are left out.
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// usageCounter counts departure lookups per parent stop ID
+type usageCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+var stationUsage = &usageCounter{counts: map[string]int64{}}
+
+func (u *usageCounter) mark(stopID string) {
+	u.mu.Lock()
+	u.counts[parentStopID(stopID)]++
+	u.mu.Unlock()
+}
+
+func (u *usageCounter) snapshot() map[string]int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	out := make(map[string]int64, len(u.counts))
+	for k, v := range u.counts {
+		out[k] = v
+	}
+	return out
+}
+
+// stationRidership is total ridership per station complex ID
+var stationRidership map[string]float64
+
+// loadRidership sums a ridership CSV by station complex
+func loadRidership(ctx context.Context, csvURL string) error {
+	body, err := openDataSource(ctx, csvURL)
+	if err != nil {
+		return fmt.Errorf("download ridership: %w", err)
+	}
+	defer body.Close()
+	r := csv.NewReader(body)
+	r.FieldsPerRecord = -1
+
+	idx, err := parseCSVHeaders(r, []string{"stationcomplexid", "ridership"}, "ridership")
+	if err != nil {
+		return err
+	}
+	out := map[string]float64{}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read ridership row: %w", err)
+		}
+		n, err := strconv.ParseFloat(strings.ReplaceAll(row[idx["ridership"]], ",", ""), 64)
+		if err != nil {
+			continue
+		}
+		out[strings.TrimSpace(row[idx["stationcomplexid"]])] += n
+	}
+	stationRidership = out
+	log.Printf("Loaded ridership for %d station complexes", len(out))
+	return nil
+}
+
+type geoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // lon, lat
+}
+
+type heatmapFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONPoint      `json:"geometry"`
+	Properties heatmapProperties `json:"properties"`
+}
+
+type heatmapProperties struct {
+	StopID    string  `json:"stop_id"`
+	Name      string  `json:"name"`
+	Requests  int64   `json:"requests"`
+	Ridership float64 `json:"ridership"`
+	Intensity float64 `json:"intensity"`
+}
+
+type heatmapResponse struct {
+	Type     string           `json:"type"`
+	Features []heatmapFeature `json:"features"`
+}
+
+// buildHeatmap combines request counts and ridership into features, busiest first
+func buildHeatmap(list []Station, requests map[string]int64, ridership map[string]float64) heatmapResponse {
+	var maxRequests int64
+	var maxRidership float64
+	seen := map[string]bool{}
+	features := []heatmapFeature{}
+	for _, s := range list {
+		id := parentStopID(s.StopID)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		p := heatmapProperties{StopID: id, Name: s.Name, Requests: requests[id]}
+		if s.ComplexID != "" {
+			p.Ridership = ridership[s.ComplexID]
+		}
+		if p.Requests == 0 && p.Ridership == 0 {
+			continue
+		}
+		if p.Requests > maxRequests {
+			maxRequests = p.Requests
+		}
+		if p.Ridership > maxRidership {
+			maxRidership = p.Ridership
+		}
+		features = append(features, heatmapFeature{Type: "Feature", Geometry: geoJSONPoint{Type: "Point", Coordinates: [2]float64{s.Lon, s.Lat}}, Properties: p})
+	}
+	for i := range features {
+		p := &features[i].Properties
+		var sum float64
+		var n int
+		if maxRequests > 0 {
+			sum += float64(p.Requests) / float64(maxRequests)
+			n++
+		}
+		if maxRidership > 0 {
+			sum += p.Ridership / maxRidership
+			n++
+		}
+		if n > 0 {
+			p.Intensity = sum / float64(n)
+		}
+	}
+	sort.SliceStable(features, func(i, j int) bool { return features[i].Properties.Intensity > features[j].Properties.Intensity })
+	return heatmapResponse{Type: "FeatureCollection", Features: features}
+}
+
+func handleHeatmap(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	writeJSON(w, buildHeatmap(data().Stations, stationUsage.snapshot(), stationRidership))
+	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+}
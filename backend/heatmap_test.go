@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadRidership(t *testing.T) {
+	original := stationRidership
+	t.Cleanup(func() { stationRidership = original })
+	path := filepath.Join(t.TempDir(), "ridership.csv")
+	os.WriteFile(path, []byte("transit_timestamp,station_complex_id,station_complex,ridership\n"+
+		"10/01/2026 08:00:00 AM,602,14 St-Union Sq,\"1,200\"\n"+
+		"10/01/2026 09:00:00 AM,602,14 St-Union Sq,800\n"+
+		"10/01/2026 08:00:00 AM,622,Brooklyn Bridge,500\n"), 0o644)
+	if err := loadRidership(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	if stationRidership["602"] != 2000 || stationRidership["622"] != 500 {
+		t.Errorf("unexpected ridership %v", stationRidership)
+	}
+}
+
+func TestHeatmap(t *testing.T) {
+	keepTestData(t)
+	originalUsage, originalRidership := stationUsage, stationRidership
+	t.Cleanup(func() { stationUsage = originalUsage; stationRidership = originalRidership })
+	setTestStations([]Station{
+		{StopID: "635", Name: "14 St-Union Sq", Lat: 40.7347, Lon: -73.9899, ComplexID: "602"},
+		{StopID: "640", Name: "Brooklyn Bridge-City Hall", Lat: 40.7131, Lon: -74.0041, ComplexID: "622"},
+		{StopID: "A27", Name: "42 St-Port Authority", Lat: 40.7573, Lon: -73.9898},
+	})
+	stationUsage = &usageCounter{counts: map[string]int64{}}
+	stationRidership = map[string]float64{"602": 1000, "622": 2000}
+	for i := 0; i < 4; i++ {
+		stationUsage.mark("635N")
+	}
+	stationUsage.mark("640")
+
+	w := httptest.NewRecorder()
+	handleHeatmap(w, httptest.NewRequest("GET", "/api/stats/heatmap", nil))
+	var resp heatmapResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Type != "FeatureCollection" || len(resp.Features) != 2 {
+		t.Fatalf("expected 2 features (A27 has no data), got %+v", resp)
+	}
+	// 635: requests 4/4, ridership 1000/2000; 640: requests 1/4, ridership 2000/2000
+	first, second := resp.Features[0], resp.Features[1]
+	if first.Properties.StopID != "635" || first.Properties.Requests != 4 || first.Properties.Intensity != 0.75 {
+		t.Errorf("unexpected first feature %+v", first)
+	}
+	if second.Properties.StopID != "640" || second.Properties.Intensity != 0.625 || second.Properties.Ridership != 2000 {
+		t.Errorf("unexpected second feature %+v", second)
+	}
+	if first.Geometry.Type != "Point" || first.Geometry.Coordinates != [2]float64{-73.9899, 40.7347} {
+		t.Errorf("unexpected geometry %+v", first.Geometry)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+// Self-describing API root.
+//
+//   GET /api
+//
+// Lists every endpoint with its methods and query parameters, the API version and which
+// optional features this deployment has enabled, so clients can discover capabilities
+// instead of hardcoding paths. Each endpoint's href is relative to the server root.
+// apiEndpoints must be kept in step with newMux; the tests check every href is routed.
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// apiVersion changes when a response shape changes incompatibly
+const apiVersion = "1"
+
+// APIParam is one query parameter of an endpoint
+type APIParam struct {
+	Name        string `json:"name"`
+	Required    bool   `json:"required,omitempty"`
+	Description string `json:"description"`
+}
+
+// APIEndpoint describes one route for the index
+type APIEndpoint struct {
+	Name        string     `json:"name"`
+	Href        string     `json:"href"`
+	Methods     []string   `json:"methods"`
+	Description string     `json:"description"`
+	Params      []APIParam `json:"params,omitempty"`
+}
+
+// APIIndex is the /api response
+type APIIndex struct {
+	Version   string            `json:"version"`
+	Links     map[string]string `json:"links"`
+	Endpoints []APIEndpoint     `json:"endpoints"`
+	Features  map[string]bool   `json:"features"`
+}
+
+// departureFilterParams are the filters every departures endpoint accepts, see filters.go
+var departureFilterParams = []APIParam{
+	{Name: "routes", Description: "comma-separated route IDs to keep"},
+	{Name: "direction", Description: "N (uptown) or S (downtown)"},
+	{Name: "limit", Description: "departures per route and direction"},
+	{Name: "horizon", Description: "only departures within this duration, e.g. 30m"},
+}
+
+// fieldsParam trims departure objects, see fields.go
+var fieldsParam = APIParam{Name: "fields", Description: "comma-separated departure keys to return"}
+
+// tableParam switches a departures response to CSV or TSV, see table.go
+var tableParam = APIParam{Name: "format", Description: "csv or tsv for one row per departure"}
+
+// geometryParam adds the route to the station to walks (directions=true still works)
+var geometryParam = APIParam{Name: "geometry", Description: "true to include the route polyline and turn-by-turn steps"}
+
+// sortParam orders nearest candidates by distance or by door-to-train time
+var sortParam = APIParam{Name: "sort", Description: "distance or total_time (walk plus wait for the next departure)"}
+
+// adaParam limits nearest queries to accessible stations, see accessible.go
+var adaParam = APIParam{Name: "ada", Description: "true for ADA-accessible stations without a reported elevator outage"}
+
+// modeParam times the trip to the station by another OSRM profile, see travelmode.go
+var modeParam = APIParam{Name: "mode", Description: "walk, bike or drive; how the trip to the station is timed"}
+
+func withFilters(params ...APIParam) []APIParam {
+	return append(params, departureFilterParams...)
+}
+
+var apiEndpoints = []APIEndpoint{
+	{Name: "stops", Href: "/api/stops", Methods: []string{"GET"}, Description: "Station complexes",
+		Params: []APIParam{{Name: "view", Description: "rider (default) or raw for one row per GTFS stop"}}},
+	{Name: "routes", Href: "/api/routes", Methods: []string{"GET"}, Description: "Route names and colors"},
+	{Name: "route_stations", Href: "/api/routes/{id}/stations", Methods: []string{"GET"}, Description: "A route's stations in calling order, per direction"},
+	{Name: "route_shape", Href: "/api/routes/{id}/shape", Methods: []string{"GET"}, Description: "A route's shapes as encoded polylines, most used first",
+		Params: []APIParam{{Name: "format", Description: "geojson for a FeatureCollection of LineStrings"}}},
+	{Name: "alerts", Href: "/api/alerts", Methods: []string{"GET"}, Description: "Active service alerts",
+		Params: []APIParam{{Name: "route", Description: "route ID"}, {Name: "stop_id", Description: "stop ID"}}},
+	{Name: "feeds", Href: "/api/feeds", Methods: []string{"GET"}, Description: "Names of the proxied GTFS-RT feeds"},
+	{Name: "feed", Href: "/api/feeds/{name}", Methods: []string{"GET"}, Description: "Raw GTFS-RT protobuf for a feed, cached for the feed cache TTL"},
+	{Name: "feed_status", Href: "/api/feeds/status", Methods: []string{"GET"}, Description: "Per-feed last successful fetch, header timestamp, entity count, consecutive failures and cache age"},
+	{Name: "nearest", Href: "/api/departures/nearest", Methods: []string{"GET"}, Description: "Departures at the nearest station, with the walk there",
+		Params: withFilters(
+			APIParam{Name: "lat", Description: "latitude, required unless place is given"},
+			APIParam{Name: "lon", Description: "longitude, required unless place is given"},
+			APIParam{Name: "place", Description: "gazetteer place ID instead of lat/lon"},
+			APIParam{Name: "address", Description: "street address instead of lat/lon, geocoded"},
+			APIParam{Name: "count", Description: "return the N closest stations"},
+			geometryParam,
+			APIParam{Name: "catchable", Description: "true to keep only trains reachable on foot"},
+			modeParam,
+			adaParam,
+			sortParam,
+			APIParam{Name: "client", Description: "client ID for geofence pinning"},
+			fieldsParam,
+			tableParam,
+		)},
+	{Name: "nearest_multi", Href: "/api/departures/nearest-multi", Methods: []string{"GET"}, Description: "Closest stations ranked by door-to-train time",
+		Params: []APIParam{{Name: "lat", Required: true, Description: "latitude"}, {Name: "lon", Required: true, Description: "longitude"}, {Name: "count", Description: "number of stations"}, modeParam, geometryParam, adaParam, sortParam, fieldsParam, tableParam}},
+	{Name: "by_id", Href: "/api/departures/by-id", Methods: []string{"GET"}, Description: "Departures for a station",
+		Params: withFilters(APIParam{Name: "id", Required: true, Description: "stop ID"}, fieldsParam, tableParam)},
+	{Name: "by_name", Href: "/api/departures/by-name", Methods: []string{"GET"}, Description: "Departures for a station by name (409 with candidates when ambiguous)",
+		Params: withFilters(
+			APIParam{Name: "name", Required: true, Description: "station name"},
+			APIParam{Name: "route", Description: "route ID to disambiguate"},
+			APIParam{Name: "borough", Description: "M, Bk, Q, Bx or SI"},
+			fieldsParam,
+			tableParam,
+		)},
+	{Name: "bulk", Href: "/api/departures/bulk", Methods: []string{"GET"}, Description: "Departures for several stations",
+		Params: withFilters(APIParam{Name: "ids", Required: true, Description: "comma-separated stop IDs"}, fieldsParam, tableParam)},
+	{Name: "any", Href: "/api/departures/any", Methods: []string{"GET"}, Description: "Departures merged across several stations",
+		Params: withFilters(
+			APIParam{Name: "ids", Required: true, Description: "comma-separated stop IDs"},
+			APIParam{Name: "lat", Description: "origin latitude for walking times"},
+			APIParam{Name: "lon", Description: "origin longitude for walking times"},
+			fieldsParam,
+			tableParam,
+		)},
+	{Name: "stream", Href: "/api/departures/stream", Methods: []string{"GET"}, Description: "Server-Sent Events stream of a station's departures",
+		Params: withFilters(APIParam{Name: "id", Required: true, Description: "stop ID"})},
+	{Name: "corridor", Href: "/api/corridor", Methods: []string{"GET"}, Description: "Trains by stop along consecutive stations, for progress displays (direction required)",
+		Params: withFilters(APIParam{Name: "stops", Required: true, Description: "comma-separated stop IDs in travel order"})},
+	{Name: "ws", Href: "/ws", Methods: []string{"GET"}, Description: "WebSocket subscriptions to station departures and alerts"},
+	{Name: "poster", Href: "/api/stations/poster", Methods: []string{"GET"}, Description: "Printable PDF station poster",
+		Params: []APIParam{{Name: "id", Required: true, Description: "stop ID"}}},
+	{Name: "closures", Href: "/api/closures", Methods: []string{"GET", "POST", "DELETE"}, Description: "Station and entrance closure overrides (changes need the admin token)",
+		Params: []APIParam{{Name: "stop_id", Description: "stop ID, for DELETE"}, {Name: "entrance", Description: "lat,lon of a closed entrance, for DELETE"}}},
+	{Name: "geofences", Href: "/api/geofences", Methods: []string{"GET", "POST", "DELETE"}, Description: "Per-client station pinning for nearest (needs the client_secret issued with the first fence)",
+		Params: []APIParam{{Name: "client", Description: "client ID"}, {Name: "id", Description: "geofence ID, for DELETE"}}},
+	{Name: "geocode", Href: "/api/geocode", Methods: []string{"GET"}, Description: "Addresses in NYC resolved to coordinates",
+		Params: []APIParam{{Name: "q", Required: true, Description: "address"}}},
+	{Name: "playground", Href: "/playground", Methods: []string{"GET"}, Description: "HTML page for trying the API from a browser"},
+	{Name: "startupz", Href: "/startupz", Methods: []string{"GET"}, Description: "Startup probe"},
+	{Name: "healthz", Href: "/healthz", Methods: []string{"GET"}, Description: "Liveness probe"},
+	{Name: "readyz", Href: "/readyz", Methods: []string{"GET"}, Description: "Readiness probe with data-source state"},
+	{Name: "quit", Href: "/quitquitquit", Methods: []string{"POST"}, Description: "Start draining"},
+	{Name: "snapshot", Href: "/admin/snapshot", Methods: []string{"GET"}, Description: "State snapshot for warm starts"},
+	{Name: "metrics", Href: "/metrics", Methods: []string{"GET"}, Description: "Per-endpoint SLO burn rates and per-station data age"},
+	{Name: "heatmap", Href: "/api/stats/heatmap", Methods: []string{"GET"}, Description: "GeoJSON of per-station request counts and ridership"},
+	{Name: "reconciliation", Href: "/api/stats/reconciliation", Methods: []string{"GET"}, Description: "Scheduled trips running now vs trips in the realtime feeds, per route",
+		Params: []APIParam{{Name: "route", Description: "route ID"}}},
+}
+
+// apiFeatures reports which optional features are enabled by the current config
+func apiFeatures() map[string]bool {
+	return map[string]bool{
+		"alerts":      alertsFeedURL != "",
+		"posters":     boardURL != "",
+		"poller":      pollerInterval > 0,
+		"shadow_mode": shadowMode,
+		"digests":     len(appConfig.Digests) > 0 && appConfig.Notifier.Type != "",
+		"admin":       adminToken != "",
+		"tls_pinning": len(appConfig.TLSPins) > 0,
+	}
+}
+
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	links := map[string]string{"self": "/api"}
+	for _, e := range apiEndpoints {
+		links[e.Name] = e.Href
+	}
+	writeJSON(w, APIIndex{Version: apiVersion, Links: links, Endpoints: apiEndpoints, Features: apiFeatures()})
+	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+}
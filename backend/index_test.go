@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIIndex(t *testing.T) {
+	originalBoard := boardURL
+	t.Cleanup(func() { boardURL = originalBoard })
+	boardURL = "https://example.com/?station={id}"
+
+	w := httptest.NewRecorder()
+	handleIndex(w, httptest.NewRequest("GET", "/api", nil))
+	var index APIIndex
+	if err := json.NewDecoder(w.Body).Decode(&index); err != nil {
+		t.Fatalf("failed to decode index: %v", err)
+	}
+	if index.Version != apiVersion || index.Links["self"] != "/api" || index.Links["by_id"] != "/api/departures/by-id" {
+		t.Errorf("unexpected index header %+v", index)
+	}
+	if !index.Features["posters"] {
+		t.Error("expected posters enabled with board_url set")
+	}
+	for _, e := range index.Endpoints {
+		if e.Name == "by_id" && (len(e.Params) == 0 || e.Params[0].Name != "id" || !e.Params[0].Required) {
+			t.Errorf("by-id should require id, got %+v", e.Params)
+		}
+	}
+}
+
+// Every indexed endpoint must be routed by newMux
+func TestAPIIndexMatchesMux(t *testing.T) {
+	mux := newRoutes()
+	for _, e := range append(apiEndpoints, APIEndpoint{Href: "/api"}) {
+		path := strings.NewReplacer("{id}", "6", "{name}", "gtfs").Replace(e.Href)
+		if _, pattern := mux.Handler(httptest.NewRequest("GET", path, nil)); pattern == "" {
+			t.Errorf("%s is indexed but not routed", e.Href)
+		}
+	}
+}
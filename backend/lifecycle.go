@@ -0,0 +1,344 @@
+package main
+
+// Process lifecycle for orchestrators (Kubernetes and friends).
+//
+//   GET  /startupz       200 once static data has loaded, 503 with per-step progress before
+//   GET  /healthz        200 while the process is serving (liveness)
+//   GET  /readyz         200 when stations and trips are loaded and a feed is reachable,
+//                        503 otherwise (and while draining); JSON describes every source
+//   POST /quitquitquit   start draining and shut down (admin token required; for preStop hooks)
+//
+// The server listens before static data loads so the startup probe can report progress;
+// /api/ requests are answered with 503 until loading finishes. On SIGTERM (or
+// /quitquitquit) the server stops accepting new connections, asks keep-alive clients to
+// reconnect elsewhere, and gives in-flight requests shutdown_grace_period to finish.
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Startup step states
+const (
+	stepPending = "pending"
+	stepOK      = "ok"
+	stepFailed  = "failed" // optional data that failed to load; startup still completes
+)
+
+// startupProgress tracks static-data loading. The zero value reports startup complete, so
+// tests and tools that build the mux directly are never gated.
+type startupProgress struct {
+	mu     sync.RWMutex
+	order  []string
+	steps  map[string]string
+	active bool // begin was called and finish has not been
+}
+
+var startup = &startupProgress{}
+
+// begin marks the listed steps pending; API requests are refused until finish
+func (p *startupProgress) begin(steps ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.order = steps
+	p.steps = make(map[string]string, len(steps))
+	for _, s := range steps {
+		p.steps[s] = stepPending
+	}
+	p.active = true
+}
+
+// mark records the outcome of a step
+func (p *startupProgress) mark(step string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.steps[step] = stepFailed
+	} else {
+		p.steps[step] = stepOK
+	}
+}
+
+func (p *startupProgress) finish() {
+	p.mu.Lock()
+	p.active = false
+	p.mu.Unlock()
+}
+
+func (p *startupProgress) complete() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return !p.active
+}
+
+// StartupStatus is the /startupz response body
+type StartupStatus struct {
+	Complete       bool              `json:"complete"`
+	Steps          map[string]string `json:"steps,omitempty"`           // step -> pending/ok/failed
+	StationsSource string            `json:"stations_source,omitempty"` // which failover source is live
+}
+
+func (p *startupProgress) status() StartupStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	st := StartupStatus{Complete: !p.active, StationsSource: liveStationsSource}
+	if len(p.steps) > 0 {
+		st.Steps = make(map[string]string, len(p.steps))
+		for _, s := range p.order {
+			st.Steps[s] = p.steps[s]
+		}
+	}
+	return st
+}
+
+func handleStartupz(w http.ResponseWriter, r *http.Request) {
+	st := startup.status()
+	code := http.StatusOK
+	if !st.Complete {
+		code = http.StatusServiceUnavailable
+	}
+	writeProbe(w, code, st)
+}
+
+// processStart is when the process started, for /healthz uptime
+var processStart = time.Now()
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeProbe(w, http.StatusOK, map[string]any{
+		"status":         "ok",
+		"uptime_seconds": int64(time.Since(processStart).Seconds()),
+	})
+}
+
+// feedReachableWindow is how recent a successful feed fetch must be to count for readiness
+const feedReachableWindow = 5 * time.Minute
+
+// FeedHealth is the last known state of one realtime feed
+type FeedHealth struct {
+	Reachable   bool       `json:"reachable"`
+	LastOK      *time.Time `json:"last_ok,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+type feedHealthRecord struct {
+	lastOK, lastErrorAt time.Time
+	lastError           string
+	failures            int // consecutive, since lastOK
+	// from the last message parsed and the last copy cached, for /api/feeds/status
+	headerTime time.Time
+	entities   int
+	cachedAt   time.Time
+}
+
+var feedHealth = struct {
+	sync.Mutex
+	byURL map[string]*feedHealthRecord
+}{byURL: map[string]*feedHealthRecord{}}
+
+// recordFeedResult notes the outcome of a feed download
+func recordFeedResult(url string, err error) {
+	updateFeedHealth(url, func(rec *feedHealthRecord) {
+		if err != nil {
+			rec.lastError, rec.lastErrorAt = err.Error(), time.Now()
+			rec.failures++
+		} else {
+			rec.lastOK = time.Now()
+			rec.failures = 0
+		}
+	})
+}
+
+// feedHealthSnapshot reports every feed in urls as of now
+func feedHealthSnapshot(urls []string, now time.Time) (map[string]FeedHealth, bool) {
+	feedHealth.Lock()
+	defer feedHealth.Unlock()
+	out := make(map[string]FeedHealth, len(urls))
+	reachable := false
+	for _, u := range urls {
+		var fh FeedHealth
+		if rec, ok := feedHealth.byURL[u]; ok {
+			if !rec.lastOK.IsZero() {
+				t := rec.lastOK
+				fh.LastOK = &t
+				fh.Reachable = now.Sub(t) <= feedReachableWindow && !rec.lastErrorAt.After(t)
+			}
+			if !rec.lastErrorAt.IsZero() {
+				t := rec.lastErrorAt
+				fh.LastError, fh.LastErrorAt = rec.lastError, &t
+			}
+		}
+		reachable = reachable || fh.Reachable
+		out[u] = fh
+	}
+	return out, reachable
+}
+
+// DataSourceStatus describes one static data source in /readyz
+type DataSourceStatus struct {
+	Loaded bool   `json:"loaded"`
+	Count  int    `json:"count"`
+	Source string `json:"source,omitempty"`
+}
+
+// ReadyStatus is the /readyz response body
+type ReadyStatus struct {
+	Ready             bool                  `json:"ready"`
+	Reasons           []string              `json:"reasons,omitempty"` // why the instance is not ready
+	Draining          bool                  `json:"draining,omitempty"`
+	Stations          DataSourceStatus      `json:"stations"`
+	Trips             DataSourceStatus      `json:"trips"`
+	SupplementedTrips DataSourceStatus      `json:"supplemented_trips"`
+	StopTimes         bool                  `json:"stop_times"`
+	Feeds             map[string]FeedHealth `json:"feeds"`
+	Upstreams         []UpstreamCheck       `json:"upstreams,omitempty"` // startup self-test, see tls.go
+}
+
+// readiness evaluates /readyz. With no recent feed success it probes the feeds (through
+// the feed cache) until one answers.
+func readiness(now time.Time) ReadyStatus {
+	ds := data()
+	st := ReadyStatus{
+		Draining:          isDraining(),
+		Stations:          DataSourceStatus{Loaded: len(ds.Stations) > 0, Count: len(ds.Stations), Source: liveStationsSource},
+		Trips:             DataSourceStatus{Loaded: len(ds.Trips) > 0, Count: len(ds.Trips)},
+		SupplementedTrips: DataSourceStatus{Loaded: len(ds.SupplementedTrips) > 0, Count: len(ds.SupplementedTrips)},
+		StopTimes:         ds.StopTimes != nil,
+		Upstreams:         upstreamCheckResults(),
+	}
+	if !startup.complete() {
+		st.Reasons = append(st.Reasons, "static data still loading")
+	}
+	if !st.Stations.Loaded {
+		st.Reasons = append(st.Reasons, "no stations loaded")
+	}
+	if !st.Trips.Loaded {
+		st.Reasons = append(st.Reasons, "no trips loaded")
+	}
+	if st.Draining {
+		st.Reasons = append(st.Reasons, "draining")
+	}
+
+	feeds, reachable := feedHealthSnapshot(feedURLs, now)
+	if !reachable && len(st.Reasons) == 0 {
+		for _, u := range feedURLs {
+			if _, err := fetchGTFS(u); err == nil {
+				break
+			}
+		}
+		feeds, reachable = feedHealthSnapshot(feedURLs, now)
+	}
+	st.Feeds = feeds
+	if !reachable {
+		st.Reasons = append(st.Reasons, "no realtime feed reachable")
+	}
+	st.Ready = len(st.Reasons) == 0
+	return st
+}
+
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	st := readiness(time.Now())
+	code := http.StatusOK
+	if !st.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeProbe(w, code, st)
+}
+
+// writeProbe writes an uncacheable JSON probe response
+func writeProbe(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// draining is set once shutdown has been requested
+var draining int32
+
+func isDraining() bool { return atomic.LoadInt32(&draining) == 1 }
+
+var (
+	quitOnce     sync.Once
+	quitCh       = make(chan struct{})
+	shutdownDone = make(chan struct{}) // closed once the server has shut down
+)
+
+// requestShutdown starts draining; safe to call more than once
+func requestShutdown(reason string) {
+	quitOnce.Do(func() {
+		log.Printf("Shutdown requested (%s), draining", reason)
+		atomic.StoreInt32(&draining, 1)
+		notifySystemd("STOPPING=1")
+		close(quitCh)
+	})
+}
+
+func handleQuit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	requestShutdown("quitquitquit")
+}
+
+// withLifecycle refuses API requests until startup completes and closes keep-alive
+// connections while draining so clients move to another instance
+func withLifecycle(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isDraining() {
+			w.Header().Set("Connection", "close")
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/") && !startup.complete() {
+			w.Header().Set("Retry-After", "5")
+			httpError(w, http.StatusServiceUnavailable, "starting up: static data still loading")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serveUntilShutdown serves srv on listeners until SIGTERM/SIGINT or /quitquitquit, then
+// shuts it down gracefully, waiting up to grace for in-flight requests
+func serveUntilShutdown(srv *http.Server, listeners []net.Listener, grace time.Duration) {
+	defer close(shutdownDone)
+	errCh := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln net.Listener) { errCh <- srv.Serve(ln) }(ln)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-sigCh
+		requestShutdown(sig.String())
+	}()
+
+	select {
+	case err := <-errCh:
+		log.Panic(err)
+	case <-quitCh:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Graceful shutdown incomplete after %s: %v", grace, err)
+		return
+	}
+	log.Printf("Shutdown complete")
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStartupProbeGatesAPI(t *testing.T) {
+	original := startup
+	startup = &startupProgress{}
+	defer func() { startup = original }()
+	mux := newMux()
+
+	startup.begin("stations", "trips")
+	startup.mark("stations", nil)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/startupz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 while loading, got %d", w.Code)
+	}
+	var st StartupStatus
+	if err := json.NewDecoder(w.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Complete || st.Steps["stations"] != stepOK || st.Steps["trips"] != stepPending {
+		t.Errorf("unexpected progress %+v", st)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/stops", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected API to be refused during startup, got %d", w.Code)
+	}
+
+	// A failed optional step still lets startup complete
+	startup.mark("trips", errTest)
+	startup.finish()
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/startupz", nil))
+	st = StartupStatus{}
+	json.NewDecoder(w.Body).Decode(&st)
+	if w.Code != http.StatusOK || !st.Complete || st.Steps["trips"] != stepFailed {
+		t.Errorf("expected complete startup with failed trips, got %d %+v", w.Code, st)
+	}
+}
+
+var errTest = errors.New("test failure")
+
+func TestHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	newMux().ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	var body map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || body["status"] != "ok" {
+		t.Errorf("expected 200 ok, got %d %v", w.Code, body)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := newTestFeedServer(t)
+	mux := newMux()
+	readyz := func() (int, ReadyStatus) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+		var st ReadyStatus
+		if err := json.NewDecoder(w.Body).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, st
+	}
+
+	setTestStations(nil)
+	setTestTrips(nil)
+	useTestFeeds(t, down.URL)
+	code, st := readyz()
+	if code != http.StatusServiceUnavailable || st.Ready || len(st.Reasons) != 3 {
+		t.Fatalf("expected 503 without static data, got %d %+v", code, st)
+	}
+
+	setTestStations([]Station{{StopID: "635", Name: "14 St - Union Sq"}})
+	setTestTrips([]Trip{{}})
+	code, st = readyz()
+	if code != http.StatusServiceUnavailable || st.Feeds[down.URL].Reachable || st.Feeds[down.URL].LastError == "" {
+		t.Fatalf("expected 503 with the feed error reported, got %d %+v", code, st)
+	}
+
+	useTestFeeds(t, down.URL, up.URL)
+	code, st = readyz()
+	if code != http.StatusOK || !st.Ready || !st.Feeds[up.URL].Reachable || st.Stations.Count != 1 {
+		t.Fatalf("expected ready once a feed answers, got %d %+v", code, st)
+	}
+
+	atomic.StoreInt32(&draining, 1)
+	defer atomic.StoreInt32(&draining, 0)
+	if code, st = readyz(); code != http.StatusServiceUnavailable || !st.Draining {
+		t.Errorf("expected 503 while draining, got %d %+v", code, st)
+	}
+}
+
+func TestQuitQuitQuit(t *testing.T) {
+	originalToken := adminToken
+	adminToken = "secret"
+	t.Cleanup(func() {
+		adminToken = originalToken
+		quitOnce, quitCh = sync.Once{}, make(chan struct{})
+		atomic.StoreInt32(&draining, 0)
+	})
+	mux := newMux()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/quitquitquit", nil))
+	if w.Code != http.StatusUnauthorized || isDraining() {
+		t.Fatalf("expected 401 without token and no draining, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("POST", "/quitquitquit", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", w.Code)
+	}
+	select {
+	case <-quitCh:
+	default:
+		t.Fatal("quit channel not closed")
+	}
+
+	// Draining instances ask clients to drop keep-alive connections
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/startupz", nil))
+	if w.Header().Get("Connection") != "close" {
+		t.Error("expected Connection: close while draining")
+	}
+}
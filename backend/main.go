@@ -1,19 +1,61 @@
 // Minimal NYC Subway departures backend with extra logging
 // - Endpoints:
-//   GET /api/stops
+//   GET /api   (machine-readable index of endpoints, API version and enabled features, see index.go)
+//   GET /playground   (HTML forms for trying every GET endpoint from a browser, see playground.go)
+//   GET /api/stops   (merged station complexes; ?view=raw for one row per GTFS stop)
+//   GET /api/routes   (route names and colors from routes.txt)
+//   GET /api/routes/{id}/stations   (stations in calling order, per direction)
+//   GET /api/routes/{id}/shape   (encoded polylines or GeoJSON from shapes.txt)
+//   GET /api/alerts?route=<id>&stop_id=<id>   (active service alerts, see alerts.go)
+//   GET /api/feeds, /api/feeds/{name}   (raw GTFS-RT protobuf through the feed cache, see feeds.go)
+//   GET /api/feeds/status   (per-feed last success, header timestamp, failures and cache age, see feedstatus.go)
 //   GET /api/departures/nearest?lat=<lat>&lon=<lon>
+//   GET /api/departures/nearest?place=<gazetteer id>
+//   GET /api/departures/nearest?address=<address>   (geocoded, see geocode.go)
+//   GET /api/departures/nearest?lat=<lat>&lon=<lon>&count=<n>   (N closest stations)
+//   GET /api/departures/nearest?lat=<lat>&lon=<lon>&catchable=true   (only trains reachable on foot, with leave_in_seconds)
+//   GET /api/departures/nearest?lat=<lat>&lon=<lon>&sort=total_time   (station where the rider boards soonest, walk plus wait)
+//   GET /api/departures/nearest?lat=<lat>&lon=<lon>&ada=true   (accessible stations only, also on nearest-multi, see accessible.go)
+//   GET /api/departures/nearest?lat=<lat>&lon=<lon>&mode=bike   (walk, bike or drive time to the station, see travelmode.go)
+//   GET /api/departures/nearest?lat=<lat>&lon=<lon>&geometry=true   (walk route polyline and turn-by-turn steps, also on nearest-multi)
 //   GET /api/departures/by-id?id=<stop id>
+//   GET /api/departures/by-name?name=<name>&route=<id>&borough=<code>   (see byname.go)
+//   GET /api/departures/bulk?ids=<stop id>,<stop id>,...   (several stations, see bulk.go)
+//   (nearest, by-id, by-name and bulk answer in protobuf with Accept: application/x-protobuf, see protobuf.go)
+//   (every JSON endpoint answers in MessagePack with Accept: application/msgpack or format=msgpack, see msgpack.go)
+//   (departure endpoints answer in CSV or TSV with format=csv or format=tsv, see table.go)
+//   (endpoints and fields listed in the deprecations config carry Deprecation/Sunset headers and meta.deprecations, see deprecations.go)
+//   GET /api/departures/any?ids=<stop id>,<stop id>&lat=<lat>&lon=<lon>   (merged, see bulk.go)
+//   GET /api/departures/stream?id=<stop id>   (Server-Sent Events on every feed refresh, see stream.go)
+//   (nearest, by-id, by-name, bulk, any and stream accept routes, direction, limit and horizon filters, see filters.go)
+//   (nearest, nearest-multi, by-id, by-name, bulk and any accept fields=<key>,... to trim departures, see fields.go)
+//   GET /api/departures/nearest-multi?lat=<lat>&lon=<lon>&count=<n>
+//   GET /api/corridor?stops=<stop id>,<stop id>,...&direction=N|S   (train-by-stop matrix along a line, see corridor.go)
+//   GET /ws   (WebSocket: subscribe to stations, receive departures and alerts, see ws.go)
+//   GET /api/stations/poster?id=<stop id>   (printable PDF with a QR link to the live board, see poster.go)
+//   GET|POST|DELETE /api/closures (station closure overrides, see closures.go)
+//   GET|POST|DELETE /api/geofences (per-client station pinning for nearest, see geofences.go)
+//   GET /api/geocode?q=<address>   (address to lat/lon in NYC, see geocode.go)
+//   GET /startupz, /healthz, /readyz, POST /quitquitquit (orchestrator probes and draining, see lifecycle.go)
+//   GET /admin/snapshot (state snapshot for warm starts with -snapshot, see snapshot.go)
+//   GET /metrics (per-endpoint SLO burn rates, see slo.go; per-station data age, see freshness.go)
+//   GET /api/stats/heatmap   (GeoJSON of per-station requests and ridership, see heatmap.go)
+//   GET /api/stats/reconciliation?route=<id>   (scheduled vs realtime trips per route, see reconcile.go)
 //
 // Build/run:
 //   go mod init nyc-subway
 //   go get github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs
 //   go get google.golang.org/protobuf/proto
 //   go run backend/main.go
+//   CONFIG_FILE=config.json go run ./backend            # optional JSON config, see config.go
+//   go run ./backend check-config config.json          # validate a config file and exit
+//   go run ./backend doctor config.json                # check every upstream's format, see doctor.go
 //
 // Data sources used at runtime (no API keys):
 // - Real-time GTFS-RT feeds (9 endpoints): https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs[-suffix]
 //   e.g., .../nyct%2Fgtfs, -ace, -bdfm, -g, -jz, -l, -nqrw, -7, -si
-// - Stations list (with GTFS Stop ID, lat/lon): https://data.ny.gov/api/views/39hk-dx4f/rows.csv?accessType=DOWNLOAD
+// - Stations list: stops.txt in the static GTFS zip (see stations.go), with
+//   https://data.ny.gov/api/views/39hk-dx4f/rows.csv?accessType=DOWNLOAD as failover/override
 // - Walking time: OSRM demo: https://router.project-osrm.org/route/v1/foot/{lon1},{lat1};{lon2},{lat2}?overview=false
 //
 // NOTES:
@@ -25,10 +67,13 @@ package main
 
 import (
 	"archive/zip"
+	"bytes"
 	"context"
+	_ "embed" // data/stations.csv fallback snapshot
 	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -38,6 +83,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bluele/gcache"
@@ -46,33 +92,117 @@ import (
 )
 
 type Station struct {
-	StopID string   `json:"gtfs_stop_id"`
-	Name   string   `json:"stop_name"`
-	Lat    float64  `json:"lat"`
-	Lon    float64  `json:"lon"`
-	Routes []string `json:"routes,omitempty"` // Routes serving this station (e.g., ["N", "W"])
+	StopID       string   `json:"gtfs_stop_id"`
+	Name         string   `json:"stop_name"`
+	OfficialName string   `json:"official_name,omitempty"` // GTFS stops.txt name
+	DisplayName  string   `json:"display_name,omitempty"`  // name chosen by station_name_source
+	Lat          float64  `json:"lat"`
+	Lon          float64  `json:"lon"`
+	Routes       []string `json:"routes,omitempty"` // Routes serving this station (e.g., ["N", "W"])
+	ComplexID    string   `json:"complex_id,omitempty"` // stations CSV complex, shared by linked platforms
+	Borough      string   `json:"borough,omitempty"`    // M, Bk, Q, Bx or SI
+	NorthLabel   string   `json:"north_direction_label,omitempty"` // Stations.csv name for trains leaving northbound, e.g. "Manhattan"
+	SouthLabel   string   `json:"south_direction_label,omitempty"`
+	Platforms    []Platform `json:"platforms,omitempty"` // per-direction platform locations, see platforms.go
+	ADA          int      `json:"ada,omitempty"` // Stations.csv: 1 fully or 2 partially accessible, see accessible.go
+	ADANorthbound bool    `json:"ada_northbound,omitempty"` // partially accessible: which platforms
+	ADASouthbound bool    `json:"ada_southbound,omitempty"`
 }
 
 type NearestResponse struct {
-	Station    Station     `json:"station"`
-	Walking    *WalkResult `json:"walking,omitempty"`
-	Departures []Departure `json:"departures"`
+	Station    Station        `json:"station"`
+	Photos     []StationPhoto `json:"photos,omitempty"`
+	Closure    *Closure       `json:"closure,omitempty"` // set when the station is closed by an operator override
+	PinnedBy   *Geofence      `json:"pinned_by,omitempty"` // the client geofence that chose this station
+	Walking    *WalkResult    `json:"walking,omitempty"`
+	Entrance   *Entrance      `json:"entrance,omitempty"` // the entrance the walk ends at, see entrances.go
+	Transfers  []Transfer     `json:"transfers,omitempty"` // other platforms in the station complex
+	Alerts     []ServiceAlert `json:"alerts,omitempty"`    // active service alerts affecting the station
+	Cancellations []Cancellation `json:"cancellations,omitempty"` // trips due here that were canceled or rerouted, see cancellations.go
+	Partial    bool           `json:"partial,omitempty"`   // some feeds failed; see warnings
+	Warnings   []string       `json:"warnings,omitempty"`  // e.g. "C/E data unavailable"
+	Departures []Departure    `json:"departures"`
+	SuggestedRefreshSeconds int `json:"suggested_refresh_seconds,omitempty"` // when to poll again, see refresh.go
+	Meta       *ResponseMeta  `json:"meta,omitempty"` // feed timestamps and data age, see meta.go
+}
+
+// RankedStation is one candidate in a multi-station response
+type RankedStation struct {
+	NearestResponse
+	DistanceMeters float64 `json:"distance_meters"`
+	// TotalSeconds is the door-to-train time: walking time plus the wait for the first
+	// departure the rider can still catch. Nil when no catchable departure is known.
+	TotalSeconds *int64 `json:"total_seconds,omitempty"`
+	// err is the departures error, kept so single-station responses can reuse the result
+	err error
+}
+
+// MultiNearestResponse lists nearby stations ranked by door-to-train time
+type MultiNearestResponse struct {
+	Stations []RankedStation `json:"stations"`
+	SuggestedRefreshSeconds int `json:"suggested_refresh_seconds"`
+	Meta     *ResponseMeta   `json:"meta,omitempty"`
+}
+
+// StationPhoto is a picture of a station entrance (NY Open Data / Wikimedia Commons)
+type StationPhoto struct {
+	URL         string `json:"url"`
+	Caption     string `json:"caption,omitempty"`
+	Attribution string `json:"attribution,omitempty"`
 }
 
 type Departure struct {
 	RouteID    string `json:"route_id"`
 	StopID     string `json:"stop_id"`
-	Direction  string `json:"direction"` // last letter of stop_id (N/S/E/W) if present
+	Direction  string `json:"direction"` // N or S (E/W letters are normalized per route), empty if unknown
+	DirectionLabel string `json:"direction_label,omitempty"` // rider-facing direction from Stations.csv, e.g. "Manhattan & Queens"
 	UnixTime   int64  `json:"unix_time"`
 	ETASeconds int64  `json:"eta_seconds"`
 	TripID     string `json:"trip_id,omitempty"`
 	HeadSign   string `json:"headsign,omitempty"`
+	ShortTurned bool  `json:"short_turned,omitempty"` // train ends before its scheduled terminal
+	Confidence string `json:"confidence"` // high, medium or low, see confidence.go
+	Occupancy  string `json:"occupancy,omitempty"` // crowding from the feed's vehicle positions, see occupancy.go
+	CarCount   int    `json:"car_count,omitempty"` // train length where known, see carcount.go
+	LeaveInSeconds *int64 `json:"leave_in_seconds,omitempty"` // with catchable=true: time left before walking out the door
+	Annotations []string `json:"annotations,omitempty"` // operator notes from annotations_file, see annotations.go
+	ETAClamped bool `json:"eta_clamped,omitempty"` // the feed's ETA was implausibly soon and was raised, see etafloor.go
+	DelaySeconds *int32 `json:"delay_seconds,omitempty"` // behind schedule (negative: early), when the feed says, see delay.go
+	UncertaintySeconds *int32 `json:"uncertainty_seconds,omitempty"` // expected error of the prediction, when the feed says
+	ScheduledTrack string `json:"scheduled_track,omitempty"` // from the NYCT feed extensions, see nyct.go
+	ActualTrack string `json:"actual_track,omitempty"` // where the train is actually routed, once known
+	IsAssigned *bool `json:"is_assigned,omitempty"` // a train is assigned to the trip; false means only planned
+	StopsAway *int `json:"stops_away,omitempty"` // stops the train has left to serve before this one, see stopsaway.go
+	CurrentStatus string `json:"current_status,omitempty"` // stopped_at, incoming_at or in_transit_to current_stop
+	CurrentStop string `json:"current_stop,omitempty"` // name of the stop the train is at or heading to
+	Stale bool `json:"stale,omitempty"` // from a feed older than stale_feed_threshold, see stale.go
 	LastStop   string `json:"-"` // Last stop name, not serialized to JSON
+	LastStopID string `json:"-"` // Last stop ID in the realtime trip (its actual terminal)
 }
 
 type WalkResult struct {
-	Seconds  float64 `json:"seconds"`
-	Distance float64 `json:"meters"`
+	Seconds  float64    `json:"seconds"`
+	Distance float64    `json:"meters"`
+	Geometry string     `json:"geometry,omitempty"` // encoded polyline (precision 5), only with geometry=true (or directions=true)
+	Steps    []WalkStep `json:"steps,omitempty"`    // turn-by-turn steps, only with geometry=true (or directions=true)
+	Mode     string     `json:"mode,omitempty"`     // bike or drive with mode=, see travelmode.go
+	Estimated bool      `json:"estimated,omitempty"` // straight-line estimate, OSRM failed (see walkestimate.go)
+}
+
+// Place is a named walking origin from the gazetteer (hospital lobby, campus gate, venue)
+type Place struct {
+	ID   string  `json:"id"`
+	Name string  `json:"name"`
+	Lat  float64 `json:"lat"`
+	Lon  float64 `json:"lon"`
+}
+
+// WalkStep is a single turn-by-turn instruction from OSRM
+type WalkStep struct {
+	Instruction string  `json:"instruction"`
+	Name        string  `json:"name,omitempty"` // street name
+	Seconds     float64 `json:"seconds"`
+	Distance    float64 `json:"meters"`
 }
 
 type Trip struct {
@@ -81,20 +211,20 @@ type Trip struct {
 	ServiceID   string
 	TripHeadsign string
 	DirectionID string
+	ShapeID     string // optional shape_id column
 }
 
 
 var (
-	stations   []Station
-	trips           []Trip
-	supplementedTrips []Trip
+	stationPhotos   map[string][]StationPhoto // keyed by parent stop ID
+	places          map[string]Place          // gazetteer keyed by lowercase place ID
 	httpClient      = &http.Client{Timeout: 12 * time.Second}
+	osrmBaseURL     = "https://router.project-osrm.org"
+	// nowFunc is the clock used for ETAs and service-day selection (replaced in replay tests)
+	nowFunc = time.Now
 	walkCache       gcache.Cache
 	stopsCache      gcache.Cache
 	transitFeedCache gcache.Cache
-	// NYC area bounding box (coarse)
-	minLat, maxLat = 40.3, 41.1
-	minLon, maxLon = -74.5, -73.3
 
 	// Feeds: base + ACE, BDFM, G, JZ, L, NQRW, 7, SI
 	feedURLs = []string{
@@ -147,27 +277,54 @@ var (
 		"SIR": "https://api-endpoint.mta.info/Dataservice/mtagtfsfeeds/nyct%2Fgtfs-si",
 	}
 
-	// Default stations CSV from NY Open Data (no token needed)
-	stationsCSV = "https://data.ny.gov/api/views/39hk-dx4f/rows.csv?accessType=DOWNLOAD"
+	// Stations CSV from NY Open Data (no token needed): a failover source, and an override
+	// on the GTFS stations when set to anything else
+	stationsCSV = defaultStationsCSV
 	// MTA Stations.csv with route information
 	mtaStationsCSV = "http://web.mta.info/developers/data/nyct/subway/Stations.csv"
 	gtfsZipURL = "http://web.mta.info/developers/data/nyct/subway/google_transit.zip"
 	// Supplemented GTFS with additional headsign information
 	supplementedGTFSURL = "https://rrgtfsfeeds.s3.amazonaws.com/gtfs_supplemented.zip"
+	// Optional station entrance photos CSV (columns: GTFS Stop ID, Image URL, Caption, Attribution)
+	stationPhotosCSV = ""
+	// Optional gazetteer of named places usable as place=<id> on nearest (columns: ID, Name, Latitude, Longitude)
+	placesCSV = ""
 )
 
 func main() {
 	// Enable line numbers in logging with microsecond granularity
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile)
+
+	if len(os.Args) > 1 && os.Args[1] == "check-config" {
+		os.Exit(runCheckConfig(os.Args[2:], os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check-upstreams" {
+		os.Exit(runCheckUpstreams(os.Args[2:], os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		os.Exit(runDoctor(os.Args[2:], os.Stdout))
+	}
+
+	snapshotSrc := flag.String("snapshot", "", "warm-start from a state snapshot (file or URL) taken via /admin/snapshot")
+	flag.Parse()
+
+	cfg, err := loadConfig(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	applyConfig(cfg)
+	if err := configureUpstreamTLS(cfg.TLSCABundle, cfg.TLSPins); err != nil {
+		log.Fatalf("%v", err)
+	}
 	
 	// Initialize walking time cache: 24h TTL, max 10,000 entries with LRU eviction
 	walkCache = gcache.New(10000).
 		LRU().
-		Expiration(24 * time.Hour).
+		Expiration(cfg.WalkCacheTTL.orDefault(24 * time.Hour)).
 		Build()
 	
-	// Initialize stops cache: 24h TTL, stores the JSON response
-	stopsCache = gcache.New(1).
+	// Initialize stops cache: 24h TTL, stores the JSON response per view
+	stopsCache = gcache.New(2).
 		LRU().
 		Expiration(24 * time.Hour).
 		Build()
@@ -175,71 +332,256 @@ func main() {
 	// Initialize transit feed cache: 30 second TTL for real-time transit data
 	transitFeedCache = gcache.New(20).
 		LRU().
-		Expiration(30 * time.Second).
+		Expiration(cfg.FeedCacheTTL.orDefault(30 * time.Second)).
 		Build()
-	
-	
+
+	// Every env and config value is in place before the listeners start, since handlers
+	// (requireAdmin among them) read them without locking
 	if v := os.Getenv("STATIONS_CSV"); v != "" {
 		stationsCSV = v
 	}
-	if err := loadStations(context.Background(), stationsCSV); err != nil {
-		log.Panic(err)
+	if v := os.Getenv("STATION_PHOTOS_CSV"); v != "" {
+		stationPhotosCSV = v
 	}
-
-	// Log full list of stations as requested
-	log.Printf("Loaded %d stations", len(stations))
-
-	if err := loadTrips(context.Background(), gtfsZipURL); err != nil {
-		log.Printf("Warning: failed to load GTFS trips data: %v", err)
-	} else {
-		log.Printf("Loaded %d trips", len(trips))
+	if v := os.Getenv("PLACES_CSV"); v != "" {
+		placesCSV = v
+	}
+	if v := os.Getenv("MTA_API_KEY"); v != "" {
+		mtaAPIKey = v
+	}
+	if v := os.Getenv("ADMIN_TOKEN"); v != "" {
+		adminToken = v
+	}
+	if cfg.ClosuresFile != "" {
+		if err := closures.load(cfg.ClosuresFile); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if cfg.GeofencesFile != "" {
+		if err := geofences.load(cfg.GeofencesFile); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if cfg.AnnotationsFile != "" {
+		if err := annotations.load(cfg.AnnotationsFile); err != nil {
+			log.Fatalf("%v", err)
+		}
 	}
 
-	// Load supplemented GTFS trips with additional headsigns
 	supplementedURL := supplementedGTFSURL
 	if v := os.Getenv("SUPPLEMENTED_GTFS_URL"); v != "" {
 		supplementedURL = v
 	}
-	if suppTrips, err := loadSupplementedTrips(context.Background(), supplementedURL); err != nil {
-		log.Printf("Warning: failed to load supplemented GTFS trips data: %v", err)
-	} else {
-		supplementedTrips = suppTrips
-		log.Printf("Loaded %d supplemented trips", len(supplementedTrips))
-	}
-
-	// Start background refresh for supplemented GTFS data (every 30 minutes)
-	go func() {
-		ticker := time.NewTicker(30 * time.Minute)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				log.Printf("Refreshing supplemented GTFS data...")
-				if suppTrips, err := loadSupplementedTrips(context.Background(), supplementedURL); err != nil {
-					log.Printf("Warning: failed to refresh supplemented GTFS trips data: %v", err)
-				} else {
-					supplementedTrips = suppTrips
-					log.Printf("Refreshed %d supplemented trips", len(supplementedTrips))
-				}
+	if cfg.PollDemand.enabled() || cfg.PollInterval > 0 {
+		shadowMode = cfg.ShadowMode
+	} else if cfg.ShadowMode {
+		log.Printf("Warning: shadow_mode requires poll_interval or poll_demand; shadow comparisons disabled")
+	}
+	if g, err := newGeocoder(cfg.Geocoder); err != nil {
+		log.Printf("Warning: %v; using %s", err, defaultNominatimURL)
+	} else if g != nil {
+		addressGeocoder = g
+	}
+
+	// Listen right away so the startup probe can report loading progress
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = cfg.Port
+	}
+	if port == "" {
+		port = "8080"
+	}
+	addrs := cfg.Listen
+	if len(addrs) == 0 {
+		addrs = []string{":" + port}
+	}
+	listeners, err := listenAll(addrs)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	startup.begin("stations", "trips", "supplemented_trips")
+	srv := &http.Server{Handler: newMux()}
+	for _, ln := range listeners {
+		log.Printf("Listening on %s (%s)", ln.Addr(), ln.Addr().Network())
+	}
+	go serveUntilShutdown(srv, listeners, cfg.ShutdownGracePeriod.orDefault(15*time.Second))
+
+	// Name any unreachable upstream before the downloads that need it fail
+	logUpstreamChecks(context.Background())
+
+	// A snapshot from the instance being replaced skips the static downloads entirely
+	warm := false
+	if *snapshotSrc != "" {
+		if err := loadSnapshot(context.Background(), *snapshotSrc); err != nil {
+			log.Printf("Warning: failed to load snapshot, loading from sources instead: %v", err)
+		} else {
+			warm = true
+			for _, step := range []string{"stations", "trips", "supplemented_trips"} {
+				startup.mark(step, nil)
 			}
 		}
-	}()
+	}
+	if !warm {
+		loadStaticData(supplementedURL)
+	}
 
+	// Keep the supplemented GTFS current (see supplemented.go)
+	if supplementedEnabled() {
+		go runSupplementedRefresh(context.Background(), supplementedURL, cfg.SupplementedRefreshInterval.orDefault(30*time.Minute))
+	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/stops", withCORS(handleStops))
-	mux.HandleFunc("/api/departures/nearest", withCORS(handleNearest))
-	mux.HandleFunc("/api/departures/by-id", withCORS(handleByID))
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	if cfg.PollDemand.enabled() {
+		startDemandPoller(context.Background(), feedURLs, cfg.PollDemand)
+	} else if cfg.PollInterval > 0 {
+		startFeedPoller(context.Background(), feedURLs, time.Duration(cfg.PollInterval))
+	}
+
+	if len(cfg.Digests) > 0 {
+		n, err := newNotifier(cfg.Notifier)
+		switch {
+		case err != nil:
+			log.Printf("Warning: %v; digests disabled", err)
+		case n == nil:
+			log.Printf("Warning: digests require a notifier; digests disabled")
+		case alertsFeedURL == "":
+			log.Printf("Warning: digests require alerts_feed_url; digests disabled")
+		default:
+			startDigests(context.Background(), cfg.Digests, n)
+		}
+	}
+
+	startup.finish()
+	log.Printf("Startup complete")
+	notifySystemd("READY=1")
+	startWatchdog(context.Background())
+
+	// Run until SIGTERM or /quitquitquit has drained in-flight requests
+	<-shutdownDone
+}
+
+// loadStaticData downloads stations, trips and the supplemented headsigns, recording
+// progress for the startup probe. Missing stations are fatal; the rest is best-effort.
+func loadStaticData(supplementedURL string) {
+	// One download of the static GTFS zip serves both stations and trips
+	zf, zipErr := openGTFSZip(context.Background(), gtfsZipURL)
+	if zipErr != nil {
+		log.Printf("Warning: failed to download GTFS zip: %v", zipErr)
+	} else {
+		defer zf.Close()
+		// Every stop ID lookup from here on goes through the parent stations (see stopids.go)
+		if err := loadStopParents(zf); err != nil {
+			log.Printf("Warning: failed to load parent stations from stops.txt: %v", err)
+		}
+	}
+
+	sources := appConfig.StationsSources
+	if len(sources) == 0 {
+		sources = stationsSourceChain()
 	}
-	addr := ":" + port
-	log.Printf("Listening on %s", addr)
-	if err := http.ListenAndServe(addr, mux); err != nil {
+	if err := loadStationsWithFailover(context.Background(), sources, zf); err != nil {
 		log.Panic(err)
 	}
+	startup.mark("stations", nil)
+
+	// Log full list of stations as requested
+	log.Printf("Loaded %d stations", len(data().Stations))
+
+	if stationPhotosCSV != "" {
+		if err := loadStationPhotos(context.Background(), stationPhotosCSV); err != nil {
+			log.Printf("Warning: failed to load station photos: %v", err)
+		}
+	}
+
+	if appConfig.StationTranslationsCSV != "" {
+		if err := loadStationTranslations(context.Background(), appConfig.StationTranslationsCSV); err != nil {
+			log.Printf("Warning: failed to load station translations: %v", err)
+		}
+	}
+
+	if placesCSV != "" {
+		if err := loadPlaces(context.Background(), placesCSV); err != nil {
+			log.Printf("Warning: failed to load places: %v", err)
+		}
+	}
+
+	if appConfig.EntrancesCSV != "" {
+		if err := loadEntrances(context.Background(), appConfig.EntrancesCSV); err != nil {
+			log.Printf("Warning: failed to load station entrances: %v", err)
+		}
+	}
+
+	if appConfig.RidershipCSV != "" {
+		if err := loadRidership(context.Background(), appConfig.RidershipCSV); err != nil {
+			log.Printf("Warning: failed to load ridership: %v", err)
+		}
+	}
+
+	tripsErr := fmt.Errorf("download GTFS zip: %w", zipErr)
+	if zf != nil {
+		tripsErr = loadTripsFromZip(zf)
+	}
+	if tripsErr != nil {
+		log.Printf("Warning: failed to load GTFS trips data: %v", tripsErr)
+	} else {
+		log.Printf("Loaded %d trips", len(data().Trips))
+	}
+	startup.mark("trips", tripsErr)
+
+	// Load supplemented GTFS trips with additional headsigns
+	if !supplementedEnabled() {
+		log.Printf("Supplemented GTFS disabled by headsign_precedence")
+		startup.mark("supplemented_trips", nil)
+		return
+	}
+	suppErr := refreshSupplemented(context.Background(), supplementedURL)
+	if suppErr != nil {
+		log.Printf("Warning: failed to load supplemented GTFS trips data: %v", suppErr)
+	} else {
+		log.Printf("Loaded %d supplemented trips", len(data().SupplementedTrips))
+	}
+	startup.mark("supplemented_trips", suppErr)
+}
+
+// newMux registers every API route
+func newMux() http.Handler {
+	return withClientIP(withLifecycle(withSLO(withMsgpack(withTable(withDeprecations(withStationLanguage(newRoutes())))))))
+}
+
+// newRoutes registers every endpoint, without the lifecycle and SLO middleware
+func newRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api", withCORS(handleIndex))
+	mux.HandleFunc("/playground", handlePlayground)
+	mux.HandleFunc("/api/stops", withCORS(handleStops))
+	mux.HandleFunc("/api/routes", withCORS(handleRoutes))
+	mux.HandleFunc("/api/routes/", withCORS(handleRouteResource))
+	mux.HandleFunc("/api/alerts", withCORS(handleAlerts))
+	mux.HandleFunc("/api/feeds", withCORS(handleFeeds))
+	mux.HandleFunc("/api/feeds/", withCORS(handleFeeds))
+	mux.HandleFunc("/api/feeds/status", withCORS(handleFeedStatus))
+	mux.HandleFunc("/api/departures/nearest", withCORS(withFields(handleNearest)))
+	mux.HandleFunc("/api/departures/by-id", withCORS(withFields(handleByID)))
+	mux.HandleFunc("/api/departures/by-name", withCORS(withFields(handleByName)))
+	mux.HandleFunc("/api/departures/bulk", withCORS(withFields(handleBulk)))
+	mux.HandleFunc("/api/departures/any", withCORS(withFields(handleAny)))
+	mux.HandleFunc("/api/departures/stream", withCORS(handleStream))
+	mux.HandleFunc("/api/departures/nearest-multi", withCORS(withFields(handleNearestMulti)))
+	mux.HandleFunc("/api/corridor", withCORS(handleCorridor))
+	mux.HandleFunc("/api/stations/poster", withCORS(handlePoster))
+	mux.HandleFunc("/ws", handleWS)
+	mux.HandleFunc("/api/closures", withCORS(handleClosures))
+	mux.HandleFunc("/api/geofences", withCORS(handleGeofences))
+	mux.HandleFunc("/api/geocode", withCORS(handleGeocode))
+	mux.HandleFunc("/startupz", handleStartupz)
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz)
+	mux.HandleFunc("/quitquitquit", handleQuit)
+	mux.HandleFunc("/admin/snapshot", handleSnapshot)
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/api/stats/heatmap", withCORS(handleHeatmap))
+	mux.HandleFunc("/api/stats/reconciliation", withCORS(handleReconciliation))
+	return mux
 }
 
 func withCORS(h http.HandlerFunc) http.HandlerFunc {
@@ -257,8 +599,17 @@ func handleStops(w http.ResponseWriter, r *http.Request) {
 	var jsonData []byte
 	var cacheHit bool
 	
+	view := r.URL.Query().Get("view")
+	if view == "" {
+		view = stopsViewRider
+	}
+	if view != stopsViewRider && view != stopsViewRaw {
+		httpError(w, http.StatusBadRequest, "view must be rider or raw")
+		return
+	}
+
 	// Check cache first
-	const cacheKey = "stops"
+	cacheKey := "stops:" + view
 	if cached, err := stopsCache.Get(cacheKey); err == nil {
 		if data, ok := cached.([]byte); ok {
 			jsonData = data
@@ -270,7 +621,11 @@ func handleStops(w http.ResponseWriter, r *http.Request) {
 	// Generate JSON if not cached
 	if jsonData == nil {
 		var err error
-		jsonData, err = json.Marshal(stations)
+		if view == stopsViewRaw {
+			jsonData, err = json.Marshal(data().Stations)
+		} else {
+			jsonData, err = json.Marshal(mergeStations(data().Stations))
+		}
 		if err != nil {
 			httpError(w, http.StatusInternalServerError, "failed to marshal stations")
 			return
@@ -298,35 +653,316 @@ func handleStops(w http.ResponseWriter, r *http.Request) {
 func handleNearest(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	log.Printf("Request received: %s %s", r.Method, r.URL.String())
-	lat, lon, err := parseLatLon(r)
+	var lat, lon float64
+	if name := strings.TrimSpace(r.URL.Query().Get("place")); name != "" {
+		p, ok := lookupPlace(name)
+		if !ok {
+			httpError(w, http.StatusNotFound, "unknown place")
+			return
+		}
+		lat, lon = p.Lat, p.Lon
+		log.Printf("Resolved place %q to %s at (%.6f, %.6f)", name, p.Name, lat, lon)
+	} else if addr := strings.TrimSpace(r.URL.Query().Get("address")); addr != "" {
+		results, err := geocodeAddress(r.Context(), addr)
+		if err != nil {
+			geocodeError(w, err)
+			return
+		}
+		lat, lon = results[0].Lat, results[0].Lon
+		log.Printf("Resolved address %q to %s at (%.6f, %.6f)", addr, results[0].Label, lat, lon)
+	} else {
+		var err error
+		lat, lon, err = parseLatLon(r)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if outsideNYC(lat, lon) {
+		httpError(w, http.StatusBadRequest, "location outside NYC area")
+		return
+	}
+
+	directions := wantsRouteGeometry(r)
+	catchable := queryBool(r, "catchable")
+	filter, err := parseDepartureFilter(r)
 	if err != nil {
 		httpError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	if outsideNYC(lat, lon) {
-		httpError(w, http.StatusBadRequest, "location outside NYC area")
+	mode, err := parseTravelMode(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var keep func(Station) bool // ada=true: accessible stations only, see accessible.go
+	if queryBool(r, "ada") {
+		keep = accessibleFilter(filter.Direction)
+	}
+	order, err := parseStationSort(r, sortDistance)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// A client's geofence pins its station ahead of the closest one (see geofences.go)
+	var pinned *Station
+	var pinnedBy *Geofence
+	if s, fence, ok := geofences.pinned(strings.TrimSpace(r.URL.Query().Get("client")), lat, lon); ok && (keep == nil || keep(s)) {
+		log.Printf("Geofence %s pins %s [%s]", fence.ID, s.Name, s.StopID)
+		pinned, pinnedBy = &s, &fence
+	}
+
+	// count=N returns the N closest stations, each with its own walk and departures
+	if r.URL.Query().Get("count") != "" {
+		count, err := parseCount(r, 1)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		candidates := nearestStationsWhere(lat, lon, count, keep)
+		if pinned != nil {
+			candidates = withPinned(*pinned, candidates, count)
+		}
+		ranked := collectStations(lat, lon, candidates, mode, directions, filter)
+		if order == sortTotalTime {
+			if pinned != nil {
+				sortByDoorToTrain(ranked[1:])
+			} else {
+				sortByDoorToTrain(ranked)
+			}
+		}
+		if pinned != nil {
+			ranked[0].PinnedBy = pinnedBy
+		}
+		if catchable {
+			for i := range ranked {
+				toLat, toLon, _ := walkDestination(ranked[i].Station, filter.Direction, lat, lon)
+				ranked[i].Departures = catchableDepartures(ranked[i].Departures, walkSeconds(haversine(lat, lon, toLat, toLon), ranked[i].Walking))
+			}
+		}
+		writeJSON(w, MultiNearestResponse{Stations: ranked, SuggestedRefreshSeconds: rankedRefreshSeconds(ranked), Meta: departureMeta(filter, rankedStations(ranked)...)})
+		log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
 		return
 	}
 
 	nearest := nearestStation(lat, lon)
+	if keep != nil {
+		found := nearestStationsWhere(lat, lon, 1, keep)
+		if len(found) == 0 {
+			httpError(w, http.StatusNotFound, "no accessible station found")
+			return
+		}
+		nearest = found[0]
+	}
+	// The closest few stations, by how soon the rider is on a train; the winner's
+	// departures and walk are reused below
+	var best *RankedStation
+	if order == sortTotalTime && pinned == nil {
+		ranked := collectStations(lat, lon, nearestStationsWhere(lat, lon, defaultMultiCount, keep), mode, directions, filter)
+		sortByDoorToTrain(ranked)
+		if len(ranked) > 0 {
+			best = &ranked[0]
+			nearest = best.Station
+		}
+	}
 	log.Printf("Nearest station to (%.6f, %.6f) is %s [%s] at (%.6f, %.6f)",
 		lat, lon, nearest.Name, nearest.StopID, nearest.Lat, nearest.Lon)
+	if pinned != nil {
+		nearest = *pinned
+	}
 
-	deps, err := departuresForStation(nearest)
+	var deps []Departure
+	if best != nil {
+		deps, err = best.Departures, best.err
+	} else {
+		deps, err = departuresForStation(nearest, filter)
+	}
+	warnings, err := splitPartial(err)
 	if err != nil {
 		httpError(w, http.StatusBadGateway, err.Error())
 		return
 	}
 
-	walk, werr := walkingTime(lat, lon, nearest.Lat, nearest.Lon) // best-effort
-	if werr != nil {
-		log.Printf("walkingTime error: %v", werr)
+	toLat, toLon, entrance := walkDestination(nearest, filter.Direction, lat, lon)
+	var walk *WalkResult
+	if best != nil {
+		walk = best.Walking
+	} else {
+		walk = walkOrEstimate(mode, lat, lon, toLat, toLon, directions)
+	}
+	if catchable {
+		deps = catchableDepartures(deps, walkSeconds(haversine(lat, lon, toLat, toLon), walk))
+	}
+	resp := NearestResponse{Station: nearest, Photos: photosForStation(nearest), PinnedBy: pinnedBy, Walking: walk, Entrance: entrance, Transfers: transfersForStation(nearest), Alerts: alertsForStation(nearest), Cancellations: cancellationsForStation(nearest, filter, fetchGTFS), Partial: len(warnings) > 0, Warnings: warnings, Departures: deps, SuggestedRefreshSeconds: suggestedRefreshSeconds(deps), Meta: departureMeta(filter, nearest)}
+	writeResponse(w, r, resp)
+	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+}
+
+// Limits for multi-station queries
+const (
+	defaultMultiCount = 3
+	maxMultiCount     = 10
+)
+
+func handleNearestMulti(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	lat, lon, err := parseLatLon(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if outsideNYC(lat, lon) {
+		httpError(w, http.StatusBadRequest, "location outside NYC area")
+		return
+	}
+	count, err := parseCount(r, defaultMultiCount)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	mode, err := parseTravelMode(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var keep func(Station) bool
+	if queryBool(r, "ada") {
+		keep = accessibleFilter("")
+	}
+	order, err := parseStationSort(r, sortTotalTime)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ranked := collectStations(lat, lon, nearestStationsWhere(lat, lon, count, keep), mode, wantsRouteGeometry(r), departureFilter{})
+	if order == sortTotalTime {
+		sortByDoorToTrain(ranked)
 	}
-	resp := NearestResponse{Station: nearest, Walking: walk, Departures: deps}
-	writeJSON(w, resp)
+	writeJSON(w, MultiNearestResponse{Stations: ranked, SuggestedRefreshSeconds: rankedRefreshSeconds(ranked), Meta: departureMeta(departureFilter{}, rankedStations(ranked)...)})
 	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
 }
 
+// Station orders for the sort parameter
+const (
+	sortDistance  = "distance"
+	sortTotalTime = "total_time" // door-to-train time, see doorToTrainSeconds
+)
+
+// parseStationSort reads the optional sort parameter (distance or total_time)
+func parseStationSort(r *http.Request, def string) (string, error) {
+	switch v := strings.TrimSpace(r.URL.Query().Get("sort")); v {
+	case "":
+		return def, nil
+	case sortDistance, sortTotalTime:
+		return v, nil
+	default:
+		return "", fmt.Errorf("invalid sort %q (expected distance or total_time)", v)
+	}
+}
+
+// parseCount reads the optional count parameter (1..maxMultiCount)
+func parseCount(r *http.Request, def int) (int, error) {
+	v := r.URL.Query().Get("count")
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid count")
+	}
+	if n > maxMultiCount {
+		n = maxMultiCount
+	}
+	return n, nil
+}
+
+// collectStations fetches departures and travel times in mode for each candidate
+// concurrently, preserving the candidates' order
+func collectStations(lat, lon float64, candidates []Station, mode travelMode, directions bool, filter departureFilter) []RankedStation {
+	ranked := make([]RankedStation, len(candidates))
+	var wg sync.WaitGroup
+	for i, s := range candidates {
+		wg.Add(1)
+		go func(i int, s Station) {
+			defer wg.Done()
+			rs := RankedStation{
+				NearestResponse: NearestResponse{Station: s, Photos: photosForStation(s), Transfers: transfersForStation(s), Alerts: alertsForStation(s), Cancellations: cancellationsForStation(s, filter, fetchGTFS)},
+				DistanceMeters:  haversine(lat, lon, s.Lat, s.Lon),
+			}
+			deps, err := departuresForStation(s, filter)
+			if err != nil {
+				log.Printf("departuresForStation error for %s: %v", s.StopID, err)
+			}
+			rs.Departures, rs.err = deps, err
+			rs.Warnings = feedWarnings(err)
+			rs.Partial = len(rs.Warnings) > 0
+			toLat, toLon, entrance := walkDestination(s, filter.Direction, lat, lon)
+			walk := walkOrEstimate(mode, lat, lon, toLat, toLon, directions)
+			rs.Walking = walk
+			rs.Entrance = entrance
+			rs.TotalSeconds = doorToTrainSeconds(haversine(lat, lon, toLat, toLon), walk, deps)
+			ranked[i] = rs
+		}(i, s)
+	}
+	wg.Wait()
+	return ranked
+}
+
+// sortByDoorToTrain orders stations by door-to-train time. Stations without a catchable
+// departure sort last, by distance.
+func sortByDoorToTrain(ranked []RankedStation) {
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i].TotalSeconds, ranked[j].TotalSeconds
+		if a != nil && b != nil {
+			return *a < *b
+		}
+		if a != nil || b != nil {
+			return a != nil
+		}
+		return ranked[i].DistanceMeters < ranked[j].DistanceMeters
+	})
+}
+
+// walkSeconds is the walking time, or an estimate from the straight-line distance
+func walkSeconds(distance float64, walk *WalkResult) int64 {
+	if walk != nil {
+		return int64(math.Ceil(walk.Seconds))
+	}
+	return int64(math.Ceil(distance * walkGridFactor() / walkingSpeed()))
+}
+
+// doorToTrainSeconds is the walk time plus the wait for the first departure leaving after
+// the rider reaches the platform. Without an OSRM result the walk is estimated from distance.
+func doorToTrainSeconds(distance float64, walk *WalkResult, deps []Departure) *int64 {
+	walkSec := walkSeconds(distance, walk)
+	for _, d := range deps {
+		if d.ETASeconds >= walkSec {
+			total := d.ETASeconds // walk + wait on the platform
+			return &total
+		}
+	}
+	return nil
+}
+
+// catchableDepartures keeps the departures leaving once the rider can be on the platform,
+// annotating each with how long the rider can wait before setting off
+func catchableDepartures(deps []Departure, walkSec int64) []Departure {
+	out := make([]Departure, 0, len(deps))
+	for _, d := range deps {
+		if d.ETASeconds < walkSec {
+			continue
+		}
+		leave := d.ETASeconds - walkSec
+		d.LeaveInSeconds = &leave
+		out = append(out, d)
+	}
+	return out
+}
+
 func handleByID(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	log.Printf("Request received: %s %s", r.Method, r.URL.String())
@@ -335,12 +971,17 @@ func handleByID(w http.ResponseWriter, r *http.Request) {
 		httpError(w, http.StatusBadRequest, "missing id")
 		return
 	}
-	// Use baseStopID function to get base stop ID
-	baseID := baseStopID(id)
+	filter, err := parseDepartureFilter(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Use parentStopID function to get parent stop ID
+	baseID := parentStopID(id)
 	var matched []Station
-	for _, s := range stations {
-		// Match stations with the same base ID (ignoring N/S/E/W suffix)
-		if baseStopID(s.StopID) == baseID {
+	for _, s := range data().Stations {
+		// Match stations with the same parent ID (ignoring N/S/E/W suffix)
+		if parentStopID(s.StopID) == baseID {
 			matched = append(matched, s)
 		}
 	}
@@ -349,16 +990,32 @@ func handleByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Printf("handleByID matched %d station records for id %q", len(matched), id)
-	deps, err := departuresForStation(matched[0])
+	deps, err := departuresForStation(matched[0], filter)
+	warnings, err := splitPartial(err)
 	if err != nil {
 		httpError(w, http.StatusBadGateway, err.Error())
 		return
 	}
-	resp := NearestResponse{Station: matched[0], Departures: deps}
-	writeJSON(w, resp)
+	resp := NearestResponse{Station: matched[0], Photos: photosForStation(matched[0]), Transfers: transfersForStation(matched[0]), Alerts: alertsForStation(matched[0]), Cancellations: cancellationsForStation(matched[0], filter, fetchGTFS), Partial: len(warnings) > 0, Warnings: warnings, Departures: deps, SuggestedRefreshSeconds: suggestedRefreshSeconds(deps), Meta: departureMeta(filter, matched[0])}
+	if cl, closed := closures.active(matched[0].StopID, time.Now()); closed {
+		resp.Closure = &cl
+	}
+	writeResponse(w, r, resp)
 	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
 }
 
+// queryBool reports whether a query parameter is set to a true value ("true", "1", ...)
+func queryBool(r *http.Request, name string) bool {
+	b, _ := strconv.ParseBool(r.URL.Query().Get(name))
+	return b
+}
+
+// wantsRouteGeometry reports whether walks should carry their route polyline and steps:
+// geometry=true, or directions=true, its original name
+func wantsRouteGeometry(r *http.Request) bool {
+	return queryBool(r, "geometry") || queryBool(r, "directions")
+}
+
 func writeJSON(w http.ResponseWriter, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	// HTTP cache headers: Allow browsers to cache departure data for 30s (matching our server cache TTL).
@@ -376,14 +1033,13 @@ func httpError(w http.ResponseWriter, code int, msg string) {
 	_ = json.NewEncoder(w).Encode(map[string]any{"error": msg})
 }
 
-func outsideNYC(lat, lon float64) bool {
-	return lat < minLat || lat > maxLat || lon < minLon || lon > maxLon
-}
-
 func nearestStation(lat, lon float64) Station {
 	best := Station{}
 	bestD := math.MaxFloat64
-	for _, s := range stations {
+	for _, s := range data().Stations {
+		if isStationClosed(s) {
+			continue
+		}
 		d := haversine(lat, lon, s.Lat, s.Lon)
 		if d < bestD {
 			bestD = d
@@ -393,6 +1049,44 @@ func nearestStation(lat, lon float64) Station {
 	return best
 }
 
+// nearestStations returns up to n stations ordered by distance, skipping rows that share a
+// parent stop ID with a closer one
+func nearestStations(lat, lon float64, n int) []Station {
+	return nearestStationsWhere(lat, lon, n, nil)
+}
+
+// nearestStationsWhere is nearestStations over the stations keep accepts (nil for all)
+func nearestStationsWhere(lat, lon float64, n int, keep func(Station) bool) []Station {
+	type cand struct {
+		s Station
+		d float64
+	}
+	list := data().Stations
+	cands := make([]cand, 0, len(list))
+	for _, s := range list {
+		if isStationClosed(s) || (keep != nil && !keep(s)) {
+			continue
+		}
+		cands = append(cands, cand{s, haversine(lat, lon, s.Lat, s.Lon)})
+	}
+	sort.SliceStable(cands, func(i, j int) bool { return cands[i].d < cands[j].d })
+
+	seen := make(map[string]bool)
+	var out []Station
+	for _, c := range cands {
+		if len(out) == n {
+			break
+		}
+		base := parentStopID(c.s.StopID)
+		if seen[base] {
+			continue
+		}
+		seen[base] = true
+		out = append(out, c.s)
+	}
+	return out
+}
+
 func haversine(lat1, lon1, lat2, lon2 float64) float64 {
 	const R = 6371000.0
 	φ1 := lat1 * math.Pi / 180.0
@@ -418,8 +1112,25 @@ func makeCacheKey(fromLat, fromLon, toLat, toLon float64) string {
 }
 
 func walkingTime(fromLat, fromLon, toLat, toLon float64) (*WalkResult, error) {
+	return walkingRoute(fromLat, fromLon, toLat, toLon, false)
+}
+
+// walkingRoute queries OSRM for walking duration and distance. With directions=true it also
+// requests the full route geometry and turn-by-turn steps so clients don't need a second OSRM call.
+func walkingRoute(fromLat, fromLon, toLat, toLon float64, directions bool) (*WalkResult, error) {
+	return travelRoute(modeWalk, fromLat, fromLon, toLat, toLon, directions)
+}
+
+// travelRoute is walkingRoute with the OSRM profile of mode
+func travelRoute(mode travelMode, fromLat, fromLon, toLat, toLon float64, directions bool) (*WalkResult, error) {
 	// Check cache first
 	cacheKey := makeCacheKey(fromLat, fromLon, toLat, toLon)
+	if mode != modeWalk {
+		cacheKey += "|" + string(mode)
+	}
+	if directions {
+		cacheKey += "|directions"
+	}
 	if cached, err := walkCache.Get(cacheKey); err == nil {
 		if result, ok := cached.(*WalkResult); ok {
 			log.Printf("walkingTime cache hit for key %s", cacheKey)
@@ -427,9 +1138,13 @@ func walkingTime(fromLat, fromLon, toLat, toLon float64) (*WalkResult, error) {
 		}
 	}
 	
+	query := "overview=false"
+	if directions {
+		query = "overview=full&geometries=polyline&steps=true"
+	}
 	url := fmt.Sprintf(
-		"https://router.project-osrm.org/route/v1/foot/%f,%f;%f,%f?overview=false",
-		fromLon, fromLat, toLon, toLat,
+		"%s/route/v1/%s/%f,%f;%f,%f?%s",
+		osrmBaseURL, osrmProfiles[mode], fromLon, fromLat, toLon, toLat, query,
 	)
 	log.Printf("walkingTime request: %s", url)
 	req, _ := http.NewRequest("GET", url, nil)
@@ -445,9 +1160,23 @@ func walkingTime(fromLat, fromLon, toLat, toLon float64) (*WalkResult, error) {
 		log.Printf("walkingTime non-200 status=%d body=%s", resp.StatusCode, string(body))
 		return nil, fmt.Errorf("osrm status %d", resp.StatusCode)
 	}
+	type step struct {
+		Duration float64 `json:"duration"`
+		Distance float64 `json:"distance"`
+		Name     string  `json:"name"`
+		Maneuver struct {
+			Type     string `json:"type"`
+			Modifier string `json:"modifier"`
+		} `json:"maneuver"`
+	}
+	type leg struct {
+		Steps []step `json:"steps"`
+	}
 	type route struct {
 		Duration float64 `json:"duration"`
 		Distance float64 `json:"distance"`
+		Geometry string  `json:"geometry"`
+		Legs     []leg   `json:"legs"`
 	}
 	var obj struct {
 		Routes []route `json:"routes"`
@@ -461,7 +1190,20 @@ func walkingTime(fromLat, fromLon, toLat, toLon float64) (*WalkResult, error) {
 		return nil, errors.New("no route")
 	}
 	
-	result := &WalkResult{Seconds: obj.Routes[0].Duration, Distance: obj.Routes[0].Distance}
+	result := &WalkResult{Seconds: obj.Routes[0].Duration, Distance: obj.Routes[0].Distance, Mode: mode.label()}
+	if directions {
+		result.Geometry = obj.Routes[0].Geometry
+		for _, l := range obj.Routes[0].Legs {
+			for _, st := range l.Steps {
+				result.Steps = append(result.Steps, WalkStep{
+					Instruction: describeManeuver(st.Maneuver.Type, st.Maneuver.Modifier, st.Name),
+					Name:        st.Name,
+					Seconds:     st.Duration,
+					Distance:    st.Distance,
+				})
+			}
+		}
+	}
 	
 	// Store in cache
 	walkCache.Set(cacheKey, result)
@@ -470,26 +1212,99 @@ func walkingTime(fromLat, fromLon, toLat, toLon float64) (*WalkResult, error) {
 	return result, nil
 }
 
-func departuresForStation(s Station) ([]Departure, error) {
+// describeManeuver turns an OSRM maneuver into a short human-readable instruction,
+// e.g. ("turn", "left", "Broadway") -> "Turn left onto Broadway"
+func describeManeuver(kind, modifier, street string) string {
+	var text string
+	switch kind {
+	case "depart":
+		text = "Head out"
+		if street != "" {
+			text += " on " + street
+		}
+		return text
+	case "arrive":
+		return "Arrive at the station"
+	case "turn", "end of road", "fork":
+		text = "Turn"
+		if modifier != "" {
+			text += " " + modifier
+		}
+	case "continue", "new name":
+		text = "Continue"
+		if modifier != "" && modifier != "straight" {
+			text += " " + modifier
+		}
+	case "roundabout", "rotary":
+		text = "Take the roundabout"
+	default:
+		text = strings.TrimSpace(kind + " " + modifier)
+		if text != "" {
+			text = strings.ToUpper(text[:1]) + text[1:]
+		}
+	}
+	if street != "" {
+		text += " onto " + street
+	}
+	return text
+}
+
+func departuresForStation(s Station, filter departureFilter) ([]Departure, error) {
+	stationUsage.mark(s.StopID)
+	deps, err := departuresFromSource(s, filter, fetchGTFS)
+	if err == nil && shadowMode {
+		go shadowCompare(s, filter, deps)
+	}
+	return deps, err
+}
+
+// departuresFromSource builds the departure list for a station from feeds obtained via
+// fetch (the per-request cached fetch, or the poller store in shadow mode).
+func departuresFromSource(s Station, filter departureFilter, fetch func(string) (*gtfs_realtime.FeedMessage, error)) ([]Departure, error) {
 	// Build sets for exact stop IDs and their "base" IDs (without trailing direction letter).
 	stopExact := map[string]struct{}{}
 	stopBase := map[string]struct{}{}
 	stopExact[s.StopID] = struct{}{}
-	stopBase[baseStopID(s.StopID)] = struct{}{}
+	stopBase[parentStopID(s.StopID)] = struct{}{}
 
-	now := time.Now().Unix()
+	now := nowFunc().Unix()
 	deps := make([]Departure, 0, 64)
+	runTimes := data().RunTimes
 
-	// Determine which feeds to fetch based on station's routes
-	feeds := getFeedsForStation(s)
-	log.Printf("Station %s serves routes %v, fetching %d feed(s)", s.Name, s.Routes, len(feeds))
+	// Determine which feeds to fetch based on station's routes (narrowed by the route filter)
+	feedStation, ok := filter.feedStation(s)
+	if !ok {
+		log.Printf("Station %s serves none of the requested routes", s.Name)
+		return deps, nil
+	}
+	feeds := getFeedsForStation(feedStation)
+	log.Printf("Station %s serves routes %v, fetching %d feed(s)", s.Name, feedStation.Routes, len(feeds))
 
+	var failed, staleWarnings []string
 	for _, u := range feeds {
-		feed, err := fetchGTFS(u)
+		feedDemand.mark(u, time.Now())
+	}
+	// Feeds download concurrently and are merged in order as they arrive (see feedfetch.go)
+	pending := fetchFeeds(feeds, fetch)
+	for i, u := range feeds {
+		feed, err := pending[i].wait()
 		if err != nil {
 			log.Printf("fetchGTFS error for %s: %v", u, err)
+			if w := feedUnavailableWarning(u, feedStation.Routes); !containsString(failed, w) {
+				failed = append(failed, w)
+			}
 			continue
 		}
+		occupancy := vehicleOccupancy(feed)
+		carCounts := vehicleCarCounts(feed)
+		vehicles := vehiclePositions(feed)
+		feedNow := feedClock(feed, now) // ETAs count from when the feed was made (see delay.go)
+		stale := feedStale(feed, now)   // too old to trust (see stale.go)
+		if stale {
+			if w := feedStaleWarning(u, feedStation.Routes); !containsString(staleWarnings, w) {
+				staleWarnings = append(staleWarnings, w)
+			}
+		}
 		for _, ent := range feed.GetEntity() {
 			tu := ent.GetTripUpdate()
 			if tu == nil {
@@ -501,58 +1316,93 @@ func departuresForStation(s Station) ([]Departure, error) {
 				routeID = td.GetRouteId()
 				tripID = td.GetTripId()
 			}
+			if !filter.allowsRoute(routeID) || tripCanceled(tu) {
+				continue
+			}
+			nyct := parseNYCTTrip(tu.GetTrip()) // train assignment, see nyct.go
+			// Where the train is now, for stops_away (see stopsaway.go)
+			vehicle, hasVehicle := vehicles[tripID]
+			vehicleIdx := -1
+			if hasVehicle {
+				vehicleIdx = vehicleStopIndex(tu.GetStopTimeUpdate(), vehicle)
+			}
 
-			// Find the last stop for this trip (highest stop_sequence)
+			// Find the last stop for this trip (highest stop_sequence it serves, see schedrel.go)
 			lastStopID := ""
-			lastStopName := ""
+			var tripStops []string // remaining stops, from the one the train is headed to
 			for _, stu := range tu.GetStopTimeUpdate() {
-				lastStopID = stu.GetStopId()
-			}
-			baseLastStopID := baseStopID(lastStopID)
-			for _, s := range stations {
-				// Match stations with the same base ID (ignoring N/S/E/W suffix)
-				if baseStopID(s.StopID) == baseLastStopID {
-					lastStopName = s.Name
+				if stopServed(stu) {
+					lastStopID = stu.GetStopId()
 				}
+				tripStops = append(tripStops, stu.GetStopId())
 			}
+			lastStopName := lastStopDisplayName(lastStopID)
 			// Look up station name for this stop ID
 			// IMPORTANT: translate and append within the same loop that iterates stop time updates.
-			for _, stu := range tu.GetStopTimeUpdate() {
+			for k, stu := range tu.GetStopTimeUpdate() {
 				stopID := stu.GetStopId()
+				if !stopServed(stu) {
+					continue
+				}
 
-				// Match against exact stop ID OR base stop ID (handles N/S/E/W suffix in GTFS-RT).
+				// Match against exact stop ID OR parent stop ID (handles N/S/E/W suffix in GTFS-RT).
 				if _, ok := stopExact[stopID]; !ok {
-					if _, ok2 := stopBase[baseStopID(stopID)]; !ok2 {
+					if _, ok2 := stopBase[parentStopID(stopID)]; !ok2 {
 						continue
 					}
 				}
 
-				var t int64
-				if dep := stu.GetDeparture(); dep != nil {
-					t = dep.GetTime()
-				}
-				if t == 0 {
-					if arr := stu.GetArrival(); arr != nil {
-						t = arr.GetTime()
-					}
-				}
-				if t == 0 || t < now {
+				ev := stopEvent(stu)
+				if ev == nil || ev.GetTime() < now {
 					continue
 				}
+				t := ev.GetTime()
+				// Raise glitched ETAs to the fastest the train could get here (see etafloor.go)
+				clamped := false
+				if floor, ok := etaFloor(runTimes, routeID, tripStops[:k+1]); ok && t-feedNow < floor {
+					t, clamped = feedNow+floor, true
+				}
 
 
-				dir := getStopDirection(stopID)
-				etaSec := t - now
+				dir := normalizeDirection(routeID, getStopDirection(stopID))
+				if !filter.allowsDirection(dir) {
+					continue
+				}
+				etaSec := t - feedNow
+				if !filter.allowsETA(etaSec) || !appConfig.ETAConfidence.allows(etaSec) {
+					continue
+				}
+				track := parseNYCTStop(stu)
+				var away *int
+				var status, currentStop string
+				if n, ok := stopsAway(tu.GetStopTimeUpdate(), vehicleIdx, k); ok {
+					away, status, currentStop = &n, vehicleStatus(vehicle.Status), lastStopDisplayName(vehicle.StopID)
+				}
 
 				deps = append(deps, Departure{
 					RouteID:    routeID,
 					StopID:     stopID,
 					Direction:  dir,
+					DirectionLabel: stationDirectionLabel(s, routeID, dir),
 					UnixTime:   t,
 					ETASeconds: etaSec,
+					Confidence: appConfig.ETAConfidence.tier(etaSec, ev.GetUncertainty()),
+					DelaySeconds: eventDelay(ev, tu),
+					UncertaintySeconds: eventUncertainty(ev),
+					ScheduledTrack: track.ScheduledTrack,
+					ActualTrack: track.ActualTrack,
+					IsAssigned: nyct.IsAssigned,
+					StopsAway: away,
+					CurrentStatus: status,
+					CurrentStop: currentStop,
+					Stale: stale,
 					TripID:     tripID,
 					HeadSign:   "",
 					LastStop:   lastStopName,
+					LastStopID: lastStopID,
+					Occupancy:  occupancy[tripID],
+					CarCount:   carCount(carCounts, tripID, routeID),
+					ETAClamped: clamped,
 				})
 			}
 		}
@@ -560,18 +1410,41 @@ func departuresForStation(s Station) ([]Departure, error) {
 
 	sort.Slice(deps, func(i, j int) bool { return deps[i].UnixTime < deps[j].UnixTime })
 	
-	// Limit to 2 departures per route and direction
-	deps = limitDeparturesByRouteAndDirection(deps)
+	// Limit departures per route and direction (request limit, else the configured default)
+	perDirection := filter.Limit
+	if perDirection == 0 {
+		perDirection = appConfig.DeparturesPerDirection
+	}
+	deps = limitDeparturesByRouteAndDirection(deps, perDirection)
+
+	// Cap the whole response without letting one busy route crowd out the others
+	deps = limitDeparturesFairly(deps, appConfig.MaxDepartures, appConfig.FairnessPolicy)
 	
 	// Fill in headsigns for the filtered departures
 	for i := range deps {
-		deps[i].HeadSign = lookupHeadsignWithTiming(deps[i].TripID)
+		// A short-turning train must show where it really ends, not its scheduled terminal
+		if ix := data().StopTimes; deps[i].LastStop != "" && ix != nil {
+			if trip, ok := findStaticTrip(deps[i].TripID); ok && ix.isShortTurn(trip.TripID, deps[i].LastStopID) {
+				deps[i].ShortTurned = true
+				deps[i].HeadSign = deps[i].LastStop
+				continue
+			}
+		}
+		// Prefer the variant headsign for where this train actually terminates
+		deps[i].HeadSign = patternHeadsign(deps[i].RouteID, deps[i].LastStopID)
+		if deps[i].HeadSign == "" {
+			deps[i].HeadSign = lookupHeadsignWithTiming(deps[i].TripID)
+		}
 		if deps[i].HeadSign == "" {
 			deps[i].HeadSign = deps[i].LastStop
 		}
 	}
+	annotations.annotate(s.StopID, deps)
 	
 	log.Printf("departuresForStation produced %d departures (after filtering)", len(deps))
+	if len(failed) > 0 || len(staleWarnings) > 0 {
+		return deps, &feedError{warnings: append(failed, staleWarnings...), all: len(failed) == len(feeds)}
+	}
 	return deps, nil
 }
 
@@ -626,15 +1499,28 @@ func getFeedsForStation(s Station) []string {
 	return feeds
 }
 
-// limitDeparturesByRouteAndDirection limits departures to at most 2 per route+direction combination
-func limitDeparturesByRouteAndDirection(deps []Departure) []Departure {
+// Departures kept per route+direction: the default, and the most a request or config may ask for
+const (
+	defaultDeparturesPerDirection = 2
+	maxDeparturesPerDirection     = 10
+)
+
+// limitDeparturesByRouteAndDirection limits departures to at most limit per route+direction
+// combination (limit <= 0 uses the default of 2)
+func limitDeparturesByRouteAndDirection(deps []Departure, limit int) []Departure {
+	if limit <= 0 {
+		limit = defaultDeparturesPerDirection
+	}
+	if limit > maxDeparturesPerDirection {
+		limit = maxDeparturesPerDirection
+	}
 	// Group departures by route+direction
 	counts := make(map[string]int)
 	result := []Departure{}
 	
 	for _, dep := range deps {
 		key := dep.RouteID + "_" + dep.Direction
-		if counts[key] < 2 {
+		if counts[key] < limit {
 			result = append(result, dep)
 			counts[key]++
 		}
@@ -646,6 +1532,56 @@ func limitDeparturesByRouteAndDirection(deps []Departure) []Departure {
 
 
 
+// Fairness policies for the per-response departure cap
+const (
+	fairnessChronological = "chronological" // earliest departures win regardless of route
+	fairnessPerRoute      = "per_route"     // every served route gets one slot before any route gets a second
+)
+
+// limitDeparturesFairly caps a time-sorted departure list at max entries (0 = unlimited).
+// With the per_route policy (the default) each route's earliest departure is kept first,
+// then remaining slots are filled chronologically, so a frequent route like the 6 can't
+// push a less frequent route off a complex's board.
+func limitDeparturesFairly(deps []Departure, max int, policy string) []Departure {
+	if max <= 0 || len(deps) <= max {
+		return deps
+	}
+	if policy == fairnessChronological {
+		return deps[:max]
+	}
+
+	keep := make([]bool, len(deps))
+	kept := 0
+	seenRoute := make(map[string]bool)
+	for i, dep := range deps {
+		if kept == max {
+			break
+		}
+		if !seenRoute[dep.RouteID] {
+			seenRoute[dep.RouteID] = true
+			keep[i] = true
+			kept++
+		}
+	}
+	for i := range deps {
+		if kept == max {
+			break
+		}
+		if !keep[i] {
+			keep[i] = true
+			kept++
+		}
+	}
+
+	result := make([]Departure, 0, max)
+	for i, dep := range deps {
+		if keep[i] {
+			result = append(result, dep)
+		}
+	}
+	return result
+}
+
 func fetchGTFS(url string) (*gtfs_realtime.FeedMessage, error) {
 	return fetchGTFSWithCache(url)
 }
@@ -665,85 +1601,159 @@ func fetchGTFSWithCache(url string) (*gtfs_realtime.FeedMessage, error) {
 	
 	// Cache miss - fetch from network
 	log.Printf("Transit feed cache miss for %s, fetching from network", url)
-	req, _ := http.NewRequest("GET", url, nil)
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	b, err := io.ReadAll(resp.Body)
+	// Parsed once per change of the feed (see conditional.go)
+	feed, b, err := downloadFeedMessage(url)
 	if err != nil {
 		return nil, err
 	}
 	
-	// Parse the protobuf
-	var feed gtfs_realtime.FeedMessage
-	if err := proto.Unmarshal(b, &feed); err != nil {
-		return nil, err
-	}
-	
 	// Store in cache
-	transitFeedCache.Set(url, b)
+	transitFeedCache.SetWithExpire(url, b, feedCacheTTL(url)) // per feed, see feedttl.go
+	recordFeedCached(url, time.Now())
 	log.Printf("Transit feed cached for %s", url)
+	stationFreshness.observe(feed, time.Now())
+	feedRefresh.broadcast()
 	
-	return &feed, nil
+	return feed, nil
+}
+
+// downloadFeed fetches the raw GTFS-RT protobuf bytes for a feed URL
+// (a conditional request when the feed was fetched before, see conditional.go)
+func downloadFeed(url string) ([]byte, error) {
+	b, _, err := downloadFeedConditional(url)
+	return b, err
 }
 
 func loadStations(ctx context.Context, csvURL string) error {
-	req, _ := http.NewRequestWithContext(ctx, "GET", csvURL, nil)
-	resp, err := httpClient.Do(req)
+	var body io.ReadCloser
+	if csvURL == embeddedStationsSource {
+		body = io.NopCloser(bytes.NewReader(embeddedStationsCSV))
+	} else {
+		var err error
+		body, err = openDataSource(ctx, csvURL)
+		if err != nil {
+			return fmt.Errorf("download stations: %w", err)
+		}
+	}
+	defer body.Close()
+	out, err := parseStations(body)
 	if err != nil {
-		return fmt.Errorf("download stations: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
-	r := csv.NewReader(resp.Body)
+	if len(out) == 0 {
+		return fmt.Errorf("no stations in %s", csvURL)
+	}
+	updateData(func(ds *dataset) { ds.Stations = out })
+	
+	// Load route mappings from MTA Stations.csv
+	if err := loadRouteMapping(ctx); err != nil {
+		log.Printf("Warning: failed to load route mappings: %v", err)
+		// Continue without route optimization if loading fails
+	}
+	
+	return nil
+}
+
+// parseStations reads a stations CSV (NY Open Data or MTA Stations.csv layout)
+func parseStations(body io.Reader) ([]Station, error) {
+	r := csv.NewReader(body)
 	r.FieldsPerRecord = -1
 
 	// NOTE: column keys use "gtfs", not "gtsf".
 	need := []string{"gtfsstopid", "stopname", "gtfslatitude", "gtfslongitude"}
 	idx, err := parseCSVHeaders(r, need, "stations")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	var out []Station
-	for {
-		row, err := r.Read()
-		if err == io.EOF {
-			break
+	var out []Station
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read stations row: %w", err)
+		}
+		stopID := row[idx["gtfsstopid"]]
+		name := row[idx["stopname"]]
+		lat, _ := strconv.ParseFloat(row[idx["gtfslatitude"]], 64)
+		lon, _ := strconv.ParseFloat(row[idx["gtfslongitude"]], 64)
+		if stopID == "" || lat == 0 || lon == 0 {
+			continue
+		}
+		st := Station{StopID: stopID, Name: name, Lat: lat, Lon: lon}
+		if i, ok := idx["complexid"]; ok && i < len(row) {
+			st.ComplexID = row[i]
+		}
+		if i, ok := idx["borough"]; ok && i < len(row) {
+			st.Borough = row[i]
+		}
+		if i, ok := idx["northdirectionlabel"]; ok && i < len(row) {
+			st.NorthLabel = strings.TrimSpace(row[i])
+		}
+		if i, ok := idx["southdirectionlabel"]; ok && i < len(row) {
+			st.SouthLabel = strings.TrimSpace(row[i])
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+// embeddedStationsSource names the stations snapshot compiled into the binary. The
+// Docker build refreshes data/stations.csv from NY Open Data; the committed copy only
+// has the header, so the embedded source fails over cleanly in dev builds.
+const embeddedStationsSource = "embedded"
+
+//go:embed data/stations.csv
+var embeddedStationsCSV []byte
+
+// liveStationsSource records which source the loaded stations came from
+var liveStationsSource string
+
+// defaultStationsCSV is the NY Open Data stations export
+const defaultStationsCSV = "https://data.ny.gov/api/views/39hk-dx4f/rows.csv?accessType=DOWNLOAD"
+
+// stationsSourceChain is the default failover order: GTFS stops.txt (see stations.go),
+// NY Open Data, MTA Stations.csv (same columns), then the embedded snapshot
+func stationsSourceChain() []string {
+	return []string{gtfsStationsSource, stationsCSV, mtaStationsCSV, embeddedStationsSource}
+}
+
+// loadStationsWithFailover tries each source in order until one yields stations. The
+// "gtfs" source reads zf, which is nil when the zip couldn't be downloaded.
+func loadStationsWithFailover(ctx context.Context, sources []string, zf *gtfsZip) error {
+	var errs []string
+	for _, src := range sources {
+		var err error
+		switch {
+		case src != gtfsStationsSource:
+			err = loadStations(ctx, src)
+		case zf == nil:
+			err = fmt.Errorf("GTFS zip unavailable")
+		default:
+			err = loadGTFSStations(ctx, zf)
 		}
 		if err != nil {
-			return fmt.Errorf("read stations row: %w", err)
-		}
-		stopID := row[idx["gtfsstopid"]]
-		name := row[idx["stopname"]]
-		lat, _ := strconv.ParseFloat(row[idx["gtfslatitude"]], 64)
-		lon, _ := strconv.ParseFloat(row[idx["gtfslongitude"]], 64)
-		if stopID == "" || lat == 0 || lon == 0 {
+			log.Printf("Warning: stations source %s failed: %v", src, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", src, err))
 			continue
 		}
-		out = append(out, Station{StopID: stopID, Name: name, Lat: lat, Lon: lon})
-	}
-	stations = out
-	
-	// Load route mappings from MTA Stations.csv
-	if err := loadRouteMapping(ctx); err != nil {
-		log.Printf("Warning: failed to load route mappings: %v", err)
-		// Continue without route optimization if loading fails
+		liveStationsSource = src
+		log.Printf("Stations loaded from %s", src)
+		return nil
 	}
-	
-	return nil
+	return fmt.Errorf("all stations sources failed: %s", strings.Join(errs, "; "))
 }
 
 // loadRouteMapping loads the MTA Stations.csv to extract route information for each stop
 func loadRouteMapping(ctx context.Context) error {
-	req, _ := http.NewRequestWithContext(ctx, "GET", mtaStationsCSV, nil)
-	resp, err := httpClient.Do(req)
+	body, err := openDataSource(ctx, mtaStationsCSV)
 	if err != nil {
 		return fmt.Errorf("download MTA stations: %w", err)
 	}
-	defer resp.Body.Close()
-	r := csv.NewReader(resp.Body)
+	defer body.Close()
+	r := csv.NewReader(body)
 	r.FieldsPerRecord = -1
 
 	// MTA Stations.csv uses different column names
@@ -755,6 +1765,15 @@ func loadRouteMapping(ctx context.Context) error {
 	
 	// Create a map for quick lookup
 	routeMap := make(map[string][]string)
+	boroughIdx, hasBorough := idx["borough"]
+	boroughMap := make(map[string]string)
+	labelMap := make(map[string][2]string) // north, south direction labels
+	northIdx, hasNorth := idx["northdirectionlabel"]
+	southIdx, hasSouth := idx["southdirectionlabel"]
+	adaIdx, hasADA := idx["ada"]
+	adaNorthIdx, hasADANorth := idx["adanorthbound"]
+	adaSouthIdx, hasADASouth := idx["adasouthbound"]
+	adaMap := make(map[string][3]int) // ADA, northbound, southbound
 	
 	for {
 		row, err := r.Read()
@@ -767,6 +1786,22 @@ func loadRouteMapping(ctx context.Context) error {
 		
 		stopID := row[idx["gtfsstopid"]]
 		routesStr := row[idx["daytimeroutes"]]
+		if hasBorough && boroughIdx < len(row) && stopID != "" {
+			boroughMap[stopID] = row[boroughIdx]
+		}
+		if hasNorth && hasSouth && northIdx < len(row) && southIdx < len(row) && stopID != "" {
+			labelMap[stopID] = [2]string{strings.TrimSpace(row[northIdx]), strings.TrimSpace(row[southIdx])}
+		}
+		if hasADA && adaIdx < len(row) && stopID != "" {
+			ada := [3]int{parseADA(row[adaIdx])}
+			if hasADANorth && adaNorthIdx < len(row) {
+				ada[1] = parseADA(row[adaNorthIdx])
+			}
+			if hasADASouth && adaSouthIdx < len(row) {
+				ada[2] = parseADA(row[adaSouthIdx])
+			}
+			adaMap[stopID] = ada
+		}
 		
 		if stopID == "" || routesStr == "" {
 			continue
@@ -778,64 +1813,252 @@ func loadRouteMapping(ctx context.Context) error {
 	}
 	
 	// Update stations with route information
-	for i := range stations {
-		if routes, ok := routeMap[stations[i].StopID]; ok {
-			stations[i].Routes = routes
+	mutateStations(func(list []Station) {
+		for i := range list {
+			if routes, ok := routeMap[list[i].StopID]; ok {
+				list[i].Routes = routes
+			}
+			if list[i].Borough == "" {
+				list[i].Borough = boroughMap[list[i].StopID]
+			}
+			if labels, ok := labelMap[list[i].StopID]; ok && list[i].NorthLabel == "" && list[i].SouthLabel == "" {
+				list[i].NorthLabel, list[i].SouthLabel = labels[0], labels[1]
+			}
+			if ada, ok := adaMap[list[i].StopID]; ok {
+				list[i].ADA, list[i].ADANorthbound, list[i].ADASouthbound = ada[0], ada[1] != 0, ada[2] != 0
+			}
 		}
-	}
+	})
 	
 	log.Printf("Loaded route mappings for %d stops", len(routeMap))
 	return nil
 }
 
+// loadStationPhotos loads entrance photo URLs per station from a CSV export.
+// Rows are keyed by parent stop ID so both platforms of a station share the same photos.
+func loadStationPhotos(ctx context.Context, csvURL string) error {
+	body, err := openDataSource(ctx, csvURL)
+	if err != nil {
+		return fmt.Errorf("download station photos: %w", err)
+	}
+	defer body.Close()
+	r := csv.NewReader(body)
+	r.FieldsPerRecord = -1
+
+	need := []string{"gtfsstopid", "imageurl"}
+	idx, err := parseCSVHeaders(r, need, "station-photos")
+	if err != nil {
+		return err
+	}
+	captionIdx, hasCaption := idx["caption"]
+	attribIdx, hasAttrib := idx["attribution"]
+
+	out := make(map[string][]StationPhoto)
+	count := 0
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read station photos row: %w", err)
+		}
+		stopID := strings.TrimSpace(row[idx["gtfsstopid"]])
+		imageURL := strings.TrimSpace(row[idx["imageurl"]])
+		if stopID == "" || imageURL == "" {
+			continue
+		}
+		photo := StationPhoto{URL: imageURL}
+		if hasCaption && captionIdx < len(row) {
+			photo.Caption = row[captionIdx]
+		}
+		if hasAttrib && attribIdx < len(row) {
+			photo.Attribution = row[attribIdx]
+		}
+		key := parentStopID(stopID)
+		out[key] = append(out[key], photo)
+		count++
+	}
+	stationPhotos = out
+	log.Printf("Loaded %d station photos for %d stations", count, len(out))
+	return nil
+}
+
+// loadPlaces loads the gazetteer of named walking origins
+func loadPlaces(ctx context.Context, csvURL string) error {
+	body, err := openDataSource(ctx, csvURL)
+	if err != nil {
+		return fmt.Errorf("download places: %w", err)
+	}
+	defer body.Close()
+	r := csv.NewReader(body)
+	r.FieldsPerRecord = -1
+
+	need := []string{"id", "name", "latitude", "longitude"}
+	idx, err := parseCSVHeaders(r, need, "places")
+	if err != nil {
+		return err
+	}
+
+	out := make(map[string]Place)
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read places row: %w", err)
+		}
+		id := strings.TrimSpace(row[idx["id"]])
+		lat, _ := strconv.ParseFloat(row[idx["latitude"]], 64)
+		lon, _ := strconv.ParseFloat(row[idx["longitude"]], 64)
+		if id == "" || lat == 0 || lon == 0 {
+			continue
+		}
+		out[strings.ToLower(id)] = Place{ID: id, Name: row[idx["name"]], Lat: lat, Lon: lon}
+	}
+	places = out
+	log.Printf("Loaded %d places", len(places))
+	return nil
+}
+
+// lookupPlace finds a gazetteer entry by ID (case-insensitive)
+func lookupPlace(id string) (Place, bool) {
+	p, ok := places[strings.ToLower(id)]
+	return p, ok
+}
+
+// photosForStation returns the entrance photos for a station, or nil if none are known
+func photosForStation(s Station) []StationPhoto {
+	return stationPhotos[parentStopID(s.StopID)]
+}
+
 func normalizeHeader(s string) string {
 	s = strings.ToLower(strings.TrimSpace(s))
 	replacer := strings.NewReplacer(" ", "", "_", "", "-", "", "/", "", ".", "")
 	return replacer.Replace(s)
 }
 
-func loadTrips(ctx context.Context, zipURL string) error {
-	req, _ := http.NewRequestWithContext(ctx, "GET", zipURL, nil)
+// Upper bounds for static GTFS downloads. The zip is spooled to disk rather than held in
+// memory, and individual members are read through a limit so a corrupt or hostile archive
+// can't exhaust a small container.
+var (
+	maxGTFSZipBytes    int64 = 512 << 20
+	maxGTFSMemberBytes int64 = 1 << 30
+)
+
+var errGTFSTooLarge = errors.New("exceeds configured size limit")
+
+// gtfsZip is a GTFS archive opened from disk. Remote archives are streamed to a temp file
+// which is removed on Close.
+type gtfsZip struct {
+	*zip.ReadCloser
+	tmpPath string
+}
+
+// openGTFSZip downloads (or opens, for local paths) a GTFS zip without buffering it in memory
+func openGTFSZip(ctx context.Context, src string) (*gtfsZip, error) {
+	if !isRemoteSource(src) {
+		zr, err := zip.OpenReader(strings.TrimPrefix(src, "file://"))
+		if err != nil {
+			return nil, err
+		}
+		return &gtfsZip{ReadCloser: zr}, nil
+	}
+	if dataDir != "" {
+		p, err := fetchToDataDir(ctx, src, maxGTFSZipBytes, validateZipFile)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.OpenReader(p)
+		if err != nil {
+			return nil, err
+		}
+		return &gtfsZip{ReadCloser: zr}, nil
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", src, nil)
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("download GTFS zip: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	zipData, err := io.ReadAll(resp.Body)
+	tmp, err := os.CreateTemp("", "gtfs-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(tmp, io.LimitReader(resp.Body, maxGTFSZipBytes+1))
+	tmp.Close()
+	if err == nil && n > maxGTFSZipBytes {
+		err = fmt.Errorf("download %w (%d bytes)", errGTFSTooLarge, maxGTFSZipBytes)
+	}
 	if err != nil {
-		return fmt.Errorf("read GTFS zip: %w", err)
+		os.Remove(tmp.Name())
+		return nil, err
 	}
 
-	zipReader, err := zip.NewReader(strings.NewReader(string(zipData)), int64(len(zipData)))
+	zr, err := zip.OpenReader(tmp.Name())
 	if err != nil {
-		return fmt.Errorf("open GTFS zip: %w", err)
+		os.Remove(tmp.Name())
+		return nil, err
 	}
+	return &gtfsZip{ReadCloser: zr, tmpPath: tmp.Name()}, nil
+}
 
-	var tripsFile *zip.File
-	for _, f := range zipReader.File {
-		if f.Name == "trips.txt" {
-			tripsFile = f
-			break
-		}
+func (g *gtfsZip) Close() error {
+	err := g.ReadCloser.Close()
+	if g.tmpPath != "" {
+		os.Remove(g.tmpPath)
 	}
-	if tripsFile == nil {
-		return fmt.Errorf("trips.txt not found in GTFS zip")
+	return err
+}
+
+// openMember opens a single file in the archive (e.g. "trips.txt"), reading at most
+// maxGTFSMemberBytes of decompressed data.
+func (g *gtfsZip) openMember(name string) (io.ReadCloser, error) {
+	for _, f := range g.File {
+		if f.Name == name {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("open %s: %w", name, err)
+			}
+			return &limitedReadCloser{rc: rc, remaining: maxGTFSMemberBytes, name: name}, nil
+		}
 	}
+	return nil, fmt.Errorf("%s not found in GTFS zip", name)
+}
 
-	rc, err := tripsFile.Open()
-	if err != nil {
-		return fmt.Errorf("open trips.txt: %w", err)
+// limitedReadCloser fails (rather than silently truncating) once more than remaining bytes are read
+type limitedReadCloser struct {
+	rc        io.ReadCloser
+	remaining int64
+	name      string
+}
+
+func (l *limitedReadCloser) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, fmt.Errorf("%s %w", l.name, errGTFSTooLarge)
 	}
-	defer rc.Close()
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.rc.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
 
-	r := csv.NewReader(rc)
+func (l *limitedReadCloser) Close() error { return l.rc.Close() }
+
+// parseTrips parses a GTFS trips.txt stream
+func parseTrips(rd io.Reader) ([]Trip, error) {
+	r := csv.NewReader(rd)
 	r.FieldsPerRecord = -1
 
 	need := []string{"route_id", "trip_id", "service_id", "trip_headsign", "direction_id"}
 	idx, err := parseCSVHeaders(r, need, "trips")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var out []Trip
@@ -845,7 +2068,7 @@ func loadTrips(ctx context.Context, zipURL string) error {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("read trips row: %w", err)
+			return nil, fmt.Errorf("read trips row: %w", err)
 		}
 
 		trip := Trip{
@@ -855,11 +2078,81 @@ func loadTrips(ctx context.Context, zipURL string) error {
 			TripHeadsign: row[idx["trip_headsign"]],
 			DirectionID:  row[idx["direction_id"]],
 		}
+		if i, ok := idx["shape_id"]; ok && i < len(row) {
+			trip.ShapeID = row[i]
+		}
 		out = append(out, trip)
 	}
+	return out, nil
+}
+
+func loadTrips(ctx context.Context, zipURL string) error {
+	zf, err := openGTFSZip(ctx, zipURL)
+	if err != nil {
+		return fmt.Errorf("download GTFS zip: %w", err)
+	}
+	defer zf.Close()
+	return loadTripsFromZip(zf)
+}
+
+// loadTripsFromZip loads trips, the schedule index and the other static tables from an
+// open GTFS zip
+func loadTripsFromZip(zf *gtfsZip) error {
+	rc, err := zf.openMember("trips.txt")
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := parseTrips(rc)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Loaded %d trips from GTFS data", len(out))
+
+	// Without a calendar, services are guessed from their names
+	cal, err := loadCalendar(zf)
+	if err != nil {
+		log.Printf("Warning: failed to load the service calendar: %v", err)
+	}
+
+	// The schedule index is optional; headsigns still work without it
+	ix, err := loadStopTimesIndex(zf)
+	if err != nil {
+		log.Printf("Warning: failed to index stop_times.txt: %v", err)
+	}
 
-	trips = out
-	log.Printf("Loaded %d trips from GTFS data", len(trips))
+	// Trips, calendar and index are published together (see dataset.go)
+	updateData(func(ds *dataset) {
+		if cal != nil {
+			ds.Calendar = cal
+		}
+		if ix == nil {
+			ds.Trips, ds.TripServices = out, indexTripServices(out)
+			return
+		}
+		ds.setTrips(out, ix)
+		ds.Stations = withScheduledRoutes(ds.Stations, scheduledRoutes(out, ix))
+	})
+	if err := loadTransfers(zf); err != nil {
+		log.Printf("Warning: failed to load transfers.txt: %v", err)
+	}
+	if err := loadRoutes(zf); err != nil {
+		log.Printf("Warning: failed to load routes.txt: %v", err)
+	}
+	if err := loadShapes(zf, out); err != nil {
+		log.Printf("Warning: failed to load shapes.txt: %v", err)
+	}
+	if err := loadStopNames(zf); err != nil {
+		log.Printf("Warning: failed to load stops.txt: %v", err)
+	}
+	if err := loadStopPlatforms(zf); err != nil {
+		log.Printf("Warning: failed to load platform locations: %v", err)
+	}
+	if err := loadGTFSTranslations(zf); err != nil {
+		log.Printf("Warning: failed to load translations.txt: %v", err)
+	}
 	return nil
 }
 
@@ -877,18 +2170,6 @@ func parseLatLon(r *http.Request) (float64, float64, error) {
 	return lat, lon, nil
 }
 
-// baseStopID returns the base stop ID without directional suffix (N/S/E/W)
-func baseStopID(id string) string {
-	if id == "" {
-		return id
-	}
-	last := id[len(id)-1]
-	if (last >= 'A' && last <= 'Z') || (last >= 'a' && last <= 'z') {
-		return id[:len(id)-1]
-	}
-	return id
-}
-
 // getStopDirection returns the directional suffix (N/S/E/W) from a stop ID, or empty string if none
 func getStopDirection(id string) string {
 	if id == "" || len(id) < 2 {
@@ -901,6 +2182,62 @@ func getStopDirection(id string) string {
 	return ""
 }
 
+// gtfsCSVSources are GTFS static files, whose headers are already snake_case and are
+// matched as-is rather than through normalizeHeader.
+var gtfsCSVSources = map[string]bool{
+	"trips":          true,
+	"stop_times":     true,
+	"transfers":      true,
+	"routes":         true,
+	"stops":          true,
+	"translations":   true,
+	"calendar":       true,
+	"calendar_dates": true,
+	"shapes":         true,
+}
+
+// crosstownDirections maps E/W stop suffixes on crosstown lines to the GTFS N/S convention
+// (N is toward 8 Av on the L and toward Flushing on the 7).
+var crosstownDirections = map[string]map[string]string{
+	"L":  {"W": "N", "E": "S"},
+	"7":  {"E": "N", "W": "S"},
+	"7X": {"E": "N", "W": "S"},
+}
+
+// crosstownLabels are rider-facing names for N/S on lines where compass directions mislead
+var crosstownLabels = map[string]map[string]string{
+	"L":  {"N": "Manhattan-bound", "S": "Brooklyn-bound"},
+	"7":  {"N": "Flushing-bound", "S": "Manhattan-bound"},
+	"7X": {"N": "Flushing-bound", "S": "Manhattan-bound"},
+}
+
+// normalizeDirection converts a raw stop suffix into the N/S convention used in responses
+func normalizeDirection(routeID, dir string) string {
+	if m, ok := crosstownDirections[routeID]; ok {
+		if d, ok := m[dir]; ok {
+			return d
+		}
+	}
+	return dir
+}
+
+// directionLabel returns the rider-facing label for a normalized direction, if one is known
+func directionLabel(routeID, dir string) string {
+	return crosstownLabels[routeID][dir]
+}
+
+// stationDirectionLabel prefers the station's own label from Stations.csv (e.g. "Manhattan &
+// Queens") and falls back to the line's label
+func stationDirectionLabel(s Station, routeID, dir string) string {
+	switch {
+	case dir == "N" && s.NorthLabel != "":
+		return s.NorthLabel
+	case dir == "S" && s.SouthLabel != "":
+		return s.SouthLabel
+	}
+	return directionLabel(routeID, dir)
+}
+
 func parseCSVHeaders(r *csv.Reader, needed []string, source string) (map[string]int, error) {
 	headers, err := r.Read()
 	if err != nil {
@@ -911,7 +2248,7 @@ func parseCSVHeaders(r *csv.Reader, needed []string, source string) (map[string]
 	idx := map[string]int{}
 	for i, h := range headers {
 		var key string
-		if source == "trips" {
+		if gtfsCSVSources[source] {
 			key = strings.ToLower(strings.TrimSpace(h))
 		} else {
 			key = normalizeHeader(h)
@@ -935,121 +2272,39 @@ func parseCSVHeaders(r *csv.Reader, needed []string, source string) (map[string]
 }
 
 func lookupHeadsign(tripID string) string {
-	if tripID == "" || len(trips) == 0 {
+	trip, ok := findStaticTrip(tripID)
+	if !ok {
 		return ""
 	}
+	return trip.TripHeadsign
+}
 
-	// Get current day of week
-	now := time.Now()
-	dayOfWeek := now.Weekday()
-	var service string
-	switch dayOfWeek {
-	case time.Sunday:
-		service = "Sunday"
-	case time.Saturday:
-		service = "Saturday"
-	default:
-		service = "Weekday"
+// findStaticTrip matches a GTFS-RT trip ID to its trips.txt entry, preferring the trip
+// that runs on today's service day
+func findStaticTrip(tripID string) (Trip, bool) {
+	list := data().Trips
+	if tripID == "" || len(list) == 0 {
+		return Trip{}, false
 	}
 
-	// Find matching trips where tripID from GTFS-RT is a substring of trip_id from trips.txt
-	var matches []Trip
-	for _, trip := range trips {
-		if strings.Contains(trip.TripID, tripID) {
-			matches = append(matches, trip)
-		}
-	}
+	// Use the transit service day (a 1:30am trip still runs on the previous day's service)
+	day := serviceDate(nowFunc())
 
+	// Match on origin time, route and direction (see tripids.go)
+	matches := matchingTrips(list, tripID)
 	if len(matches) == 0 {
-		return ""
+		return Trip{}, false
 	}
 
-	// If multiple matches, prefer the one matching today's service
-	for _, match := range matches {
-		if match.ServiceID == service {
-			return match.TripHeadsign
+	// If multiple matches, prefer the one whose service runs today
+	if len(matches) > 1 {
+		if match, ok := matchTripService(matches, day, tripID); ok {
+			return match, true
 		}
 	}
 
 	// If no service match, return first match
-	return matches[0].TripHeadsign
-}
-
-// Stub functions for supplemented GTFS functionality - to be implemented
-
-func loadSupplementedTrips(ctx context.Context, zipURL string) ([]Trip, error) {
-	start := time.Now()
-	log.Printf("Loading supplemented GTFS trips from %s", zipURL)
-	
-	log.Printf("Downloading supplemented GTFS trips from network")
-	
-	req, _ := http.NewRequestWithContext(ctx, "GET", zipURL, nil)
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("download supplemented GTFS zip: %w", err)
-	}
-	defer resp.Body.Close()
-
-	zipData, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("read supplemented GTFS zip: %w", err)
-	}
-
-	zipReader, err := zip.NewReader(strings.NewReader(string(zipData)), int64(len(zipData)))
-	if err != nil {
-		return nil, fmt.Errorf("open supplemented GTFS zip: %w", err)
-	}
-
-	var tripsFile *zip.File
-	for _, f := range zipReader.File {
-		if f.Name == "trips.txt" {
-			tripsFile = f
-			break
-		}
-	}
-	if tripsFile == nil {
-		return nil, fmt.Errorf("trips.txt not found in supplemented GTFS zip")
-	}
-
-	rc, err := tripsFile.Open()
-	if err != nil {
-		return nil, fmt.Errorf("open trips.txt: %w", err)
-	}
-	defer rc.Close()
-
-	r := csv.NewReader(rc)
-	r.FieldsPerRecord = -1
-
-	need := []string{"route_id", "trip_id", "service_id", "trip_headsign", "direction_id"}
-	idx, err := parseCSVHeaders(r, need, "trips")
-	if err != nil {
-		return nil, err
-	}
-
-	var out []Trip
-	for {
-		row, err := r.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("read supplemented trips row: %w", err)
-		}
-
-		trip := Trip{
-			RouteID:      row[idx["route_id"]],
-			TripID:       row[idx["trip_id"]],
-			ServiceID:    row[idx["service_id"]],
-			TripHeadsign: row[idx["trip_headsign"]],
-			DirectionID:  row[idx["direction_id"]],
-		}
-		out = append(out, trip)
-	}
-
-	
-	log.Printf("Loaded %d supplemented trips in %.2f ms", len(out), 
-		float64(time.Since(start).Microseconds())/1000.0)
-	return out, nil
+	return matches[0], true
 }
 
 // matchServiceID performs improved service matching with substring matching for irregular service IDs
@@ -1124,38 +2379,24 @@ func findBestServiceMatch(matches []Trip, targetDay string, tripID string) (Trip
 	return Trip{}, false
 }
 
+// lookupHeadsignWithSupplemented looks a trip up in the supplemented and base trips, in
+// headsign_precedence order (see supplemented.go)
 func lookupHeadsignWithSupplemented(tripID string) string {
 	if tripID == "" {
 		return ""
 	}
 
-	// Get current day of week
-	now := time.Now()
-	dayOfWeek := now.Weekday()
-	var service string
-	switch dayOfWeek {
-	case time.Sunday:
-		service = "Sunday"
-	case time.Saturday:
-		service = "Saturday"
-	default:
-		service = "Weekday"
-	}
-
-	// First check supplemented trips (preferred source)
-	if len(supplementedTrips) > 0 {
-		var matches []Trip
-		for _, trip := range supplementedTrips {
-			if strings.Contains(trip.TripID, tripID) {
-				matches = append(matches, trip)
-			}
-		}
+	// Use the transit service day (a 1:30am trip still runs on the previous day's service)
+	day := serviceDate(nowFunc())
+	service := day.Format(gtfsDateLayout)
 
+	for _, src := range headsignTripSources() {
+		matches := matchingTrips(src.trips, tripID)
 		if len(matches) > 0 {
 			// Try to find the best service match
-			if bestMatch, found := findBestServiceMatch(matches, service, tripID); found {
-				log.Printf("Headsign for trip %s found in supplemented feed: %s (service: %s)", 
-					tripID, bestMatch.TripHeadsign, bestMatch.ServiceID)
+			if bestMatch, found := matchTripService(matches, day, tripID); found {
+				log.Printf("Headsign for trip %s found in %s feed: %s (service: %s)", 
+					tripID, src.name, bestMatch.TripHeadsign, bestMatch.ServiceID)
 				return bestMatch.TripHeadsign
 			}
 			
@@ -1166,30 +2407,6 @@ func lookupHeadsignWithSupplemented(tripID string) string {
 		}
 	}
 
-	// Fallback to regular trips
-	if len(trips) > 0 {
-		var matches []Trip
-		for _, trip := range trips {
-			if strings.Contains(trip.TripID, tripID) {
-				matches = append(matches, trip)
-			}
-		}
-
-		if len(matches) > 0 {
-			// Try to find the best service match
-			if bestMatch, found := findBestServiceMatch(matches, service, tripID); found {
-				log.Printf("Headsign for trip %s found in regular feed: %s (service: %s)", 
-					tripID, bestMatch.TripHeadsign, bestMatch.ServiceID)
-				return bestMatch.TripHeadsign
-			}
-			
-			// If no service match, return first match but log a warning  
-			log.Printf("Warning: No service match for trip %s on %s, using first match (service: %s): %s", 
-				tripID, service, matches[0].ServiceID, matches[0].TripHeadsign)
-			return matches[0].TripHeadsign
-		}
-	}
-
 	log.Printf("Headsign for trip %s not found", tripID)
 	return ""
 }
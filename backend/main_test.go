@@ -3,8 +3,11 @@ package main
 import (
 	"context"
 	"encoding/csv"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -25,11 +28,11 @@ func TestHaversine(t *testing.T) {
 
 func TestNearestStation(t *testing.T) {
 	// Inject a tiny station list
-	stations = []Station{
+	setTestStations([]Station{
 		{StopID: "R14N", Name: "14 St - Union Sq", Lat: 40.7359, Lon: -73.9906},
 		{StopID: "635S", Name: "Grand Central - 42 St", Lat: 40.7527, Lon: -73.9772},
 		{StopID: "A32N", Name: "Times Sq - 42 St", Lat: 40.7553, Lon: -73.9877},
-	}
+	})
 	// Point near Grand Central
 	s := nearestStation(40.7528, -73.9775)
 	if s.Name != "Grand Central - 42 St" {
@@ -65,7 +68,7 @@ func TestLimitDeparturesByRouteAndDirection(t *testing.T) {
 		{RouteID: "Q", Direction: "", UnixTime: 325, ETASeconds: 195},
 	}
 
-	limited := limitDeparturesByRouteAndDirection(deps)
+	limited := limitDeparturesByRouteAndDirection(deps, 0)
 
 	// Check total count: should be 2*2 (route 6) + 1 (Q North) + 2 (Q no direction) = 7
 	if len(limited) != 7 {
@@ -101,9 +104,58 @@ func TestLimitDeparturesByRouteAndDirection(t *testing.T) {
 			}
 		}
 	}
+
+	// A larger limit keeps more trains per direction
+	if got := limitDeparturesByRouteAndDirection(deps, 3); len(got) != 10 {
+		t.Errorf("expected 10 departures with limit 3, got %d", len(got))
+	}
 }
 
 // Test to verify the departure limiting logic works end-to-end
+func TestLimitDeparturesFairly(t *testing.T) {
+	// The 6 runs every few minutes; the 4 and 5 only appear later in the list
+	deps := []Departure{
+		{RouteID: "6", Direction: "N", UnixTime: 100},
+		{RouteID: "6", Direction: "S", UnixTime: 110},
+		{RouteID: "6", Direction: "N", UnixTime: 200},
+		{RouteID: "6", Direction: "S", UnixTime: 210},
+		{RouteID: "4", Direction: "N", UnixTime: 300},
+		{RouteID: "5", Direction: "S", UnixTime: 400},
+	}
+
+	fair := limitDeparturesFairly(deps, 4, fairnessPerRoute)
+	if len(fair) != 4 {
+		t.Fatalf("expected 4 departures, got %d", len(fair))
+	}
+	routes := map[string]int{}
+	for i, d := range fair {
+		routes[d.RouteID]++
+		if i > 0 && fair[i-1].UnixTime > d.UnixTime {
+			t.Errorf("fair result not in time order: %+v", fair)
+		}
+	}
+	if routes["4"] != 1 || routes["5"] != 1 || routes["6"] != 2 {
+		t.Errorf("expected every route represented, got %v", routes)
+	}
+
+	// Default (empty) policy behaves like per_route
+	if got := limitDeparturesFairly(deps, 3, ""); got[2].RouteID != "5" {
+		t.Errorf("expected default policy to keep route 5, got %+v", got)
+	}
+
+	chrono := limitDeparturesFairly(deps, 4, fairnessChronological)
+	for _, d := range chrono {
+		if d.RouteID != "6" {
+			t.Errorf("chronological policy should keep only the earliest trains, got %+v", chrono)
+			break
+		}
+	}
+
+	if got := limitDeparturesFairly(deps, 0, fairnessPerRoute); len(got) != len(deps) {
+		t.Errorf("max=0 should not limit, got %d", len(got))
+	}
+}
+
 func TestDepartureGroupingIntegration(t *testing.T) {
 	// Create test departures with multiple routes and directions
 	now := time.Now().Unix()
@@ -132,7 +184,7 @@ func TestDepartureGroupingIntegration(t *testing.T) {
 	}
 
 	// Apply the limiting function
-	limited := limitDeparturesByRouteAndDirection(deps)
+	limited := limitDeparturesByRouteAndDirection(deps, 0)
 
 	// Verify we have the right number of departures
 	expectedTotal := 2 + 2 + 2 + 1 + 2 // 6N + 6S + QN + QS + 7
@@ -222,8 +274,7 @@ func TestLoadStations(t *testing.T) {
 	defer server.Close()
 
 	// Clear existing stations
-	originalStations := stations
-	defer func() { stations = originalStations }()
+	keepTestData(t)
 
 	// Test successful load
 	err := loadStations(context.Background(), server.URL)
@@ -232,8 +283,8 @@ func TestLoadStations(t *testing.T) {
 	}
 
 	// Verify loaded stations
-	if len(stations) != 2 {
-		t.Errorf("expected 2 valid stations, got %d", len(stations))
+	if len(data().Stations) != 2 {
+		t.Errorf("expected 2 valid stations, got %d", len(data().Stations))
 	}
 
 	// Verify station data
@@ -243,15 +294,50 @@ func TestLoadStations(t *testing.T) {
 	}
 
 	for i, expected := range expectedStations {
-		if i >= len(stations) {
+		if i >= len(data().Stations) {
 			break
 		}
-		if stations[i].StopID != expected.StopID {
-			t.Errorf("station[%d].StopID = %s, want %s", i, stations[i].StopID, expected.StopID)
+		if data().Stations[i].StopID != expected.StopID {
+			t.Errorf("station[%d].StopID = %s, want %s", i, data().Stations[i].StopID, expected.StopID)
 		}
 	}
 }
 
+func TestLoadStationsWithFailover(t *testing.T) {
+	keepTestData(t)
+	originalLive, originalEmbedded := liveStationsSource, embeddedStationsCSV
+	defer func() {  liveStationsSource = originalLive; embeddedStationsCSV = originalEmbedded }()
+	ctx := context.Background()
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "maintenance", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	empty := filepath.Join(t.TempDir(), "empty.csv")
+	os.WriteFile(empty, []byte("GTFS Stop ID,Stop Name,GTFS Latitude,GTFS Longitude\n"), 0o644)
+	local := filepath.Join(t.TempDir(), "stations.csv")
+	os.WriteFile(local, []byte("GTFS Stop ID,Stop Name,GTFS Latitude,GTFS Longitude\n635,14 St - Union Sq,40.734673,-73.989951\n"), 0o644)
+
+	// A failing URL and a header-only file both fail over to the next source
+	if err := loadStationsWithFailover(ctx, []string{down.URL, empty, local}, nil); err != nil {
+		t.Fatalf("failover failed: %v", err)
+	}
+	if liveStationsSource != local || len(data().Stations) != 1 {
+		t.Errorf("expected stations from %s, got %q (%d stations)", local, liveStationsSource, len(data().Stations))
+	}
+
+	// The embedded snapshot is a source like any other
+	embeddedStationsCSV = []byte("GTFS Stop ID,Stop Name,GTFS Latitude,GTFS Longitude\nR20,14 St - Union Sq,40.735736,-73.990568\n")
+	if err := loadStationsWithFailover(ctx, []string{down.URL, embeddedStationsSource}, nil); err != nil || liveStationsSource != embeddedStationsSource {
+		t.Errorf("expected embedded snapshot to be live, got %q (%v)", liveStationsSource, err)
+	}
+
+	err := loadStationsWithFailover(ctx, []string{down.URL, empty}, nil)
+	if err == nil || !strings.Contains(err.Error(), "all stations sources failed") {
+		t.Errorf("expected combined error, got %v", err)
+	}
+}
+
 // Test loadStations error cases
 func TestLoadStationsErrors(t *testing.T) {
 	ctx := context.Background()
@@ -399,7 +485,7 @@ func TestDeparturesForStopsArrivalOnly(t *testing.T) {
 	defer func() { feedURLs = originalURLs }()
 
 	station := Station{StopID: "635N", Name: "Test", Lat: 40.75, Lon: -73.98}
-	deps, err := departuresForStation(station)
+	deps, err := departuresForStation(station, departureFilter{})
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
@@ -483,8 +569,11 @@ func TestParseLatLon(t *testing.T) {
 	}
 }
 
-// Test baseStopID helper function
-func TestBaseStopID(t *testing.T) {
+// Test the parentStopID fallback for stops stops.txt doesn't list (see stopids_test.go)
+func TestParentStopIDFallback(t *testing.T) {
+	keepTestData(t)
+	setTestStopParents(nil)
+
 	tests := []struct {
 		input    string
 		expected string
@@ -495,27 +584,93 @@ func TestBaseStopID(t *testing.T) {
 		{"101W", "101"},
 		{"635", "635"},
 		{"", ""},
-		{"123n", "123"},
-		{"456s", "456"},
+		{"123n", "123n"}, // only upper-case direction letters are suffixes
+		{"456s", "456s"},
 		{"A12N", "A12"},
 		{"R14S", "R14"},
-		{"123X", "123"},
+		{"123X", "123X"}, // not a direction
 		{"4567", "4567"},
-		{"A", ""},
+		{"A", "A"},
+		{"N", "N"},
 		{"1", "1"},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			result := baseStopID(tt.input)
+			result := parentStopID(tt.input)
 			if result != tt.expected {
-				t.Errorf("baseStopID(%q) = %q, want %q", tt.input, result, tt.expected)
+				t.Errorf("parentStopID(%q) = %q, want %q", tt.input, result, tt.expected)
 			}
 		})
 	}
 }
 
 // Test parseCSVHeaders helper function
+func TestNormalizeDirection(t *testing.T) {
+	tests := []struct {
+		route, raw, want, label string
+	}{
+		{"L", "W", "N", "Manhattan-bound"},
+		{"L", "E", "S", "Brooklyn-bound"},
+		{"L", "N", "N", "Manhattan-bound"},
+		{"7", "E", "N", "Flushing-bound"},
+		{"7", "W", "S", "Manhattan-bound"},
+		{"7X", "S", "S", "Manhattan-bound"},
+		{"6", "N", "N", ""},
+		{"6", "E", "E", ""}, // not a crosstown line, left untouched
+		{"L", "", "", ""},
+	}
+	for _, tt := range tests {
+		got := normalizeDirection(tt.route, tt.raw)
+		if got != tt.want {
+			t.Errorf("normalizeDirection(%q, %q) = %q, want %q", tt.route, tt.raw, got, tt.want)
+		}
+		if label := directionLabel(tt.route, got); label != tt.label {
+			t.Errorf("directionLabel(%q, %q) = %q, want %q", tt.route, got, label, tt.label)
+		}
+	}
+}
+
+func TestStationDirectionLabels(t *testing.T) {
+	list, err := parseStations(strings.NewReader(`GTFS Stop ID,Stop Name,GTFS Latitude,GTFS Longitude,North Direction Label,South Direction Label
+R01,Astoria-Ditmars Blvd,40.775036,-73.912034,,Manhattan
+L08,Bedford Av,40.717304,-73.956872,Manhattan,Canarsie - Rockaway Pkwy
+`))
+	if err != nil || len(list) != 2 {
+		t.Fatalf("expected two stations, got %+v (%v)", list, err)
+	}
+	tests := []struct {
+		station int
+		route   string
+		dir     string
+		label   string
+	}{
+		{0, "N", "S", "Manhattan"},
+		{0, "N", "N", ""}, // terminal: no northbound label
+		{1, "L", "N", "Manhattan"},
+		{1, "L", "S", "Canarsie - Rockaway Pkwy"},
+		{1, "L", "", ""},
+	}
+	for _, tt := range tests {
+		if got := stationDirectionLabel(list[tt.station], tt.route, tt.dir); got != tt.label {
+			t.Errorf("stationDirectionLabel(%s, %q, %q) = %q, want %q", list[tt.station].StopID, tt.route, tt.dir, got, tt.label)
+		}
+	}
+	// Without Stations.csv labels the line's label is used
+	if got := stationDirectionLabel(Station{StopID: "L08"}, "L", "S"); got != "Brooklyn-bound" {
+		t.Errorf("expected the crosstown fallback, got %q", got)
+	}
+
+	keepTestData(t)
+	list[1].Routes = []string{"L"} // one feed
+	setTestStations(list[1:])
+	feed := newTestFeed(testTripUpdate("L", "tripL", []string{"L08N"}, []int64{60}))
+	deps, err := departuresFromSource(list[1], departureFilter{}, func(string) (*gtfs_realtime.FeedMessage, error) { return feed, nil })
+	if err != nil || len(deps) != 1 || deps[0].Direction != "N" || deps[0].DirectionLabel != "Manhattan" {
+		t.Errorf("expected a departure labelled Manhattan, got %+v (%v)", deps, err)
+	}
+}
+
 func TestParseCSVHeaders(t *testing.T) {
 	t.Run("valid headers for stations", func(t *testing.T) {
 		csvData := `"GTFS Stop ID","Stop Name","GTFS Latitude","GTFS Longitude"
@@ -708,15 +863,14 @@ func TestGetFeedsForStation(t *testing.T) {
 // Test loadRouteMapping with mock CSV data
 func TestLoadRouteMapping(t *testing.T) {
 	// Save original stations
-	originalStations := stations
-	defer func() { stations = originalStations }()
+	keepTestData(t)
 	
 	// Create test stations
-	stations = []Station{
+	setTestStations([]Station{
 		{StopID: "R01", Name: "Astoria-Ditmars Blvd", Lat: 40.775036, Lon: -73.912034},
 		{StopID: "635", Name: "Times Sq-42 St", Lat: 40.754672, Lon: -73.986754},
 		{StopID: "A32", Name: "Penn Station", Lat: 40.750373, Lon: -73.991057},
-	}
+	})
 	
 	// Create a test server with mock CSV data
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -724,6 +878,10 @@ func TestLoadRouteMapping(t *testing.T) {
 R01,1,R01,BMT,Astoria,Astoria-Ditmars Blvd,Q,N W,Elevated
 635,611,635,IRT,42 St,Times Sq-42 St,M,N Q R W 1 2 3 7,Subway
 A32,614,A32,IND,8 Av,Penn Station,M,A C E,Subway`
+		if r.URL.Query().Get("labels") != "" {
+			csv = `GTFS Stop ID,Daytime Routes,North Direction Label,South Direction Label
+R01,N W,,Manhattan`
+		}
 		w.Write([]byte(csv))
 	}))
 	defer server.Close()
@@ -751,9 +909,9 @@ A32,614,A32,IND,8 Av,Penn Station,M,A C E,Subway`
 	
 	for _, tt := range tests {
 		var found *Station
-		for i := range stations {
-			if stations[i].StopID == tt.stopID {
-				found = &stations[i]
+		for i := range data().Stations {
+			if data().Stations[i].StopID == tt.stopID {
+				found = &data().Stations[i]
 				break
 			}
 		}
@@ -780,6 +938,78 @@ A32,614,A32,IND,8 Av,Penn Station,M,A C E,Subway`
 			}
 		}
 	}
+
+	// Direction labels fill stations loaded from a source without them
+	mtaStationsCSV = server.URL + "?labels=1"
+	if err := loadRouteMapping(context.Background()); err != nil {
+		t.Fatalf("loadRouteMapping with labels failed: %v", err)
+	}
+	if s := data().Stations[0]; s.NorthLabel != "" || s.SouthLabel != "Manhattan" {
+		t.Errorf("expected R01 labelled Manhattan southbound, got %q / %q", s.NorthLabel, s.SouthLabel)
+	}
+}
+
+// Test loadStationPhotos with mock CSV data
+func TestLoadStationPhotos(t *testing.T) {
+	originalPhotos := stationPhotos
+	defer func() { stationPhotos = originalPhotos }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		csv := `GTFS Stop ID,Image URL,Caption,Attribution
+635,https://example.com/635-a.jpg,Lexington Av entrance,Wikimedia Commons
+635N,https://example.com/635-b.jpg,,
+R14,,Missing URL,
+,https://example.com/orphan.jpg,,`
+		w.Write([]byte(csv))
+	}))
+	defer server.Close()
+
+	if err := loadStationPhotos(context.Background(), server.URL); err != nil {
+		t.Fatalf("loadStationPhotos failed: %v", err)
+	}
+
+	// Both platform rows share the parent stop ID
+	photos := photosForStation(Station{StopID: "635S"})
+	if len(photos) != 2 {
+		t.Fatalf("expected 2 photos for 635, got %d", len(photos))
+	}
+	if photos[0].Caption != "Lexington Av entrance" || photos[0].Attribution != "Wikimedia Commons" {
+		t.Errorf("unexpected photo metadata: %+v", photos[0])
+	}
+	if got := photosForStation(Station{StopID: "R14N"}); got != nil {
+		t.Errorf("expected no photos for R14, got %v", got)
+	}
+}
+
+// Test loadPlaces and case-insensitive lookup
+func TestLoadPlaces(t *testing.T) {
+	originalPlaces := places
+	defer func() { places = originalPlaces }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		csv := `ID,Name,Latitude,Longitude
+main-entrance,Mount Sinai Main Entrance,40.7900,-73.9526
+bad-coords,Nowhere,,`
+		w.Write([]byte(csv))
+	}))
+	defer server.Close()
+
+	if err := loadPlaces(context.Background(), server.URL); err != nil {
+		t.Fatalf("loadPlaces failed: %v", err)
+	}
+	if len(places) != 1 {
+		t.Fatalf("expected 1 valid place, got %d", len(places))
+	}
+	p, ok := lookupPlace("Main-Entrance")
+	if !ok {
+		t.Fatal("expected main-entrance to resolve")
+	}
+	if p.Lat != 40.79 || p.Lon != -73.9526 {
+		t.Errorf("unexpected coordinates %f,%f", p.Lat, p.Lon)
+	}
+	if _, ok := lookupPlace("bad-coords"); ok {
+		t.Error("place without coordinates should be skipped")
+	}
 }
 
 
@@ -797,16 +1027,52 @@ func TestLoadSupplementedTrips(t *testing.T) {
 	defer server.Close()
 
 	// Test the function exists (will fail initially)
-	_, err := loadSupplementedTrips(context.Background(), server.URL)
+	_, _, err := loadSupplementedTrips(context.Background(), server.URL)
 	if err == nil {
 		t.Error("loadSupplementedTrips should not exist yet - this test should fail initially")
 	}
 }
 
+// Test that trips are parsed from a streamed zip and that size limits are enforced
+func TestLoadTripsFromZip(t *testing.T) {
+	keepTestData(t)
+
+	server := newTestGTFSServer(t, map[string]string{
+		"trips.txt": "route_id,trip_id,service_id,trip_headsign,direction_id\n" +
+			"6,AFA23GEN-6034-Weekday-00_000600_6..N01R,Weekday,Pelham Bay Park,0\n" +
+			"L,BFA23GEN-L034-Weekday-00_001000_L..S01R,Weekday,Canarsie - Rockaway Pkwy,1\n",
+		"stops.txt": "stop_id,stop_name\n",
+	})
+	defer server.Close()
+
+	if err := loadTrips(context.Background(), server.URL); err != nil {
+		t.Fatalf("loadTrips failed: %v", err)
+	}
+	if len(data().Trips) != 2 || data().Trips[1].TripHeadsign != "Canarsie - Rockaway Pkwy" {
+		t.Errorf("unexpected trips %+v", data().Trips)
+	}
+
+	// Oversized members fail instead of being silently truncated
+	originalLimit := maxGTFSMemberBytes
+	maxGTFSMemberBytes = 16
+	defer func() { maxGTFSMemberBytes = originalLimit }()
+	err := loadTrips(context.Background(), server.URL)
+	if err == nil || !errors.Is(err, errGTFSTooLarge) {
+		t.Errorf("expected size limit error, got %v", err)
+	}
+
+	// Missing member
+	noTrips := newTestGTFSServer(t, map[string]string{"stops.txt": "stop_id\n"})
+	defer noTrips.Close()
+	if err := loadTrips(context.Background(), noTrips.URL); err == nil || !strings.Contains(err.Error(), "trips.txt not found") {
+		t.Errorf("expected missing trips.txt error, got %v", err)
+	}
+}
+
 // Test lookupHeadsignWithSupplemented function
 func TestLookupHeadsignWithSupplemented(t *testing.T) {
 	// Initialize test data
-	trips = []Trip{
+	setTestTrips([]Trip{
 		{
 			RouteID:      "6",
 			TripID:       "123456_6",
@@ -814,9 +1080,9 @@ func TestLookupHeadsignWithSupplemented(t *testing.T) {
 			TripHeadsign: "Pelham Bay Park",
 			DirectionID:  "0",
 		},
-	}
+	})
 	
-	supplementedTrips = []Trip{
+	setTestSupplementedTrips([]Trip{
 		{
 			RouteID:      "6",
 			TripID:       "123456_6",
@@ -824,7 +1090,7 @@ func TestLookupHeadsignWithSupplemented(t *testing.T) {
 			TripHeadsign: "Brooklyn Bridge - City Hall",
 			DirectionID:  "1",
 		},
-	}
+	})
 	
 	// Test that supplemented trips are preferred
 	headsign := lookupHeadsignWithSupplemented("123456_6")
@@ -839,7 +1105,7 @@ func TestLookupHeadsignWithSupplemented(t *testing.T) {
 	}
 	
 	// Clear supplemented trips and test fallback to regular
-	supplementedTrips = []Trip{}
+	setTestSupplementedTrips([]Trip{})
 	headsign3 := lookupHeadsignWithSupplemented("123456_6")
 	if headsign3 != "Pelham Bay Park" {
 		t.Errorf("expected 'Pelham Bay Park' from regular feed fallback, got %s", headsign3)
@@ -0,0 +1,84 @@
+package main
+
+// Response freshness metadata.
+//
+// ETAs are only as good as the feed they came from. Departure responses carry a "meta"
+// object so clients can tell how old the data is and show it, rather than trusting ETAs
+// blindly:
+//
+//   "meta": {
+//     "server_time": "2026-10-16T12:00:05Z",
+//     "feeds": [{"name": "gtfs-ace", "header_timestamp": "2026-10-16T11:59:52Z",
+//                "age_seconds": 13, "cache_age_seconds": 4}]
+//   }
+//
+// feeds lists each feed the response's stations draw on, with the header timestamp of the
+// copy last fetched, its age at server_time, and how long ago that copy was cached (see
+// feedstatus.go). A feed not fetched yet is listed by name only. Other meta entries, like
+// meta.deprecations, are merged in alongside.
+
+import (
+	"time"
+)
+
+// ResponseMeta is the meta object of a departure response
+type ResponseMeta struct {
+	ServerTime time.Time  `json:"server_time"`
+	Feeds      []FeedMeta `json:"feeds,omitempty"`
+}
+
+// FeedMeta is the freshness of one feed behind a response
+type FeedMeta struct {
+	Name            string     `json:"name"`
+	HeaderTimestamp *time.Time `json:"header_timestamp,omitempty"`
+	AgeSeconds      *int64     `json:"age_seconds,omitempty"`       // server_time - header_timestamp
+	CacheAgeSeconds *int64     `json:"cache_age_seconds,omitempty"` // since the copy was cached
+}
+
+// departureMeta describes the feeds behind departures at stations under filter
+func departureMeta(filter departureFilter, stations ...Station) *ResponseMeta {
+	now := nowFunc()
+	meta := &ResponseMeta{ServerTime: now.UTC()}
+	seen := map[string]bool{}
+	var urls []string
+	for _, s := range stations {
+		fs, ok := filter.feedStation(s)
+		if !ok {
+			continue
+		}
+		for _, u := range getFeedsForStation(fs) {
+			if !seen[u] {
+				seen[u] = true
+				urls = append(urls, u)
+			}
+		}
+	}
+
+	feedHealth.Lock()
+	defer feedHealth.Unlock()
+	for _, u := range urls {
+		fm := FeedMeta{Name: feedName(u)}
+		if rec, ok := feedHealth.byURL[u]; ok {
+			if !rec.headerTime.IsZero() {
+				t := rec.headerTime.UTC()
+				age := int64(now.Sub(t).Seconds())
+				fm.HeaderTimestamp, fm.AgeSeconds = &t, &age
+			}
+			if !rec.cachedAt.IsZero() {
+				age := int64(time.Since(rec.cachedAt).Seconds()) // cache times are wall-clock
+				fm.CacheAgeSeconds = &age
+			}
+		}
+		meta.Feeds = append(meta.Feeds, fm)
+	}
+	return meta
+}
+
+// rankedStations lists the stations of a multi-station response
+func rankedStations(ranked []RankedStation) []Station {
+	out := make([]Station, len(ranked))
+	for i, rs := range ranked {
+		out[i] = rs.Station
+	}
+	return out
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDepartureMeta(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+	server := newTestFeedServer(t, testTripUpdate("L", "tripL", []string{"L08N"}, []int64{60}))
+	fetched, unfetched := server.URL+"/nyct%2Fgtfs-l", server.URL+"/nyct%2Fgtfs-g"
+	useTestFeeds(t, fetched, unfetched)
+	feed, err := fetchGTFSWithCache(fetched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := time.Unix(int64(feed.GetHeader().GetTimestamp()), 0)
+	original := nowFunc
+	nowFunc = func() time.Time { return header.Add(45 * time.Second) }
+	t.Cleanup(func() { nowFunc = original })
+
+	// No routes: both feeds, each listed once for the two stations
+	meta := departureMeta(departureFilter{}, Station{StopID: "L08"}, Station{StopID: "G29"})
+	if !meta.ServerTime.Equal(header.Add(45*time.Second)) || len(meta.Feeds) != 2 {
+		t.Fatalf("unexpected meta %+v", meta)
+	}
+	l, g := meta.Feeds[0], meta.Feeds[1]
+	if l.Name != "gtfs-l" || l.HeaderTimestamp == nil || !l.HeaderTimestamp.Equal(header) || l.AgeSeconds == nil || *l.AgeSeconds != 45 {
+		t.Errorf("expected the fetched feed's header and age, got %+v", l)
+	}
+	if l.CacheAgeSeconds == nil || *l.CacheAgeSeconds > 1 {
+		t.Errorf("expected a fresh cache age, got %+v", l)
+	}
+	if g.Name != "gtfs-g" || g.HeaderTimestamp != nil || g.CacheAgeSeconds != nil {
+		t.Errorf("expected the unfetched feed by name only, got %+v", g)
+	}
+}
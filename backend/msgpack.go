@@ -0,0 +1,165 @@
+package main
+
+// MessagePack responses for clients where JSON parsing dominates CPU (microcontrollers).
+//
+//   GET /api/departures/by-id?id=635&format=msgpack
+//   GET /api/departures/by-id?id=635   with "Accept: application/msgpack"
+//
+// Every JSON response, errors included, is re-encoded as MessagePack with the same keys
+// and structure; integers stay integers. Non-JSON responses (posters, raw feeds,
+// protobuf) and the SSE and WebSocket streams are left alone.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const msgpackContentType = "application/msgpack"
+
+// wantsMsgpack reports whether the request asks for MessagePack
+func wantsMsgpack(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "msgpack" {
+		return true
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt := strings.TrimSpace(strings.Split(part, ";")[0])
+		if mt == msgpackContentType || mt == "application/x-msgpack" {
+			return true
+		}
+	}
+	return false
+}
+
+// withMsgpack re-encodes JSON responses as MessagePack when the client asks for it
+func withMsgpack(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/departures/stream" || r.URL.Path == "/ws" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		varyAccept(w)
+		if !wantsMsgpack(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		buf := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		h.ServeHTTP(buf, r)
+		body := buf.body.Bytes()
+		if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			if out, err := jsonToMsgpack(body); err == nil {
+				w.Header().Set("Content-Type", msgpackContentType)
+				body = out
+			}
+		}
+		w.WriteHeader(buf.status)
+		w.Write(body)
+	})
+}
+
+// jsonToMsgpack converts one JSON document
+func jsonToMsgpack(b []byte) ([]byte, error) {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return appendMsgpack(nil, v)
+}
+
+// appendMsgpack encodes a decoded JSON value (UseNumber) in its smallest MessagePack form
+func appendMsgpack(b []byte, v any) ([]byte, error) {
+	switch t := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if t {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return appendMsgpackInt(b, i), nil
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, 0xcb)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(f)), nil
+	case string:
+		return appendMsgpackString(b, t), nil
+	case []any:
+		b = appendMsgpackHeader(b, len(t), 0x90, 0xdc, 0xdd)
+		for _, e := range t {
+			var err error
+			if b, err = appendMsgpack(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]any:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys) // deterministic output
+		b = appendMsgpackHeader(b, len(t), 0x80, 0xde, 0xdf)
+		for _, k := range keys {
+			b = appendMsgpackString(b, k)
+			var err error
+			if b, err = appendMsgpack(b, t[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type %T", v)
+}
+
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		return append(b, byte(i))
+	case i < 0 && i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(i))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(i))
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+// appendMsgpackHeader writes an array or map length: fix form below 16, then 16 or 32 bits
+func appendMsgpackHeader(b []byte, n int, fix, b16, b32 byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, b16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, b32), uint32(n))
+}
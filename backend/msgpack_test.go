@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJSONToMsgpack(t *testing.T) {
+	tests := []struct {
+		json string
+		want []byte
+	}{
+		{`null`, []byte{0xc0}},
+		{`[true, false]`, []byte{0x92, 0xc3, 0xc2}},
+		{`[5, -3, 200, -200, 70000, 1700000000000]`, []byte{0x96, 0x05, 0xfd, 0xd1, 0x00, 0xc8, 0xd1, 0xff, 0x38, 0xd2, 0x00, 0x01, 0x11, 0x70, 0xd3, 0x00, 0x00, 0x01, 0x8b, 0xcf, 0xe5, 0x68, 0x00}},
+		{`1.5`, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{`{"b": "x", "a": []}`, []byte{0x82, 0xa1, 'a', 0x90, 0xa1, 'b', 0xa1, 'x'}},
+	}
+	for _, tt := range tests {
+		got, err := jsonToMsgpack([]byte(tt.json))
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got % x, %v; want % x", tt.json, got, err, tt.want)
+		}
+	}
+
+	long, _ := jsonToMsgpack([]byte(`"` + strings.Repeat("a", 40) + `"`))
+	if long[0] != 0xd9 || long[1] != 40 || len(long) != 42 {
+		t.Errorf("unexpected str8 header % x", long[:2])
+	}
+	arr, _ := jsonToMsgpack([]byte(`[` + strings.TrimSuffix(strings.Repeat("0,", 20), ",") + `]`))
+	if arr[0] != 0xdc || arr[1] != 0 || arr[2] != 20 {
+		t.Errorf("unexpected array16 header % x", arr[:3])
+	}
+}
+
+func TestWithMsgpack(t *testing.T) {
+	server := httptest.NewServer(newMux())
+	defer server.Close()
+
+	want := append([]byte{0x81, 0xa5}, "error"...)
+	want = append(append(want, 0xaa), "missing id"...)
+	for _, tc := range []struct {
+		path, accept string
+	}{
+		{"/api/departures/by-id?format=msgpack", ""},
+		{"/api/departures/by-id", "application/msgpack"},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+tc.path, nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body bytes.Buffer
+		body.ReadFrom(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Content-Type") != "application/msgpack" || !bytes.Equal(body.Bytes(), want) {
+			t.Errorf("%s: got %d %q % x", tc.path, resp.StatusCode, resp.Header.Get("Content-Type"), body.Bytes())
+		}
+	}
+
+	// Without either, JSON as before
+	resp, err := http.Get(server.URL + "/api/departures/by-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" || resp.Header.Get("Vary") != "Accept" {
+		t.Errorf("expected JSON varying on Accept, got %q / %q", ct, resp.Header.Get("Vary"))
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+// Listening addresses and client addresses behind reverse proxies.
+//
+// By default the server listens on ":<port>", which is a single dual-stack socket on
+// systems that allow IPv4-mapped IPv6 addresses. The listen config key lists explicit
+// addresses instead, each served by its own socket:
+//
+//   "listen": ["0.0.0.0:8080", "[::]:8080"]   separate IPv4 and IPv6-only sockets
+//   "listen": ["127.0.0.1:8080", "[::1]:8080"] loopback only, both families
+//
+// An IPv4 literal listens on IPv4 only and an IPv6 literal (including "[::]") on IPv6 only,
+// so the pair above never collides; an empty or host-name host keeps the dual-stack default.
+//
+// trusted_proxies lists the CIDRs (or single addresses) of reverse proxies allowed to
+// report the client address. For a request from a trusted peer, X-Forwarded-For is read
+// right to left and the first address that is not itself a trusted proxy is the client;
+// without that header X-Real-IP is used. Headers from untrusted peers are ignored, so
+// clients can't spoof their address. withClientIP rewrites r.RemoteAddr to the result,
+// so everything behind it sees the real client.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// trustedProxies are the peers whose forwarding headers are believed
+var trustedProxies []*net.IPNet
+
+// listenNetwork picks the network for a listen address: tcp4 or tcp6 for IP literals,
+// tcp (dual-stack where available) otherwise
+func listenNetwork(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "tcp", nil
+	case ip.To4() != nil:
+		return "tcp4", nil
+	default:
+		return "tcp6", nil
+	}
+}
+
+// listenAll opens a listener per address, closing the ones already open on failure
+func listenAll(addrs []string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		network, err := listenNetwork(addr)
+		if err == nil {
+			var ln net.Listener
+			if ln, err = net.Listen(network, addr); err == nil {
+				listeners = append(listeners, ln)
+				continue
+			}
+		}
+		for _, ln := range listeners {
+			ln.Close()
+		}
+		return nil, fmt.Errorf("listen on %s: %w", addr, err)
+	}
+	return listeners, nil
+}
+
+// parseProxyCIDRs parses CIDRs, treating a bare address as a single-host network
+func parseProxyCIDRs(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP resolves the client address of r, honoring forwarding headers from trusted
+// proxies only
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if peer == nil || !isTrustedProxy(peer) {
+		return peer
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break // a malformed hop ends the chain we can vouch for
+			}
+			if !isTrustedProxy(ip) {
+				return ip
+			}
+			peer = ip
+		}
+		return peer
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip
+	}
+	return peer
+}
+
+// withClientIP replaces r.RemoteAddr with the client address when a trusted proxy
+// forwarded the request
+func withClientIP(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(trustedProxies) > 0 {
+			if ip := clientIP(r); ip != nil {
+				if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil && !ip.Equal(net.ParseIP(host)) {
+					log.Printf("Request from %s via proxy %s", ip, host)
+					r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+				}
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// validateListen checks the listen config value
+func validateListen(v json.RawMessage) string {
+	var addrs []string
+	if err := json.Unmarshal(v, &addrs); err != nil || len(addrs) == 0 {
+		return fmt.Sprintf("expected a non-empty array of addresses like \"[::]:8080\", got %s", v)
+	}
+	for i, a := range addrs {
+		if _, err := listenNetwork(a); err != nil {
+			return fmt.Sprintf("[%d]: invalid address %q: %v", i, a, err)
+		}
+	}
+	return ""
+}
+
+// validateTrustedProxies checks the trusted_proxies config value
+func validateTrustedProxies(v json.RawMessage) string {
+	var list []string
+	if err := json.Unmarshal(v, &list); err != nil {
+		return fmt.Sprintf("expected an array of CIDRs, got %s", v)
+	}
+	if _, err := parseProxyCIDRs(list); err != nil {
+		return err.Error()
+	}
+	return ""
+}
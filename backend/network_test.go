@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestListenNetwork(t *testing.T) {
+	tests := map[string]string{
+		":8080":          "tcp",
+		"localhost:8080": "tcp",
+		"0.0.0.0:8080":   "tcp4",
+		"127.0.0.1:0":    "tcp4",
+		"[::]:8080":      "tcp6",
+		"[::1]:8080":     "tcp6",
+	}
+	for addr, want := range tests {
+		if got, err := listenNetwork(addr); err != nil || got != want {
+			t.Errorf("listenNetwork(%q) = %q, %v; want %q", addr, got, err, want)
+		}
+	}
+	for _, bad := range []string{"8080", "[::]:http", ":70000"} {
+		if _, err := listenNetwork(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestListenAllSeparateFamilies(t *testing.T) {
+	listeners, err := listenAll([]string{"127.0.0.1:0", "[::1]:0"})
+	if err != nil {
+		t.Skipf("no IPv6 loopback here: %v", err)
+	}
+	defer func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}()
+	if len(listeners) != 2 || listeners[0].Addr().Network() != "tcp" || !strings.HasPrefix(listeners[1].Addr().String(), "[::1]:") {
+		t.Errorf("unexpected listeners %v, %v", listeners[0].Addr(), listeners[1].Addr())
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	original := trustedProxies
+	t.Cleanup(func() { trustedProxies = original })
+	var err error
+	trustedProxies, err = parseProxyCIDRs([]string{"10.0.0.0/8", "fd00::/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, remote, xff, realIP, want string
+	}{
+		{"direct client", "203.0.113.7:5000", "", "", "203.0.113.7"},
+		{"untrusted peer can't spoof", "203.0.113.7:5000", "198.51.100.1", "198.51.100.2", "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:443", "198.51.100.1", "", "198.51.100.1"},
+		{"proxy chain", "10.1.2.3:443", "198.51.100.9, 198.51.100.1, 10.9.9.9", "", "198.51.100.1"},
+		{"spoofed leftmost hop ignored", "192.0.2.1:443", "1.1.1.1, 198.51.100.1", "", "198.51.100.1"},
+		{"all hops trusted", "10.1.2.3:443", "10.4.4.4", "", "10.4.4.4"},
+		{"X-Real-IP", "10.1.2.3:443", "", "2001:db8::5", "2001:db8::5"},
+		{"IPv6 proxy", "[fd00::1]:443", "198.51.100.1", "", "198.51.100.1"},
+		{"IPv6 client", "[2001:db8::1]:5000", "", "", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/stops", nil)
+			r.RemoteAddr = tt.remote
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := clientIP(r); got.String() != tt.want {
+				t.Errorf("clientIP = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithClientIP(t *testing.T) {
+	original := trustedProxies
+	t.Cleanup(func() { trustedProxies = original })
+	trustedProxies, _ = parseProxyCIDRs([]string{"10.0.0.0/8"})
+
+	var seen string
+	h := withClientIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { seen = r.RemoteAddr }))
+	r := httptest.NewRequest("GET", "/api/stops", nil)
+	r.RemoteAddr = "10.1.2.3:443"
+	r.Header.Set("X-Forwarded-For", "2001:db8::1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if seen != "[2001:db8::1]:0" {
+		t.Errorf("expected the forwarded client, got %q", seen)
+	}
+}
+
+func TestValidateNetworkConfig(t *testing.T) {
+	errs := validateConfig([]byte(`{"listen": ["[::]:8080", "8080"], "trusted_proxies": ["10.0.0.0/33"]}`))
+	if len(errs) != 2 || !strings.Contains(errs[0], `listen: [1]: invalid address "8080"`) || !strings.Contains(errs[1], `trusted_proxies: invalid CIDR "10.0.0.0/33"`) {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	if errs := validateConfig([]byte(`{"listen": ["0.0.0.0:8080", "[::]:8080"], "trusted_proxies": ["127.0.0.1", "fd00::/8"]}`)); len(errs) != 0 {
+		t.Errorf("expected a valid config, got %v", errs)
+	}
+}
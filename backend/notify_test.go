@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSlackNotifier(t *testing.T) {
+	var body map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer server.Close()
+	n, err := newNotifier(NotifierConfig{Type: notifierSlack, URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.notify(context.Background(), notification{Title: "Digest", Text: "All good"}); err != nil {
+		t.Fatal(err)
+	}
+	if body["text"] != "*Digest*\nAll good" {
+		t.Errorf("unexpected slack payload %v", body)
+	}
+}
+
+func TestWebhookNotifierStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	err := webhookNotifier{url: server.URL}.notify(context.Background(), notification{Data: map[string]int{"a": 1}})
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("expected status error, got %v", err)
+	}
+}
+
+func TestMQTTNotifier(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	type published struct {
+		client, user, topic, payload string
+	}
+	got := make(chan published, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		connect := readMQTTPacket(t, r)
+		// protocol name (6) + level + flags + keep-alive (2), then client id and username
+		rest := connect[10:]
+		clientLen := int(rest[0])<<8 | int(rest[1])
+		client := string(rest[2 : 2+clientLen])
+		rest = rest[2+clientLen:]
+		user := string(rest[2 : 2+(int(rest[0])<<8|int(rest[1]))])
+		conn.Write([]byte{0x20, 2, 0, 0})
+		publish := readMQTTPacket(t, r)
+		topicLen := int(publish[0])<<8 | int(publish[1])
+		got <- published{client: client, user: user, topic: string(publish[2 : 2+topicLen]), payload: string(publish[2+topicLen:])}
+	}()
+
+	n, err := newNotifier(NotifierConfig{Type: notifierMQTT, URL: "mqtt://rider:secret@" + ln.Addr().String(), Topic: "subway/digest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.notify(context.Background(), notification{Data: map[string]string{"name": "morning"}}); err != nil {
+		t.Fatal(err)
+	}
+	p := <-got
+	if p.user != "rider" || p.topic != "subway/digest" || p.payload != `{"name":"morning"}` {
+		t.Errorf("unexpected publish %+v", p)
+	}
+	// Within what every 3.1.1 broker accepts
+	if len(p.client) == 0 || len(p.client) > 23 || strings.Trim(p.client, "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		t.Errorf("client ID %q is not 1-23 alphanumerics", p.client)
+	}
+}
+
+// readMQTTPacket reads one control packet and returns its body
+func readMQTTPacket(t *testing.T, r *bufio.Reader) []byte {
+	if _, err := r.ReadByte(); err != nil {
+		t.Error(err)
+		return nil
+	}
+	n, mult := 0, 1
+	for {
+		b, _ := r.ReadByte()
+		n += int(b&0x7f) * mult
+		mult *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, n)
+	io.ReadFull(r, body)
+	return body
+}
+
+func TestValidateNotifier(t *testing.T) {
+	cases := map[string]string{
+		`{"type": "slack", "url": "https://hooks.slack.com/x"}`:            "",
+		`{"type": "mqtt", "url": "mqtt://broker:1883", "topic": "subway"}`: "",
+		`{"type": "mqtt", "url": "mqtt://broker:1883"}`:                    "needs a topic",
+		`{"type": "webhook", "url": "ftp://example.com"}`:                  "needs an http(s) url",
+		`{"type": "pager", "url": "https://example.com"}`:                  "invalid type",
+		`{"type": "webhook", "url": "https://example.com", "secret": "x"}`: "expected an object",
+	}
+	for in, want := range cases {
+		got := validateNotifier(json.RawMessage(in))
+		if (want == "" && got != "") || !strings.Contains(got, want) {
+			t.Errorf("validateNotifier(%s) = %q, want %q", in, got, want)
+		}
+	}
+}
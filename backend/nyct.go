@@ -0,0 +1,126 @@
+package main
+
+// NYCT GTFS-RT extensions.
+//
+// The subway feeds extend the standard messages (MTA's nyct-subway.proto):
+//
+//   TripDescriptor field 1001, NyctTripDescriptor: train_id = 1, is_assigned = 2, direction = 3
+//   StopTimeUpdate field 1001, NyctStopTimeUpdate: scheduled_track = 1, actual_track = 2
+//
+// is_assigned is set once a train and crew are assigned to the trip; until then the trip
+// is only planned and may not run. The tracks tell riders which platform to wait on at
+// stations with several (Atlantic Av, Times Sq); actual_track differs from
+// scheduled_track when the train is routed elsewhere. Like api.proto's messages (see
+// protobuf.go) the extensions are decoded with protowire, from the fields the generated
+// bindings keep as unknown, so the build needs no protoc step.
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// nyctExtensionField is the field number of both NYCT extensions
+const nyctExtensionField = 1001
+
+// nyctTrip is the NYCT extension of a trip descriptor
+type nyctTrip struct {
+	TrainID    string
+	IsAssigned *bool // nil when the feed doesn't say
+}
+
+// nyctStop is the NYCT extension of a stop time update
+type nyctStop struct {
+	ScheduledTrack string
+	ActualTrack    string
+}
+
+// nyctExtension returns the bytes of the NYCT extension in a message's unknown fields
+func nyctExtension(unknown []byte) ([]byte, bool) {
+	var ext []byte
+	found := false
+	for len(unknown) > 0 {
+		num, typ, n := protowire.ConsumeTag(unknown)
+		if n < 0 {
+			return nil, false
+		}
+		unknown = unknown[n:]
+		if num == nyctExtensionField && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(unknown)
+			if n < 0 {
+				return nil, false
+			}
+			ext, found = append(ext, v...), true // repeated occurrences merge
+			unknown = unknown[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, unknown)
+		if n < 0 {
+			return nil, false
+		}
+		unknown = unknown[n:]
+	}
+	return ext, found
+}
+
+// eachField calls fn with every field of a message; it stops at malformed input
+func eachField(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte)) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return
+		}
+		fn(num, typ, b[:n])
+		b = b[n:]
+	}
+}
+
+// parseNYCTTrip reads the NYCT extension of a trip descriptor
+func parseNYCTTrip(td *gtfs_realtime.TripDescriptor) nyctTrip {
+	var out nyctTrip
+	if td == nil {
+		return out
+	}
+	ext, ok := nyctExtension(td.ProtoReflect().GetUnknown())
+	if !ok {
+		return out
+	}
+	eachField(ext, func(num protowire.Number, typ protowire.Type, v []byte) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			s, _ := protowire.ConsumeBytes(v)
+			out.TrainID = string(s)
+		case num == 2 && typ == protowire.VarintType:
+			x, _ := protowire.ConsumeVarint(v)
+			assigned := x != 0
+			out.IsAssigned = &assigned
+		}
+	})
+	return out
+}
+
+// parseNYCTStop reads the NYCT extension of a stop time update
+func parseNYCTStop(stu *gtfs_realtime.TripUpdate_StopTimeUpdate) nyctStop {
+	var out nyctStop
+	ext, ok := nyctExtension(stu.ProtoReflect().GetUnknown())
+	if !ok {
+		return out
+	}
+	eachField(ext, func(num protowire.Number, typ protowire.Type, v []byte) {
+		if typ != protowire.BytesType {
+			return
+		}
+		s, _ := protowire.ConsumeBytes(v)
+		switch num {
+		case 1:
+			out.ScheduledTrack = string(s)
+		case 2:
+			out.ActualTrack = string(s)
+		}
+	})
+	return out
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// nyctExtensionBytes encodes a NYCT extension message as unknown fields
+func nyctExtensionBytes(build func(b []byte) []byte) []byte {
+	b := protowire.AppendTag(nil, nyctExtensionField, protowire.BytesType)
+	return protowire.AppendBytes(b, build(nil))
+}
+
+func TestDeparturesNYCTExtensions(t *testing.T) {
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "D24", Name: "Atlantic Av-Barclays Ctr", Routes: []string{"Q"}}})
+
+	assigned := testTripUpdate("Q", "assigned", []string{"D24N"}, []int64{120})
+	assigned.TripUpdate.Trip.ProtoReflect().SetUnknown(nyctExtensionBytes(func(b []byte) []byte {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, "1Q 1234+ CTL/STL")
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		return protowire.AppendVarint(b, 1)
+	}))
+	assigned.TripUpdate.StopTimeUpdate[0].ProtoReflect().SetUnknown(nyctExtensionBytes(func(b []byte) []byte {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, "B1")
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		return protowire.AppendString(b, "B2")
+	}))
+	planned := testTripUpdate("Q", "planned", []string{"D24N"}, []int64{240})
+	planned.TripUpdate.Trip.ProtoReflect().SetUnknown(nyctExtensionBytes(func(b []byte) []byte {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		return protowire.AppendVarint(b, 0)
+	}))
+	plain := testTripUpdate("Q", "plain", []string{"D24N"}, []int64{360})
+
+	// Round-trip through the wire format, as the feeds arrive
+	raw, err := proto.Marshal(newTestFeed(assigned, planned, plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var feed gtfs_realtime.FeedMessage
+	if err := proto.Unmarshal(raw, &feed); err != nil {
+		t.Fatal(err)
+	}
+	deps, err := departuresFromSource(data().Stations[0], departureFilter{Limit: 3}, func(string) (*gtfs_realtime.FeedMessage, error) { return &feed, nil })
+	if err != nil || len(deps) != 3 {
+		t.Fatalf("expected three departures, got %+v (%v)", deps, err)
+	}
+	if d := deps[0]; d.ScheduledTrack != "B1" || d.ActualTrack != "B2" || d.IsAssigned == nil || !*d.IsAssigned {
+		t.Errorf("expected tracks B1/B2 on an assigned train, got %+v", d)
+	}
+	if d := deps[1]; d.IsAssigned == nil || *d.IsAssigned || d.ScheduledTrack != "" {
+		t.Errorf("expected an unassigned trip without tracks, got %+v", d)
+	}
+	if d := deps[2]; d.IsAssigned != nil {
+		t.Errorf("expected no assignment without the extension, got %+v", d)
+	}
+	if got := parseNYCTTrip(assigned.TripUpdate.Trip); got.TrainID != "1Q 1234+ CTL/STL" {
+		t.Errorf("train ID = %q", got.TrainID)
+	}
+}
+
+func TestNYCTExtensionMalformed(t *testing.T) {
+	td := &gtfs_realtime.TripDescriptor{}
+	td.ProtoReflect().SetUnknown([]byte{0xca, 0x3e, 0x05, 0x10}) // field 1001, truncated
+	if got := parseNYCTTrip(td); got.IsAssigned != nil || got.TrainID != "" {
+		t.Errorf("expected nothing from a malformed extension, got %+v", got)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+// Vehicle occupancy passthrough.
+//
+// GTFS-RT reports crowding as VehiclePosition.occupancy_status. The MTA subway feeds don't
+// publish it yet (the LIRR and Metro-North feeds do); when a feed carries it, departures of
+// the same trip get an "occupancy" value from occupancyLevels.
+
+import (
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// occupancyLevels maps GTFS-RT occupancy statuses to the API's values. NO_DATA_AVAILABLE
+// (and anything unknown) is left out so clients can't mistake it for a real reading.
+var occupancyLevels = map[gtfs_realtime.VehiclePosition_OccupancyStatus]string{
+	gtfs_realtime.VehiclePosition_EMPTY:                      "empty",
+	gtfs_realtime.VehiclePosition_MANY_SEATS_AVAILABLE:       "many_seats",
+	gtfs_realtime.VehiclePosition_FEW_SEATS_AVAILABLE:        "few_seats",
+	gtfs_realtime.VehiclePosition_STANDING_ROOM_ONLY:         "standing_room",
+	gtfs_realtime.VehiclePosition_CRUSHED_STANDING_ROOM_ONLY: "crushed",
+	gtfs_realtime.VehiclePosition_FULL:                       "full",
+	gtfs_realtime.VehiclePosition_NOT_ACCEPTING_PASSENGERS:   "not_accepting_passengers",
+	gtfs_realtime.VehiclePosition_NOT_BOARDABLE:              "not_boardable",
+}
+
+// vehicleOccupancy indexes a feed's vehicle occupancy by trip ID
+func vehicleOccupancy(feed *gtfs_realtime.FeedMessage) map[string]string {
+	var out map[string]string
+	for _, ent := range feed.GetEntity() {
+		v := ent.GetVehicle()
+		if v == nil || v.OccupancyStatus == nil || v.GetTrip().GetTripId() == "" {
+			continue
+		}
+		level, ok := occupancyLevels[v.GetOccupancyStatus()]
+		if !ok {
+			continue
+		}
+		if out == nil {
+			out = map[string]string{}
+		}
+		out[v.GetTrip().GetTripId()] = level
+	}
+	return out
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+func testVehicle(tripID string, status *gtfs_realtime.VehiclePosition_OccupancyStatus) *gtfs_realtime.FeedEntity {
+	return &gtfs_realtime.FeedEntity{
+		Id: proto.String("v-" + tripID),
+		Vehicle: &gtfs_realtime.VehiclePosition{
+			Trip:            &gtfs_realtime.TripDescriptor{TripId: proto.String(tripID)},
+			OccupancyStatus: status,
+		},
+	}
+}
+
+func TestVehicleOccupancy(t *testing.T) {
+	feed := newTestFeed(
+		testVehicle("full", gtfs_realtime.VehiclePosition_STANDING_ROOM_ONLY.Enum()),
+		testVehicle("empty", gtfs_realtime.VehiclePosition_EMPTY.Enum()),
+		testVehicle("nodata", gtfs_realtime.VehiclePosition_NO_DATA_AVAILABLE.Enum()),
+		testVehicle("unset", nil),
+		testTripUpdate("1", "full", []string{"101N"}, []int64{60}),
+	)
+	got := vehicleOccupancy(feed)
+	if len(got) != 2 || got["full"] != "standing_room" || got["empty"] != "empty" {
+		t.Errorf("unexpected occupancy %v", got)
+	}
+	if vehicleOccupancy(newTestFeed()) != nil {
+		t.Error("expected nil map for a feed without vehicles")
+	}
+}
+
+func TestDepartureOccupancy(t *testing.T) {
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "101", Name: "Van Cortlandt Park-242 St", Routes: []string{"1"}}})
+
+	feed := newTestFeed(
+		testTripUpdate("1", "crowded", []string{"101S"}, []int64{60}),
+		testTripUpdate("1", "unknown", []string{"101S"}, []int64{120}),
+		testVehicle("crowded", gtfs_realtime.VehiclePosition_FEW_SEATS_AVAILABLE.Enum()),
+	)
+	deps, err := departuresFromSource(data().Stations[0], departureFilter{}, func(string) (*gtfs_realtime.FeedMessage, error) { return feed, nil })
+	if err != nil || len(deps) != 2 {
+		t.Fatalf("expected two departures, got %+v (%v)", deps, err)
+	}
+	if deps[0].Occupancy != "few_seats" || deps[1].Occupancy != "" {
+		t.Errorf("expected occupancy only on the reported trip, got %q and %q", deps[0].Occupancy, deps[1].Occupancy)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+// Partial responses.
+//
+// A station's departures can come from several feeds. When some of them fail, the
+// response still carries what the others returned, marked "partial": true with a
+// warning per failed feed naming the routes it covers ("C/E data unavailable"), instead
+// of silently missing those trains. Only when every needed feed fails is a single-station
+// request an error (502); multi-station responses mark that station partial instead.
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// feedError reports the feeds that failed while building a station's departures
+type feedError struct {
+	warnings []string
+	all      bool // every needed feed failed, so the departures are empty
+}
+
+func (e *feedError) Error() string {
+	if e.all {
+		return "realtime data unavailable: " + strings.Join(e.warnings, "; ")
+	}
+	return "partial departures: " + strings.Join(e.warnings, "; ")
+}
+
+// splitPartial separates a departures error into warnings and a fatal error: some
+// failed feeds are only warnings, all failed feeds (or any other error) are fatal
+func splitPartial(err error) ([]string, error) {
+	var fe *feedError
+	if errors.As(err, &fe) && !fe.all {
+		return fe.warnings, nil
+	}
+	return nil, err
+}
+
+// feedWarnings lists the warnings for a departures error in multi-station responses,
+// where one station's failure must not fail the rest
+func feedWarnings(err error) []string {
+	var fe *feedError
+	if errors.As(err, &fe) {
+		return fe.warnings
+	}
+	if err != nil {
+		return []string{"realtime data unavailable"}
+	}
+	return nil
+}
+
+// feedUnavailableWarning names the routes lost with a failed feed, preferring the ones
+// the station serves
+func feedUnavailableWarning(url string, stationRoutes []string) string {
+	routes := feedRoutes(url, stationRoutes)
+	if len(routes) == 0 {
+		return "some realtime data unavailable"
+	}
+	return fmt.Sprintf("%s data unavailable", strings.Join(routes, "/"))
+}
+
+// feedRoutes lists the routes a feed carries, only the ones the station serves if any
+func feedRoutes(url string, stationRoutes []string) []string {
+	var serving, all []string
+	for route, u := range routeToFeed {
+		if u != url {
+			continue
+		}
+		all = append(all, route)
+		if containsString(stationRoutes, route) || containsString(stationRoutes, route+"X") {
+			serving = append(serving, route)
+		}
+	}
+	routes := serving
+	if len(routes) == 0 {
+		routes = all
+	}
+	sort.Strings(routes)
+	return routes
+}
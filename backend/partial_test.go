@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPartialDepartures(t *testing.T) {
+	initTestCaches()
+	useTestFeeds(t)
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "A27", Name: "42 St-Port Authority Bus Terminal", Lat: 40.757308, Lon: -73.989735, Routes: []string{"A", "C", "E", "7"}}})
+
+	sevenServer := newTestFeedServer(t, testTripUpdate("7", "trip7", []string{"A27N"}, []int64{120}))
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	originalRouteToFeed := routeToFeed
+	routeToFeed = map[string]string{"A": down.URL, "C": down.URL, "E": down.URL, "H": down.URL, "7": sevenServer.URL}
+	defer func() { routeToFeed = originalRouteToFeed }()
+
+	w := httptest.NewRecorder()
+	handleByID(w, httptest.NewRequest("GET", "/api/departures/by-id?id=A27", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for a partial response, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp NearestResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Partial || len(resp.Warnings) != 1 || resp.Warnings[0] != "A/C/E data unavailable" {
+		t.Errorf("expected an A/C/E warning, got partial=%v %v", resp.Partial, resp.Warnings)
+	}
+	if len(resp.Departures) != 1 || resp.Departures[0].RouteID != "7" {
+		t.Errorf("expected the 7 departure from the working feed, got %+v", resp.Departures)
+	}
+
+	// Every needed feed down: single-station requests fail, multi-station ones flag it
+	w = httptest.NewRecorder()
+	handleByID(w, httptest.NewRequest("GET", "/api/departures/by-id?id=A27&routes=A,C", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected 502 when every feed failed, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	handleBulk(w, httptest.NewRequest("GET", "/api/departures/bulk?ids=A27&routes=E", nil))
+	var bulk BulkResponse
+	if err := json.NewDecoder(w.Body).Decode(&bulk); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || !bulk.Stations[0].Partial || len(bulk.Stations[0].Warnings) != 1 || bulk.Stations[0].Warnings[0] != "E data unavailable" {
+		t.Errorf("expected the bulk station flagged partial, got %d %+v", w.Code, bulk.Stations)
+	}
+
+	// Healthy feeds carry no flag
+	w = httptest.NewRecorder()
+	handleByID(w, httptest.NewRequest("GET", "/api/departures/by-id?id=A27&routes=7", nil))
+	resp = NearestResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Partial || resp.Warnings != nil {
+		t.Errorf("expected a complete response, got %+v", resp)
+	}
+}
+
+func TestFeedUnavailableWarning(t *testing.T) {
+	originalRouteToFeed := routeToFeed
+	defer func() { routeToFeed = originalRouteToFeed }()
+	routeToFeed = map[string]string{"N": "nqrw", "Q": "nqrw", "R": "nqrw", "W": "nqrw"}
+
+	if got := feedUnavailableWarning("nqrw", []string{"N", "W", "7"}); got != "N/W data unavailable" {
+		t.Errorf("expected the station's routes, got %q", got)
+	}
+	if got := feedUnavailableWarning("nqrw", nil); got != "N/Q/R/W data unavailable" {
+		t.Errorf("expected the feed's routes without station routes, got %q", got)
+	}
+	if got := feedUnavailableWarning("other", nil); got != "some realtime data unavailable" {
+		t.Errorf("unexpected warning %q", got)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+// Per-direction platform locations.
+//
+// The stations CSV has one point per station, but an elevated station can be a block long
+// with each direction's platform at a different end. GTFS stops.txt has a child stop per
+// direction (635N, 635S) with its own coordinates; they are exposed as Station.Platforms.
+// When a request filters on a direction, walks are routed to that platform rather than
+// the station's single point (see walkTarget).
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+)
+
+// Platform is one direction's platform of a station
+type Platform struct {
+	StopID    string  `json:"gtfs_stop_id"`
+	Direction string  `json:"direction"` // N or S
+	Lat       float64 `json:"lat"`
+	Lon       float64 `json:"lon"`
+}
+
+// parseStopPlatforms reads the directional child stops of stops.txt, keyed by parent stop ID
+func parseStopPlatforms(rd io.Reader) (map[string][]Platform, error) {
+	r := csv.NewReader(rd)
+	r.FieldsPerRecord = -1
+
+	idx, err := parseCSVHeaders(r, []string{"stop_id", "stop_lat", "stop_lon"}, "stops")
+	if err != nil {
+		return nil, err
+	}
+	out := map[string][]Platform{}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read stops row: %w", err)
+		}
+		id := row[idx["stop_id"]]
+		dir := getStopDirection(id)
+		if dir != "N" && dir != "S" {
+			continue // parent stations and anything not directional
+		}
+		lat, err1 := strconv.ParseFloat(row[idx["stop_lat"]], 64)
+		lon, err2 := strconv.ParseFloat(row[idx["stop_lon"]], 64)
+		if err1 != nil || err2 != nil || (lat == 0 && lon == 0) {
+			continue
+		}
+		base := parentStopID(id)
+		out[base] = append(out[base], Platform{StopID: id, Direction: dir, Lat: lat, Lon: lon})
+	}
+	for _, list := range out {
+		sort.Slice(list, func(i, j int) bool { return list[i].Direction < list[j].Direction })
+	}
+	return out, nil
+}
+
+// applyStationPlatforms attaches platforms to the loaded stations
+func applyStationPlatforms(byBase map[string][]Platform) {
+	mutateStations(func(list []Station) {
+		for i := range list {
+			list[i].Platforms = byBase[parentStopID(list[i].StopID)]
+		}
+	})
+}
+
+// loadStopPlatforms reads platform locations from stops.txt in an open GTFS zip
+func loadStopPlatforms(zf *gtfsZip) error {
+	rc, err := zf.openMember("stops.txt")
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	platforms, err := parseStopPlatforms(rc)
+	if err != nil {
+		return err
+	}
+	applyStationPlatforms(platforms)
+	log.Printf("Loaded platform locations for %d stations", len(platforms))
+	return nil
+}
+
+// walkTarget is where a walk to s should end: the platform for direction when known,
+// otherwise the station's own point
+func walkTarget(s Station, direction string) (lat, lon float64) {
+	for _, p := range s.Platforms {
+		if direction != "" && p.Direction == direction {
+			return p.Lat, p.Lon
+		}
+	}
+	return s.Lat, s.Lon
+}
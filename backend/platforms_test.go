@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseStopPlatforms(t *testing.T) {
+	csv := "stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station\n" +
+		"M11,Myrtle Av,40.697207,-73.935657,1,\n" +
+		"M11N,Myrtle Av,40.697500,-73.935000,0,M11\n" +
+		"M11S,Myrtle Av,40.696900,-73.936300,0,M11\n" +
+		"X01N,Broken,,,0,X01\n"
+	got, err := parseStopPlatforms(strings.NewReader(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || len(got["M11"]) != 2 {
+		t.Fatalf("expected two platforms for M11 only, got %+v", got)
+	}
+	if p := got["M11"][1]; p.StopID != "M11S" || p.Direction != "S" || p.Lat != 40.6969 {
+		t.Errorf("unexpected southbound platform %+v", p)
+	}
+}
+
+func TestWalkTarget(t *testing.T) {
+	s := Station{StopID: "M11", Lat: 40.697207, Lon: -73.935657, Platforms: []Platform{
+		{StopID: "M11N", Direction: "N", Lat: 40.6975, Lon: -73.935},
+		{StopID: "M11S", Direction: "S", Lat: 40.6969, Lon: -73.9363},
+	}}
+	if lat, lon := walkTarget(s, "S"); lat != 40.6969 || lon != -73.9363 {
+		t.Errorf("expected the southbound platform, got %f,%f", lat, lon)
+	}
+	if lat, lon := walkTarget(s, ""); lat != s.Lat || lon != s.Lon {
+		t.Errorf("expected the station point without a direction, got %f,%f", lat, lon)
+	}
+	if lat, _ := walkTarget(Station{Lat: 1}, "N"); lat != 1 {
+		t.Error("expected the station point without platforms")
+	}
+}
+
+// A direction-filtered nearest request walks to that direction's platform
+func TestNearestWalksToPlatform(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "M11", Name: "Myrtle Av", Lat: 40.697207, Lon: -73.935657, Platforms: []Platform{
+		{StopID: "M11N", Direction: "N", Lat: 40.6975, Lon: -73.935},
+		{StopID: "M11S", Direction: "S", Lat: 40.6969, Lon: -73.9363},
+	}}})
+	server := newTestFeedServer(t, testTripUpdate("M", "tripM", []string{"M11S"}, []int64{300}))
+	useTestFeeds(t, server.URL)
+
+	var mu sync.Mutex
+	var paths []string
+	osrm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		fmt.Fprint(w, `{"routes": [{"duration": 60, "distance": 80}]}`)
+	}))
+	defer osrm.Close()
+	original := osrmBaseURL
+	osrmBaseURL = osrm.URL
+	defer func() { osrmBaseURL = original }()
+
+	w := httptest.NewRecorder()
+	handleNearest(w, httptest.NewRequest("GET", "/api/departures/nearest?lat=40.6960&lon=-73.9370&direction=S", nil))
+	var resp NearestResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || len(resp.Station.Platforms) != 2 {
+		t.Fatalf("unexpected response %d %+v (%v)", w.Code, resp, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 1 || !strings.HasSuffix(paths[0], ";-73.936300,40.696900") {
+		t.Errorf("expected a walk to the southbound platform, got %v", paths)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+// API playground.
+//
+//   GET /playground
+//
+// A single self-contained HTML page, built from apiEndpoints, with a form per GET
+// endpoint: fill in the path and query parameters, send the request from the browser and
+// see the status, content type and (pretty-printed) body. Nothing is loaded from other
+// hosts. The stream and WebSocket endpoints never finish a plain request and are left
+// out, as are the admin endpoints, which need the admin token.
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// playgroundSkip are GET endpoints without a playground form
+var playgroundSkip = map[string]bool{"stream": true, "ws": true, "snapshot": true, "playground": true}
+
+// pathParamPattern matches {name} placeholders in an endpoint href
+var pathParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+// playgroundEndpoint is one form on the page
+type playgroundEndpoint struct {
+	APIEndpoint
+	PathParams []string
+}
+
+// playgroundEndpoints lists the endpoints the playground can call
+func playgroundEndpoints() []playgroundEndpoint {
+	var out []playgroundEndpoint
+	for _, e := range apiEndpoints {
+		if playgroundSkip[e.Name] || !containsString(e.Methods, "GET") {
+			continue
+		}
+		pe := playgroundEndpoint{APIEndpoint: e}
+		for _, m := range pathParamPattern.FindAllStringSubmatch(e.Href, -1) {
+			pe.PathParams = append(pe.PathParams, m[1])
+		}
+		out = append(out, pe)
+	}
+	return out
+}
+
+var playgroundTemplate = template.Must(template.New("playground").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>NYC Subway API playground</title>
+<style>
+body { font: 14px/1.4 system-ui, sans-serif; margin: 0 auto; max-width: 960px; padding: 1em; color: #222; }
+details { border: 1px solid #ccc; border-radius: 4px; margin: .5em 0; padding: .5em .75em; }
+summary { cursor: pointer; }
+summary code { font-weight: bold; }
+label { display: grid; grid-template-columns: 12em 1fr; gap: .5em; margin: .25em 0; }
+label span.req::after { content: " *"; color: #b00; }
+input { font: inherit; padding: .15em .3em; }
+button { font: inherit; margin-top: .5em; }
+pre { background: #f5f5f5; padding: .5em; overflow: auto; max-height: 30em; }
+.status { color: #555; }
+</style>
+</head>
+<body>
+<h1>NYC Subway API playground</h1>
+<p>Every GET endpoint from <a href="/api">/api</a>. Fill in the parameters and send the request; * marks required ones.</p>
+{{range .}}
+<details>
+<summary><code>{{.Href}}</code> &mdash; {{.Description}}</summary>
+<form data-endpoint="{{.Href}}">
+{{range .PathParams}}<label><span class="req">{{.}}</span><input data-path="{{.}}" required></label>
+{{end}}{{range .Params}}<label><span{{if .Required}} class="req"{{end}}>{{.Name}}</span><input name="{{.Name}}" placeholder="{{.Description}}"{{if .Required}} required{{end}}></label>
+{{end}}<button type="submit">Send</button>
+<p class="status"></p>
+<pre hidden></pre>
+</form>
+</details>
+{{end}}
+<script>
+document.querySelectorAll("form[data-endpoint]").forEach(function (form) {
+  form.addEventListener("submit", function (ev) {
+    ev.preventDefault();
+    var path = form.dataset.endpoint;
+    form.querySelectorAll("input[data-path]").forEach(function (input) {
+      path = path.replace("{" + input.dataset.path + "}", encodeURIComponent(input.value));
+    });
+    var query = new URLSearchParams();
+    form.querySelectorAll("input[name]").forEach(function (input) {
+      if (input.value !== "") query.append(input.name, input.value);
+    });
+    var url = path + (query.toString() ? "?" + query : "");
+    var status = form.querySelector(".status"), out = form.querySelector("pre");
+    status.textContent = "GET " + url + " ...";
+    var started = performance.now();
+    fetch(url).then(function (resp) {
+      var type = resp.headers.get("Content-Type") || "";
+      return resp.text().then(function (body) {
+        status.textContent = "GET " + url + " → " + resp.status + " " + type + " in " + Math.round(performance.now() - started) + " ms";
+        if (type.indexOf("application/json") === 0) {
+          try { body = JSON.stringify(JSON.parse(body), null, 2); } catch (e) {}
+        } else if (!/^text\//.test(type) && !/json|xml/.test(type)) {
+          body = "(" + body.length + " bytes of " + (type || "unknown content") + ")";
+        }
+        out.textContent = body;
+        out.hidden = false;
+      });
+    }).catch(function (err) {
+      status.textContent = "GET " + url + " failed: " + err;
+    });
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+func handlePlayground(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := playgroundTemplate.Execute(w, playgroundEndpoints()); err != nil {
+		log.Printf("playground render failed: %v", err)
+	}
+	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+}
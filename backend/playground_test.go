@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPlayground(t *testing.T) {
+	w := httptest.NewRecorder()
+	newRoutes().ServeHTTP(w, httptest.NewRequest("GET", "/playground", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("expected an HTML 200, got %d %v", w.Code, w.Header())
+	}
+	page := w.Body.String()
+	for _, want := range []string{
+		`<form data-endpoint="/api/departures/by-id">`,
+		`<input name="id" placeholder="stop ID" required>`,
+		`<form data-endpoint="/api/routes/{id}/shape">`,
+		`<input data-path="id" required>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("expected %s in the page", want)
+		}
+	}
+	for _, skipped := range []string{`data-endpoint="/api/departures/stream"`, `data-endpoint="/ws"`, `data-endpoint="/quitquitquit"`, `data-endpoint="/admin/snapshot"`} {
+		if strings.Contains(page, skipped) {
+			t.Errorf("expected no form for %s", skipped)
+		}
+	}
+	if strings.Contains(page, "src=\"http") || strings.Contains(page, "href=\"http") {
+		t.Error("expected no external assets")
+	}
+}
+
+func TestPlaygroundEndpoints(t *testing.T) {
+	for _, e := range playgroundEndpoints() {
+		if playgroundSkip[e.Name] || !containsString(e.Methods, "GET") {
+			t.Errorf("unexpected playground endpoint %s", e.Name)
+		}
+		if e.Name == "feed" && (len(e.PathParams) != 1 || e.PathParams[0] != "name") {
+			t.Errorf("expected the feed name as a path parameter, got %v", e.PathParams)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+// Background feed poller and in-memory feed store.
+//
+// The request path fetches feeds on demand through transitFeedCache. The poller instead
+// refreshes every feed on a fixed interval and keeps the latest parsed FeedMessage per
+// URL in store. Each poll also refreshes transitFeedCache, which is how polling reaches
+// requests. Requests never read the store: it is only used by shadow mode, which
+// recomputes each legacy response from the store in the background and logs any
+// differences, and by state snapshots (see snapshot.go).
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// storedFeed is the latest successfully parsed copy of a feed
+type storedFeed struct {
+	msg     *gtfs_realtime.FeedMessage
+	fetched time.Time
+}
+
+type feedStore struct {
+	mu    sync.RWMutex
+	feeds map[string]storedFeed
+}
+
+var store = &feedStore{feeds: map[string]storedFeed{}}
+
+var (
+	// shadowMode compares legacy responses against the poller store (requires the poller)
+	shadowMode bool
+	// shadowComparisons/shadowMismatches count shadow runs and runs that differed
+	shadowComparisons int64
+	shadowMismatches  int64
+)
+
+func (fs *feedStore) put(url string, msg *gtfs_realtime.FeedMessage, at time.Time) {
+	fs.mu.Lock()
+	fs.feeds[url] = storedFeed{msg: msg, fetched: at}
+	fs.mu.Unlock()
+	feedRefresh.broadcast()
+}
+
+// get returns the stored feed for url; it matches fetchGTFS's signature so it can be
+// passed to departuresFromSource.
+func (fs *feedStore) get(url string) (*gtfs_realtime.FeedMessage, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	f, ok := fs.feeds[url]
+	if !ok {
+		return nil, fmt.Errorf("feed %s not yet polled", url)
+	}
+	return f.msg, nil
+}
+
+// pollFeedsOnce refreshes every feed in urls, keeping the previous copy on failure.
+// Each copy also goes into transitFeedCache, so requests are served from the poll
+// instead of fetching the feed again themselves.
+func pollFeedsOnce(urls []string) {
+	for _, u := range urls {
+		msg, b, err := downloadFeedMessage(u)
+		if err != nil {
+			log.Printf("poller: fetch %s failed: %v", u, err)
+			continue
+		}
+		now := time.Now()
+		stationFreshness.observe(msg, now)
+		transitFeedCache.SetWithExpire(u, b, feedCacheTTL(u))
+		recordFeedCached(u, now)
+		store.put(u, msg, now)
+	}
+}
+
+// startFeedPoller polls urls every interval, or their own poll_intervals (see
+// feedttl.go), until ctx is cancelled
+func startFeedPoller(ctx context.Context, urls []string, interval time.Duration) {
+	tick := pollTick(urls, interval)
+	log.Printf("Starting feed poller for %d feeds every %s", len(urls), interval)
+	p := newPollSchedule(interval, tick)
+	pollerInterval, pollerFeedInterval = tick, interval
+	atomic.StoreInt64(&pollerHeartbeat, time.Now().UnixNano())
+	go func() {
+		pollFeedsOnce(p.due(urls, time.Now()))
+		atomic.StoreInt64(&pollerHeartbeat, time.Now().UnixNano())
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				pollFeedsOnce(p.due(urls, time.Now()))
+				atomic.StoreInt64(&pollerHeartbeat, time.Now().UnixNano())
+			}
+		}
+	}()
+}
+
+// shadowCompare recomputes a station's departures from the poller store and logs how
+// they differ from the legacy response.
+func shadowCompare(s Station, filter departureFilter, legacy []Departure) {
+	shadow, err := departuresFromSource(s, filter, store.get)
+	atomic.AddInt64(&shadowComparisons, 1)
+	if err != nil {
+		atomic.AddInt64(&shadowMismatches, 1)
+		log.Printf("shadow: store path failed for %s: %v", s.StopID, err)
+		return
+	}
+	if diffs := diffDepartures(legacy, shadow); len(diffs) > 0 {
+		atomic.AddInt64(&shadowMismatches, 1)
+		log.Printf("shadow: %d discrepancies for %s [%s]: %v", len(diffs), s.Name, s.StopID, diffs)
+	}
+}
+
+// diffDepartures describes differences between two departure lists, matching entries
+// by trip and stop. Times within 30s are considered equal since the two paths may have
+// fetched the feed at slightly different moments.
+func diffDepartures(legacy, shadow []Departure) []string {
+	const tolerance = 30
+	key := func(d Departure) string { return d.TripID + "@" + d.StopID }
+	legacyByKey := make(map[string]Departure, len(legacy))
+	for _, d := range legacy {
+		legacyByKey[key(d)] = d
+	}
+	shadowByKey := make(map[string]Departure, len(shadow))
+	for _, d := range shadow {
+		shadowByKey[key(d)] = d
+	}
+
+	var diffs []string
+	for k, l := range legacyByKey {
+		sd, ok := shadowByKey[k]
+		if !ok {
+			diffs = append(diffs, "missing in store: "+k)
+			continue
+		}
+		if delta := l.UnixTime - sd.UnixTime; delta > tolerance || delta < -tolerance {
+			diffs = append(diffs, fmt.Sprintf("time differs for %s: legacy=%d store=%d", k, l.UnixTime, sd.UnixTime))
+		}
+		if l.HeadSign != sd.HeadSign {
+			diffs = append(diffs, fmt.Sprintf("headsign differs for %s: legacy=%q store=%q", k, l.HeadSign, sd.HeadSign))
+		}
+	}
+	for k := range shadowByKey {
+		if _, ok := legacyByKey[k]; !ok {
+			diffs = append(diffs, "extra in store: "+k)
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFeedStorePolling(t *testing.T) {
+	initTestCaches()
+	originalStore := store
+	store = &feedStore{feeds: map[string]storedFeed{}}
+	defer func() { store = originalStore }()
+
+	server := newTestFeedServer(t, testTripUpdate("6", "trip1", []string{"635N"}, []int64{300}))
+
+	if _, err := store.get(server.URL); err == nil {
+		t.Error("expected error before first poll")
+	}
+	pollFeedsOnce([]string{server.URL, "http://invalid-url-that-does-not-exist.local"})
+	feed, err := store.get(server.URL)
+	if err != nil {
+		t.Fatalf("expected feed after poll: %v", err)
+	}
+	if len(feed.GetEntity()) != 1 {
+		t.Errorf("expected 1 entity, got %d", len(feed.GetEntity()))
+	}
+	// Requests are served from the poll rather than refetching
+	if _, err := transitFeedCache.Get(server.URL); err != nil {
+		t.Errorf("expected the poll to fill transitFeedCache: %v", err)
+	}
+
+	// The store path produces the same departures as the legacy path
+	useTestFeeds(t, server.URL)
+	station := Station{StopID: "635", Name: "Test"}
+	legacy, _ := departuresFromSource(station, departureFilter{}, fetchGTFS)
+	shadow, _ := departuresFromSource(station, departureFilter{}, store.get)
+	if diffs := diffDepartures(legacy, shadow); len(diffs) != 0 {
+		t.Errorf("expected no differences, got %v", diffs)
+	}
+}
+
+func TestDiffDepartures(t *testing.T) {
+	legacy := []Departure{
+		{TripID: "a", StopID: "635N", UnixTime: 1000, HeadSign: "Pelham Bay Park"},
+		{TripID: "b", StopID: "635N", UnixTime: 2000},
+		{TripID: "c", StopID: "635S", UnixTime: 3000},
+	}
+	shadow := []Departure{
+		{TripID: "a", StopID: "635N", UnixTime: 1010, HeadSign: "Pelham Bay Park"}, // within tolerance
+		{TripID: "b", StopID: "635N", UnixTime: 2120},
+		{TripID: "d", StopID: "635S", UnixTime: 3000},
+	}
+	diffs := diffDepartures(legacy, shadow)
+	if len(diffs) != 3 {
+		t.Fatalf("expected 3 differences, got %v", diffs)
+	}
+	joined := strings.Join(diffs, "\n")
+	for _, want := range []string{"extra in store: d@635S", "missing in store: c@635S", "time differs for b@635N"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("expected %q in diffs %v", want, diffs)
+		}
+	}
+}
+
+func TestShadowCompareCountsMismatches(t *testing.T) {
+	originalStore := store
+	store = &feedStore{feeds: map[string]storedFeed{}}
+	defer func() { store = originalStore }()
+	useTestFeeds(t, "http://not-polled.local")
+
+	before := atomic.LoadInt64(&shadowMismatches)
+	shadowCompare(Station{StopID: "635", Name: "Test"}, departureFilter{}, []Departure{{TripID: "a", StopID: "635N", UnixTime: time.Now().Unix()}})
+	if atomic.LoadInt64(&shadowMismatches) != before+1 {
+		t.Error("expected a mismatch when the store has no data for the legacy departures")
+	}
+}
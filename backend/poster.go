@@ -0,0 +1,204 @@
+package main
+
+// Printable station posters for community spaces and building lobbies.
+//
+//   GET /api/stations/poster?id=635
+//
+// Returns a one-page US Letter PDF with the station name, its route bullets and a QR code
+// linking to the station's live board. The link comes from the board_url config key, with
+// {id} replaced by the station's stop ID, e.g. "https://subway.example.com/?station={id}";
+// posters are disabled (404) until it is set.
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// boardURL is the live-board link template for posters ({id} = stop ID)
+var boardURL = ""
+
+// Poster page geometry, in PDF points
+const (
+	posterWidth  = 612.0
+	posterHeight = 792.0
+	posterMargin = 54.0
+)
+
+// posterLink is the board URL for a station
+func posterLink(s Station) string {
+	return strings.ReplaceAll(boardURL, "{id}", url.QueryEscape(parentStopID(s.StopID)))
+}
+
+// routeColors looks up a route's bullet and text colors, with MTA gray as the fallback
+func routeColors(id string) (fill, text string) {
+	for _, rt := range routes {
+		if rt.ID == id && rt.Color != "" {
+			text = rt.TextColor
+			if text == "" {
+				text = "FFFFFF"
+			}
+			return rt.Color, text
+		}
+	}
+	return "808183", "FFFFFF"
+}
+
+// renderPoster lays out the poster for s and returns the PDF
+func renderPoster(s Station, link string) ([]byte, error) {
+	qr, err := encodeQR(link)
+	if err != nil {
+		return nil, err
+	}
+	var c bytes.Buffer
+
+	// Station name, shrunk to fit the width (Helvetica-Bold averages ~0.6 em per glyph)
+	size := 44.0
+	if w := 0.6 * size * float64(len(s.Name)); w > posterWidth-2*posterMargin {
+		size *= (posterWidth - 2*posterMargin) / w
+	}
+	pdfText(&c, "F1", size, posterMargin, 700, "000000", s.Name)
+
+	// Route bullets
+	x := posterMargin + 22
+	for _, route := range s.Routes {
+		fill, text := routeColors(route)
+		pdfCircle(&c, x, 640, 22, fill)
+		label := strings.TrimSuffix(route, "X")
+		pdfText(&c, "F1", 26, x-0.3*26*float64(len(label)), 640-9, text, label)
+		x += 54
+	}
+
+	pdfText(&c, "F2", 22, posterMargin, 570, "000000", "Scan for live departures")
+
+	// QR code with a four-module quiet zone, centered
+	side := 360.0
+	module := side / float64(qr.Size+8)
+	left := (posterWidth - side) / 2
+	bottom := 170.0
+	fmt.Fprintf(&c, "0 0 0 rg\n")
+	for y := 0; y < qr.Size; y++ {
+		for x := 0; x < qr.Size; x++ {
+			if qr.Modules[y][x] {
+				fmt.Fprintf(&c, "%s %s %s %s re\n", pdfNum(left+float64(x+4)*module), pdfNum(bottom+float64(qr.Size+3-y)*module), pdfNum(module), pdfNum(module))
+			}
+		}
+	}
+	fmt.Fprintf(&c, "f\n")
+
+	pdfText(&c, "F2", 11, posterMargin, 140, "555555", link)
+	pdfText(&c, "F2", 9, posterMargin, posterMargin, "555555", "Live arrival times from MTA real-time feeds.")
+	return buildPDF(c.Bytes()), nil
+}
+
+// pdfText draws one line of text; color is hex RGB
+func pdfText(c *bytes.Buffer, font string, size, x, y float64, color, text string) {
+	fmt.Fprintf(c, "%s rg BT /%s %s Tf %s %s Td (%s) Tj ET\n", pdfColor(color), font, pdfNum(size), pdfNum(x), pdfNum(y), pdfString(text))
+}
+
+// pdfCircle fills a circle using four Bezier arcs
+func pdfCircle(c *bytes.Buffer, cx, cy, r float64, color string) {
+	k := 0.5523 * r
+	fmt.Fprintf(c, "%s rg\n", pdfColor(color))
+	fmt.Fprintf(c, "%s %s m\n", pdfNum(cx+r), pdfNum(cy))
+	fmt.Fprintf(c, "%s %s %s %s %s %s c\n", pdfNum(cx+r), pdfNum(cy+k), pdfNum(cx+k), pdfNum(cy+r), pdfNum(cx), pdfNum(cy+r))
+	fmt.Fprintf(c, "%s %s %s %s %s %s c\n", pdfNum(cx-k), pdfNum(cy+r), pdfNum(cx-r), pdfNum(cy+k), pdfNum(cx-r), pdfNum(cy))
+	fmt.Fprintf(c, "%s %s %s %s %s %s c\n", pdfNum(cx-r), pdfNum(cy-k), pdfNum(cx-k), pdfNum(cy-r), pdfNum(cx), pdfNum(cy-r))
+	fmt.Fprintf(c, "%s %s %s %s %s %s c f\n", pdfNum(cx+k), pdfNum(cy-r), pdfNum(cx+r), pdfNum(cy-k), pdfNum(cx+r), pdfNum(cy))
+}
+
+// pdfColor converts hex RGB ("EE352E") to PDF operands; bad input is black
+func pdfColor(hex string) string {
+	v, err := strconv.ParseUint(strings.TrimPrefix(hex, "#"), 16, 32)
+	if err != nil || len(strings.TrimPrefix(hex, "#")) != 6 {
+		return "0 0 0"
+	}
+	return fmt.Sprintf("%.3f %.3f %.3f", float64(v>>16&0xff)/255, float64(v>>8&0xff)/255, float64(v&0xff)/255)
+}
+
+func pdfNum(f float64) string {
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
+
+// pdfString escapes text for a literal string in WinAnsiEncoding; characters outside it
+// become '?'
+func pdfString(s string) string {
+	special := map[rune]byte{'–': 0x96, '—': 0x97, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95}
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case special[r] != 0:
+			fmt.Fprintf(&b, "\\%03o", special[r])
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// buildPDF wraps one page's content stream in a minimal PDF document
+func buildPDF(content []byte) []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 5 0 R /F2 6 0 R >> >> /Contents 4 0 R >>", pdfNum(posterWidth), pdfNum(posterHeight)),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes()
+}
+
+func handlePoster(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	if boardURL == "" {
+		httpError(w, http.StatusNotFound, "posters disabled: board_url not configured")
+		return
+	}
+	id := strings.TrimSpace(r.URL.Query().Get("id"))
+	if id == "" {
+		httpError(w, http.StatusBadRequest, "missing id")
+		return
+	}
+	s, ok := stationByID(id)
+	if !ok {
+		httpError(w, http.StatusNotFound, "no station matched by id")
+		return
+	}
+	pdf, err := renderPoster(s, posterLink(s))
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="poster-%s.pdf"`, parentStopID(s.StopID)))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(pdf)
+	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+}
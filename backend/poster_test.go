@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestPoster(t *testing.T) {
+	keepTestData(t)
+	originalBoard := boardURL
+	t.Cleanup(func() { boardURL = originalBoard })
+	setTestStations([]Station{{StopID: "635", Name: "14 St - Union Sq (Park Ave)", Routes: []string{"4", "5", "6"}}})
+
+	boardURL = ""
+	w := httptest.NewRecorder()
+	handlePoster(w, httptest.NewRequest("GET", "/api/stations/poster?id=635", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without board_url, got %d", w.Code)
+	}
+
+	boardURL = "https://subway.example.com/?station={id}"
+	w = httptest.NewRecorder()
+	handlePoster(w, httptest.NewRequest("GET", "/api/stations/poster?id=635N", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/pdf" {
+		t.Fatalf("expected a PDF, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	pdf := w.Body.Bytes()
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatal("malformed PDF envelope")
+	}
+	for _, want := range []string{`(14 St - Union Sq \(Park Ave\)) Tj`, "(https://subway.example.com/?station=635) Tj", "(6) Tj"} {
+		if !bytes.Contains(pdf, []byte(want)) {
+			t.Errorf("PDF missing %q", want)
+		}
+	}
+
+	// Every xref entry points at its object
+	m := regexp.MustCompile(`startxref\n(\d+)`).FindSubmatch(pdf)
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(pdf[xref:], []byte("xref\n0 7\n")) {
+		t.Fatalf("startxref does not point at the xref table")
+	}
+	for i, e := range regexp.MustCompile(`(\d{10}) 00000 n`).FindAllSubmatch(pdf[xref:], -1) {
+		off, _ := strconv.Atoi(string(e[1]))
+		if !bytes.HasPrefix(pdf[off:], []byte(fmt.Sprintf("%d 0 obj", i+1))) {
+			t.Errorf("xref entry %d points at %q", i+1, pdf[off:off+10])
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handlePoster(w, httptest.NewRequest("GET", "/api/stations/poster?id=999", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown station, got %d", w.Code)
+	}
+}
+
+func TestPDFString(t *testing.T) {
+	cases := map[string]string{
+		`Jay St–MetroTech`: `Jay St\226MetroTech`,
+		`Bway (Lafayette)`: `Bway \(Lafayette\)`,
+		`Café`:             `Caf\351`,
+		`駅`:                `?`,
+	}
+	for in, want := range cases {
+		if got := pdfString(in); got != want {
+			t.Errorf("pdfString(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+// Protocol buffer responses for high-frequency pollers.
+//
+// With "Accept: application/x-protobuf", nearest (single station), by-id, by-name and
+// bulk answer with the messages in api.proto at the repository root instead of indented
+// JSON. Other endpoints, and responses without a protobuf form, stay JSON. The messages
+// are encoded here with protowire rather than generated code so the build needs no protoc
+// step; field numbers must match api.proto.
+
+import (
+	"math"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const protobufContentType = "application/x-protobuf"
+
+// wantsProtobuf reports whether the request's Accept header asks for protobuf
+func wantsProtobuf(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt := strings.TrimSpace(strings.Split(part, ";")[0])
+		if mt == protobufContentType || mt == "application/protobuf" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeResponse writes v as protobuf when the client asked for it and v has a protobuf
+// form, and as JSON otherwise
+func writeResponse(w http.ResponseWriter, r *http.Request, v any) {
+	varyAccept(w)
+	if wantsProtobuf(r) {
+		var b []byte
+		switch t := v.(type) {
+		case NearestResponse:
+			b = appendNearestPB(nil, t)
+		case BulkResponse:
+			b = appendBulkPB(nil, t)
+		default:
+			writeJSON(w, v)
+			return
+		}
+		w.Header().Set("Content-Type", protobufContentType)
+		w.Header().Set("Cache-Control", "public, max-age=30, stale-while-revalidate=10")
+		w.Write(b)
+		return
+	}
+	writeJSON(w, v)
+}
+
+// varyAccept marks a response as depending on the Accept header
+func varyAccept(w http.ResponseWriter) {
+	for _, v := range w.Header().Values("Vary") {
+		if v == "Accept" {
+			return
+		}
+	}
+	w.Header().Add("Vary", "Accept")
+}
+
+// Field appenders; proto3 omits zero values
+
+func pbString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func pbStrings(b []byte, num protowire.Number, list []string) []byte {
+	for _, s := range list {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendString(b, s)
+	}
+	return b
+}
+
+func pbInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func pbBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+func pbDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+// pbOptionalInt appends an optional int32 field, present even when zero
+func pbOptionalInt(b []byte, num protowire.Number, v *int32) []byte {
+	if v == nil {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(int64(*v)))
+}
+
+// pbOptionalBool appends an optional bool field, present even when false
+func pbOptionalBool(b []byte, num protowire.Number, v *bool) []byte {
+	if v == nil {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, protowire.EncodeBool(*v))
+}
+
+// pbMessage appends an embedded message field
+func pbMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func appendStationPB(b []byte, s Station) []byte {
+	b = pbString(b, 1, s.StopID)
+	b = pbString(b, 2, s.Name)
+	b = pbString(b, 3, s.OfficialName)
+	b = pbString(b, 4, s.DisplayName)
+	b = pbDouble(b, 5, s.Lat)
+	b = pbDouble(b, 6, s.Lon)
+	b = pbStrings(b, 7, s.Routes)
+	b = pbString(b, 8, s.ComplexID)
+	return pbString(b, 9, s.Borough)
+}
+
+func appendDeparturePB(b []byte, d Departure) []byte {
+	b = pbString(b, 1, d.RouteID)
+	b = pbString(b, 2, d.StopID)
+	b = pbString(b, 3, d.Direction)
+	b = pbString(b, 4, d.DirectionLabel)
+	b = pbInt(b, 5, d.UnixTime)
+	b = pbInt(b, 6, d.ETASeconds)
+	b = pbString(b, 7, d.TripID)
+	b = pbString(b, 8, d.HeadSign)
+	b = pbBool(b, 9, d.ShortTurned)
+	b = pbString(b, 10, d.Confidence)
+	b = pbString(b, 11, d.Occupancy)
+	b = pbInt(b, 12, int64(d.CarCount))
+	if d.LeaveInSeconds != nil { // optional: present even when zero
+		b = protowire.AppendTag(b, 13, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(*d.LeaveInSeconds))
+	}
+	for _, a := range d.Annotations {
+		b = pbString(b, 14, a)
+	}
+	b = pbBool(b, 15, d.ETAClamped)
+	b = pbOptionalInt(b, 16, d.DelaySeconds)
+	b = pbOptionalInt(b, 17, d.UncertaintySeconds)
+	b = pbString(b, 18, d.ScheduledTrack)
+	b = pbString(b, 19, d.ActualTrack)
+	b = pbOptionalBool(b, 20, d.IsAssigned)
+	if d.StopsAway != nil {
+		n := int32(*d.StopsAway)
+		b = pbOptionalInt(b, 21, &n)
+	}
+	b = pbString(b, 22, d.CurrentStatus)
+	b = pbString(b, 23, d.CurrentStop)
+	b = pbBool(b, 24, d.Stale)
+	return b
+}
+
+func appendNearestPB(b []byte, resp NearestResponse) []byte {
+	b = pbMessage(b, 1, appendStationPB(nil, resp.Station))
+	for _, d := range resp.Departures {
+		b = pbMessage(b, 2, appendDeparturePB(nil, d))
+	}
+	if resp.Walking != nil {
+		walk := pbDouble(nil, 1, resp.Walking.Seconds)
+		walk = pbDouble(walk, 2, resp.Walking.Distance)
+		b = pbMessage(b, 3, walk)
+	}
+	b = pbBool(b, 4, resp.Partial)
+	b = pbStrings(b, 5, resp.Warnings)
+	for _, a := range resp.Alerts {
+		b = pbString(b, 6, a.ID)
+	}
+	b = pbBool(b, 7, resp.Closure != nil)
+	return pbInt(b, 8, int64(resp.SuggestedRefreshSeconds))
+}
+
+func appendBulkPB(b []byte, resp BulkResponse) []byte {
+	for _, s := range resp.Stations {
+		b = pbMessage(b, 1, appendNearestPB(nil, s))
+	}
+	b = pbStrings(b, 2, resp.NotFound)
+	return pbInt(b, 3, int64(resp.SuggestedRefreshSeconds))
+}
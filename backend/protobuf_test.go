@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// pbFields decodes one message level into field number -> raw values (bytes for
+// length-delimited fields, uint64 for varints and fixed64)
+func pbFields(t *testing.T, b []byte) map[protowire.Number][]any {
+	t.Helper()
+	out := map[protowire.Number][]any{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("bad tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		var v any
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		default:
+			t.Fatalf("unexpected wire type %d for field %d", typ, num)
+		}
+		if n < 0 {
+			t.Fatalf("bad field %d: %v", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+		out[num] = append(out[num], v)
+	}
+	return out
+}
+
+func TestAppendNearestPB(t *testing.T) {
+	leave := int64(0)
+	resp := NearestResponse{
+		Station: Station{StopID: "635", Name: "14 St - Union Sq", Lat: 40.7347, Routes: []string{"4", "6"}},
+		Departures: []Departure{
+			{RouteID: "6", TripID: "a", ETASeconds: 120, CarCount: 10, LeaveInSeconds: &leave},
+			{RouteID: "4", TripID: "b", ETASeconds: 300},
+		},
+		Walking:  &WalkResult{Seconds: 90, Distance: 120},
+		Alerts:   []ServiceAlert{{ID: "alert-1"}},
+		Closure:  &Closure{},
+		Warnings: []string{"L data unavailable"},
+		Partial:  true,
+	}
+	top := pbFields(t, appendNearestPB(nil, resp))
+
+	station := pbFields(t, top[1][0].([]byte))
+	if string(station[1][0].([]byte)) != "635" || math.Float64frombits(station[5][0].(uint64)) != 40.7347 || len(station[7]) != 2 {
+		t.Errorf("unexpected station %v", station)
+	}
+	if _, ok := station[6]; ok {
+		t.Error("zero longitude should be omitted")
+	}
+	if len(top[2]) != 2 {
+		t.Fatalf("expected 2 departures, got %d", len(top[2]))
+	}
+	first := pbFields(t, top[2][0].([]byte))
+	if string(first[7][0].([]byte)) != "a" || first[6][0].(uint64) != 120 || first[12][0].(uint64) != 10 {
+		t.Errorf("unexpected departure %v", first)
+	}
+	if v, ok := first[13]; !ok || v[0].(uint64) != 0 {
+		t.Errorf("leave_in_seconds should be present even when zero, got %v", v)
+	}
+	if _, ok := pbFields(t, top[2][1].([]byte))[13]; ok {
+		t.Error("leave_in_seconds should be absent when unset")
+	}
+	walk := pbFields(t, top[3][0].([]byte))
+	if math.Float64frombits(walk[2][0].(uint64)) != 120 {
+		t.Errorf("unexpected walk %v", walk)
+	}
+	if top[4][0].(uint64) != 1 || string(top[5][0].([]byte)) != "L data unavailable" || string(top[6][0].([]byte)) != "alert-1" || top[7][0].(uint64) != 1 {
+		t.Errorf("unexpected response fields %v", top)
+	}
+}
+
+func TestAPIByIDProtobuf(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "635", Name: "14 St - Union Sq", Lat: 40.7347, Lon: -73.9897}})
+	server := newTestFeedServer(t, testTripUpdate("6", "trip6", []string{"635N"}, []int64{60}))
+	useTestFeeds(t, server.URL)
+
+	req := httptest.NewRequest("GET", "/api/departures/by-id?id=635", nil)
+	req.Header.Set("Accept", "application/x-protobuf, application/json;q=0.5")
+	w := httptest.NewRecorder()
+	handleByID(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-protobuf" {
+		t.Fatalf("expected protobuf, got %q", ct)
+	}
+	top := pbFields(t, w.Body.Bytes())
+	if len(top[2]) != 1 || string(pbFields(t, top[2][0].([]byte))[7][0].([]byte)) != "trip6" {
+		t.Errorf("unexpected departures %v", top[2])
+	}
+
+	// Plain requests still get JSON
+	w = httptest.NewRecorder()
+	handleByID(w, httptest.NewRequest("GET", "/api/departures/by-id?id=635", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" || w.Header().Get("Vary") != "Accept" {
+		t.Errorf("expected JSON varying on Accept, got %q / %q", ct, w.Header().Get("Vary"))
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// ISO/IEC 18004 annex example: "01234567" at version 1-M
+	data := []byte{0x10, 0x20, 0x0C, 0x56, 0x61, 0x80, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11}
+	want := []byte{0xA5, 0x24, 0xD4, 0xC1, 0xED, 0x36, 0xC7, 0x87, 0x2C, 0x55}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("ecc = % X, want % X", got, want)
+	}
+}
+
+func TestQRFormatAndVersionBits(t *testing.T) {
+	want := []int{0x5412, 0x5125, 0x5E7C, 0x5B4B, 0x45F9, 0x40CE, 0x4F97, 0x4AA0}
+	for mask, w := range want {
+		if got := qrFormatBits(mask); got != w {
+			t.Errorf("format bits for M mask %d = %015b, want %015b", mask, got, w)
+		}
+	}
+	if got := qrVersionBits(7); got != 0x07C94 {
+		t.Errorf("version 7 bits = %018b, want %018b", got, 0x07C94)
+	}
+}
+
+// decodeQR reads a matrix back into its byte-mode payload, checking the format
+// information and every block's error correction on the way
+func decodeQR(t *testing.T, q *QRCode) string {
+	t.Helper()
+	version := (q.Size - 17) / 4
+	bit := func(x, y int) int {
+		if q.Modules[y][x] {
+			return 1
+		}
+		return 0
+	}
+	// Format information, first copy around the top-left finder
+	format := 0
+	coords := [][2]int{{8, 0}, {8, 1}, {8, 2}, {8, 3}, {8, 4}, {8, 5}, {8, 7}, {8, 8}, {7, 8}, {5, 8}, {4, 8}, {3, 8}, {2, 8}, {1, 8}, {0, 8}}
+	for i, c := range coords {
+		format |= bit(c[0], c[1]) << i
+	}
+	second := 0
+	for i := 0; i < 8; i++ {
+		second |= bit(q.Size-1-i, 8) << i
+	}
+	for i := 8; i < 15; i++ {
+		second |= bit(8, q.Size-15+i) << i
+	}
+	if format != second {
+		t.Fatalf("format copies differ: %015b vs %015b", format, second)
+	}
+	mask := -1
+	for m := 0; m < 8; m++ {
+		if qrFormatBits(m) == format {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("format bits %015b are not level M", format)
+	}
+	masks := []func(x, y int) bool{
+		func(x, y int) bool { return (x+y)%2 == 0 },
+		func(x, y int) bool { return y%2 == 0 },
+		func(x, y int) bool { return x%3 == 0 },
+		func(x, y int) bool { return (x+y)%3 == 0 },
+		func(x, y int) bool { return (x/3+y/2)%2 == 0 },
+		func(x, y int) bool { return (x*y)%2+(x*y)%3 == 0 },
+		func(x, y int) bool { return ((x*y)%2+(x*y)%3)%2 == 0 },
+		func(x, y int) bool { return ((x+y)%2+(x*y)%3)%2 == 0 },
+	}
+
+	function := newQRCode(version).function
+	var raw []byte
+	var cur byte
+	n := 0
+	for right := q.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = q.Size - 1 - vert
+				}
+				if function[y][x] {
+					continue
+				}
+				v := q.Modules[y][x] != masks[mask](x, y)
+				cur <<= 1
+				if v {
+					cur |= 1
+				}
+				if n++; n%8 == 0 {
+					raw = append(raw, cur)
+				}
+			}
+		}
+	}
+
+	b := qrBlocks[version]
+	count := b.shortBlocks + b.longBlocks
+	blocks := make([][]byte, count)
+	i := 0
+	for k := 0; k < b.longDataLen || k < b.shortData; k++ {
+		for bi := range blocks {
+			if (bi < b.shortBlocks && k < b.shortData) || (bi >= b.shortBlocks && k < b.longDataLen) {
+				blocks[bi] = append(blocks[bi], raw[i])
+				i++
+			}
+		}
+	}
+	ecc := make([][]byte, count)
+	for k := 0; k < b.ecPerBlock; k++ {
+		for bi := range ecc {
+			ecc[bi] = append(ecc[bi], raw[i])
+			i++
+		}
+	}
+	var data []byte
+	for bi := range blocks {
+		if !bytes.Equal(rsRemainder(blocks[bi], rsDivisor(b.ecPerBlock)), ecc[bi]) {
+			t.Fatalf("block %d error correction mismatch", bi)
+		}
+		data = append(data, blocks[bi]...)
+	}
+
+	if data[0]>>4 != 0x4 {
+		t.Fatalf("expected byte mode, got %x", data[0]>>4)
+	}
+	// Re-read as a bit stream after the mode indicator
+	readBits := func(pos, count int) int {
+		v := 0
+		for k := 0; k < count; k++ {
+			v = v<<1 | int(data[(pos+k)/8]>>(7-(pos+k)%8)&1)
+		}
+		return v
+	}
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+	length := readBits(4, countBits)
+	out := make([]byte, length)
+	for k := range out {
+		out[k] = byte(readBits(4+countBits+8*k, 8))
+	}
+	return string(out)
+}
+
+func TestEncodeQRRoundTrip(t *testing.T) {
+	for _, n := range []int{1, 14, 30, 60, 100, 130, 150, 180, 213} {
+		payload := strings.Repeat("https://subway.example/s/635?", 10)[:n]
+		q, err := encodeQR(payload)
+		if err != nil {
+			t.Fatalf("encode %d bytes: %v", n, err)
+		}
+		if q.Size != 17+4*q.version {
+			t.Errorf("size %d does not match version %d", q.Size, q.version)
+		}
+		// Finder pattern corners are dark, separators light
+		for _, c := range [][2]int{{0, 0}, {q.Size - 1, 0}, {0, q.Size - 1}} {
+			if !q.Modules[c[1]][c[0]] {
+				t.Errorf("%d bytes: finder corner %v not dark", n, c)
+			}
+		}
+		if got := decodeQR(t, q); got != payload {
+			t.Errorf("%d bytes (version %d): decoded %q", n, q.version, got)
+		}
+	}
+	if _, err := encodeQR(strings.Repeat("x", 214)); err == nil {
+		t.Error("expected an error beyond version 10 capacity")
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+// Schedule vs realtime reconciliation.
+//
+//   GET /api/stats/reconciliation[?route=<id>]
+//
+// For each route, compares the static trips that should be running right now (their
+// service runs today per the calendar, and the current time falls between their first and
+// last scheduled stop) with the trips in the realtime feeds:
+//
+//   - coverage: the share of scheduled trips that appear in the feeds; the rest are listed
+//     in missing_trips (cancelled, not yet signed on, or missing from the board)
+//   - cancelled_trips: realtime trips marked CANCELED
+//   - unmatched_trips: realtime trips that match no trip scheduled today in the static or
+//     supplemented GTFS, which is how trips.txt/realtime ID drift shows up
+//
+// Realtime trip IDs are matched to static ones the same way headsigns are, by tripIDsMatch:
+// same origin time, route and direction in the NYCT trip ID (see tripids.go). Only the
+// base GTFS has stop times, so only its trips count as scheduled; the supplemented trips
+// help match realtime IDs.
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// Lists in the report are capped so one badly drifted route can't produce megabytes
+const maxReconciliationTrips = 50
+
+// RouteReconciliation is one route's row of the report
+type RouteReconciliation struct {
+	RouteID        string   `json:"route_id"`
+	Scheduled      int      `json:"scheduled"` // static trips that should be running now
+	Observed       int      `json:"observed"`  // realtime trips, excluding cancelled ones
+	Matched        int      `json:"matched"`   // scheduled trips present in realtime
+	Coverage       float64  `json:"coverage"`  // matched / scheduled, 1 when nothing is scheduled
+	MissingTrips   []string `json:"missing_trips,omitempty"`
+	CancelledTrips []string `json:"cancelled_trips,omitempty"`
+	UnmatchedTrips []string `json:"unmatched_trips,omitempty"`
+}
+
+type ReconciliationResponse struct {
+	ServiceDate string                `json:"service_date"`
+	Routes      []RouteReconciliation `json:"routes"`
+	Warnings    []string              `json:"warnings,omitempty"`
+}
+
+// tripSpan is the first and last scheduled second of a trip
+type tripSpan struct {
+	First, Last int32
+}
+
+var tripSpanCache struct {
+	mu    sync.Mutex
+	ix    *stopTimesIndex
+	spans map[string]tripSpan
+}
+
+// tripSpans derives each trip's scheduled span from the stop_times index, once per index
+func tripSpans(ix *stopTimesIndex) map[string]tripSpan {
+	tripSpanCache.mu.Lock()
+	defer tripSpanCache.mu.Unlock()
+	if ix == nil {
+		return nil
+	}
+	if tripSpanCache.ix == ix {
+		return tripSpanCache.spans
+	}
+	byTrip := make([]tripSpan, len(ix.TripIDs))
+	seen := make([]bool, len(ix.TripIDs))
+	for _, deps := range ix.Departures {
+		for _, d := range deps {
+			s := &byTrip[d.Trip]
+			if !seen[d.Trip] || d.Seconds < s.First {
+				s.First = d.Seconds
+			}
+			if !seen[d.Trip] || d.Seconds > s.Last {
+				s.Last = d.Seconds
+			}
+			seen[d.Trip] = true
+		}
+	}
+	spans := make(map[string]tripSpan, len(byTrip))
+	for i, s := range byTrip {
+		if seen[i] {
+			spans[ix.TripIDs[i]] = s
+		}
+	}
+	tripSpanCache.ix, tripSpanCache.spans = ix, spans
+	return spans
+}
+
+// activeTrip reports whether a trip is scheduled to be running at now, on today's or
+// (for trips past midnight) yesterday's service
+func activeTrip(t Trip, span tripSpan, now time.Time) bool {
+	today := serviceDate(now)
+	for _, date := range []time.Time{today.AddDate(0, 0, -1), today} {
+		if !serviceRunsOn(t.ServiceID, date) {
+			continue
+		}
+		if !now.Before(scheduledTime(date, int(span.First))) && now.Before(scheduledTime(date, int(span.Last))) {
+			return true
+		}
+	}
+	return false
+}
+
+// observedTrip is a realtime trip
+type observedTrip struct {
+	TripID    string
+	Cancelled bool
+}
+
+// reconcile builds the report from static trips and realtime trips by route
+func reconcile(base, supplemented []Trip, spans map[string]tripSpan, observed map[string][]observedTrip, now time.Time) []RouteReconciliation {
+	today := serviceDate(now)
+	scheduled := map[string][]string{} // route -> static trip IDs running now
+	runsToday := map[string][]string{} // route -> static trip IDs (base and supplemented) running today
+	for _, t := range base {
+		if span, ok := spans[t.TripID]; ok && activeTrip(t, span, now) {
+			scheduled[t.RouteID] = append(scheduled[t.RouteID], t.TripID)
+		}
+	}
+	for _, list := range [][]Trip{base, supplemented} {
+		for _, t := range list {
+			if serviceRunsOn(t.ServiceID, today) || serviceRunsOn(t.ServiceID, today.AddDate(0, 0, -1)) {
+				runsToday[t.RouteID] = append(runsToday[t.RouteID], t.TripID)
+			}
+		}
+	}
+
+	routeIDs := map[string]bool{}
+	for r := range scheduled {
+		routeIDs[r] = true
+	}
+	for r := range observed {
+		routeIDs[r] = true
+	}
+	var out []RouteReconciliation
+	for r := range routeIDs {
+		row := RouteReconciliation{RouteID: r, Scheduled: len(scheduled[r])}
+		var live []string
+		for _, o := range observed[r] {
+			if o.Cancelled {
+				row.CancelledTrips = append(row.CancelledTrips, o.TripID)
+				continue
+			}
+			live = append(live, o.TripID)
+			if !matchesAnyTrip(runsToday[r], o.TripID) {
+				row.UnmatchedTrips = append(row.UnmatchedTrips, o.TripID)
+			}
+		}
+		row.Observed = len(live)
+		for _, id := range scheduled[r] {
+			if containsTripID(live, id) {
+				row.Matched++
+			} else {
+				row.MissingTrips = append(row.MissingTrips, id)
+			}
+		}
+		row.Coverage = 1
+		if row.Scheduled > 0 {
+			row.Coverage = float64(row.Matched) / float64(row.Scheduled)
+		}
+		for _, list := range []*[]string{&row.MissingTrips, &row.CancelledTrips, &row.UnmatchedTrips} {
+			sort.Strings(*list)
+			if len(*list) > maxReconciliationTrips {
+				*list = (*list)[:maxReconciliationTrips]
+			}
+		}
+		out = append(out, row)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].RouteID < out[j].RouteID })
+	return out
+}
+
+// matchesAnyTrip reports whether a realtime trip ID matches one of the static trip IDs
+func matchesAnyTrip(staticIDs []string, rtID string) bool {
+	for _, id := range staticIDs {
+		if tripIDsMatch(id, rtID) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsTripID reports whether any realtime trip ID matches a static trip ID
+func containsTripID(rtIDs []string, staticID string) bool {
+	for _, rt := range rtIDs {
+		if rt != "" && tripIDsMatch(staticID, rt) {
+			return true
+		}
+	}
+	return false
+}
+
+// observedTrips collects the realtime trips of every feed by route
+func observedTrips(feeds []*gtfs_realtime.FeedMessage) map[string][]observedTrip {
+	out := map[string][]observedTrip{}
+	for _, feed := range feeds {
+		for _, e := range feed.GetEntity() {
+			trip := e.GetTripUpdate().GetTrip()
+			if trip.GetTripId() == "" || trip.GetRouteId() == "" {
+				continue
+			}
+			out[trip.GetRouteId()] = append(out[trip.GetRouteId()], observedTrip{
+				TripID:    trip.GetTripId(),
+				Cancelled: trip.GetScheduleRelationship() == gtfs_realtime.TripDescriptor_CANCELED,
+			})
+		}
+	}
+	return out
+}
+
+func handleReconciliation(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	ds := data()
+	if ds.StopTimes == nil {
+		httpError(w, http.StatusServiceUnavailable, "stop_times index not loaded")
+		return
+	}
+	var feeds []*gtfs_realtime.FeedMessage
+	var warnings []string
+	for _, u := range feedURLs {
+		feed, err := fetchGTFS(u)
+		if err != nil {
+			warnings = append(warnings, "feed "+feedName(u)+" unavailable: "+err.Error())
+			continue
+		}
+		feeds = append(feeds, feed)
+	}
+
+	now := nowFunc()
+	routes := reconcile(ds.Trips, ds.SupplementedTrips, tripSpans(ds.StopTimes), observedTrips(feeds), now)
+	if route := strings.TrimSpace(r.URL.Query().Get("route")); route != "" {
+		var filtered []RouteReconciliation
+		for _, row := range routes {
+			if strings.EqualFold(row.RouteID, route) {
+				filtered = append(filtered, row)
+			}
+		}
+		routes = filtered
+	}
+	writeJSON(w, ReconciliationResponse{ServiceDate: serviceDate(now).Format("2006-01-02"), Routes: routes, Warnings: warnings})
+	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+}
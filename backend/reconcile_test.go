@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+const reconcileStopTimes = `trip_id,arrival_time,departure_time,stop_id,stop_sequence
+W-080000_6..S,08:00:00,08:00:00,601S,1
+W-080000_6..S,08:30:00,08:30:00,640S,2
+W-075000_6..S,07:50:00,07:50:00,601S,1
+W-075000_6..S,08:20:00,08:20:00,640S,2
+W-090000_6..S,09:00:00,09:00:00,601S,1
+W-090000_6..S,09:30:00,09:30:00,640S,2
+S-080000_6..S,08:00:00,08:00:00,601S,1
+S-080000_6..S,08:30:00,08:30:00,640S,2
+`
+
+func TestReconcile(t *testing.T) {
+	keepTestData(t)
+	setTestCalendar(nil)
+	setTestSuppCalendar(nil) // service by name
+
+	ix, err := buildStopTimesIndex(strings.NewReader(reconcileStopTimes), "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := []Trip{
+		{RouteID: "6", TripID: "W-080000_6..S", ServiceID: "Weekday"},
+		{RouteID: "6", TripID: "W-075000_6..S", ServiceID: "Weekday"},
+		{RouteID: "6", TripID: "W-090000_6..S", ServiceID: "Weekday"},
+		{RouteID: "6", TripID: "S-080000_6..S", ServiceID: "Sunday"},
+	}
+	supplemented := []Trip{{RouteID: "6", TripID: "W-083000_6..N", ServiceID: "Weekday"}}
+	observed := map[string][]observedTrip{"6": {
+		{TripID: "080000_6..S"},
+		{TripID: "083000_6..N"}, // only in the supplemented GTFS
+		{TripID: "081500_6..N"}, // in neither
+		{TripID: "077000_6..S", Cancelled: true},
+	}}
+
+	now := time.Date(2026, 10, 16, 8, 5, 0, 0, transitLocation) // a Friday
+	rows := reconcile(base, supplemented, tripSpans(ix), observed, now)
+	want := []RouteReconciliation{{
+		RouteID:        "6",
+		Scheduled:      2,
+		Observed:       3,
+		Matched:        1,
+		Coverage:       0.5,
+		MissingTrips:   []string{"W-075000_6..S"},
+		CancelledTrips: []string{"077000_6..S"},
+		UnmatchedTrips: []string{"081500_6..N"},
+	}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("reconcile =\n%+v\nwant\n%+v", rows, want)
+	}
+
+	// A route in realtime with nothing scheduled is fully covered
+	rows = reconcile(nil, nil, nil, map[string][]observedTrip{"L": {{TripID: "080000_L..N"}}}, now)
+	if len(rows) != 1 || rows[0].Coverage != 1 || len(rows[0].UnmatchedTrips) != 1 {
+		t.Errorf("unexpected rows %+v", rows)
+	}
+}
+
+func TestReconciliationEndpoint(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+	originalNow := nowFunc
+	t.Cleanup(func() {
+		nowFunc = originalNow
+	})
+	setTestCalendar(nil)
+	setTestSuppCalendar(nil)
+
+	setTestStopTimes(nil)
+	w := httptest.NewRecorder()
+	handleReconciliation(w, httptest.NewRequest("GET", "/api/stats/reconciliation", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without a schedule, got %d", w.Code)
+	}
+
+	ix, _ := buildStopTimesIndex(strings.NewReader(reconcileStopTimes), "k")
+	setTestStopTimes(ix)
+	setTestTrips([]Trip{{RouteID: "6", TripID: "W-080000_6..S", ServiceID: "Weekday"}})
+	setTestSupplementedTrips(nil)
+	nowFunc = func() time.Time { return time.Date(2026, 10, 16, 8, 5, 0, 0, transitLocation) }
+	cancelled := testTripUpdate("4", "080000_4..N", []string{"626N"}, []int64{60})
+	cancelled.TripUpdate.Trip.ScheduleRelationship = gtfs_realtime.TripDescriptor_CANCELED.Enum()
+	server := newTestFeedServer(t, testTripUpdate("6", "080000_6..S", []string{"601S"}, []int64{60}), cancelled)
+	useTestFeeds(t, server.URL+"/nyct%2Fgtfs")
+
+	w = httptest.NewRecorder()
+	handleReconciliation(w, httptest.NewRequest("GET", "/api/stats/reconciliation?route=6", nil))
+	var resp ReconciliationResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ServiceDate != "2026-10-16" || len(resp.Routes) != 1 || resp.Routes[0].Coverage != 1 || resp.Routes[0].Matched != 1 {
+		t.Errorf("unexpected response %s", w.Body.String())
+	}
+}
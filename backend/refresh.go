@@ -0,0 +1,93 @@
+package main
+
+// Suggested client refresh intervals.
+//
+// Departure responses carry suggested_refresh_seconds so battery-powered clients can poll
+// as slowly as the board allows instead of hardcoding an interval: a fifteenth of the wait
+// for the soonest train, between 10s (a train is due) and 60s (the next is 15 minutes or
+// more out, or nothing is scheduled). It is never shorter than the cadence of the feeds
+// serving the departures (their poll interval, or their cache TTL without a poller; see
+// feedttl.go), since polling faster than that returns the same data.
+
+import (
+	"strings"
+	"time"
+)
+
+// Bounds of the suggested refresh interval
+const (
+	minSuggestedRefresh = 10 * time.Second
+	maxSuggestedRefresh = 60 * time.Second
+)
+
+// feedCadence is how often feedURL's data can change
+func feedCadence(feedURL string) time.Duration {
+	if pollerInterval > 0 {
+		if d, ok := feedPollInterval(feedURL); ok {
+			return d
+		}
+		return pollerFeedInterval
+	}
+	return feedCacheTTL(feedURL)
+}
+
+// departuresCadence is the shortest cadence of the feeds serving the departures in
+// lists, or the default cadence when none is known
+func departuresCadence(lists [][]Departure) time.Duration {
+	var cadence time.Duration
+	seen := map[string]bool{}
+	for _, deps := range lists {
+		for _, d := range deps {
+			u, ok := routeToFeed[d.RouteID]
+			if !ok {
+				u, ok = routeToFeed[strings.TrimSuffix(d.RouteID, "X")] // express variants
+			}
+			if !ok || seen[u] {
+				continue
+			}
+			seen[u] = true
+			if c := feedCadence(u); cadence == 0 || c < cadence {
+				cadence = c
+			}
+		}
+	}
+	if cadence == 0 {
+		return feedCadence("")
+	}
+	return cadence
+}
+
+// suggestedRefreshSeconds picks a refresh interval from the soonest departure in lists
+func suggestedRefreshSeconds(lists ...[]Departure) int {
+	soonest := int64(-1)
+	for _, deps := range lists {
+		for _, d := range deps {
+			if d.ETASeconds >= 0 && (soonest < 0 || d.ETASeconds < soonest) {
+				soonest = d.ETASeconds
+			}
+		}
+	}
+	refresh := maxSuggestedRefresh
+	if soonest >= 0 {
+		refresh = time.Duration(soonest) * time.Second / 15
+	}
+	if refresh < minSuggestedRefresh {
+		refresh = minSuggestedRefresh
+	}
+	if refresh > maxSuggestedRefresh {
+		refresh = maxSuggestedRefresh
+	}
+	if c := departuresCadence(lists); refresh < c {
+		refresh = c
+	}
+	return int(refresh / time.Second)
+}
+
+// rankedRefreshSeconds is the suggested refresh across a multi-station response
+func rankedRefreshSeconds(ranked []RankedStation) int {
+	lists := make([][]Departure, len(ranked))
+	for i := range ranked {
+		lists[i] = ranked[i].Departures
+	}
+	return suggestedRefreshSeconds(lists...)
+}
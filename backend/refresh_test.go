@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSuggestedRefreshSeconds(t *testing.T) {
+	originalInterval, originalTTL := pollerInterval, appConfig.FeedCacheTTL
+	t.Cleanup(func() { pollerInterval, appConfig.FeedCacheTTL = originalInterval, originalTTL })
+	pollerInterval, appConfig.FeedCacheTTL = 0, Duration(5*time.Second)
+
+	cases := []struct {
+		name string
+		etas []int64
+		want int
+	}{
+		{"train due", []int64{30, 600}, 10},
+		{"next in 9 minutes", []int64{900, 540}, 36},
+		{"next in 20 minutes", []int64{1200}, 60},
+		{"nothing scheduled", nil, 60},
+	}
+	for _, c := range cases {
+		var deps []Departure
+		for _, eta := range c.etas {
+			deps = append(deps, Departure{ETASeconds: eta})
+		}
+		if got := suggestedRefreshSeconds(deps); got != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+
+	// Never faster than the feeds change
+	pollerInterval, pollerFeedInterval = 30*time.Second, 30*time.Second
+	if got := suggestedRefreshSeconds([]Departure{{ETASeconds: 30}}); got != 30 {
+		t.Errorf("with a 30s poller: got %d, want 30", got)
+	}
+	pollerInterval, appConfig.FeedCacheTTL = 0, Duration(45*time.Second)
+	if got := suggestedRefreshSeconds([]Departure{{ETASeconds: 30}}); got != 45 {
+		t.Errorf("with a 45s feed cache: got %d, want 45", got)
+	}
+}
+
+func TestSuggestedRefreshFeedCadence(t *testing.T) {
+	originalInterval, originalFeedInterval, originalConfig := pollerInterval, pollerFeedInterval, appConfig
+	t.Cleanup(func() {
+		pollerInterval, pollerFeedInterval, appConfig = originalInterval, originalFeedInterval, originalConfig
+	})
+	pollerInterval, pollerFeedInterval = 10*time.Second, 20*time.Second
+	appConfig.PollIntervals = map[string]Duration{"gtfs-si": Duration(50 * time.Second), "gtfs-l": Duration(10 * time.Second)}
+
+	// The cadence is that of the feeds serving the departures, not the poller's tick
+	due := func(route string) []Departure { return []Departure{{RouteID: route, ETASeconds: 30}} }
+	for _, c := range []struct {
+		name  string
+		lists [][]Departure
+		want  int
+	}{
+		{"feed with its own interval", [][]Departure{due("SI")}, 50},
+		{"feed on the default interval", [][]Departure{due("A")}, 20},
+		{"shortest of the feeds", [][]Departure{due("SI"), due("L")}, 10},
+		{"express variant", [][]Departure{due("6X")}, 20},
+	} {
+		if got := suggestedRefreshSeconds(c.lists...); got != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+
+	// Without a poller, per-feed cache TTLs
+	pollerInterval = 0
+	appConfig.FeedCacheTTLs = map[string]Duration{"gtfs-si": Duration(40 * time.Second)}
+	if got := suggestedRefreshSeconds(due("SI")); got != 40 {
+		t.Errorf("with a 40s cache for the SIR feed: got %d, want 40", got)
+	}
+}
+
+func TestRankedRefreshSeconds(t *testing.T) {
+	originalInterval, originalTTL := pollerInterval, appConfig.FeedCacheTTL
+	t.Cleanup(func() { pollerInterval, appConfig.FeedCacheTTL = originalInterval, originalTTL })
+	pollerInterval, appConfig.FeedCacheTTL = 0, Duration(time.Second)
+
+	ranked := []RankedStation{
+		{NearestResponse: NearestResponse{Departures: []Departure{{ETASeconds: 1200}}}},
+		{NearestResponse: NearestResponse{Departures: []Departure{{ETASeconds: 300}}}},
+	}
+	if got := rankedRefreshSeconds(ranked); got != 20 {
+		t.Errorf("got %d, want 20", got)
+	}
+}
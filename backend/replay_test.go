@@ -0,0 +1,161 @@
+package main
+
+// Replay harness: serves recorded GTFS-RT feeds and static fixtures from testdata/replay,
+// runs each endpoint through the real mux with a fixed clock, and compares the response
+// body to testdata/replay/golden/<case>.json.
+//
+// Regenerate golden files after an intentional response change with:
+//   go test -run TestReplayGolden -update
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata/replay/golden")
+
+// replayNow is the wall-clock time the fixtures were recorded at
+var replayNow = time.Unix(1760000000, 0)
+
+const replayDir = "testdata/replay"
+
+// loadReplayFeed reads a recorded feed, either raw protobuf (.pb) or text format (.textproto)
+func loadReplayFeed(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read feed %s: %v", path, err)
+	}
+	if filepath.Ext(path) == ".pb" {
+		return data
+	}
+	var msg gtfs_realtime.FeedMessage
+	if err := prototext.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("parse feed %s: %v", path, err)
+	}
+	b, err := proto.Marshal(&msg)
+	if err != nil {
+		t.Fatalf("marshal feed %s: %v", path, err)
+	}
+	return b
+}
+
+// setupReplay installs the fixtures as the server's data; everything is restored on cleanup
+func setupReplay(t *testing.T) {
+	t.Helper()
+	initTestCaches()
+
+	keepTestData(t)
+	originalMTA, originalNow, originalClosures := mtaStationsCSV, nowFunc, closures
+	t.Cleanup(func() {
+		mtaStationsCSV, nowFunc, closures = originalMTA, originalNow, originalClosures
+	})
+
+	nowFunc = func() time.Time { return replayNow }
+	closures = &closureStore{byStop: map[string]Closure{}}
+	setTestSupplementedTrips(nil)
+
+	// No route mapping fixture: every station falls back to all recorded feeds
+	mtaStationsCSV = filepath.Join(replayDir, "no-route-mapping.csv")
+	if err := loadStations(context.Background(), filepath.Join(replayDir, "stations.csv")); err != nil {
+		t.Fatalf("load stations fixture: %v", err)
+	}
+	f, err := os.Open(filepath.Join(replayDir, "trips.txt"))
+	if err != nil {
+		t.Fatalf("open trips fixture: %v", err)
+	}
+	defer f.Close()
+	list, err := parseTrips(f)
+	if err != nil {
+		t.Fatalf("parse trips fixture: %v", err)
+	}
+	setTestTrips(list)
+
+	paths, _ := filepath.Glob(filepath.Join(replayDir, "feeds", "*"))
+	feeds := map[string][]byte{}
+	var names []string
+	for _, p := range paths {
+		name := strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+		feeds["/"+name] = loadReplayFeed(t, p)
+		names = append(names, name)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := feeds[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+
+	var urls []string
+	for _, name := range names {
+		urls = append(urls, server.URL+"/"+name)
+	}
+	useTestFeeds(t, urls...)
+	useTestOSRM(t, 180, 220)
+}
+
+var replayCases = []struct {
+	name   string
+	path   string
+	status int
+}{
+	{"stops", "/api/stops", http.StatusOK},
+	{"stops_raw", "/api/stops?view=raw", http.StatusOK},
+	{"nearest_union_sq", "/api/departures/nearest?lat=40.7347&lon=-73.9899", http.StatusOK},
+	{"by_id_635", "/api/departures/by-id?id=635", http.StatusOK},
+	{"by_id_l03", "/api/departures/by-id?id=L03", http.StatusOK},
+	{"by_name_union_sq", "/api/departures/by-name?name=14%20St%20-%20Union%20Sq", http.StatusOK},
+	{"nearest_multi", "/api/departures/nearest-multi?lat=40.7347&lon=-73.9899&count=4", http.StatusOK},
+	{"nearest_outside_nyc", "/api/departures/nearest?lat=34.0522&lon=-118.2437", http.StatusBadRequest},
+	{"closures", "/api/closures", http.StatusOK},
+}
+
+func TestReplayGolden(t *testing.T) {
+	setupReplay(t)
+	mux := newMux()
+
+	for _, tc := range replayCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Departure responses are cached per feed, so each case starts from the same state
+			initTestCaches()
+			req := httptest.NewRequest("GET", tc.path, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tc.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tc.status, w.Body.String())
+			}
+
+			goldenPath := filepath.Join(replayDir, "golden", tc.name+".json")
+			if *update {
+				if err := os.WriteFile(goldenPath, w.Body.Bytes(), 0o644); err != nil {
+					t.Fatalf("write golden: %v", err)
+				}
+				return
+			}
+			want, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatalf("read golden (run with -update to create it): %v", err)
+			}
+			if !bytes.Equal(w.Body.Bytes(), want) {
+				t.Errorf("response differs from %s (run with -update if intended)\n--- got ---\n%s\n--- want ---\n%s",
+					goldenPath, w.Body.String(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+// Subway route metadata from GTFS routes.txt, for rendering line bullets.
+//
+//   GET /api/routes                  every route with its names and colors
+//   GET /api/routes/{id}/stations     stations in calling order for direction_id 0 and 1
+//   GET /api/routes/{id}/shape        the route drawn on a map, see shapes.go
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Route is one line from routes.txt. Colors are hex without a leading '#', as in GTFS.
+type Route struct {
+	ID        string `json:"route_id"`
+	ShortName string `json:"short_name,omitempty"`
+	LongName  string `json:"long_name"`
+	Color     string `json:"color,omitempty"`
+	TextColor string `json:"text_color,omitempty"`
+	sortOrder int
+}
+
+// routes is sorted by route_sort_order, then route ID
+var routes []Route
+
+// parseRoutes reads routes.txt
+func parseRoutes(rd io.Reader) ([]Route, error) {
+	r := csv.NewReader(rd)
+	r.FieldsPerRecord = -1
+
+	need := []string{"route_id", "route_long_name"}
+	idx, err := parseCSVHeaders(r, need, "routes")
+	if err != nil {
+		return nil, err
+	}
+	optional := func(row []string, name string) string {
+		if i, ok := idx[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	var out []Route
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read routes row: %w", err)
+		}
+		rt := Route{
+			ID:        row[idx["route_id"]],
+			ShortName: optional(row, "route_short_name"),
+			LongName:  row[idx["route_long_name"]],
+			Color:     optional(row, "route_color"),
+			TextColor: optional(row, "route_text_color"),
+		}
+		rt.sortOrder, _ = strconv.Atoi(optional(row, "route_sort_order"))
+		out = append(out, rt)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].sortOrder != out[j].sortOrder {
+			return out[i].sortOrder < out[j].sortOrder
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
+
+// loadRoutes reads routes.txt from an open GTFS zip
+func loadRoutes(zf *gtfsZip) error {
+	rc, err := zf.openMember("routes.txt")
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	out, err := parseRoutes(rc)
+	if err != nil {
+		return err
+	}
+	routes = out
+	log.Printf("Loaded %d routes", len(out))
+	return nil
+}
+
+func handleRoutes(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	if len(routes) == 0 {
+		httpError(w, http.StatusServiceUnavailable, "route data not loaded")
+		return
+	}
+	writeJSON(w, routes)
+	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+}
+
+// RouteStations is the /api/routes/{id}/stations response. Directions maps GTFS
+// direction_id ("0", "1") to the route's stations in calling order.
+type RouteStations struct {
+	RouteID    string               `json:"route_id"`
+	Directions map[string][]Station `json:"directions"`
+}
+
+// handleRouteResource serves the per-route paths under /api/routes/
+func handleRouteResource(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/shape") {
+		handleRouteShape(w, r)
+		return
+	}
+	handleRouteStations(w, r)
+}
+
+func handleRouteStations(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	rest := strings.TrimPrefix(r.URL.Path, "/api/routes/")
+	id := strings.TrimSuffix(rest, "/stations")
+	if id == rest || id == "" || strings.Contains(id, "/") {
+		httpError(w, http.StatusNotFound, "not found")
+		return
+	}
+	ds := data()
+	orders, ok := ds.RouteStopOrders[id]
+	if !ok {
+		orders, ok = ds.RouteStopOrders[strings.ToUpper(id)]
+	}
+	if !ok {
+		httpError(w, http.StatusNotFound, "unknown route")
+		return
+	}
+
+	byBase := make(map[string]Station, len(ds.Stations))
+	for _, s := range ds.Stations {
+		byBase[parentStopID(s.StopID)] = s
+	}
+	resp := RouteStations{RouteID: id, Directions: map[string][]Station{}}
+	for dir, stops := range orders {
+		list := make([]Station, 0, len(stops))
+		for _, stop := range stops {
+			s, ok := byBase[stop]
+			if !ok {
+				s = Station{StopID: stop} // stop missing from the stations CSV; keep the ID
+			}
+			list = append(list, s)
+		}
+		resp.Directions[dir] = list
+	}
+	writeJSON(w, resp)
+	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testRoutesTxt = `agency_id,route_id,route_short_name,route_long_name,route_type,route_desc,route_url,route_color,route_text_color,route_sort_order
+MTA NYCT,Q,Q,Broadway Express,1,,,F6BC26,000000,16
+MTA NYCT,1,1,Broadway - 7 Avenue Local,1,,,EE352E,FFFFFF,1
+MTA NYCT,GS,S,42 St Shuttle,1,,,808183,FFFFFF,
+`
+
+func TestParseRoutes(t *testing.T) {
+	out, err := parseRoutes(strings.NewReader(testRoutesTxt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 3 {
+		t.Fatalf("expected 3 routes, got %d", len(out))
+	}
+	// route_sort_order first (missing sorts as 0), then route ID
+	if out[0].ID != "GS" || out[1].ID != "1" || out[2].ID != "Q" {
+		t.Errorf("unexpected order %v", out)
+	}
+	if out[1].LongName != "Broadway - 7 Avenue Local" || out[1].Color != "EE352E" || out[1].TextColor != "FFFFFF" {
+		t.Errorf("unexpected route %+v", out[1])
+	}
+
+	if _, err := parseRoutes(strings.NewReader("route_id,route_color\nQ,F6BC26\n")); err == nil {
+		t.Error("expected error when route_long_name is missing")
+	}
+}
+
+func TestAPIRoutes(t *testing.T) {
+	original := routes
+	defer func() { routes = original }()
+
+	routes = nil
+	w := httptest.NewRecorder()
+	handleRoutes(w, httptest.NewRequest("GET", "/api/routes", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 before routes load, got %d", w.Code)
+	}
+
+	routes, _ = parseRoutes(strings.NewReader(testRoutesTxt))
+	w = httptest.NewRecorder()
+	handleRoutes(w, httptest.NewRequest("GET", "/api/routes", nil))
+	var got []Route
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(got) != 3 || got[2].Color != "F6BC26" {
+		t.Errorf("unexpected response %d %+v", w.Code, got)
+	}
+}
+
+func TestRouteStations(t *testing.T) {
+	stopTimesCSV := `trip_id,arrival_time,departure_time,stop_id,stop_sequence
+Q-N-EXP,08:00:00,08:00:00,D43N,1
+Q-N-EXP,08:30:00,08:30:00,R20N,2
+Q-N,09:00:00,09:00:00,D43N,1
+Q-N,09:20:00,09:20:00,Q01N,2
+Q-N,09:40:00,09:40:00,R20N,3
+Q-S,10:00:00,10:00:00,R20S,1
+Q-S,10:30:00,10:30:00,D43S,2
+`
+	ix, err := buildStopTimesIndex(strings.NewReader(stopTimesCSV), "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := []Trip{
+		{RouteID: "Q", TripID: "Q-N-EXP", DirectionID: "0"},
+		{RouteID: "Q", TripID: "Q-N", DirectionID: "0"},
+		{RouteID: "Q", TripID: "Q-S", DirectionID: "1"},
+	}
+
+	keepTestData(t)
+	setTestRouteStopOrders(buildRouteStopOrders(list, ix))
+	setTestStations([]Station{
+		{StopID: "D43", Name: "Coney Island-Stillwell Av"},
+		{StopID: "R20", Name: "14 St-Union Sq"},
+	})
+
+	// The longest pattern wins for each direction
+	if got := strings.Join(data().RouteStopOrders["Q"]["0"], ","); got != "D43,Q01,R20" {
+		t.Errorf("direction 0 order = %s, want D43,Q01,R20", got)
+	}
+
+	w := httptest.NewRecorder()
+	handleRouteStations(w, httptest.NewRequest("GET", "/api/routes/q/stations", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp RouteStations
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	dir1 := resp.Directions["1"]
+	if len(dir1) != 2 || dir1[0].Name != "14 St-Union Sq" || dir1[1].Name != "Coney Island-Stillwell Av" {
+		t.Errorf("unexpected direction 1 stations %+v", dir1)
+	}
+	if d0 := resp.Directions["0"]; len(d0) != 3 || d0[1].StopID != "Q01" {
+		t.Errorf("stops missing from stations CSV should keep their ID, got %+v", d0)
+	}
+
+	for _, path := range []string{"/api/routes/Z/stations", "/api/routes/Q", "/api/routes/Q/stops"} {
+		w = httptest.NewRecorder()
+		handleRouteStations(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, w.Code)
+		}
+	}
+}
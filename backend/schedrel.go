@@ -0,0 +1,27 @@
+package main
+
+// Schedule relationships.
+//
+// During reroutes the feeds keep trips and stops the train will not serve, marked rather
+// than removed: a trip as CANCELED, a stop time update as SKIPPED (the train runs through
+// without stopping, e.g. switched to the express track) or NO_DATA (no prediction is
+// available; any times are placeholders). None of these is a departure a rider can catch,
+// so departuresFromSource drops them, and a trip's last stop is the last one it serves.
+
+import (
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// tripCanceled reports whether a trip update is for a trip that won't run
+func tripCanceled(tu *gtfs_realtime.TripUpdate) bool {
+	return tu.GetTrip().GetScheduleRelationship() == gtfs_realtime.TripDescriptor_CANCELED
+}
+
+// stopServed reports whether a stop time update predicts a real stop
+func stopServed(stu *gtfs_realtime.TripUpdate_StopTimeUpdate) bool {
+	switch stu.GetScheduleRelationship() {
+	case gtfs_realtime.TripUpdate_StopTimeUpdate_SKIPPED, gtfs_realtime.TripUpdate_StopTimeUpdate_NO_DATA:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+func TestDeparturesHonorScheduleRelationship(t *testing.T) {
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "L08", Name: "Bedford Av", Routes: []string{"L"}}})
+
+	running := testTripUpdate("L", "running", []string{"L08N", "L06N", "L03N"}, []int64{60, 120, 180})
+	running.TripUpdate.StopTimeUpdate[2].ScheduleRelationship = gtfs_realtime.TripUpdate_StopTimeUpdate_SKIPPED.Enum()
+	cancelled := testTripUpdate("L", "cancelled", []string{"L08N"}, []int64{90})
+	cancelled.TripUpdate.Trip.ScheduleRelationship = gtfs_realtime.TripDescriptor_CANCELED.Enum()
+	skipped := testTripUpdate("L", "skipped", []string{"L08N", "L06N"}, []int64{100, 160})
+	skipped.TripUpdate.StopTimeUpdate[0].ScheduleRelationship = gtfs_realtime.TripUpdate_StopTimeUpdate_SKIPPED.Enum()
+	noData := testTripUpdate("L", "nodata", []string{"L08N"}, []int64{110})
+	noData.TripUpdate.StopTimeUpdate[0].ScheduleRelationship = gtfs_realtime.TripUpdate_StopTimeUpdate_NO_DATA.Enum()
+
+	feed := newTestFeed(running, cancelled, skipped, noData)
+	deps, err := departuresFromSource(data().Stations[0], departureFilter{}, func(string) (*gtfs_realtime.FeedMessage, error) { return feed, nil })
+	if err != nil || len(deps) != 1 || deps[0].TripID != "running" {
+		t.Fatalf("expected only the running trip, got %+v (%v)", deps, err)
+	}
+	if deps[0].LastStopID != "L06N" {
+		t.Errorf("expected the last stop served, L06N, got %q", deps[0].LastStopID)
+	}
+}
@@ -0,0 +1,499 @@
+package main
+
+// Static schedule index built from stop_times.txt.
+//
+// stop_times.txt is ~2M rows, so we never materialize it. A single streaming pass keeps
+// only what the schedule features need:
+//   - trip -> terminal stop (highest stop_sequence), for headsign/short-turn checks
+//   - stop -> scheduled departures (trip + seconds after service-day midnight)
+// Trip IDs are interned so each departure costs 8 bytes. The finished index can be
+// persisted with gob and is reused as long as the zip member's CRC and size match.
+
+import (
+	"encoding/csv"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // containers often ship without a zoneinfo database
+)
+
+// stopTimesIndex is the compact schedule index derived from stop_times.txt
+type stopTimesIndex struct {
+	Key        string                    // source fingerprint (crc32/size of stop_times.txt)
+	TripIDs    []string                  // interned trip IDs
+	Terminals  []string                  // terminal stop ID per interned trip
+	Departures map[string][]scheduledDep // parent stop ID -> departures sorted by Seconds
+	tripIndex  map[string]int32
+}
+
+// scheduledDep is one scheduled departure at a stop
+type scheduledDep struct {
+	Trip    int32 // index into TripIDs
+	Seconds int32 // seconds after service-day midnight (may exceed 24h)
+}
+
+// stopTimesCachePath is where the built index is persisted (empty disables caching)
+var stopTimesCachePath = ""
+
+// transitLocation is the agency timezone all GTFS times are expressed in
+var transitLocation = mustLoadLocation("America/New_York")
+
+// serviceDayRollover is the local time before which the previous day's service is still
+// running. Late-night trips are scheduled as 24:xx-27:xx on the previous service day.
+const serviceDayRollover = 4 * time.Hour
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+// serviceDate returns the service day (midnight, America/New_York) that t belongs to
+func serviceDate(t time.Time) time.Time {
+	local := t.In(transitLocation)
+	y, m, d := local.Date()
+	date := time.Date(y, m, d, 0, 0, 0, 0, transitLocation)
+	if local.Sub(serviceDayStart(date)) < serviceDayRollover {
+		date = date.AddDate(0, 0, -1)
+	}
+	return date
+}
+
+// serviceDayStart is the reference point GTFS times are measured from: "noon minus 12h"
+// on the service date. On DST transition days this differs from local midnight by an
+// hour, which is exactly what the GTFS spec requires.
+func serviceDayStart(date time.Time) time.Time {
+	y, m, d := date.Date()
+	return time.Date(y, m, d, 12, 0, 0, 0, transitLocation).Add(-12 * time.Hour)
+}
+
+// serviceDayName maps a service date to the coarse MTA service IDs (Weekday/Saturday/Sunday)
+func serviceDayName(date time.Time) string {
+	switch date.Weekday() {
+	case time.Sunday:
+		return "Sunday"
+	case time.Saturday:
+		return "Saturday"
+	default:
+		return "Weekday"
+	}
+}
+
+// scheduledTime converts GTFS seconds-since-service-day-start to an absolute time
+func scheduledTime(date time.Time, secs int) time.Time {
+	return serviceDayStart(date).Add(time.Duration(secs) * time.Second)
+}
+
+// ScheduledDeparture is a static-schedule departure resolved to an absolute time
+type ScheduledDeparture struct {
+	TripID string
+	Time   time.Time
+}
+
+// scheduledDepartures returns scheduled departures at a stop in [from, from+window),
+// considering both the current and the previous service day so trips scheduled past
+// 24:00:00 are found after midnight. runsOn reports whether a static trip operates on
+// a given service date.
+func (ix *stopTimesIndex) scheduledDepartures(stopID string, from time.Time, window time.Duration, runsOn func(tripID string, date time.Time) bool) []ScheduledDeparture {
+	if ix == nil {
+		return nil
+	}
+	today := serviceDate(from)
+	var out []ScheduledDeparture
+	for _, date := range []time.Time{today.AddDate(0, 0, -1), today} {
+		start := serviceDayStart(date)
+		fromSec := int(from.Sub(start) / time.Second)
+		toSec := int(from.Add(window).Sub(start)/time.Second) - 1
+		for _, d := range ix.departuresAt(stopID, fromSec, toSec) {
+			tripID := ix.TripIDs[d.Trip]
+			if runsOn != nil && !runsOn(tripID, date) {
+				continue
+			}
+			out = append(out, ScheduledDeparture{TripID: tripID, Time: scheduledTime(date, int(d.Seconds))})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out
+}
+
+func indexTripServices(list []Trip) map[string]string {
+	out := make(map[string]string, len(list))
+	for _, t := range list {
+		out[t.TripID] = t.ServiceID
+	}
+	return out
+}
+
+// tripRunsOn reports whether a static trip's service operates on a service date, from the
+// service calendar (see calendar.go). Unknown trips are assumed to run.
+func tripRunsOn(tripID string, date time.Time) bool {
+	service, ok := data().TripServices[tripID]
+	if !ok {
+		return true
+	}
+	return serviceRunsOn(service, date)
+}
+
+// routePattern is a distinct service variant of a route, identified by where it terminates
+// (e.g. 5 trains ending at Bowling Green vs Flatbush Av)
+type routePattern struct {
+	RouteID     string
+	DirectionID string
+	Terminal    string // parent stop ID
+	Headsign    string // most common trip_headsign among the pattern's trips
+	Trips       int
+}
+
+// detectRoutePatterns groups static trips by route and terminal stop and picks the
+// dominant headsign for each group
+func detectRoutePatterns(list []Trip, ix *stopTimesIndex) map[string]map[string]routePattern {
+	type key struct{ route, terminal string }
+	headsignCounts := map[key]map[string]int{}
+	directions := map[key]string{}
+	for _, t := range list {
+		term, ok := ix.terminalStop(t.TripID)
+		if !ok || t.TripHeadsign == "" {
+			continue
+		}
+		k := key{t.RouteID, parentStopID(term)}
+		if headsignCounts[k] == nil {
+			headsignCounts[k] = map[string]int{}
+		}
+		headsignCounts[k][t.TripHeadsign]++
+		directions[k] = t.DirectionID
+	}
+
+	out := map[string]map[string]routePattern{}
+	for k, counts := range headsignCounts {
+		best, bestN, total := "", 0, 0
+		for hs, n := range counts {
+			total += n
+			if n > bestN || (n == bestN && hs < best) {
+				best, bestN = hs, n
+			}
+		}
+		if out[k.route] == nil {
+			out[k.route] = map[string]routePattern{}
+		}
+		out[k.route][k.terminal] = routePattern{
+			RouteID: k.route, DirectionID: directions[k], Terminal: k.terminal, Headsign: best, Trips: total,
+		}
+	}
+	n := 0
+	for _, m := range out {
+		n += len(m)
+	}
+	log.Printf("Detected %d route patterns across %d routes", n, len(out))
+	return out
+}
+
+// patternHeadsign returns the variant headsign for a realtime trip of routeID whose last
+// reported stop is lastStopID, or "" when no static pattern ends there
+func patternHeadsign(routeID, lastStopID string) string {
+	if lastStopID == "" {
+		return ""
+	}
+	return data().RoutePatterns[routeID][parentStopID(lastStopID)].Headsign
+}
+
+// buildRouteStopOrders picks, for each route and direction, the static trip calling at the
+// most stops and returns its stops in calling order. That is the full local pattern on
+// most lines; for branching lines (A, 5) it is the longest branch.
+func buildRouteStopOrders(list []Trip, ix *stopTimesIndex) map[string]map[string][]string {
+	if ix == nil {
+		return nil
+	}
+	stopCounts := make([]int, len(ix.TripIDs))
+	for _, deps := range ix.Departures {
+		for _, d := range deps {
+			stopCounts[d.Trip]++
+		}
+	}
+
+	type key struct{ route, dir string }
+	best := map[key]int32{}
+	for _, t := range list {
+		i, ok := ix.tripIndex[t.TripID]
+		if !ok {
+			continue
+		}
+		k := key{t.RouteID, t.DirectionID}
+		if cur, seen := best[k]; !seen || stopCounts[i] > stopCounts[cur] {
+			best[k] = i
+		}
+	}
+
+	type call struct {
+		stop string
+		secs int32
+	}
+	calls := map[int32][]call{}
+	for _, i := range best {
+		calls[i] = nil
+	}
+	for stop, deps := range ix.Departures {
+		for _, d := range deps {
+			if _, ok := calls[d.Trip]; ok {
+				calls[d.Trip] = append(calls[d.Trip], call{stop, d.Seconds})
+			}
+		}
+	}
+
+	out := map[string]map[string][]string{}
+	for k, i := range best {
+		cs := calls[i]
+		sort.Slice(cs, func(a, b int) bool { return cs[a].secs < cs[b].secs })
+		stops := make([]string, len(cs))
+		for j, c := range cs {
+			stops[j] = c.stop
+		}
+		if out[k.route] == nil {
+			out[k.route] = map[string][]string{}
+		}
+		out[k.route][k.dir] = stops
+	}
+	return out
+}
+
+// parseGTFSTime parses an HH:MM:SS stop time. Hours may exceed 23 for trips that run
+// past midnight of their service day.
+func parseGTFSTime(s string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid GTFS time %q", s)
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	sec, err3 := strconv.Atoi(parts[2])
+	if err1 != nil || err2 != nil || err3 != nil || m > 59 || sec > 59 || h < 0 {
+		return 0, fmt.Errorf("invalid GTFS time %q", s)
+	}
+	return h*3600 + m*60 + sec, nil
+}
+
+// buildStopTimesIndex streams stop_times.txt once and builds the index
+func buildStopTimesIndex(rd io.Reader, key string) (*stopTimesIndex, error) {
+	start := time.Now()
+	r := csv.NewReader(rd)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+
+	need := []string{"trip_id", "stop_id", "stop_sequence", "departure_time"}
+	idx, err := parseCSVHeaders(r, need, "stop_times")
+	if err != nil {
+		return nil, err
+	}
+	arrivalIdx, hasArrival := idx["arrival_time"]
+
+	ix := &stopTimesIndex{
+		Key:        key,
+		Departures: make(map[string][]scheduledDep),
+		tripIndex:  make(map[string]int32),
+	}
+	lastSeq := []int{}
+	rows := 0
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read stop_times row: %w", err)
+		}
+		rows++
+
+		tripID := row[idx["trip_id"]]
+		t, ok := ix.tripIndex[tripID]
+		if !ok {
+			t = int32(len(ix.TripIDs))
+			// Copy so the index does not pin the reader's line buffers
+			ix.TripIDs = append(ix.TripIDs, cloneString(tripID))
+			ix.Terminals = append(ix.Terminals, "")
+			lastSeq = append(lastSeq, -1)
+			ix.tripIndex[ix.TripIDs[t]] = t
+		}
+
+		stopID := row[idx["stop_id"]]
+		seq, _ := strconv.Atoi(row[idx["stop_sequence"]])
+		if seq > lastSeq[t] {
+			lastSeq[t] = seq
+			ix.Terminals[t] = cloneString(stopID)
+		}
+
+		timeStr := row[idx["departure_time"]]
+		if timeStr == "" && hasArrival {
+			timeStr = row[arrivalIdx]
+		}
+		secs, err := parseGTFSTime(timeStr)
+		if err != nil {
+			continue
+		}
+		base := parentStopID(stopID)
+		ix.Departures[base] = append(ix.Departures[base], scheduledDep{Trip: t, Seconds: int32(secs)})
+	}
+
+	for stop := range ix.Departures {
+		deps := ix.Departures[stop]
+		sort.Slice(deps, func(i, j int) bool { return deps[i].Seconds < deps[j].Seconds })
+	}
+	log.Printf("Indexed %d stop_times rows (%d trips, %d stops) in %.2f ms",
+		rows, len(ix.TripIDs), len(ix.Departures), float64(time.Since(start).Microseconds())/1000.0)
+	return ix, nil
+}
+
+// cloneString copies s so it doesn't pin the CSV reader's line buffer
+func cloneString(s string) string {
+	return string([]byte(s))
+}
+
+// terminalStop returns the scheduled last stop ID of a static trip
+func (ix *stopTimesIndex) terminalStop(tripID string) (string, bool) {
+	if ix == nil {
+		return "", false
+	}
+	t, ok := ix.tripIndex[tripID]
+	if !ok {
+		return "", false
+	}
+	return ix.Terminals[t], true
+}
+
+// callsAt reports whether a static trip is scheduled to stop at stopID (any platform)
+func (ix *stopTimesIndex) callsAt(tripID, stopID string) bool {
+	if ix == nil {
+		return false
+	}
+	t, ok := ix.tripIndex[tripID]
+	if !ok {
+		return false
+	}
+	for _, d := range ix.Departures[parentStopID(stopID)] {
+		if d.Trip == t {
+			return true
+		}
+	}
+	return false
+}
+
+// isShortTurn reports whether a realtime trip ends before its scheduled terminal: the
+// static trip calls at the realtime last stop but is scheduled to continue past it.
+// Trips extended beyond their terminal or rerouted off their pattern are not short turns.
+func (ix *stopTimesIndex) isShortTurn(staticTripID, rtLastStopID string) bool {
+	if rtLastStopID == "" {
+		return false
+	}
+	term, ok := ix.terminalStop(staticTripID)
+	if !ok || parentStopID(term) == parentStopID(rtLastStopID) {
+		return false
+	}
+	return ix.callsAt(staticTripID, rtLastStopID)
+}
+
+// departuresAt returns scheduled departures at a stop between fromSec and toSec
+// (seconds after service-day midnight)
+func (ix *stopTimesIndex) departuresAt(stopID string, fromSec, toSec int) []scheduledDep {
+	if ix == nil {
+		return nil
+	}
+	deps := ix.Departures[parentStopID(stopID)]
+	i := sort.Search(len(deps), func(i int) bool { return int(deps[i].Seconds) >= fromSec })
+	j := sort.Search(len(deps), func(i int) bool { return int(deps[i].Seconds) > toSec })
+	if i >= j {
+		return nil
+	}
+	return deps[i:j]
+}
+
+// loadStopTimesIndex builds the index from an open GTFS zip, reusing the on-disk cache
+// when it was built from the same stop_times.txt.
+func loadStopTimesIndex(zf *gtfsZip) (*stopTimesIndex, error) {
+	var key string
+	for _, f := range zf.File {
+		if f.Name == "stop_times.txt" {
+			key = fmt.Sprintf("%08x-%d", f.CRC32, f.UncompressedSize64)
+			break
+		}
+	}
+	if key == "" {
+		return nil, fmt.Errorf("stop_times.txt not found in GTFS zip")
+	}
+
+	if ix, err := readStopTimesCache(stopTimesCachePath, key); err == nil {
+		log.Printf("Loaded stop_times index from cache %s", stopTimesCachePath)
+		return ix, nil
+	} else if stopTimesCachePath != "" {
+		log.Printf("stop_times index cache unavailable (%v), rebuilding", err)
+	}
+
+	rc, err := zf.openMember("stop_times.txt")
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	ix, err := buildStopTimesIndex(rc, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeStopTimesCache(stopTimesCachePath, ix); err != nil {
+		log.Printf("Warning: failed to write stop_times index cache: %v", err)
+	}
+	return ix, nil
+}
+
+func readStopTimesCache(path, key string) (*stopTimesIndex, error) {
+	if path == "" {
+		return nil, fmt.Errorf("cache disabled")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ix stopTimesIndex
+	if err := gob.NewDecoder(f).Decode(&ix); err != nil {
+		return nil, err
+	}
+	if ix.Key != key {
+		return nil, fmt.Errorf("cache built from a different stop_times.txt")
+	}
+	ix.rebuildTripIndex()
+	return &ix, nil
+}
+
+// rebuildTripIndex restores the unexported trip lookup after gob decoding
+func (ix *stopTimesIndex) rebuildTripIndex() {
+	ix.tripIndex = make(map[string]int32, len(ix.TripIDs))
+	for i, id := range ix.TripIDs {
+		ix.tripIndex[id] = int32(i)
+	}
+}
+
+// writeStopTimesCache persists the index atomically (write temp file, then rename)
+func writeStopTimesCache(path string, ix *stopTimesIndex) error {
+	if path == "" {
+		return nil
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(ix); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
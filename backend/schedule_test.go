@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+const testStopTimes = `trip_id,arrival_time,departure_time,stop_id,stop_sequence
+T1,08:00:00,08:00:00,601S,1
+T1,08:05:00,08:05:30,635S,2
+T1,08:20:00,08:20:00,640S,3
+T2,24:10:00,24:10:00,601S,1
+T2,24:15:00,,635S,2
+`
+
+func TestParseGTFSTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		ok   bool
+	}{
+		{"00:00:00", 0, true},
+		{"08:05:30", 8*3600 + 5*60 + 30, true},
+		{"25:01:00", 25*3600 + 60, true}, // after midnight of the service day
+		{" 7:00:00", 7 * 3600, true},
+		{"8:61:00", 0, false},
+		{"", 0, false},
+		{"12:00", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseGTFSTime(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseGTFSTime(%q) = %d, %v; want %d, ok=%v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestBuildStopTimesIndex(t *testing.T) {
+	ix, err := buildStopTimesIndex(strings.NewReader(testStopTimes), "k1")
+	if err != nil {
+		t.Fatalf("buildStopTimesIndex failed: %v", err)
+	}
+	if term, ok := ix.terminalStop("T1"); !ok || term != "640S" {
+		t.Errorf("terminal for T1 = %q, %v; want 640S", term, ok)
+	}
+	if term, _ := ix.terminalStop("T2"); term != "635S" {
+		t.Errorf("terminal for T2 = %q, want 635S", term)
+	}
+	if _, ok := ix.terminalStop("nope"); ok {
+		t.Error("unknown trip should not have a terminal")
+	}
+
+	// Both platforms of 635 are indexed under the parent stop, ordered by time,
+	// and arrival_time is used when departure_time is blank.
+	deps := ix.departuresAt("635N", 0, 30*3600)
+	if len(deps) != 2 {
+		t.Fatalf("expected 2 departures at 635, got %d", len(deps))
+	}
+	if deps[0].Seconds != 8*3600+5*60+30 || deps[1].Seconds != 24*3600+15*60 {
+		t.Errorf("unexpected departure times %+v", deps)
+	}
+	if got := ix.departuresAt("635", 9*3600, 10*3600); len(got) != 0 {
+		t.Errorf("expected no departures in window, got %d", len(got))
+	}
+
+	var nilIndex *stopTimesIndex
+	if deps := nilIndex.departuresAt("635", 0, 1); deps != nil {
+		t.Error("nil index should return no departures")
+	}
+}
+
+func TestStopTimesIndexCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stop_times.gob")
+	ix, err := buildStopTimesIndex(strings.NewReader(testStopTimes), "k1")
+	if err != nil {
+		t.Fatalf("buildStopTimesIndex failed: %v", err)
+	}
+	if err := writeStopTimesCache(path, ix); err != nil {
+		t.Fatalf("writeStopTimesCache failed: %v", err)
+	}
+
+	cached, err := readStopTimesCache(path, "k1")
+	if err != nil {
+		t.Fatalf("readStopTimesCache failed: %v", err)
+	}
+	if term, ok := cached.terminalStop("T1"); !ok || term != "640S" {
+		t.Errorf("cached terminal for T1 = %q, %v", term, ok)
+	}
+
+	// A different source fingerprint invalidates the cache
+	if _, err := readStopTimesCache(path, "k2"); err == nil {
+		t.Error("expected stale cache to be rejected")
+	}
+}
+
+func TestLoadStopTimesIndexFromZip(t *testing.T) {
+	server := newTestGTFSServer(t, map[string]string{"stop_times.txt": testStopTimes})
+	defer server.Close()
+
+	zf, err := openGTFSZip(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("openGTFSZip failed: %v", err)
+	}
+	defer zf.Close()
+
+	originalPath := stopTimesCachePath
+	stopTimesCachePath = filepath.Join(t.TempDir(), "index.gob")
+	defer func() { stopTimesCachePath = originalPath }()
+
+	ix, err := loadStopTimesIndex(zf)
+	if err != nil {
+		t.Fatalf("loadStopTimesIndex failed: %v", err)
+	}
+	if len(ix.TripIDs) != 2 {
+		t.Errorf("expected 2 trips, got %d", len(ix.TripIDs))
+	}
+	// Second load comes from the cache file
+	if _, err := readStopTimesCache(stopTimesCachePath, ix.Key); err != nil {
+		t.Errorf("expected cache file to be written: %v", err)
+	}
+}
+
+func TestServiceDate(t *testing.T) {
+	ny := transitLocation
+	tests := []struct {
+		name    string
+		at      time.Time
+		date    string
+		service string
+	}{
+		{"friday evening", time.Date(2025, 10, 10, 22, 0, 0, 0, ny), "2025-10-10", "Weekday"},
+		{"1:30am saturday is still friday's service", time.Date(2025, 10, 11, 1, 30, 0, 0, ny), "2025-10-10", "Weekday"},
+		{"saturday morning", time.Date(2025, 10, 11, 7, 0, 0, 0, ny), "2025-10-11", "Saturday"},
+		{"1am monday is sunday service", time.Date(2025, 10, 13, 1, 0, 0, 0, ny), "2025-10-12", "Sunday"},
+		{"utc input is converted to new york", time.Date(2025, 10, 11, 5, 0, 0, 0, time.UTC), "2025-10-10", "Weekday"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := serviceDate(tt.at)
+			if got := d.Format("2006-01-02"); got != tt.date {
+				t.Errorf("serviceDate = %s, want %s", got, tt.date)
+			}
+			if got := serviceDayName(d); got != tt.service {
+				t.Errorf("serviceDayName = %s, want %s", got, tt.service)
+			}
+		})
+	}
+}
+
+func TestScheduledTimeAcrossDST(t *testing.T) {
+	ny := transitLocation
+	// Fall back (2025-11-02) and spring forward (2025-03-09): an 08:00:00 stop time
+	// must still mean 8am on the wall clock.
+	for _, date := range []time.Time{
+		time.Date(2025, 11, 2, 0, 0, 0, 0, ny),
+		time.Date(2025, 3, 9, 0, 0, 0, 0, ny),
+	} {
+		got := scheduledTime(date, 8*3600).In(ny)
+		if got.Hour() != 8 || got.Minute() != 0 || got.Day() != date.Day() {
+			t.Errorf("08:00:00 on %s resolved to %s", date.Format("2006-01-02"), got)
+		}
+	}
+
+	// 25:30:00 on Friday's service is 1:30am Saturday
+	got := scheduledTime(time.Date(2025, 10, 10, 0, 0, 0, 0, ny), 25*3600+30*60).In(ny)
+	if want := time.Date(2025, 10, 11, 1, 30, 0, 0, ny); !got.Equal(want) {
+		t.Errorf("25:30:00 resolved to %s, want %s", got, want)
+	}
+}
+
+func TestScheduledDeparturesAfterMidnight(t *testing.T) {
+	ix, err := buildStopTimesIndex(strings.NewReader(testStopTimes), "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keepTestData(t)
+	setTestTripServices(map[string]string{"T1": "Weekday", "T2": "Weekday"})
+
+	// Saturday 00:05: T2 departs 601 at 24:10:00 on Friday's service
+	from := time.Date(2025, 10, 11, 0, 5, 0, 0, transitLocation)
+	deps := ix.scheduledDepartures("601", from, 30*time.Minute, tripRunsOn)
+	if len(deps) != 1 || deps[0].TripID != "T2" {
+		t.Fatalf("expected T2 from the previous service day, got %+v", deps)
+	}
+	if want := time.Date(2025, 10, 11, 0, 10, 0, 0, transitLocation); !deps[0].Time.Equal(want) {
+		t.Errorf("departure time = %s, want %s", deps[0].Time, want)
+	}
+
+	// Saturday 07:55: T1 is a weekday trip and must not run
+	from = time.Date(2025, 10, 11, 7, 55, 0, 0, transitLocation)
+	if deps := ix.scheduledDepartures("601", from, 30*time.Minute, tripRunsOn); len(deps) != 0 {
+		t.Errorf("expected no weekday trips on Saturday, got %+v", deps)
+	}
+	// Friday 07:55: it does
+	from = time.Date(2025, 10, 10, 7, 55, 0, 0, transitLocation)
+	if deps := ix.scheduledDepartures("601", from, 30*time.Minute, tripRunsOn); len(deps) != 1 {
+		t.Errorf("expected T1 on Friday, got %+v", deps)
+	}
+}
+
+func TestDetectRoutePatterns(t *testing.T) {
+	stopTimesCSV := `trip_id,arrival_time,departure_time,stop_id,stop_sequence
+5-A,08:00:00,08:00:00,501N,1
+5-A,08:40:00,08:40:00,420S,9
+5-B,08:10:00,08:10:00,501N,1
+5-B,09:10:00,09:10:00,247S,20
+5-C,08:20:00,08:20:00,501N,1
+5-C,09:20:00,09:20:00,247S,20
+`
+	ix, err := buildStopTimesIndex(strings.NewReader(stopTimesCSV), "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := []Trip{
+		{RouteID: "5", TripID: "5-A", TripHeadsign: "Bowling Green", DirectionID: "1"},
+		{RouteID: "5", TripID: "5-B", TripHeadsign: "Flatbush Av-Brooklyn College", DirectionID: "1"},
+		{RouteID: "5", TripID: "5-C", TripHeadsign: "Flatbush Av-Brooklyn College", DirectionID: "1"},
+	}
+
+	keepTestData(t)
+	setTestRoutePatterns(detectRoutePatterns(list, ix))
+
+	if len(data().RoutePatterns["5"]) != 2 {
+		t.Fatalf("expected 2 patterns for the 5, got %+v", data().RoutePatterns["5"])
+	}
+	if p := data().RoutePatterns["5"]["247"]; p.Trips != 2 || p.Headsign != "Flatbush Av-Brooklyn College" {
+		t.Errorf("unexpected Flatbush pattern %+v", p)
+	}
+	// The realtime trip's last stop picks the variant, regardless of platform suffix
+	if hs := patternHeadsign("5", "420S"); hs != "Bowling Green" {
+		t.Errorf("patternHeadsign(5, 420S) = %q, want Bowling Green", hs)
+	}
+	if hs := patternHeadsign("5", "999S"); hs != "" {
+		t.Errorf("unknown terminal should return empty headsign, got %q", hs)
+	}
+	if hs := patternHeadsign("4", "420S"); hs != "" {
+		t.Errorf("other routes should not match, got %q", hs)
+	}
+}
+
+func TestShortTurnDetection(t *testing.T) {
+	stopTimesCSV := `trip_id,arrival_time,departure_time,stop_id,stop_sequence
+AFA-048000_1..N01R,08:00:00,08:00:00,101N,1
+AFA-048000_1..N01R,08:20:00,08:20:00,120N,2
+AFA-048000_1..N01R,08:40:00,08:40:00,142N,3
+`
+	ix, err := buildStopTimesIndex(strings.NewReader(stopTimesCSV), "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ix.isShortTurn("AFA-048000_1..N01R", "120N") {
+		t.Error("trip ending at 120 should be a short turn")
+	}
+	if ix.isShortTurn("AFA-048000_1..N01R", "142S") || ix.isShortTurn("AFA-048000_1..N01R", "999N") || ix.isShortTurn("unknown", "120N") {
+		t.Error("only an early stop on the trip's own pattern is a short turn")
+	}
+
+	keepTestData(t)
+	setTestStations([]Station{
+		{StopID: "101", Name: "Van Cortlandt Park-242 St", Routes: []string{"1"}},
+		{StopID: "120", Name: "96 St"},
+		{StopID: "142", Name: "South Ferry"},
+	})
+	setTestTrips([]Trip{{RouteID: "1", TripID: "AFA-048000_1..N01R", TripHeadsign: "South Ferry", ServiceID: "Weekday"}})
+	setTestStopTimes(ix)
+
+	feed := newTestFeed(testTripUpdate("1", "048000_1..N01R", []string{"101N", "120N"}, []int64{60, 1200}))
+	deps, err := departuresFromSource(data().Stations[0], departureFilter{}, func(string) (*gtfs_realtime.FeedMessage, error) { return feed, nil })
+	if err != nil || len(deps) != 1 {
+		t.Fatalf("expected one departure, got %+v (%v)", deps, err)
+	}
+	if !deps[0].ShortTurned || deps[0].HeadSign != "96 St" {
+		t.Errorf("expected short-turned departure headed to 96 St, got %+v", deps[0])
+	}
+}
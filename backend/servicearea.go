@@ -0,0 +1,72 @@
+package main
+
+// Service area.
+//
+// Locations are accepted only inside New York City. The old lat/lon box took in Jersey
+// City, Hoboken and much of Westchester and Long Island, none of which the subway serves,
+// and answered them with a station across the river. serviceArea traces the city line
+// instead: up the middle of the Hudson, along the Yonkers, Mount Vernon and Pelham line to
+// Long Island Sound, down the Nassau border to the Rockaways, out along the Atlantic shore
+// and Raritan Bay, and back up the Arthur Kill and the Kill Van Kull. The city's own
+// boundary runs through the water, so the trace follows channel midlines and doesn't
+// bother with shore detail; it keeps every terminal station well inside, including
+// Wakefield-241 St, Far Rockaway and Tottenville.
+
+import "math"
+
+// latLon is a point of the service area outline
+type latLon struct{ Lat, Lon float64 }
+
+// serviceArea is the city line, clockwise from the Hudson at the Yonkers border
+var serviceArea = []latLon{
+	// Bronx: Yonkers, Mount Vernon and Pelham Manor borders
+	{40.9130, -73.9190}, {40.9150, -73.8600}, {40.8950, -73.8200}, {40.8820, -73.7880},
+	// Long Island Sound past City Island and Hart Island
+	{40.8700, -73.7600}, {40.8000, -73.7650},
+	// Queens: Little Neck Bay and the Nassau border
+	{40.7800, -73.7530}, {40.7680, -73.7360}, {40.7540, -73.7010}, {40.7250, -73.7160},
+	{40.7000, -73.7290}, {40.6650, -73.7290}, {40.6500, -73.7380}, {40.6300, -73.7450},
+	{40.6100, -73.7410}, {40.5950, -73.7380},
+	// Atlantic shore of the Rockaways and Coney Island, Lower Bay, Raritan Bay
+	{40.5700, -73.7380}, {40.5350, -73.9400}, {40.5450, -74.0100}, {40.5100, -74.1200},
+	{40.4900, -74.2450}, {40.5000, -74.2620},
+	// Arthur Kill
+	{40.5500, -74.2460}, {40.5800, -74.2130}, {40.6100, -74.2040}, {40.6360, -74.1970},
+	// Kill Van Kull and the Upper Bay
+	{40.6460, -74.1800}, {40.6470, -74.1200}, {40.6480, -74.0850}, {40.6900, -74.0350},
+	// Hudson River
+	{40.7000, -74.0250}, {40.7300, -74.0190}, {40.7600, -74.0110}, {40.8000, -73.9800},
+	{40.8500, -73.9570}, {40.8800, -73.9300},
+}
+
+// Bounding box of serviceArea, for quick rejection and for bounding geocoder searches
+var minLat, maxLat, minLon, maxLon = bounds(serviceArea)
+
+func bounds(poly []latLon) (minLat, maxLat, minLon, maxLon float64) {
+	minLat, maxLat, minLon, maxLon = poly[0].Lat, poly[0].Lat, poly[0].Lon, poly[0].Lon
+	for _, p := range poly[1:] {
+		minLat, maxLat = math.Min(minLat, p.Lat), math.Max(maxLat, p.Lat)
+		minLon, maxLon = math.Min(minLon, p.Lon), math.Max(maxLon, p.Lon)
+	}
+	return
+}
+
+// inPolygon reports whether a point is inside poly (even-odd rule)
+func inPolygon(poly []latLon, lat, lon float64) bool {
+	in := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		a, b := poly[i], poly[j]
+		if (a.Lat > lat) != (b.Lat > lat) && lon < (b.Lon-a.Lon)*(lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			in = !in
+		}
+	}
+	return in
+}
+
+// outsideNYC reports whether a point is outside the service area
+func outsideNYC(lat, lon float64) bool {
+	if lat < minLat || lat > maxLat || lon < minLon || lon > maxLon {
+		return true
+	}
+	return !inPolygon(serviceArea, lat, lon)
+}
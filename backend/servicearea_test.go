@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestServiceArea(t *testing.T) {
+	inside := map[string]latLon{
+		"Wakefield-241 St":        {40.903125, -73.850620},
+		"Eastchester-Dyre Av":     {40.888300, -73.830834},
+		"Van Cortlandt Park":      {40.889248, -73.898583},
+		"Inwood-207 St":           {40.868072, -73.919899},
+		"Pelham Bay Park":         {40.852462, -73.828121},
+		"Flushing-Main St":        {40.759600, -73.830030},
+		"Jamaica-179 St":          {40.712646, -73.783817},
+		"Far Rockaway-Mott Av":    {40.603995, -73.755405},
+		"Rockaway Park-Beach 116": {40.580903, -73.835592},
+		"Broad Channel":           {40.608382, -73.815925},
+		"Howard Beach-JFK":        {40.660476, -73.830301},
+		"Coney Island":            {40.577422, -73.981233},
+		"South Ferry":             {40.702068, -74.013664},
+		"WTC Cortlandt":           {40.711835, -74.012188},
+		"St George":               {40.643748, -74.073643},
+		"Arthur Kill":             {40.516578, -74.242096},
+		"Tottenville":             {40.512764, -74.251961},
+		"Governors Island":        {40.689500, -74.016800},
+	}
+	for name, p := range inside {
+		if outsideNYC(p.Lat, p.Lon) {
+			t.Errorf("%s should be inside NYC", name)
+		}
+	}
+	outside := map[string]latLon{
+		"Hoboken":       {40.744000, -74.032400},
+		"Jersey City":   {40.716000, -74.033000},
+		"Fort Lee":      {40.850900, -73.970100},
+		"Bayonne":       {40.668700, -74.114300},
+		"Perth Amboy":   {40.506800, -74.265400},
+		"Elizabeth":     {40.664000, -74.210700},
+		"Yonkers":       {40.931200, -73.898700},
+		"Mount Vernon":  {40.912600, -73.837100},
+		"Great Neck":    {40.800700, -73.728500},
+		"Valley Stream": {40.664300, -73.708500},
+		"Lawrence":      {40.615700, -73.729600},
+		"Sandy Hook":    {40.461800, -74.000000},
+		"Los Angeles":   {34.052200, -118.243700},
+	}
+	for name, p := range outside {
+		if !outsideNYC(p.Lat, p.Lon) {
+			t.Errorf("%s should be outside NYC", name)
+		}
+	}
+}
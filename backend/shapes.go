@@ -0,0 +1,288 @@
+package main
+
+// Route shapes from GTFS shapes.txt, for drawing lines on a map.
+//
+//   GET /api/routes/{id}/shape                  every shape the route's trips run along
+//   GET /api/routes/{id}/shape?format=geojson   the same as a GeoJSON FeatureCollection
+//
+// Shapes are tied to routes through the shape_id column of trips.txt. The MTA's trip IDs
+// end in the shape ID too (..._1..S03R runs along shape 1..S03R), which is used when
+// trips.txt has no shape_id. A route has one shape per distinct pattern, most used
+// first, each with the number of trips along it so clients can draw only the main line.
+// Polylines use the Google encoded polyline format at 5 digits of precision.
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shapePoint is one vertex of a shape
+type shapePoint struct {
+	Lat, Lon float64
+}
+
+// routeShapeRef is one shape used by a route's trips
+type routeShapeRef struct {
+	ShapeID     string
+	DirectionID string
+	Trips       int
+}
+
+var (
+	shapes      map[string][]shapePoint    // shape_id -> points in sequence order
+	routeShapes map[string][]routeShapeRef // route_id -> shapes, most trips first
+)
+
+// parseShapes reads shapes.txt, ordering each shape's points by shape_pt_sequence
+func parseShapes(rd io.Reader) (map[string][]shapePoint, error) {
+	r := csv.NewReader(rd)
+	r.FieldsPerRecord = -1
+
+	need := []string{"shape_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence"}
+	idx, err := parseCSVHeaders(r, need, "shapes")
+	if err != nil {
+		return nil, err
+	}
+
+	type seqPoint struct {
+		seq int
+		pt  shapePoint
+	}
+	byShape := map[string][]seqPoint{}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read shapes row: %w", err)
+		}
+		lat, err1 := strconv.ParseFloat(strings.TrimSpace(row[idx["shape_pt_lat"]]), 64)
+		lon, err2 := strconv.ParseFloat(strings.TrimSpace(row[idx["shape_pt_lon"]]), 64)
+		seq, err3 := strconv.Atoi(strings.TrimSpace(row[idx["shape_pt_sequence"]]))
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue // skip malformed points rather than the whole shape
+		}
+		id := row[idx["shape_id"]]
+		byShape[id] = append(byShape[id], seqPoint{seq, shapePoint{lat, lon}})
+	}
+
+	out := make(map[string][]shapePoint, len(byShape))
+	for id, pts := range byShape {
+		sort.SliceStable(pts, func(i, j int) bool { return pts[i].seq < pts[j].seq })
+		list := make([]shapePoint, len(pts))
+		for i, p := range pts {
+			list[i] = p.pt
+		}
+		out[id] = list
+	}
+	return out, nil
+}
+
+// tripShapeID is the shape a trip runs along: its shape_id, or the shape its trip ID ends in
+func tripShapeID(t Trip, known map[string][]shapePoint) string {
+	if t.ShapeID != "" {
+		return t.ShapeID
+	}
+	if i := strings.LastIndex(t.TripID, "_"); i >= 0 {
+		if id := t.TripID[i+1:]; known[id] != nil {
+			return id
+		}
+	}
+	return ""
+}
+
+// buildRouteShapes associates shapes with the routes whose trips run along them
+func buildRouteShapes(list []Trip, known map[string][]shapePoint) map[string][]routeShapeRef {
+	counts := map[string]map[string]*routeShapeRef{}
+	for _, t := range list {
+		id := tripShapeID(t, known)
+		if id == "" || known[id] == nil {
+			continue
+		}
+		if counts[t.RouteID] == nil {
+			counts[t.RouteID] = map[string]*routeShapeRef{}
+		}
+		ref, ok := counts[t.RouteID][id]
+		if !ok {
+			ref = &routeShapeRef{ShapeID: id, DirectionID: t.DirectionID}
+			counts[t.RouteID][id] = ref
+		}
+		ref.Trips++
+	}
+
+	out := make(map[string][]routeShapeRef, len(counts))
+	for route, byID := range counts {
+		refs := make([]routeShapeRef, 0, len(byID))
+		for _, ref := range byID {
+			refs = append(refs, *ref)
+		}
+		sort.Slice(refs, func(i, j int) bool {
+			if refs[i].Trips != refs[j].Trips {
+				return refs[i].Trips > refs[j].Trips
+			}
+			return refs[i].ShapeID < refs[j].ShapeID
+		})
+		out[route] = refs
+	}
+	return out
+}
+
+// loadShapes reads shapes.txt from an open GTFS zip and ties the shapes to routes
+func loadShapes(zf *gtfsZip, list []Trip) error {
+	rc, err := zf.openMember("shapes.txt")
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	out, err := parseShapes(rc)
+	if err != nil {
+		return err
+	}
+	shapes = out
+	routeShapes = buildRouteShapes(list, out)
+	log.Printf("Loaded %d shapes for %d routes", len(shapes), len(routeShapes))
+	return nil
+}
+
+// encodePolyline encodes points in the Google encoded polyline format
+func encodePolyline(pts []shapePoint) string {
+	var b strings.Builder
+	var prevLat, prevLon int64
+	for _, p := range pts {
+		lat := int64(math.Round(p.Lat * 1e5))
+		lon := int64(math.Round(p.Lon * 1e5))
+		encodePolylineValue(&b, lat-prevLat)
+		encodePolylineValue(&b, lon-prevLon)
+		prevLat, prevLon = lat, lon
+	}
+	return b.String()
+}
+
+func encodePolylineValue(b *strings.Builder, v int64) {
+	u := v << 1
+	if v < 0 {
+		u = ^u
+	}
+	for u >= 0x20 {
+		b.WriteByte(byte(0x20|(u&0x1f)) + 63)
+		u >>= 5
+	}
+	b.WriteByte(byte(u) + 63)
+}
+
+// RouteShape is one shape of a route
+type RouteShape struct {
+	ShapeID     string `json:"shape_id"`
+	DirectionID string `json:"direction_id"`
+	Trips       int    `json:"trips"` // scheduled trips along this shape
+	Points      int    `json:"points"`
+	Polyline    string `json:"polyline"`
+}
+
+// RouteShapesResponse is the /api/routes/{id}/shape response
+type RouteShapesResponse struct {
+	RouteID string       `json:"route_id"`
+	Color   string       `json:"color,omitempty"`
+	Shapes  []RouteShape `json:"shapes"`
+}
+
+type geoJSONLineString struct {
+	Type        string       `json:"type"`
+	Coordinates [][2]float64 `json:"coordinates"` // lon, lat
+}
+
+type shapeFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONLineString `json:"geometry"`
+	Properties shapeProperties   `json:"properties"`
+}
+
+type shapeProperties struct {
+	RouteID     string `json:"route_id"`
+	ShapeID     string `json:"shape_id"`
+	DirectionID string `json:"direction_id"`
+	Trips       int    `json:"trips"`
+	Color       string `json:"color,omitempty"`
+}
+
+type shapeCollection struct {
+	Type     string         `json:"type"`
+	Features []shapeFeature `json:"features"`
+}
+
+// routeColor is the routes.txt color of a route, if known
+func routeColor(id string) string {
+	for _, rt := range routes {
+		if rt.ID == id {
+			return rt.Color
+		}
+	}
+	return ""
+}
+
+func handleRouteShape(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	rest := strings.TrimPrefix(r.URL.Path, "/api/routes/")
+	id := strings.TrimSuffix(rest, "/shape")
+	if id == rest || id == "" || strings.Contains(id, "/") {
+		httpError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if len(shapes) == 0 {
+		httpError(w, http.StatusServiceUnavailable, "shape data not loaded")
+		return
+	}
+	refs, ok := routeShapes[id]
+	if !ok {
+		id = strings.ToUpper(id)
+		refs, ok = routeShapes[id]
+	}
+	if !ok {
+		httpError(w, http.StatusNotFound, "unknown route")
+		return
+	}
+	color := routeColor(id)
+
+	if r.URL.Query().Get("format") == "geojson" {
+		features := make([]shapeFeature, 0, len(refs))
+		for _, ref := range refs {
+			pts := shapes[ref.ShapeID]
+			coords := make([][2]float64, len(pts))
+			for i, p := range pts {
+				coords[i] = [2]float64{p.Lon, p.Lat}
+			}
+			features = append(features, shapeFeature{
+				Type:       "Feature",
+				Geometry:   geoJSONLineString{Type: "LineString", Coordinates: coords},
+				Properties: shapeProperties{RouteID: id, ShapeID: ref.ShapeID, DirectionID: ref.DirectionID, Trips: ref.Trips, Color: color},
+			})
+		}
+		writeJSON(w, shapeCollection{Type: "FeatureCollection", Features: features})
+		log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+		return
+	}
+
+	resp := RouteShapesResponse{RouteID: id, Color: color, Shapes: make([]RouteShape, 0, len(refs))}
+	for _, ref := range refs {
+		pts := shapes[ref.ShapeID]
+		resp.Shapes = append(resp.Shapes, RouteShape{
+			ShapeID:     ref.ShapeID,
+			DirectionID: ref.DirectionID,
+			Trips:       ref.Trips,
+			Points:      len(pts),
+			Polyline:    encodePolyline(pts),
+		})
+	}
+	writeJSON(w, resp)
+	log.Printf("Request completed in %.2f ms", float64(time.Since(start).Microseconds())/1000.0)
+}
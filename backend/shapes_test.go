@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testShapesTxt = `shape_id,shape_pt_sequence,shape_pt_lat,shape_pt_lon
+1..N03R,1,40.702068,-74.013664
+1..N03R,0,40.70,-74.01
+1..N03R,2,40.703087,-74.012994
+1..S03R,0,40.703087,-74.012994
+1..S03R,1,40.702068,-74.013664
+1..N03R,3,bad,-74.0
+`
+
+func TestParseShapes(t *testing.T) {
+	out, err := parseShapes(strings.NewReader(testShapesTxt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := out["1..N03R"]
+	if len(out) != 2 || len(n) != 3 {
+		t.Fatalf("unexpected shapes %v", out)
+	}
+	// Ordered by shape_pt_sequence; the malformed point is skipped
+	if n[0] != (shapePoint{40.70, -74.01}) || n[2] != (shapePoint{40.703087, -74.012994}) {
+		t.Errorf("unexpected order %v", n)
+	}
+
+	if _, err := parseShapes(strings.NewReader("shape_id,shape_pt_lat\nA,40.7\n")); err == nil {
+		t.Error("expected error when columns are missing")
+	}
+}
+
+func TestEncodePolyline(t *testing.T) {
+	// The example from Google's polyline algorithm documentation
+	pts := []shapePoint{{38.5, -120.2}, {40.7, -120.95}, {43.252, -126.453}}
+	if got := encodePolyline(pts); got != "_p~iF~ps|U_ulLnnqC_mqNvxq`@" {
+		t.Errorf("got %q", got)
+	}
+	if got := encodePolyline(nil); got != "" {
+		t.Errorf("expected empty polyline, got %q", got)
+	}
+}
+
+func TestBuildRouteShapes(t *testing.T) {
+	known, _ := parseShapes(strings.NewReader(testShapesTxt))
+	list := []Trip{
+		{RouteID: "1", TripID: "AFA23GEN-1038-Weekday-00_000600_1..N03R", DirectionID: "0"},
+		{RouteID: "1", TripID: "AFA23GEN-1038-Weekday-00_001200_1..N03R", DirectionID: "0"},
+		{RouteID: "1", TripID: "other", DirectionID: "1", ShapeID: "1..S03R"},
+		{RouteID: "2", TripID: "AFA23GEN-2038-Weekday-00_000600_2..N08R"}, // no such shape
+	}
+	got := buildRouteShapes(list, known)
+	if len(got) != 1 || len(got["1"]) != 2 {
+		t.Fatalf("unexpected association %v", got)
+	}
+	if got["1"][0] != (routeShapeRef{ShapeID: "1..N03R", DirectionID: "0", Trips: 2}) {
+		t.Errorf("expected the most used shape first, got %+v", got["1"])
+	}
+	if got["1"][1].ShapeID != "1..S03R" || got["1"][1].DirectionID != "1" {
+		t.Errorf("expected shape_id from trips.txt, got %+v", got["1"][1])
+	}
+}
+
+func TestRouteShapeHandler(t *testing.T) {
+	originalShapes, originalRefs, originalRoutes := shapes, routeShapes, routes
+	t.Cleanup(func() { shapes, routeShapes, routes = originalShapes, originalRefs, originalRoutes })
+
+	shapes, routeShapes = nil, nil
+	w := httptest.NewRecorder()
+	handleRouteResource(w, httptest.NewRequest("GET", "/api/routes/1/shape", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 before shapes load, got %d", w.Code)
+	}
+
+	shapes, _ = parseShapes(strings.NewReader(testShapesTxt))
+	routeShapes = buildRouteShapes([]Trip{
+		{RouteID: "GS", TripID: "a", DirectionID: "0", ShapeID: "1..N03R"},
+	}, shapes)
+	routes, _ = parseRoutes(strings.NewReader(testRoutesTxt))
+
+	w = httptest.NewRecorder()
+	handleRouteResource(w, httptest.NewRequest("GET", "/api/routes/gs/shape", nil))
+	var resp RouteShapesResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || resp.RouteID != "GS" || resp.Color != "808183" || len(resp.Shapes) != 1 {
+		t.Fatalf("unexpected response %d %+v", w.Code, resp)
+	}
+	if s := resp.Shapes[0]; s.Points != 3 || s.Polyline != encodePolyline(shapes["1..N03R"]) {
+		t.Errorf("unexpected shape %+v", s)
+	}
+
+	w = httptest.NewRecorder()
+	handleRouteResource(w, httptest.NewRequest("GET", "/api/routes/GS/shape?format=geojson", nil))
+	var fc shapeCollection
+	if err := json.NewDecoder(w.Body).Decode(&fc); err != nil {
+		t.Fatal(err)
+	}
+	if fc.Type != "FeatureCollection" || len(fc.Features) != 1 {
+		t.Fatalf("unexpected collection %+v", fc)
+	}
+	f := fc.Features[0]
+	if f.Geometry.Type != "LineString" || len(f.Geometry.Coordinates) != 3 || f.Geometry.Coordinates[0] != [2]float64{-74.01, 40.70} || f.Properties.ShapeID != "1..N03R" {
+		t.Errorf("unexpected feature %+v", f)
+	}
+
+	for _, path := range []string{"/api/routes/Z/shape", "/api/routes/GS/x/shape"} {
+		w = httptest.NewRecorder()
+		handleRouteResource(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+// Per-endpoint service level objectives and error-budget burn rates.
+//
+// Each SLO in the config (key "slos") says what fraction of an endpoint's requests must
+// succeed (non-5xx) within a latency threshold:
+//
+//   "slos": {"/api/departures/nearest": {"latency": "500ms", "target": 0.99}}
+//
+// Requests are counted in one-minute buckets over the last hour. The burn rate over a
+// window is the observed bad fraction divided by the budget (1 - target): 1 means the
+// budget is being spent exactly as fast as the SLO allows, 14.4 means a 30-day budget
+// would be gone in ~2 days. GET /metrics exports the numbers in Prometheus text format
+// and a warning is logged when both the 5m and 1h burn rates exceed fastBurnThreshold.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SLOConfig is one endpoint's objective
+type SLOConfig struct {
+	Latency Duration `json:"latency"` // requests slower than this count against the budget
+	Target  float64  `json:"target"`  // fraction of good requests, e.g. 0.99
+}
+
+const (
+	sloBuckets        = 60 // one-minute buckets: a one-hour window
+	fastBurnThreshold = 14.4
+)
+
+// sloWindows are the burn-rate windows exported, in minutes
+var sloWindows = []struct {
+	name    string
+	minutes int
+}{{"5m", 5}, {"1h", 60}}
+
+type sloBucket struct {
+	minute int64 // unix minute this bucket counts
+	total  int64
+	bad    int64
+}
+
+type sloTracker struct {
+	mu       sync.Mutex
+	endpoint string
+	cfg      SLOConfig
+	buckets  [sloBuckets]sloBucket
+	alerted  int64 // unix minute of the last fast-burn warning
+}
+
+// slos holds a tracker per configured endpoint path; nil when no SLOs are configured
+var slos map[string]*sloTracker
+
+func newSLOTrackers(cfgs map[string]SLOConfig) map[string]*sloTracker {
+	if len(cfgs) == 0 {
+		return nil
+	}
+	out := make(map[string]*sloTracker, len(cfgs))
+	for path, c := range cfgs {
+		out[path] = &sloTracker{endpoint: path, cfg: c}
+	}
+	return out
+}
+
+// record counts one request finished at now
+func (t *sloTracker) record(now time.Time, latency time.Duration, status int) {
+	minute := now.Unix() / 60
+	bad := status >= 500 || latency > time.Duration(t.cfg.Latency)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := &t.buckets[minute%sloBuckets]
+	if b.minute != minute {
+		*b = sloBucket{minute: minute}
+	}
+	b.total++
+	if bad {
+		b.bad++
+	}
+
+	// Warn at most once a minute while both windows burn fast
+	if bad && t.alerted != minute {
+		short, long := t.burnRateLocked(minute, 5), t.burnRateLocked(minute, 60)
+		if short > fastBurnThreshold && long > fastBurnThreshold {
+			t.alerted = minute
+			log.Printf("Warning: SLO %s (%.2f%% < %s) burning error budget fast: %.1fx over 5m, %.1fx over 1h",
+				t.endpoint, t.cfg.Target*100, time.Duration(t.cfg.Latency), short, long)
+		}
+	}
+}
+
+// countsLocked sums the buckets of the last minutes (including the current one)
+func (t *sloTracker) countsLocked(minute int64, minutes int) (total, bad int64) {
+	for _, b := range t.buckets {
+		if b.minute > minute-int64(minutes) && b.minute <= minute {
+			total += b.total
+			bad += b.bad
+		}
+	}
+	return total, bad
+}
+
+func (t *sloTracker) burnRateLocked(minute int64, minutes int) float64 {
+	total, bad := t.countsLocked(minute, minutes)
+	if total == 0 || t.cfg.Target >= 1 {
+		return 0
+	}
+	return (float64(bad) / float64(total)) / (1 - t.cfg.Target)
+}
+
+// withSLO measures every request whose path has an SLO
+func withSLO(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t, ok := slos[r.URL.Path]
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+		t.record(time.Now(), time.Since(start), rec.status)
+	})
+}
+
+// statusRecorder remembers the status code a handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets /ws upgrade through the recorder
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response does not support hijacking")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
+
+// handleMetrics writes SLO counters and burn rates in Prometheus text format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Header().Set("Cache-Control", "no-store")
+
+	paths := make([]string, 0, len(slos))
+	for p := range slos {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	minute := time.Now().Unix() / 60
+
+	// Each family's HELP and TYPE lines are followed by all of its samples
+	family := func(name, help string, samples func(p string, t *sloTracker)) {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+		for _, p := range paths {
+			t := slos[p]
+			t.mu.Lock()
+			samples(p, t)
+			t.mu.Unlock()
+		}
+	}
+	family("nyc_subway_slo_requests", "Requests counted against the SLO in the window.", func(p string, t *sloTracker) {
+		for _, win := range sloWindows {
+			total, _ := t.countsLocked(minute, win.minutes)
+			fmt.Fprintf(w, "nyc_subway_slo_requests{endpoint=%q,window=%q} %d\n", p, win.name, total)
+		}
+	})
+	family("nyc_subway_slo_bad_requests", "Requests that failed or exceeded the SLO latency in the window.", func(p string, t *sloTracker) {
+		for _, win := range sloWindows {
+			_, bad := t.countsLocked(minute, win.minutes)
+			fmt.Fprintf(w, "nyc_subway_slo_bad_requests{endpoint=%q,window=%q} %d\n", p, win.name, bad)
+		}
+	})
+	family("nyc_subway_slo_burn_rate", "Error budget burn rate in the window (1 = exactly on budget).", func(p string, t *sloTracker) {
+		for _, win := range sloWindows {
+			fmt.Fprintf(w, "nyc_subway_slo_burn_rate{endpoint=%q,window=%q} %g\n", p, win.name, t.burnRateLocked(minute, win.minutes))
+		}
+	})
+	family("nyc_subway_slo_target", "Fraction of requests that must be good.", func(p string, t *sloTracker) {
+		fmt.Fprintf(w, "nyc_subway_slo_target{endpoint=%q,latency=%q} %g\n", p, time.Duration(t.cfg.Latency).String(), t.cfg.Target)
+	})
+	stationFreshness.writeMetrics(w, time.Now())
+	streamStats.writeMetrics(w)
+}
+
+// validateSLOs checks the "slos" config value
+func validateSLOs(v json.RawMessage) string {
+	var raw map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(v, &raw); err != nil {
+		return `expected an object of endpoint -> {"latency": "500ms", "target": 0.99}`
+	}
+	paths := make([]string, 0, len(raw))
+	for p := range raw {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if len(p) == 0 || p[0] != '/' {
+			return fmt.Sprintf("endpoint %q must be a path starting with /", p)
+		}
+		for k := range raw[p] {
+			if k != "latency" && k != "target" {
+				return fmt.Sprintf("%s: unknown key %q (expected latency and target)", p, k)
+			}
+		}
+		if raw[p]["latency"] == nil || raw[p]["target"] == nil {
+			return fmt.Sprintf("%s: both latency and target are required", p)
+		}
+		if msg := validateConfigValue("", kindDuration, raw[p]["latency"]); msg != "" {
+			return p + ": latency: " + msg
+		}
+		var target float64
+		if err := json.Unmarshal(raw[p]["target"], &target); err != nil || target <= 0 || target >= 1 {
+			return fmt.Sprintf("%s: target must be a number between 0 and 1 (exclusive), got %s", p, raw[p]["target"])
+		}
+	}
+	return ""
+}
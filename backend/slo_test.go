@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSLOBurnRate(t *testing.T) {
+	tr := &sloTracker{endpoint: "/api/x", cfg: SLOConfig{Latency: Duration(500 * time.Millisecond), Target: 0.99}}
+	now := time.Unix(1760000000, 0)
+
+	// 98 good requests, one slow, one failed: 2% bad against a 1% budget
+	for i := 0; i < 98; i++ {
+		tr.record(now, 100*time.Millisecond, http.StatusOK)
+	}
+	tr.record(now, time.Second, http.StatusOK)
+	tr.record(now, 10*time.Millisecond, http.StatusBadGateway)
+
+	minute := now.Unix() / 60
+	if total, bad := tr.countsLocked(minute, 5); total != 100 || bad != 2 {
+		t.Fatalf("counts = %d/%d, want 100/2", total, bad)
+	}
+	if rate := tr.burnRateLocked(minute, 5); rate < 1.99 || rate > 2.01 {
+		t.Errorf("burn rate = %f, want 2", rate)
+	}
+
+	// Requests older than the window stop counting
+	later := minute + 10
+	if total, _ := tr.countsLocked(later, 5); total != 0 {
+		t.Errorf("expected old buckets outside the 5m window, got %d", total)
+	}
+	if total, _ := tr.countsLocked(later, 60); total != 100 {
+		t.Errorf("expected old buckets inside the 1h window, got %d", total)
+	}
+}
+
+func TestSLOMiddlewareAndMetrics(t *testing.T) {
+	original := slos
+	slos = newSLOTrackers(map[string]SLOConfig{"/api/stops": {Latency: Duration(time.Second), Target: 0.9}})
+	defer func() { slos = original }()
+
+	h := withSLO(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/stops" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/stops", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/other", nil))
+
+	w := httptest.NewRecorder()
+	handleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`nyc_subway_slo_requests{endpoint="/api/stops",window="5m"} 1`,
+		`nyc_subway_slo_bad_requests{endpoint="/api/stops",window="1h"} 1`,
+		`nyc_subway_slo_burn_rate{endpoint="/api/stops",window="5m"} 10`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `endpoint="/other"`) {
+		t.Error("endpoints without an SLO should not be tracked")
+	}
+	if split := splitMetricFamily(body); split != "" {
+		t.Errorf("family %s is not one group of HELP, TYPE and samples:\n%s", split, body)
+	}
+}
+
+func TestValidateSLOs(t *testing.T) {
+	if errs := validateConfig([]byte(`{"slos": {"/api/stops": {"latency": "500ms", "target": 0.99}}}`)); len(errs) != 0 {
+		t.Errorf("valid SLOs rejected: %v", errs)
+	}
+	for body, want := range map[string]string{
+		`{"slos": {"/api/stops": {"latency": "fast", "target": 0.99}}}`:      "invalid duration",
+		`{"slos": {"/api/stops": {"latency": "1s", "target": 99}}}`:          "target must be a number between 0 and 1",
+		`{"slos": {"api/stops": {"latency": "1s", "target": 0.9}}}`:          "must be a path",
+		`{"slos": {"/api/stops": {"latency": "1s"}}}`:                        "both latency and target are required",
+		`{"slos": {"/api/stops": {"latency": "1s", "target": 0.9, "x": 1}}}`: `unknown key "x"`,
+	} {
+		errs := validateConfig([]byte(body))
+		if len(errs) != 1 || !strings.Contains(errs[0], want) {
+			t.Errorf("validateConfig(%s) = %v, want error containing %q", body, errs, want)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+// State snapshots for warm starts.
+//
+// Loading stations and the GTFS static data takes minutes (the stop_times index alone is
+// built from ~2M rows). During a deploy the outgoing instance can hand its in-memory state
+// to the replacement instead:
+//
+//   curl -H "Authorization: Bearer $ADMIN_TOKEN" http://old:8080/admin/snapshot > state.snap
+//   nyc-subway -snapshot state.snap            (or -snapshot http://old:8080/admin/snapshot)
+//
+// A snapshot is a gzip-compressed gob of the static data plus the poller's feed store.
+// If it cannot be loaded the server falls back to downloading everything as usual.
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// snapshotVersion changes whenever the snapshot layout does; older snapshots are rejected
+const snapshotVersion = 3
+
+type stateSnapshot struct {
+	Version              int
+	Taken                time.Time
+	Stations             []Station
+	StopParents          map[string]string
+	StopNames            map[string]string
+	StationPhotos        map[string][]StationPhoto
+	Places               map[string]Place
+	Entrances            map[string][]Entrance
+	Trips                []Trip
+	SupplementedTrips    []Trip
+	Calendar             *serviceCalendar
+	SuppCalendar         *serviceCalendar
+	StopTimes            *stopTimesIndex
+	Transfers            map[string]map[string]int
+	Routes               []Route
+	Shapes               map[string][]shapePoint
+	GTFSTranslations     map[string]map[string]string // see translations.go
+	OperatorTranslations map[string]map[string]string
+	Ridership            map[string]float64      // see heatmap.go
+	Feeds                map[string]snapshotFeed // poller store, feeds kept as protobuf bytes
+}
+
+type snapshotFeed struct {
+	Data    []byte
+	Fetched time.Time
+}
+
+// takeSnapshot captures the current in-memory state
+func takeSnapshot() (*stateSnapshot, error) {
+	ds := data()
+	snap := &stateSnapshot{
+		Version:              snapshotVersion,
+		Taken:                time.Now(),
+		Stations:             ds.Stations,
+		StopParents:          ds.StopParents,
+		StopNames:            ds.StopNames,
+		StationPhotos:        stationPhotos,
+		Places:               places,
+		Entrances:            stationEntrances,
+		Trips:                ds.Trips,
+		SupplementedTrips:    ds.SupplementedTrips,
+		Calendar:             ds.Calendar,
+		SuppCalendar:         ds.SuppCalendar,
+		StopTimes:            ds.StopTimes,
+		Transfers:            complexTransfers,
+		Routes:               routes,
+		Shapes:               shapes,
+		GTFSTranslations:     gtfsStationTranslations,
+		OperatorTranslations: operatorStationTranslations,
+		Ridership:            stationRidership,
+		Feeds:                map[string]snapshotFeed{},
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	for url, f := range store.feeds {
+		data, err := proto.Marshal(f.msg)
+		if err != nil {
+			return nil, fmt.Errorf("marshal feed %s: %w", url, err)
+		}
+		snap.Feeds[url] = snapshotFeed{Data: data, Fetched: f.fetched}
+	}
+	return snap, nil
+}
+
+func writeSnapshot(w io.Writer, snap *stateSnapshot) error {
+	zw := gzip.NewWriter(w)
+	if err := gob.NewEncoder(zw).Encode(snap); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func readSnapshot(r io.Reader) (*stateSnapshot, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot: %w", err)
+	}
+	defer zr.Close()
+	var snap stateSnapshot
+	if err := gob.NewDecoder(zr).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("decode snapshot: %w", err)
+	}
+	if snap.Version != snapshotVersion {
+		return nil, fmt.Errorf("snapshot version %d, want %d", snap.Version, snapshotVersion)
+	}
+	if len(snap.Stations) == 0 {
+		return nil, fmt.Errorf("snapshot has no stations")
+	}
+	return &snap, nil
+}
+
+// restore installs a snapshot as the server's state, rebuilding derived indexes
+func (snap *stateSnapshot) restore() error {
+	feeds := make(map[string]storedFeed, len(snap.Feeds))
+	for url, f := range snap.Feeds {
+		var msg gtfs_realtime.FeedMessage
+		if err := proto.Unmarshal(f.Data, &msg); err != nil {
+			return fmt.Errorf("unmarshal feed %s: %w", url, err)
+		}
+		feeds[url] = storedFeed{msg: &msg, fetched: f.Fetched}
+	}
+
+	if snap.StopTimes != nil {
+		snap.StopTimes.rebuildTripIndex()
+	}
+	updateData(func(ds *dataset) {
+		ds.Stations = snap.Stations
+		ds.StopParents, ds.StopNames = snap.StopParents, snap.StopNames
+		ds.setTrips(snap.Trips, snap.StopTimes)
+		ds.SupplementedTrips = snap.SupplementedTrips
+		ds.Calendar, ds.SuppCalendar = snap.Calendar, snap.SuppCalendar
+	})
+	stationPhotos = snap.StationPhotos
+	places = snap.Places
+	stationEntrances = snap.Entrances
+	complexTransfers = snap.Transfers
+	routes = snap.Routes
+	shapes = snap.Shapes
+	routeShapes = buildRouteShapes(snap.Trips, shapes)
+	gtfsStationTranslations = snap.GTFSTranslations
+	operatorStationTranslations = snap.OperatorTranslations
+	stationRidership = snap.Ridership
+	store.mu.Lock()
+	store.feeds = feeds
+	store.mu.Unlock()
+	return nil
+}
+
+// loadSnapshot reads a snapshot from a file or from another instance's /admin/snapshot
+// (sending this instance's admin token) and restores it
+func loadSnapshot(ctx context.Context, src string) error {
+	start := time.Now()
+	var rc io.ReadCloser
+	if isRemoteSource(src) {
+		req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+		if err != nil {
+			return err
+		}
+		if adminToken != "" {
+			req.Header.Set("Authorization", "Bearer "+adminToken)
+		}
+		// The default client's timeout is sized for feeds, not multi-megabyte snapshots
+		resp, err := (&http.Client{Timeout: 2 * time.Minute}).Do(req)
+		if err != nil {
+			return fmt.Errorf("fetch snapshot: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("fetch snapshot: status %d", resp.StatusCode)
+		}
+		rc = resp.Body
+	} else {
+		f, err := os.Open(strings.TrimPrefix(src, "file://"))
+		if err != nil {
+			return err
+		}
+		rc = f
+	}
+	defer rc.Close()
+
+	snap, err := readSnapshot(rc)
+	if err != nil {
+		return err
+	}
+	if err := snap.restore(); err != nil {
+		return err
+	}
+	log.Printf("Restored snapshot taken %s (%d stations, %d trips, %d feeds) in %.2f ms",
+		snap.Taken.Format(time.RFC3339), len(snap.Stations), len(snap.Trips), len(snap.Feeds),
+		float64(time.Since(start).Microseconds())/1000.0)
+	return nil
+}
+
+func handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if !startup.complete() {
+		httpError(w, http.StatusServiceUnavailable, "starting up: static data still loading")
+		return
+	}
+	snap, err := takeSnapshot()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := writeSnapshot(w, snap); err != nil {
+		log.Printf("snapshot write failed: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	keepTestData(t)
+	originalTransfers, originalStore, originalToken := complexTransfers, store, adminToken
+	originalGTFSNames, originalOperatorNames := gtfsStationTranslations, operatorStationTranslations
+	originalRidership := stationRidership
+	t.Cleanup(func() {
+		complexTransfers, store, adminToken = originalTransfers, originalStore, originalToken
+		gtfsStationTranslations, operatorStationTranslations = originalGTFSNames, originalOperatorNames
+		stationRidership = originalRidership
+	})
+
+	ix, err := buildStopTimesIndex(strings.NewReader(testStopTimes), "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setTestStations([]Station{{StopID: "635", Name: "14 St - Union Sq", Routes: []string{"6"}}})
+	setTestTrips([]Trip{{RouteID: "6", TripID: "T1", TripHeadsign: "Brooklyn Bridge", ServiceID: "Weekday"}})
+	setTestStopTimes(ix)
+	setTestStopParents(map[string]string{"635": "635", "635N": "635"})
+	complexTransfers = map[string]map[string]int{"635": {"L03": 180}}
+	gtfsStationTranslations = map[string]map[string]string{"635": {"es": "Calle 14 - Union Sq"}}
+	operatorStationTranslations = map[string]map[string]string{"635": {"zh": "14街-联合广场"}}
+	stationRidership = map[string]float64{"602": 1200}
+	store = &feedStore{feeds: map[string]storedFeed{}}
+	store.put("http://feed/6", newTestFeed(testTripUpdate("6", "T1", []string{"635N"}, []int64{60})), time.Now())
+	adminToken = "secret"
+
+	w := httptest.NewRecorder()
+	handleSnapshot(w, httptest.NewRequest("GET", "/admin/snapshot", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", w.Code)
+	}
+	req := httptest.NewRequest("GET", "/admin/snapshot", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	handleSnapshot(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	path := filepath.Join(t.TempDir(), "state.snap")
+	if err := os.WriteFile(path, w.Body.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wipe the state and warm-start from the file
+	setTestStations(nil)
+	setTestTrips(nil)
+	setTestStopTimes(nil)
+	setTestStopParents(nil)
+	complexTransfers = nil
+	gtfsStationTranslations, operatorStationTranslations = nil, nil
+	stationRidership = nil
+	store = &feedStore{feeds: map[string]storedFeed{}}
+	if err := loadSnapshot(context.Background(), path); err != nil {
+		t.Fatalf("loadSnapshot: %v", err)
+	}
+	if len(data().Stations) != 1 || len(data().Trips) != 1 || complexTransfers["635"]["L03"] != 180 {
+		t.Errorf("static data not restored: %v %v %v", data().Stations, data().Trips, complexTransfers)
+	}
+	if data().StopParents["635N"] != "635" {
+		t.Errorf("parent stations not restored: %v", data().StopParents)
+	}
+	if term, ok := data().StopTimes.terminalStop("T1"); !ok || term != "640S" {
+		t.Errorf("stop_times index not usable after restore: %q %v", term, ok)
+	}
+	if gtfsStationTranslations["635"]["es"] != "Calle 14 - Union Sq" || operatorStationTranslations["635"]["zh"] != "14街-联合广场" {
+		t.Errorf("station name translations not restored: %v %v", gtfsStationTranslations, operatorStationTranslations)
+	}
+	if stationRidership["602"] != 1200 {
+		t.Errorf("ridership not restored: %v", stationRidership)
+	}
+	if feed, err := store.get("http://feed/6"); err != nil || len(feed.GetEntity()) != 1 {
+		t.Errorf("feed store not restored: %v", err)
+	}
+
+	if _, err := readSnapshot(bytes.NewReader([]byte("not a snapshot"))); err == nil {
+		t.Error("expected error for garbage snapshot")
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+// Stale feeds.
+//
+// During an MTA outage a feed can keep answering with the same old message, and its
+// predictions, some of them for trains long gone, would be served as if current. A feed
+// whose header timestamp is older than stale_feed_threshold (default 2m) is stale: its
+// departures are marked "stale" and the response warns, e.g. "A/C/E data may be stale",
+// so clients can show the ETAs as unreliable rather than drop them. Feeds without a
+// header timestamp are never considered stale.
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+func staleFeedThreshold() time.Duration {
+	return appConfig.StaleFeedThreshold.orDefault(2 * time.Minute)
+}
+
+// feedStale reports whether a feed's header is older than the threshold at now (unix seconds)
+func feedStale(feed *gtfs_realtime.FeedMessage, now int64) bool {
+	ts := feed.GetHeader().GetTimestamp()
+	return ts > 0 && time.Duration(now-int64(ts))*time.Second > staleFeedThreshold()
+}
+
+// feedStaleWarning names the routes a stale feed carries, preferring the ones the station
+// serves
+func feedStaleWarning(url string, stationRoutes []string) string {
+	routes := feedRoutes(url, stationRoutes)
+	if len(routes) == 0 {
+		return "some realtime data may be stale"
+	}
+	return fmt.Sprintf("%s data may be stale", strings.Join(routes, "/"))
+}
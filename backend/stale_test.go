@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+func TestDeparturesFromStaleFeed(t *testing.T) {
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "L08", Name: "Bedford Av", Routes: []string{"L"}}})
+	fetchAt := func(age time.Duration) ([]Departure, []string, error) {
+		feed := newTestFeed(testTripUpdate("L", "tripL", []string{"L08N"}, []int64{300}))
+		feed.Header.Timestamp = proto.Uint64(uint64(time.Now().Add(-age).Unix()))
+		deps, err := departuresFromSource(data().Stations[0], departureFilter{}, func(string) (*gtfs_realtime.FeedMessage, error) { return feed, nil })
+		warnings, err := splitPartial(err)
+		return deps, warnings, err
+	}
+
+	deps, warnings, err := fetchAt(10 * time.Second)
+	if err != nil || len(deps) != 1 || deps[0].Stale || len(warnings) != 0 {
+		t.Fatalf("expected a fresh departure, got %+v %v (%v)", deps, warnings, err)
+	}
+	deps, warnings, err = fetchAt(5 * time.Minute)
+	if err != nil || len(deps) != 1 || !deps[0].Stale {
+		t.Fatalf("expected a stale departure, got %+v (%v)", deps, err)
+	}
+	if len(warnings) != 1 || warnings[0] != "L data may be stale" {
+		t.Errorf("expected a stale warning, got %v", warnings)
+	}
+
+	original := appConfig
+	t.Cleanup(func() { appConfig = original })
+	appConfig.StaleFeedThreshold = Duration(10 * time.Minute)
+	if deps, _, _ := fetchAt(5 * time.Minute); len(deps) != 1 || deps[0].Stale {
+		t.Errorf("expected the configured threshold to apply, got %+v", deps)
+	}
+}
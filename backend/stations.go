@@ -0,0 +1,178 @@
+package main
+
+// Stations from the static GTFS zip.
+//
+// The default stations source is "gtfs": one station per parent stop (location_type 1) in
+// stops.txt, named and placed as the MTA publishes them, with routes taken from the
+// schedule once trips and stop_times are indexed. It replaces parsing the NY Open Data and
+// MTA Stations.csv exports, whose headers drift, as the authoritative list. The CSVs stay
+// useful as overrides on top of it:
+//
+//   - stations_csv, when configured (or STATIONS_CSV is set), overrides names, locations,
+//     complex IDs and boroughs of the stops it lists
+//   - mta_stations_csv supplies daytime routes and boroughs, best-effort as before
+//
+// and as failover sources, after "gtfs", if the zip can't be loaded.
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+)
+
+// gtfsStationsSource names the stops.txt station source
+const gtfsStationsSource = "gtfs"
+
+// parseGTFSStations reads the parent stations of stops.txt. Feeds without a
+// location_type column count every stop without a parent_station.
+func parseGTFSStations(rd io.Reader) ([]Station, error) {
+	r := csv.NewReader(rd)
+	r.FieldsPerRecord = -1
+
+	need := []string{"stop_id", "stop_name", "stop_lat", "stop_lon"}
+	idx, err := parseCSVHeaders(r, need, "stops")
+	if err != nil {
+		return nil, err
+	}
+	typeIdx, hasType := idx["location_type"]
+	parentIdx, hasParent := idx["parent_station"]
+
+	var out []Station
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read stops row: %w", err)
+		}
+		if hasType {
+			if typeIdx >= len(row) || row[typeIdx] != "1" {
+				continue
+			}
+		} else if hasParent && parentIdx < len(row) && row[parentIdx] != "" {
+			continue
+		}
+		stopID := row[idx["stop_id"]]
+		lat, _ := strconv.ParseFloat(row[idx["stop_lat"]], 64)
+		lon, _ := strconv.ParseFloat(row[idx["stop_lon"]], 64)
+		if stopID == "" || lat == 0 || lon == 0 {
+			continue
+		}
+		out = append(out, Station{StopID: stopID, Name: row[idx["stop_name"]], Lat: lat, Lon: lon})
+	}
+	return out, nil
+}
+
+// loadGTFSStations loads stations from an open GTFS zip and applies the CSV overrides
+func loadGTFSStations(ctx context.Context, zf *gtfsZip) error {
+	rc, err := zf.openMember("stops.txt")
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	out, err := parseGTFSStations(rc)
+	if err != nil {
+		return err
+	}
+	if len(out) == 0 {
+		return fmt.Errorf("no parent stations in stops.txt")
+	}
+	updateData(func(ds *dataset) { ds.Stations = out })
+
+	if stationsCSV != defaultStationsCSV {
+		if err := applyStationsOverride(ctx, stationsCSV); err != nil {
+			log.Printf("Warning: failed to apply stations override %s: %v", stationsCSV, err)
+		}
+	}
+	if err := loadRouteMapping(ctx); err != nil {
+		log.Printf("Warning: failed to load route mappings: %v", err)
+	}
+	return nil
+}
+
+// applyStationsOverride overlays a stations CSV on the loaded stations, matching rows by
+// stop ID. Stops the CSV doesn't list keep their GTFS values.
+func applyStationsOverride(ctx context.Context, csvURL string) error {
+	body, err := openDataSource(ctx, csvURL)
+	if err != nil {
+		return fmt.Errorf("download stations: %w", err)
+	}
+	defer body.Close()
+	rows, err := parseStations(body)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]Station, len(rows))
+	for _, row := range rows {
+		byID[row.StopID] = row
+	}
+	n := 0
+	mutateStations(func(list []Station) {
+		for i := range list {
+			row, ok := byID[list[i].StopID]
+			if !ok {
+				continue
+			}
+			s := &list[i]
+			s.Name, s.Lat, s.Lon = row.Name, row.Lat, row.Lon
+			if row.ComplexID != "" {
+				s.ComplexID = row.ComplexID
+			}
+			if row.Borough != "" {
+				s.Borough = row.Borough
+			}
+			n++
+		}
+	})
+	log.Printf("Stations override %s applied to %d stations", csvURL, n)
+	return nil
+}
+
+// scheduledRoutes lists the routes with scheduled departures at each parent stop ID
+func scheduledRoutes(list []Trip, ix *stopTimesIndex) map[string][]string {
+	if ix == nil {
+		return nil
+	}
+	routeOf := make(map[string]string, len(list))
+	for _, t := range list {
+		routeOf[t.TripID] = t.RouteID
+	}
+	out := make(map[string][]string, len(ix.Departures))
+	for stop, deps := range ix.Departures {
+		seen := map[string]bool{}
+		for _, d := range deps {
+			route := routeOf[ix.TripIDs[d.Trip]]
+			if route != "" && !seen[route] {
+				seen[route] = true
+				out[stop] = append(out[stop], route)
+			}
+		}
+		sort.Strings(out[stop])
+	}
+	return out
+}
+
+// withScheduledRoutes returns a copy of list with routes filled in for stations that have
+// none yet
+func withScheduledRoutes(list []Station, routes map[string][]string) []Station {
+	out := append([]Station(nil), list...)
+	n := 0
+	for i := range out {
+		if len(out[i].Routes) > 0 {
+			continue
+		}
+		if r := routes[parentStopID(out[i].StopID)]; len(r) > 0 {
+			out[i].Routes = r
+			n++
+		}
+	}
+	if n > 0 {
+		log.Printf("Filled in scheduled routes for %d stations", n)
+	}
+	return out
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testGTFSStops = `stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station
+635,14 St-Union Sq,40.734673,-73.989951,1,
+635N,14 St-Union Sq,40.734700,-73.989900,,635
+635S,14 St-Union Sq,40.734600,-73.990000,,635
+640,Brooklyn Bridge-City Hall,40.713065,-74.004131,1,
+640N,Brooklyn Bridge-City Hall,40.713065,-74.004131,,640
+`
+
+func TestParseGTFSStations(t *testing.T) {
+	got, err := parseGTFSStations(strings.NewReader(testGTFSStops))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].StopID != "635" || got[0].Name != "14 St-Union Sq" || got[1].Lat != 40.713065 {
+		t.Errorf("unexpected stations %+v", got)
+	}
+
+	// Without location_type, stops without a parent are the stations
+	got, err = parseGTFSStations(strings.NewReader("stop_id,stop_name,stop_lat,stop_lon,parent_station\n635,Union Sq,40.73,-73.99,\n635N,Union Sq,40.73,-73.99,635\n"))
+	if err != nil || len(got) != 1 || got[0].StopID != "635" {
+		t.Errorf("unexpected stations %+v (%v)", got, err)
+	}
+
+	if _, err := parseGTFSStations(strings.NewReader("stop_id,stop_name\n")); err == nil || !strings.Contains(err.Error(), "missing column 'stop_lat'") {
+		t.Errorf("expected a missing column error, got %v", err)
+	}
+}
+
+func TestLoadGTFSStations(t *testing.T) {
+	keepTestData(t)
+	originalLive := liveStationsSource
+	originalCSV, originalMTA := stationsCSV, mtaStationsCSV
+	t.Cleanup(func() {
+		liveStationsSource = originalLive
+		stationsCSV, mtaStationsCSV = originalCSV, originalMTA
+	})
+	mtaStationsCSV = filepath.Join(t.TempDir(), "missing.csv") // route mapping is best-effort
+	override := filepath.Join(t.TempDir(), "stations.csv")
+	os.WriteFile(override, []byte("GTFS Stop ID,Stop Name,GTFS Latitude,GTFS Longitude,Complex ID,Borough\n635,14 St - Union Sq,40.7347,-73.9899,602,M\n"), 0o644)
+	stationsCSV = override
+
+	server := newTestGTFSServer(t, map[string]string{
+		"stops.txt": testGTFSStops,
+		"trips.txt": "route_id,trip_id,service_id,trip_headsign,direction_id\n" +
+			"6,T1,Weekday,Brooklyn Bridge,1\n" +
+			"4,T2,Weekday,Crown Hts,1\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,08:00:00,08:00:00,635S,1\n" +
+			"T1,08:10:00,08:10:00,640S,2\n" +
+			"T2,08:02:00,08:02:00,635S,1\n",
+	})
+	defer server.Close()
+	zf, err := openGTFSZip(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zf.Close()
+
+	// The CSV sources come after gtfs and aren't reached
+	if err := loadStationsWithFailover(context.Background(), []string{gtfsStationsSource, "http://invalid.local/stations.csv"}, zf); err != nil {
+		t.Fatal(err)
+	}
+	if liveStationsSource != gtfsStationsSource || len(data().Stations) != 2 {
+		t.Fatalf("expected GTFS stations, got %q (%d stations)", liveStationsSource, len(data().Stations))
+	}
+	s := data().Stations[0]
+	if s.Name != "14 St - Union Sq" || s.ComplexID != "602" || s.Borough != "M" || s.Lat != 40.7347 {
+		t.Errorf("expected the CSV override on 635, got %+v", s)
+	}
+	if data().Stations[1].Name != "Brooklyn Bridge-City Hall" {
+		t.Errorf("stops missing from the override should keep GTFS values, got %+v", data().Stations[1])
+	}
+
+	// Routes come from the schedule
+	if err := loadTripsFromZip(zf); err != nil {
+		t.Fatal(err)
+	}
+	if r := data().Stations[0].Routes; len(r) != 2 || r[0] != "4" || r[1] != "6" {
+		t.Errorf("unexpected routes at 635: %v", r)
+	}
+	if r := data().Stations[1].Routes; len(r) != 1 || r[0] != "6" {
+		t.Errorf("unexpected routes at 640: %v", r)
+	}
+
+	// Without a zip, gtfs fails over like any other source
+	err = loadStationsWithFailover(context.Background(), []string{gtfsStationsSource}, nil)
+	if err == nil || !strings.Contains(err.Error(), "GTFS zip unavailable") {
+		t.Errorf("expected a failover error, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+// Canonical stop IDs.
+//
+// GTFS stops.txt has a parent station (location_type 1, e.g. 635) and a child platform per
+// direction (635N, 635S). Stations, schedules, closures, photos and the rest are keyed by
+// the parent station, and realtime stop time updates name platforms, so every lookup goes
+// through parentStopID. It follows parent_station from stops.txt, loaded before anything
+// else from the zip; only IDs stops.txt doesn't list fall back to dropping a trailing
+// N/S/E/W direction letter. Parent stations whose IDs end in a letter are therefore kept
+// whole instead of being cut down to a neighbour's ID.
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+)
+
+// parentStopID returns the parent station of a stop ID; a parent station is its own parent
+func parentStopID(id string) string {
+	if p, ok := data().StopParents[id]; ok {
+		return p
+	}
+	if getStopDirection(id) != "" {
+		return id[:len(id)-1]
+	}
+	return id
+}
+
+// parseStopParents reads stops.txt into stop ID -> parent station ID, including each parent
+// station mapped to itself
+func parseStopParents(rd io.Reader) (map[string]string, error) {
+	r := csv.NewReader(rd)
+	r.FieldsPerRecord = -1
+
+	idx, err := parseCSVHeaders(r, []string{"stop_id"}, "stops")
+	if err != nil {
+		return nil, err
+	}
+	parentIdx, hasParent := idx["parent_station"]
+	out := map[string]string{}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read stops row: %w", err)
+		}
+		id := row[idx["stop_id"]]
+		if id == "" {
+			continue
+		}
+		parent := ""
+		if hasParent && parentIdx < len(row) {
+			parent = row[parentIdx]
+		}
+		if parent == "" {
+			parent = id
+		}
+		out[id] = parent
+	}
+	return out, nil
+}
+
+// loadStopParents reads the parent stations from stops.txt in an open GTFS zip
+func loadStopParents(zf *gtfsZip) error {
+	rc, err := zf.openMember("stops.txt")
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	parents, err := parseStopParents(rc)
+	if err != nil {
+		return err
+	}
+	updateData(func(ds *dataset) { ds.StopParents = parents })
+	log.Printf("Loaded parent stations for %d stops", len(parents))
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+const stopParentsTxt = `stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station
+635,14 St - Union Sq,40.734673,-73.989951,1,
+635N,14 St - Union Sq,40.734673,-73.989951,0,635
+635S,14 St - Union Sq,40.734673,-73.989951,0,635
+H01N,Aqueduct Racetrack,40.672097,-73.835919,1,
+H01NN,Aqueduct Racetrack,40.672097,-73.835919,0,H01N
+H01NS,Aqueduct Racetrack,40.672097,-73.835919,0,H01N
+`
+
+func TestParseStopParents(t *testing.T) {
+	parents, err := parseStopParents(strings.NewReader(stopParentsTxt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parents) != 6 || parents["635"] != "635" || parents["635S"] != "635" || parents["H01NS"] != "H01N" {
+		t.Errorf("unexpected parents %v", parents)
+	}
+}
+
+func TestParentStopID(t *testing.T) {
+	keepTestData(t)
+	parents, _ := parseStopParents(strings.NewReader(stopParentsTxt))
+	setTestStopParents(parents)
+
+	tests := map[string]string{
+		"635N":  "635",
+		"635":   "635",
+		"H01N":  "H01N", // a parent station whose ID ends in N keeps it
+		"H01NN": "H01N",
+		"H01NS": "H01N",
+		"R14S":  "R14", // not in stops.txt: the direction suffix is dropped
+		"R14":   "R14",
+	}
+	for id, want := range tests {
+		if got := parentStopID(id); got != want {
+			t.Errorf("parentStopID(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestDeparturesMatchLetterEndingStation(t *testing.T) {
+	keepTestData(t)
+	parents, _ := parseStopParents(strings.NewReader(stopParentsTxt))
+	setTestStopParents(parents)
+	setTestStations([]Station{{StopID: "H01N", Name: "Aqueduct Racetrack"}})
+
+	feed := newTestFeed(
+		testTripUpdate("A", "south", []string{"H01NS"}, []int64{60}),
+		testTripUpdate("A", "elsewhere", []string{"H01S"}, []int64{120}),
+	)
+	deps, err := departuresFromSource(data().Stations[0], departureFilter{}, func(string) (*gtfs_realtime.FeedMessage, error) { return feed, nil })
+	if err != nil || len(deps) == 0 {
+		t.Fatalf("expected departures, got %+v (%v)", deps, err)
+	}
+	for _, d := range deps {
+		if d.TripID != "south" || d.Direction != "S" {
+			t.Errorf("expected only the H01NS platform's trains, got %+v", d)
+		}
+	}
+}
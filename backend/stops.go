@@ -0,0 +1,213 @@
+package main
+
+// Official GTFS stop names.
+//
+// The stations CSV (NY Open Data) and GTFS stops.txt often spell the same station
+// differently ("14 St - Union Sq" vs "14 St-Union Sq"). Both are exposed on Station:
+// official_name is always the stops.txt name and display_name follows the
+// station_name_source setting, so clients matching against GTFS-based datasets can use
+// the official name whatever the deployment prefers. stop_name mirrors display_name.
+//
+// The stations CSV has a row per GTFS stop, so a complex like 14 St-Union Sq appears once
+// per line. /api/stops defaults to a merged rider view (see mergeStations);
+// /api/stops?view=raw returns the rows as loaded.
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strings"
+)
+
+// Station name sources for the station_name_source setting
+const (
+	nameSourceStationsCSV = "stations_csv" // default: names from the stations CSV
+	nameSourceGTFS        = "gtfs"         // names from GTFS stops.txt
+)
+
+// parseStopNames reads stops.txt into parent stop ID -> stop_name. Parent stations and
+// platforms share a parent ID; the first name seen wins.
+func parseStopNames(rd io.Reader) (map[string]string, error) {
+	r := csv.NewReader(rd)
+	r.FieldsPerRecord = -1
+
+	need := []string{"stop_id", "stop_name"}
+	idx, err := parseCSVHeaders(r, need, "stops")
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]string{}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read stops row: %w", err)
+		}
+		base := parentStopID(row[idx["stop_id"]])
+		if _, ok := out[base]; !ok && row[idx["stop_name"]] != "" {
+			out[base] = row[idx["stop_name"]]
+		}
+	}
+	return out, nil
+}
+
+// applyStationNames fills official and display names on freshly loaded stations (whose
+// Name is still the stations CSV name)
+func applyStationNames(official map[string]string, source string) {
+	mutateStations(func(list []Station) {
+		for i := range list {
+			s := &list[i]
+			s.OfficialName = official[parentStopID(s.StopID)]
+			if source == nameSourceGTFS && s.OfficialName != "" {
+				s.Name = s.OfficialName
+			}
+			s.DisplayName = s.Name
+		}
+	})
+}
+
+// loadStopNames reads stops.txt from an open GTFS zip and applies the configured naming
+func loadStopNames(zf *gtfsZip) error {
+	rc, err := zf.openMember("stops.txt")
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	names, err := parseStopNames(rc)
+	if err != nil {
+		return err
+	}
+	applyStationNames(names, appConfig.StationNameSource)
+	updateData(func(ds *dataset) { ds.StopNames = names })
+	log.Printf("Loaded %d official stop names", len(names))
+	return nil
+}
+
+// lastStopDisplayName names the last stop of a realtime trip for the headsign fallback:
+// the loaded station with the same parent stop, or the stops.txt name for stops that
+// aren't stations of their own (shuttle and terminal-only platforms)
+func lastStopDisplayName(id string) string {
+	if id == "" {
+		return ""
+	}
+	ds := data()
+	parent := parentStopID(id)
+	name := ""
+	for _, s := range ds.Stations {
+		if parentStopID(s.StopID) == parent {
+			name = s.Name
+		}
+	}
+	if name == "" {
+		name = ds.StopNames[parent]
+	}
+	return name
+}
+
+// /api/stops views
+const (
+	stopsViewRider = "rider" // default: one entry per station complex
+	stopsViewRaw   = "raw"   // one entry per stations CSV row
+)
+
+// mergeDistanceMeters is how close same-named rows must be to count as one station;
+// "23 St" alone names six different stations.
+const mergeDistanceMeters = 250
+
+// RiderStation is one station complex in the rider view. The embedded Station is the
+// complex's first row (so gtfs_stop_id works with by-id) with the routes of every row
+// and the rows' centroid as its location.
+type RiderStation struct {
+	Station
+	StopIDs []string `json:"gtfs_stop_ids"` // every GTFS stop merged into this entry
+}
+
+// mergeStations groups rows that are the same station to a rider: rows sharing a complex
+// ID or parent stop ID, rows linked by transfers.txt, and same-named rows close together.
+// Groups keep the order of their first row.
+func mergeStations(list []Station) []RiderStation {
+	parent := make([]int, len(list))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	union := func(i, j int) {
+		a, b := find(i), find(j)
+		if a > b {
+			a, b = b, a
+		}
+		parent[b] = a // the earlier row stays the root
+	}
+
+	byComplex := map[string]int{}
+	byBase := map[string]int{}
+	for i, s := range list {
+		if s.ComplexID != "" {
+			if j, ok := byComplex[s.ComplexID]; ok {
+				union(i, j)
+			} else {
+				byComplex[s.ComplexID] = i
+			}
+		}
+		base := parentStopID(s.StopID)
+		if j, ok := byBase[base]; ok {
+			union(i, j)
+		} else {
+			byBase[base] = i
+		}
+	}
+	for i, s := range list {
+		for linked := range complexTransfers[parentStopID(s.StopID)] {
+			if j, ok := byBase[linked]; ok {
+				union(i, j)
+			}
+		}
+		for j := 0; j < i; j++ {
+			if strings.EqualFold(list[j].Name, s.Name) && haversine(s.Lat, s.Lon, list[j].Lat, list[j].Lon) <= mergeDistanceMeters {
+				union(i, j)
+			}
+		}
+	}
+
+	var out []RiderStation
+	at := map[int]int{} // root row -> index in out
+	counts := map[int]int{}
+	for i, s := range list {
+		root := find(i)
+		k, ok := at[root]
+		if !ok {
+			k = len(out)
+			at[root] = k
+			first := s
+			first.Routes = nil
+			first.Lat, first.Lon = 0, 0
+			out = append(out, RiderStation{Station: first})
+		}
+		g := &out[k]
+		g.StopIDs = append(g.StopIDs, s.StopID)
+		for _, r := range s.Routes {
+			if !containsString(g.Routes, r) {
+				g.Routes = append(g.Routes, r)
+			}
+		}
+		g.Lat += s.Lat
+		g.Lon += s.Lon
+		counts[k]++
+	}
+	for k := range out {
+		// Centroid, rounded to the stations CSV's precision
+		out[k].Lat = math.Round(out[k].Lat/float64(counts[k])*1e6) / 1e6
+		out[k].Lon = math.Round(out[k].Lon/float64(counts[k])*1e6) / 1e6
+	}
+	return out
+}
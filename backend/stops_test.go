@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+func TestStationNames(t *testing.T) {
+	stopsTxt := `stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station
+635,14 St-Union Sq,40.734673,-73.989951,1,
+635N,14 St-Union Sq,40.734673,-73.989951,,635
+R20,14 St-Union Sq,40.735736,-73.990568,1,
+`
+	names, err := parseStopNames(strings.NewReader(stopsTxt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names["635"] != "14 St-Union Sq" {
+		t.Fatalf("unexpected names %v", names)
+	}
+
+	keepTestData(t)
+	load := func() {
+		setTestStations([]Station{
+			{StopID: "635", Name: "14 St - Union Sq"},
+			{StopID: "A31", Name: "14 St"},
+		})
+	}
+
+	// Default: keep the stations CSV name, expose the official one alongside
+	load()
+	applyStationNames(names, "")
+	if s := data().Stations[0]; s.Name != "14 St - Union Sq" || s.DisplayName != s.Name || s.OfficialName != "14 St-Union Sq" {
+		t.Errorf("unexpected default naming %+v", s)
+	}
+
+	// gtfs preference switches the display name where an official name exists
+	load()
+	applyStationNames(names, nameSourceGTFS)
+	if s := data().Stations[0]; s.Name != "14 St-Union Sq" || s.DisplayName != "14 St-Union Sq" {
+		t.Errorf("unexpected gtfs naming %+v", s)
+	}
+	if s := data().Stations[1]; s.Name != "14 St" || s.OfficialName != "" {
+		t.Errorf("stations without an official name keep theirs, got %+v", s)
+	}
+}
+
+func TestMergeStations(t *testing.T) {
+	originalTransfers := complexTransfers
+	defer func() { complexTransfers = originalTransfers }()
+	complexTransfers = map[string]map[string]int{"127": {"725": 180}, "725": {"127": 180}}
+
+	rows := []Station{
+		{StopID: "635", Name: "14 St-Union Sq", Lat: 40.734673, Lon: -73.989951, Routes: []string{"4", "5", "6"}, ComplexID: "602"},
+		{StopID: "R20", Name: "14 St-Union Sq", Lat: 40.735736, Lon: -73.990568, Routes: []string{"N", "Q", "R", "W"}, ComplexID: "602"},
+		{StopID: "127", Name: "Times Sq-42 St", Lat: 40.75529, Lon: -73.987495, Routes: []string{"1", "2", "3"}},
+		{StopID: "725", Name: "Times Sq-42 St", Lat: 40.755477, Lon: -73.987691, Routes: []string{"7"}},
+		{StopID: "A30", Name: "23 St", Lat: 40.745906, Lon: -73.998041, Routes: []string{"C", "E"}},
+		{StopID: "D18", Name: "23 St", Lat: 40.742878, Lon: -73.992821, Routes: []string{"F", "M"}},
+		{StopID: "L03", Name: "14 St - Union Sq", Lat: 40.734789, Lon: -73.99073, Routes: []string{"L"}},
+		{StopID: "L03N", Name: "14 St-Union Sq", Lat: 40.734789, Lon: -73.99073, Routes: []string{"L"}},
+	}
+	got := mergeStations(rows)
+	if len(got) != 4 {
+		t.Fatalf("expected 4 rider stations, got %d: %+v", len(got), got)
+	}
+	union := got[0]
+	if union.StopID != "635" || strings.Join(union.StopIDs, ",") != "635,R20,L03,L03N" {
+		t.Errorf("expected Union Sq rows merged by complex and parent ID, got %+v", union)
+	}
+	if strings.Join(union.Routes, ",") != "4,5,6,N,Q,R,W,L" {
+		t.Errorf("expected combined routes, got %v", union.Routes)
+	}
+	if union.Lat < 40.7346 || union.Lat > 40.7358 {
+		t.Errorf("expected centroid latitude, got %f", union.Lat)
+	}
+	if strings.Join(got[1].StopIDs, ",") != "127,725" {
+		t.Errorf("expected Times Sq merged via transfers, got %+v", got[1])
+	}
+	if got[2].StopID != "A30" || got[3].StopID != "D18" {
+		t.Errorf("distant same-named stations must stay apart, got %+v %+v", got[2], got[3])
+	}
+}
+
+func TestLastStopDisplayName(t *testing.T) {
+	keepTestData(t)
+	setTestStopParents(map[string]string{"901": "901", "901N": "901", "H19": "H19", "H19S": "H19"})
+	setTestStopNames(map[string]string{"901": "Grand Central-42 St", "H19": "Broad Channel", "635": "14 St-Union Sq"})
+	setTestStations([]Station{{StopID: "635", Name: "14 St - Union Sq", Routes: []string{"GS"}}})
+
+	tests := map[string]string{
+		"635N": "14 St - Union Sq",    // a loaded station keeps its display name
+		"901N": "Grand Central-42 St", // shuttle platform only in stops.txt
+		"H19S": "Broad Channel",
+		"999N": "",
+		"":     "",
+	}
+	for id, want := range tests {
+		if got := lastStopDisplayName(id); got != want {
+			t.Errorf("lastStopDisplayName(%q) = %q, want %q", id, got, want)
+		}
+	}
+
+	// The headsign falls back to it when the trip isn't in trips.txt
+	setTestTrips(nil)
+	feed := newTestFeed(testTripUpdate("GS", "shuttle", []string{"635N", "901N"}, []int64{60, 300}))
+	deps, err := departuresFromSource(data().Stations[0], departureFilter{}, func(string) (*gtfs_realtime.FeedMessage, error) { return feed, nil })
+	if err != nil || len(deps) != 1 || deps[0].HeadSign != "Grand Central-42 St" {
+		t.Errorf("expected a departure headed to Grand Central-42 St, got %+v (%v)", deps, err)
+	}
+}
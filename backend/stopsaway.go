@@ -0,0 +1,77 @@
+package main
+
+// Stops away.
+//
+// Countdown clocks say where a train is as well as when it's due: "2 stops away, at
+// 23 St". The feeds report where each train is in a VehiclePosition entity, separate from
+// its TripUpdate; the two are joined on trip ID. The vehicle's stop is found among the
+// trip's remaining stop time updates, and stops_away counts the stops the train serves
+// from there up to (not including) the departure's stop, so a train stopped at the
+// station, or on its way to it, is 0 stops away. current_status says which: the train is
+// stopped_at, incoming_at or in_transit_to current_stop. A train whose position isn't
+// on the trip's remaining stops (a stale position, or one past the station) gets neither.
+
+import (
+	"strings"
+
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// vehicleAt is where a trip's train is, from its VehiclePosition
+type vehicleAt struct {
+	StopID string
+	Status gtfs_realtime.VehiclePosition_VehicleStopStatus
+}
+
+// vehiclePositions indexes a feed's vehicle positions by trip ID
+func vehiclePositions(feed *gtfs_realtime.FeedMessage) map[string]vehicleAt {
+	var out map[string]vehicleAt
+	for _, ent := range feed.GetEntity() {
+		v := ent.GetVehicle()
+		if v == nil || v.GetStopId() == "" || v.GetTrip().GetTripId() == "" {
+			continue
+		}
+		if out == nil {
+			out = map[string]vehicleAt{}
+		}
+		// current_status defaults to IN_TRANSIT_TO when unset
+		out[v.GetTrip().GetTripId()] = vehicleAt{StopID: v.GetStopId(), Status: v.GetCurrentStatus()}
+	}
+	return out
+}
+
+// vehicleStopIndex finds the train's stop among a trip's stop time updates, or -1
+func vehicleStopIndex(stus []*gtfs_realtime.TripUpdate_StopTimeUpdate, v vehicleAt) int {
+	for i, stu := range stus {
+		if stu.GetStopId() == v.StopID {
+			return i
+		}
+	}
+	parent := parentStopID(v.StopID)
+	for i, stu := range stus {
+		if parentStopID(stu.GetStopId()) == parent {
+			return i
+		}
+	}
+	return -1
+}
+
+// stopsAway counts the stops the train serves from its own stop (index at) up to the
+// departure's (index k); ok is false if the train isn't on its way there
+func stopsAway(stus []*gtfs_realtime.TripUpdate_StopTimeUpdate, at, k int) (int, bool) {
+	if at < 0 || at > k {
+		return 0, false
+	}
+	n := 0
+	for _, stu := range stus[at:k] {
+		if stopServed(stu) {
+			n++
+		}
+	}
+	return n, true
+}
+
+// vehicleStatus is the API's name for a vehicle stop status
+func vehicleStatus(s gtfs_realtime.VehiclePosition_VehicleStopStatus) string {
+	return strings.ToLower(s.String())
+}
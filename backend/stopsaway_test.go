@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+func testVehicleAt(tripID, stopID string, status gtfs_realtime.VehiclePosition_VehicleStopStatus) *gtfs_realtime.FeedEntity {
+	return &gtfs_realtime.FeedEntity{
+		Id: proto.String("v-" + tripID),
+		Vehicle: &gtfs_realtime.VehiclePosition{
+			Trip:          &gtfs_realtime.TripDescriptor{TripId: proto.String(tripID)},
+			StopId:        proto.String(stopID),
+			CurrentStatus: status.Enum(),
+		},
+	}
+}
+
+func TestDeparturesStopsAway(t *testing.T) {
+	keepTestData(t)
+	setTestStations([]Station{
+		{StopID: "L08", Name: "Bedford Av", Routes: []string{"L"}},
+		{StopID: "L10", Name: "Graham Av"},
+	})
+
+	// Stopped at Graham Av, then Grand St (skipped), Lorimer St, Bedford Av
+	far := testTripUpdate("L", "far", []string{"L10N", "L11N", "L12N", "L08N"}, []int64{30, 90, 150, 210})
+	far.TripUpdate.StopTimeUpdate[1].ScheduleRelationship = gtfs_realtime.TripUpdate_StopTimeUpdate_SKIPPED.Enum()
+	near := testTripUpdate("L", "near", []string{"L08N", "L06N"}, []int64{60, 120})
+	unknown := testTripUpdate("L", "unknown", []string{"L08N"}, []int64{300})
+	passed := testTripUpdate("L", "passed", []string{"L08N", "L06N"}, []int64{320, 400})
+
+	feed := newTestFeed(far, near, unknown, passed,
+		testVehicleAt("far", "L10N", gtfs_realtime.VehiclePosition_STOPPED_AT),
+		testVehicleAt("near", "L08N", gtfs_realtime.VehiclePosition_INCOMING_AT),
+		testVehicleAt("passed", "L06N", gtfs_realtime.VehiclePosition_IN_TRANSIT_TO),
+	)
+	deps, err := departuresFromSource(data().Stations[0], departureFilter{Limit: 4}, func(string) (*gtfs_realtime.FeedMessage, error) { return feed, nil })
+	if err != nil || len(deps) != 4 {
+		t.Fatalf("expected four departures, got %+v (%v)", deps, err)
+	}
+	byTrip := map[string]Departure{}
+	for _, d := range deps {
+		byTrip[d.TripID] = d
+	}
+	if d := byTrip["near"]; d.StopsAway == nil || *d.StopsAway != 0 || d.CurrentStatus != "incoming_at" || d.CurrentStop != "Bedford Av" {
+		t.Errorf("expected the near train arriving, got %+v", d)
+	}
+	if d := byTrip["far"]; d.StopsAway == nil || *d.StopsAway != 2 || d.CurrentStatus != "stopped_at" || d.CurrentStop != "Graham Av" {
+		t.Errorf("expected the far train 2 stops away at Graham Av, got %+v", d)
+	}
+	for _, id := range []string{"unknown", "passed"} {
+		if d := byTrip[id]; d.StopsAway != nil || d.CurrentStatus != "" {
+			t.Errorf("expected no position for %s, got %+v", id, d)
+		}
+	}
+}
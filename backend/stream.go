@@ -0,0 +1,133 @@
+package main
+
+// Server-Sent Events departure stream for countdown clocks.
+//
+//   GET /api/departures/stream?id=635   (same filters as by-id)
+//
+// The response is a text/event-stream. A "departures" event carrying the by-id body is
+// sent right away and again whenever a feed is refreshed (by the poller, or when the feed
+// cache refetches); an "approaching" event carries a single departure the first time its
+// ETA drops inside approaching_threshold (see approaching.go). Without other traffic the stream recomputes every
+// streamRefreshInterval, which refetches expired feeds. An "error" event reports a failed
+// update without closing the stream. Streams end when the client goes away or the server
+// starts draining.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// streamRefreshInterval matches the default feed cache TTL
+const streamRefreshInterval = 30 * time.Second
+
+// refreshSignal wakes every waiter when a feed is refreshed
+type refreshSignal struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+var feedRefresh = &refreshSignal{ch: make(chan struct{})}
+
+// wait returns a channel closed at the next broadcast
+func (s *refreshSignal) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ch
+}
+
+func (s *refreshSignal) broadcast() {
+	s.mu.Lock()
+	close(s.ch)
+	s.ch = make(chan struct{})
+	s.mu.Unlock()
+}
+
+func handleStream(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	id := strings.TrimSpace(r.URL.Query().Get("id"))
+	if id == "" {
+		httpError(w, http.StatusBadRequest, "missing id")
+		return
+	}
+	filter, err := parseDepartureFilter(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s, ok := stationByID(id)
+	if !ok {
+		httpError(w, http.StatusNotFound, "no station matched by id")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	if !acquireStream(w, r, transportSSE) {
+		return
+	}
+	defer streamStats.release(transportSSE, streamAddr(r))
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no") // keep proxies from buffering events
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(streamRefreshInterval)
+	defer ticker.Stop()
+	sent := 0
+	approach := newApproachTracker()
+	for {
+		// Subscribe after computing, so a refresh caused by this update doesn't trigger another
+		update, err := stationUpdate(s, filter)
+		refreshed := feedRefresh.wait()
+		if err != nil {
+			writeEvent(w, "error", map[string]string{"error": err.Error()})
+		} else {
+			writeEvent(w, "departures", update)
+			for _, d := range approach.check(parentStopID(s.StopID), update.Departures, sent == 0, time.Now()) {
+				writeEvent(w, "approaching", d)
+			}
+		}
+		flusher.Flush()
+		sent++
+		select {
+		case <-r.Context().Done():
+			log.Printf("Stream for %s closed after %d events", s.StopID, sent)
+			return
+		case <-quitCh:
+			return
+		case <-refreshed:
+		case <-ticker.C:
+		}
+	}
+}
+
+// stationUpdate builds a by-id response for s
+func stationUpdate(s Station, filter departureFilter) (NearestResponse, error) {
+	deps, err := departuresForStation(s, filter)
+	warnings, err := splitPartial(err)
+	if err != nil {
+		return NearestResponse{}, err
+	}
+	resp := NearestResponse{Station: s, Photos: photosForStation(s), Transfers: transfersForStation(s), Alerts: alertsForStation(s), Cancellations: cancellationsForStation(s, filter, fetchGTFS), Partial: len(warnings) > 0, Warnings: warnings, Departures: deps, Meta: departureMeta(filter, s)}
+	if cl, closed := closures.active(s.StopID, time.Now()); closed {
+		resp.Closure = &cl
+	}
+	return resp, nil
+}
+
+// writeEvent writes one SSE event with a JSON payload
+func writeEvent(w http.ResponseWriter, event string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("stream: marshal %s event: %v", event, err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
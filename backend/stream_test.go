@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// readEvent reads one SSE event and returns its name and data
+func readEvent(t *testing.T, r *bufio.Reader) (string, string) {
+	t.Helper()
+	var event, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestDepartureStream(t *testing.T) {
+	initTestCaches()
+	useTestFeeds(t)
+	keepTestData(t)
+	originalRouteToFeed := routeToFeed
+	t.Cleanup(func() { routeToFeed = originalRouteToFeed })
+	setTestStations([]Station{{StopID: "635", Name: "14 St - Union Sq", Routes: []string{"6"}}})
+
+	var version int32
+	feeds := [][]byte{}
+	for _, trip := range []string{"first", "second"} {
+		b, err := proto.Marshal(newTestFeed(testTripUpdate("6", trip, []string{"635N"}, []int64{120})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		feeds = append(feeds, b)
+	}
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(feeds[atomic.LoadInt32(&version)])
+	}))
+	defer feed.Close()
+	routeToFeed = map[string]string{"6": feed.URL}
+
+	server := httptest.NewServer(newMux())
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/departures/stream?id=635", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", ct)
+	}
+	body := bufio.NewReader(resp.Body)
+
+	tripOf := func() string {
+		event, data := readEvent(t, body)
+		var update NearestResponse
+		if err := json.Unmarshal([]byte(data), &update); event != "departures" || err != nil {
+			t.Fatalf("expected a departures event, got %q %s", event, data)
+		}
+		if len(update.Departures) != 1 {
+			t.Fatalf("expected one departure, got %+v", update.Departures)
+		}
+		return update.Departures[0].TripID
+	}
+	if got := tripOf(); got != "first" {
+		t.Errorf("initial event trip = %q", got)
+	}
+
+	// The next feed refresh pushes an update
+	atomic.StoreInt32(&version, 1)
+	transitFeedCache.Purge()
+	feedRefresh.broadcast()
+	if got := tripOf(); got != "second" {
+		t.Errorf("refreshed event trip = %q", got)
+	}
+}
+
+func TestDepartureStreamUnknownStation(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleStream(w, httptest.NewRequest("GET", "/api/departures/stream?id=nope", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+// The supplemented GTFS: a second static source for headsigns.
+//
+// The MTA publishes gtfs_supplemented.zip alongside the base schedule, with the service
+// changes for the coming week folded in, so its trips carry the headsigns riders actually
+// see during planned work. It is configured on its own:
+//
+//   - supplemented_gtfs_url (or SUPPLEMENTED_GTFS_URL) is where it is downloaded from
+//   - supplemented_refresh_interval is how often it is downloaded again (default 30m)
+//   - headsign_precedence is "supplemented" (default) to look trips up there before the
+//     base trips, "base" to prefer the base trips and fall back to it, or "base_only" to
+//     not download it at all
+//
+// Its calendar, when present, takes precedence over the base calendar (see calendar.go).
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// headsign_precedence values
+const (
+	headsignSupplemented = "supplemented"
+	headsignBase         = "base"
+	headsignBaseOnly     = "base_only"
+)
+
+func headsignPrecedence() string {
+	if p := appConfig.HeadsignPrecedence; p != "" {
+		return p
+	}
+	return headsignSupplemented
+}
+
+// supplementedEnabled reports whether the supplemented GTFS is downloaded at all
+func supplementedEnabled() bool {
+	return headsignPrecedence() != headsignBaseOnly
+}
+
+// tripSource is a trips table consulted for headsigns
+type tripSource struct {
+	name  string
+	trips []Trip
+}
+
+// headsignTripSources lists the trip tables in lookup order
+func headsignTripSources() []tripSource {
+	ds := data()
+	supp := tripSource{name: "supplemented", trips: ds.SupplementedTrips}
+	base := tripSource{name: "regular", trips: ds.Trips}
+	switch headsignPrecedence() {
+	case headsignBase:
+		return []tripSource{base, supp}
+	case headsignBaseOnly:
+		return []tripSource{base}
+	default:
+		return []tripSource{supp, base}
+	}
+}
+
+// loadSupplementedTrips loads trips and the service calendar from the supplemented GTFS
+func loadSupplementedTrips(ctx context.Context, zipURL string) ([]Trip, *serviceCalendar, error) {
+	start := time.Now()
+	log.Printf("Loading supplemented GTFS trips from %s", zipURL)
+
+	zf, err := openGTFSZip(ctx, zipURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("download supplemented GTFS zip: %w", err)
+	}
+	defer zf.Close()
+
+	rc, err := zf.openMember("trips.txt")
+	if err != nil {
+		return nil, nil, fmt.Errorf("supplemented GTFS: %w", err)
+	}
+	defer rc.Close()
+
+	out, err := parseTrips(rc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("supplemented GTFS: %w", err)
+	}
+
+	// Without a calendar, headsigns fall back to the static one
+	cal, err := loadCalendar(zf)
+	if err != nil {
+		log.Printf("Warning: supplemented GTFS calendar: %v", err)
+	}
+
+	log.Printf("Loaded %d supplemented trips in %.2f ms", len(out),
+		float64(time.Since(start).Microseconds())/1000.0)
+	return out, cal, nil
+}
+
+// refreshSupplemented downloads the supplemented GTFS and installs its trips and calendar.
+// On failure the previously loaded data stays in place.
+func refreshSupplemented(ctx context.Context, zipURL string) error {
+	suppTrips, suppCal, err := loadSupplementedTrips(ctx, zipURL)
+	if err != nil {
+		return err
+	}
+	updateData(func(ds *dataset) { ds.SupplementedTrips, ds.SuppCalendar = suppTrips, suppCal })
+	return nil
+}
+
+// runSupplementedRefresh refreshes the supplemented GTFS every interval until ctx is done
+func runSupplementedRefresh(ctx context.Context, zipURL string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			log.Printf("Refreshing supplemented GTFS data...")
+			if err := refreshSupplemented(ctx, zipURL); err != nil {
+				log.Printf("Warning: failed to refresh supplemented GTFS trips data: %v", err)
+			} else {
+				log.Printf("Refreshed %d supplemented trips", len(data().SupplementedTrips))
+			}
+		}
+	}
+}
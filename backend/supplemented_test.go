@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRefreshSupplemented(t *testing.T) {
+	keepTestData(t)
+
+	server := newTestGTFSServer(t, map[string]string{
+		"trips.txt":          "route_id,trip_id,service_id,trip_headsign,direction_id\n6,Weekday-063000_6..S,Weekday,Brooklyn Bridge,1\n",
+		"calendar_dates.txt": "service_id,date,exception_type\nWeekday,20261126,2\n",
+	})
+	defer server.Close()
+	if err := refreshSupplemented(context.Background(), server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if len(data().SupplementedTrips) != 1 || data().SuppCalendar == nil {
+		t.Fatalf("expected trips and a calendar, got %d trips, calendar %v", len(data().SupplementedTrips), data().SuppCalendar)
+	}
+
+	// A failed refresh keeps what was loaded
+	server.Close()
+	if err := refreshSupplemented(context.Background(), server.URL); err == nil {
+		t.Error("expected an error from a closed server")
+	}
+	if len(data().SupplementedTrips) != 1 {
+		t.Errorf("expected the previous trips to survive a failed refresh, got %d", len(data().SupplementedTrips))
+	}
+}
+
+func TestHeadsignPrecedence(t *testing.T) {
+	keepTestData(t)
+	originalPrecedence := appConfig.HeadsignPrecedence
+	t.Cleanup(func() {
+		appConfig.HeadsignPrecedence = originalPrecedence
+	})
+	setTestCalendar(nil)
+	setTestSuppCalendar(nil)
+	setTestTrips([]Trip{{TripID: "Weekday-063000_6..S", ServiceID: "Weekday", TripHeadsign: "Brooklyn Bridge"}})
+	setTestSupplementedTrips([]Trip{{TripID: "Weekday-063000_6..S", ServiceID: "Weekday", TripHeadsign: "Bowling Green"}})
+
+	for _, tt := range []struct{ precedence, want string }{
+		{"", "Bowling Green"},
+		{headsignSupplemented, "Bowling Green"},
+		{headsignBase, "Brooklyn Bridge"},
+		{headsignBaseOnly, "Brooklyn Bridge"},
+	} {
+		appConfig.HeadsignPrecedence = tt.precedence
+		if got := lookupHeadsignWithSupplemented("063000_6..S"); got != tt.want {
+			t.Errorf("precedence %q: headsign %q, want %q", tt.precedence, got, tt.want)
+		}
+	}
+
+	// With base first, trips only the supplemented GTFS knows still resolve
+	appConfig.HeadsignPrecedence = headsignBase
+	setTestSupplementedTrips(append(data().SupplementedTrips, Trip{TripID: "Weekday-070000_6..S", ServiceID: "Weekday", TripHeadsign: "Bowling Green"}))
+	if got := lookupHeadsignWithSupplemented("070000_6..S"); got != "Bowling Green" {
+		t.Errorf("expected a fallback to the supplemented trips, got %q", got)
+	}
+	if !supplementedEnabled() {
+		t.Error("base precedence should still download the supplemented GTFS")
+	}
+	appConfig.HeadsignPrecedence = headsignBaseOnly
+	if supplementedEnabled() {
+		t.Error("base_only should disable the supplemented GTFS")
+	}
+}
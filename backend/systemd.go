@@ -0,0 +1,118 @@
+package main
+
+// systemd service notifications (sd_notify), for Type=notify units:
+//
+//   [Service]
+//   Type=notify
+//   WatchdogSec=60
+//   ExecStart=/usr/local/bin/nyc-subway
+//
+// READY=1 is sent once static data has loaded (so `systemctl start` returns when the API
+// can answer) and STOPPING=1 when draining begins. With WatchdogSec set, WATCHDOG=1 is
+// sent at half the interval for as long as the feed poller keeps completing cycles; if
+// it wedges the pings stop and systemd restarts the service. Outside systemd
+// (NOTIFY_SOCKET unset) all of this is a no-op.
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// pollerStallGrace is how long past its interval a poll cycle may run (feed downloads
+// time out individually, so a healthy cycle over all feeds fits comfortably)
+const pollerStallGrace = 2 * time.Minute
+
+var (
+	// pollerInterval is the running poller's interval (0 when the poller is off)
+	pollerInterval time.Duration
+	// pollerFeedInterval is how often the poller refreshes a feed without its own
+	// poll_intervals entry
+	pollerFeedInterval time.Duration
+	// pollerHeartbeat is the unix-nano time the poller last finished a cycle
+	pollerHeartbeat int64
+)
+
+// sdNotify sends state to the service manager; it reports false when not under systemd
+func sdNotify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:] // abstract namespace
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// notifySystemd is sdNotify for fire-and-forget callers
+func notifySystemd(state string) {
+	if _, err := sdNotify(state); err != nil {
+		log.Printf("Warning: sd_notify %q failed: %v", state, err)
+	}
+}
+
+// watchdogInterval reads the watchdog interval systemd asked for (0 if none)
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0 // meant for another process
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// pollerHealthy reports whether the poller (if running) finished a cycle recently
+func pollerHealthy(now time.Time) bool {
+	if pollerInterval <= 0 {
+		return true
+	}
+	last := time.Unix(0, atomic.LoadInt64(&pollerHeartbeat))
+	return now.Sub(last) <= pollerInterval+pollerStallGrace
+}
+
+// startWatchdog pings the systemd watchdog at half its interval while the poller is
+// healthy, until ctx is cancelled
+func startWatchdog(ctx context.Context) {
+	interval := watchdogInterval()
+	if interval <= 0 {
+		return
+	}
+	log.Printf("systemd watchdog enabled (every %s)", interval)
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		stalled := false
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if !pollerHealthy(now) {
+					if !stalled {
+						log.Printf("Warning: feed poller has not completed a cycle since %s; withholding watchdog pings",
+							time.Unix(0, atomic.LoadInt64(&pollerHeartbeat)).Format(time.RFC3339))
+					}
+					stalled = true
+					continue
+				}
+				stalled = false
+				notifySystemd("WATCHDOG=1")
+			}
+		}
+	}()
+}
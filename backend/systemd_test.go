@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSDNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := sdNotify("READY=1"); sent || err != nil {
+		t.Errorf("expected no-op outside systemd, got sent=%v err=%v", sent, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets unavailable: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	if sent, err := sdNotify("READY=1"); !sent || err != nil {
+		t.Fatalf("expected notification sent, got sent=%v err=%v", sent, err)
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Errorf("expected READY=1, got %q (%v)", buf[:n], err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	t.Setenv("WATCHDOG_PID", "")
+	if got := watchdogInterval(); got != 0 {
+		t.Errorf("expected no watchdog, got %s", got)
+	}
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	if got := watchdogInterval(); got != 30*time.Second {
+		t.Errorf("expected 30s, got %s", got)
+	}
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if got := watchdogInterval(); got != 0 {
+		t.Errorf("expected watchdog for another pid to be ignored, got %s", got)
+	}
+}
+
+func TestPollerHealthy(t *testing.T) {
+	originalInterval, originalHeartbeat := pollerInterval, atomic.LoadInt64(&pollerHeartbeat)
+	defer func() {
+		pollerInterval = originalInterval
+		atomic.StoreInt64(&pollerHeartbeat, originalHeartbeat)
+	}()
+	now := time.Now()
+
+	pollerInterval = 0
+	if !pollerHealthy(now) {
+		t.Error("no poller should always be healthy")
+	}
+	pollerInterval = 30 * time.Second
+	atomic.StoreInt64(&pollerHeartbeat, now.Add(-time.Minute).UnixNano())
+	if !pollerHealthy(now) {
+		t.Error("a cycle a minute ago should be healthy")
+	}
+	atomic.StoreInt64(&pollerHeartbeat, now.Add(-10*time.Minute).UnixNano())
+	if pollerHealthy(now) {
+		t.Error("a poller silent for 10 minutes should be wedged")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+// CSV and TSV departures.
+//
+//   GET /api/departures/by-id?id=635&format=csv
+//   curl -s '.../api/departures/nearest?lat=40.7347&lon=-73.9899&format=tsv' | column -t -s $'\t'
+//
+// format=csv (or tsv) flattens a departures response into one row per departure with the
+// columns in tableColumns, for spreadsheets and shell scripts. Station is the name of the
+// station the departure is from; timestamp is the departure time in New York (RFC 3339).
+// Errors, and the endpoints without departures, stay JSON. The conversion runs on the
+// finished JSON, after station names are localized.
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var tableColumns = []string{"station", "route", "direction", "eta_seconds", "headsign", "timestamp"}
+
+// tableFormat is the delimiter and content type of a format parameter value
+type tableFormat struct {
+	comma       rune
+	contentType string
+}
+
+var tableFormats = map[string]tableFormat{
+	"csv": {',', "text/csv; charset=utf-8"},
+	"tsv": {'\t', "text/tab-separated-values; charset=utf-8"},
+}
+
+// tableSource is the part of any departures response the table is built from: nearest,
+// by-id and by-name have station and departures, bulk and nearest-multi a list of those,
+// and any a flat departures list labelled per departure
+type tableSource struct {
+	Station    *Station       `json:"station"`
+	Departures []AnyDeparture `json:"departures"`
+	Stations   []tableSource  `json:"stations"`
+}
+
+// rows appends one row per departure in src and the responses nested in it
+func (src tableSource) rows(out [][]string) [][]string {
+	for _, d := range src.Departures {
+		station := d.StationName
+		if station == "" && src.Station != nil {
+			station = src.Station.Name
+		}
+		out = append(out, []string{
+			station,
+			d.RouteID,
+			d.Direction,
+			strconv.FormatInt(d.ETASeconds, 10),
+			d.HeadSign,
+			time.Unix(d.UnixTime, 0).In(transitLocation).Format(time.RFC3339),
+		})
+	}
+	for _, s := range src.Stations {
+		out = s.rows(out)
+	}
+	return out
+}
+
+// departuresTable converts a JSON departures response to rows
+func departuresTable(body []byte) ([][]string, error) {
+	var src tableSource
+	if err := json.Unmarshal(body, &src); err != nil {
+		return nil, err
+	}
+	return src.rows([][]string{tableColumns}), nil
+}
+
+// withTable answers departure endpoints in CSV or TSV when format asks for it
+func withTable(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format, ok := tableFormats[r.URL.Query().Get("format")]
+		if !ok || !strings.HasPrefix(r.URL.Path, "/api/departures/") || r.URL.Path == "/api/departures/stream" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		buf := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		h.ServeHTTP(buf, r)
+		body := buf.body.Bytes()
+		if buf.status == http.StatusOK && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			if rows, err := departuresTable(body); err == nil {
+				var out bytes.Buffer
+				cw := csv.NewWriter(&out)
+				cw.Comma = format.comma
+				cw.WriteAll(rows)
+				if cw.Error() == nil {
+					w.Header().Set("Content-Type", format.contentType)
+					body = out.Bytes()
+				}
+			}
+		}
+		w.WriteHeader(buf.status)
+		w.Write(body)
+	})
+}
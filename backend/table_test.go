@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDeparturesTable(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+	setTestStations([]Station{
+		{StopID: "635", Name: "14 St - Union Sq"},
+		{StopID: "L03", Name: "Union Sq - 14 St"},
+	})
+	server := newTestFeedServer(t,
+		testTripUpdate("6", "trip6", []string{"635N"}, []int64{120}),
+		testTripUpdate("L", "tripL", []string{"L03S"}, []int64{300}),
+	)
+	useTestFeeds(t, server.URL)
+	mux := newMux()
+
+	read := func(path string, comma rune, contentType string) [][]string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != contentType {
+			t.Fatalf("%s: expected a %s 200, got %d %v: %s", path, contentType, w.Code, w.Header(), w.Body.String())
+		}
+		r := csv.NewReader(w.Body)
+		r.Comma = comma
+		rows, err := r.ReadAll()
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if len(rows) == 0 || strings.Join(rows[0], ",") != "station,route,direction,eta_seconds,headsign,timestamp" {
+			t.Fatalf("%s: unexpected header %v", path, rows)
+		}
+		return rows[1:]
+	}
+
+	rows := read("/api/departures/by-id?id=635&format=csv", ',', "text/csv; charset=utf-8")
+	if len(rows) != 1 || rows[0][0] != "14 St - Union Sq" || rows[0][1] != "6" || rows[0][2] != "N" {
+		t.Errorf("unexpected by-id rows %v", rows)
+	}
+	if !strings.HasSuffix(rows[0][5], "-04:00") && !strings.HasSuffix(rows[0][5], "-05:00") {
+		t.Errorf("expected a New York timestamp, got %q", rows[0][5])
+	}
+
+	// Bulk rows are labelled with each response's station, any rows with their own
+	rows = read("/api/departures/bulk?ids=635,L03&format=tsv", '\t', "text/tab-separated-values; charset=utf-8")
+	if len(rows) != 2 || rows[0][0] != "14 St - Union Sq" || rows[1][0] != "Union Sq - 14 St" || rows[1][3] == "" {
+		t.Errorf("unexpected bulk rows %v", rows)
+	}
+	rows = read("/api/departures/any?ids=635,L03&format=csv", ',', "text/csv; charset=utf-8")
+	if len(rows) != 2 || rows[0][1] != "6" || rows[1][0] != "Union Sq - 14 St" {
+		t.Errorf("unexpected any rows %v", rows)
+	}
+}
+
+func TestDeparturesTableErrorsStayJSON(t *testing.T) {
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "635"}})
+
+	w := httptest.NewRecorder()
+	newMux().ServeHTTP(w, httptest.NewRequest("GET", "/api/departures/by-id?id=NoSuchID&format=csv", nil))
+	if w.Code != http.StatusNotFound || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("expected a JSON 404, got %d %v", w.Code, w.Header())
+	}
+}
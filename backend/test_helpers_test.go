@@ -1,9 +1,18 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
 	"time"
 
 	"github.com/bluele/gcache"
+	"google.golang.org/protobuf/proto"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
 )
 
 // initTestCaches initializes all caches with test-appropriate configurations.
@@ -16,7 +25,7 @@ func initTestCaches() {
 		Build()
 
 	// Stops cache: same size as production (1)
-	stopsCache = gcache.New(1).
+	stopsCache = gcache.New(2).
 		LRU().
 		Expiration(24 * time.Hour).
 		Build()
@@ -27,4 +36,150 @@ func initTestCaches() {
 		Expiration(30 * time.Second).
 		Build()
 
-}
\ No newline at end of file
+}
+
+// keepTestData restores the dataset (see dataset.go) when the test ends
+func keepTestData(t *testing.T) {
+	original := liveData.Load()
+	t.Cleanup(func() { liveData.Store(original) })
+}
+
+func setTestStations(list []Station) { updateData(func(ds *dataset) { ds.Stations = list }) }
+func setTestTrips(list []Trip)       { updateData(func(ds *dataset) { ds.Trips = list }) }
+func setTestSupplementedTrips(list []Trip) {
+	updateData(func(ds *dataset) { ds.SupplementedTrips = list })
+}
+func setTestCalendar(c *serviceCalendar) { updateData(func(ds *dataset) { ds.Calendar = c }) }
+func setTestSuppCalendar(c *serviceCalendar) {
+	updateData(func(ds *dataset) { ds.SuppCalendar = c })
+}
+func setTestStopTimes(ix *stopTimesIndex) { updateData(func(ds *dataset) { ds.StopTimes = ix }) }
+func setTestTripServices(m map[string]string) {
+	updateData(func(ds *dataset) { ds.TripServices = m })
+}
+func setTestRoutePatterns(m map[string]map[string]routePattern) {
+	updateData(func(ds *dataset) { ds.RoutePatterns = m })
+}
+func setTestRouteStopOrders(m map[string]map[string][]string) {
+	updateData(func(ds *dataset) { ds.RouteStopOrders = m })
+}
+func setTestRunTimes(m map[string]map[string]int32) {
+	updateData(func(ds *dataset) { ds.RunTimes = m })
+}
+func setTestStopParents(m map[string]string) {
+	updateData(func(ds *dataset) { ds.StopParents = m })
+}
+func setTestStopNames(m map[string]string) {
+	updateData(func(ds *dataset) { ds.StopNames = m })
+}
+
+// buildTestGTFSZip creates an in-memory GTFS zip containing the given files (name -> contents).
+func buildTestGTFSZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		f.Write([]byte(files[name]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// newTestGTFSServer serves a GTFS zip built from files; close it with defer server.Close().
+func newTestGTFSServer(t *testing.T, files map[string]string) *httptest.Server {
+	t.Helper()
+	data := buildTestGTFSZip(t, files)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Write(data)
+	}))
+}
+
+// testTripUpdate builds a TripUpdate entity that calls at stops[i] offsets[i] seconds from now.
+func testTripUpdate(routeID, tripID string, stops []string, offsets []int64) *gtfs_realtime.FeedEntity {
+	now := time.Now().Unix()
+	var updates []*gtfs_realtime.TripUpdate_StopTimeUpdate
+	for i, stop := range stops {
+		updates = append(updates, &gtfs_realtime.TripUpdate_StopTimeUpdate{
+			StopId:    proto.String(stop),
+			Departure: &gtfs_realtime.TripUpdate_StopTimeEvent{Time: proto.Int64(now + offsets[i])},
+		})
+	}
+	return &gtfs_realtime.FeedEntity{
+		Id: proto.String(tripID),
+		TripUpdate: &gtfs_realtime.TripUpdate{
+			Trip:           &gtfs_realtime.TripDescriptor{RouteId: proto.String(routeID), TripId: proto.String(tripID)},
+			StopTimeUpdate: updates,
+		},
+	}
+}
+
+// newTestFeed wraps entities in a FULL_DATASET FeedMessage stamped with the current time.
+func newTestFeed(entities ...*gtfs_realtime.FeedEntity) *gtfs_realtime.FeedMessage {
+	incrementality := gtfs_realtime.FeedHeader_FULL_DATASET
+	return &gtfs_realtime.FeedMessage{
+		Header: &gtfs_realtime.FeedHeader{
+			GtfsRealtimeVersion: proto.String("2.0"),
+			Timestamp:           proto.Uint64(uint64(time.Now().Unix())),
+			Incrementality:      &incrementality,
+		},
+		Entity: entities,
+	}
+}
+
+// newTestFeedServer serves the given entities as a GTFS-RT protobuf feed.
+func newTestFeedServer(t *testing.T, entities ...*gtfs_realtime.FeedEntity) *httptest.Server {
+	t.Helper()
+	data, err := proto.Marshal(newTestFeed(entities...))
+	if err != nil {
+		t.Fatalf("marshal feed: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// useTestFeeds points the all-feeds fallback at the given URLs for the rest of the test
+// and disables the service alerts feed (see useTestAlerts).
+func useTestFeeds(t *testing.T, urls ...string) {
+	t.Helper()
+	original, originalAlerts := feedURLs, alertsFeedURL
+	feedURLs = urls
+	alertsFeedURL = ""
+	t.Cleanup(func() { feedURLs, alertsFeedURL = original, originalAlerts })
+}
+
+// useTestAlerts serves the given alert entities as the service alerts feed.
+func useTestAlerts(t *testing.T, entities ...*gtfs_realtime.FeedEntity) {
+	t.Helper()
+	server := newTestFeedServer(t, entities...)
+	original := alertsFeedURL
+	alertsFeedURL = server.URL
+	t.Cleanup(func() { alertsFeedURL = original })
+}
+
+// useTestOSRM serves every OSRM route request with the given duration (seconds) and distance.
+func useTestOSRM(t *testing.T, seconds, meters float64) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"routes": [{"duration": %f, "distance": %f}]}`, seconds, meters)
+	}))
+	t.Cleanup(server.Close)
+	original := osrmBaseURL
+	osrmBaseURL = server.URL
+	t.Cleanup(func() { osrmBaseURL = original })
+}
@@ -0,0 +1,336 @@
+package main
+
+// Upstream TLS trust and the startup connectivity self-test.
+//
+// Corporate TLS-intercepting proxies re-sign upstream certificates with their own CA, so
+// every download fails with "certificate signed by unknown authority". Config:
+//
+//   "tls_ca_bundle": "/etc/ssl/corp-root.pem"
+//   "tls_pins": {"api-endpoint.mta.info": ["sha256/<base64 SPKI hash>"], "data.ny.gov": [...]}
+//
+// The CA bundle (PEM) is trusted in addition to the system roots. A pinned host must also
+// present a chain containing a certificate whose SubjectPublicKeyInfo SHA-256 matches one
+// of its pins (the HPKP format `openssl x509 -pubkey | openssl pkey -pubin -outform der |
+// openssl dgst -sha256 -binary | base64` prints); other hosts get normal verification.
+//
+// At startup every upstream host is dialed once and each failure is logged with the host
+// and the cause (DNS, connection, timeout, untrusted certificate, pin mismatch). The same
+// results appear under "upstreams" in /readyz, and `nyc-subway check-upstreams` runs the
+// test on its own, exiting 1 if any upstream is unreachable.
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Self-test failure causes
+const (
+	causeDNS         = "dns"
+	causeConnect     = "connect"
+	causeTimeout     = "timeout"
+	causeUntrusted   = "untrusted_certificate"
+	causeHostname    = "hostname_mismatch"
+	causeInvalidCert = "invalid_certificate"
+	causePinMismatch = "pin_mismatch"
+)
+
+// upstreamCheckTimeout bounds each host's dial and handshake
+const upstreamCheckTimeout = 5 * time.Second
+
+// errPinMismatch reports a verified chain that matched none of the host's pins
+var errPinMismatch = errors.New("server certificate matches none of the configured tls_pins")
+
+// upstreamTLS is the client TLS config built from tls_ca_bundle and tls_pins (nil = Go defaults)
+var upstreamTLS *tls.Config
+
+// configureUpstreamTLS builds the TLS config and installs it on httpClient
+func configureUpstreamTLS(caBundle string, pins map[string][]string) error {
+	if caBundle == "" && len(pins) == 0 {
+		return nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caBundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(caBundle)
+		if err != nil {
+			return fmt.Errorf("read tls_ca_bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls_ca_bundle %s contains no PEM certificates", caBundle)
+		}
+		cfg.RootCAs = pool
+	}
+	if len(pins) > 0 {
+		byHost := make(map[string][]string, len(pins))
+		for host, list := range pins {
+			for _, p := range list {
+				byHost[strings.ToLower(host)] = append(byHost[strings.ToLower(host)], strings.TrimPrefix(p, "sha256/"))
+			}
+		}
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return checkPins(cs, pinsFor(cs, byHost))
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	upstreamTLS = cfg
+	httpClient = &http.Client{Timeout: httpClient.Timeout, Transport: transport}
+	log.Printf("Upstream TLS: ca_bundle=%q, pinned hosts=%d", caBundle, len(pins))
+	return nil
+}
+
+// pinsFor returns the pins for the connection's host. IP hosts send no SNI, so then the
+// pins of every pinned host the leaf certificate is valid for apply.
+func pinsFor(cs tls.ConnectionState, byHost map[string][]string) []string {
+	if cs.ServerName != "" {
+		return byHost[strings.ToLower(cs.ServerName)]
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return nil
+	}
+	var pins []string
+	for host, list := range byHost {
+		if cs.PeerCertificates[0].VerifyHostname(host) == nil {
+			pins = append(pins, list...)
+		}
+	}
+	return pins
+}
+
+// checkPins accepts the connection when the host is unpinned or any certificate in a
+// verified chain has a pinned public key
+func checkPins(cs tls.ConnectionState, pins []string) error {
+	if len(pins) == 0 {
+		return nil
+	}
+	for _, chain := range cs.VerifiedChains {
+		for _, cert := range chain {
+			if containsString(pins, spkiPin(cert)) {
+				return nil
+			}
+		}
+	}
+	return errPinMismatch
+}
+
+// spkiPin is the base64 SHA-256 of a certificate's SubjectPublicKeyInfo
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// validateTLSPins checks the "tls_pins" config value
+func validateTLSPins(v json.RawMessage) string {
+	var pins map[string][]string
+	if err := json.Unmarshal(v, &pins); err != nil {
+		return `expected an object like {"api-endpoint.mta.info": ["sha256/<base64>"]}`
+	}
+	hosts := make([]string, 0, len(pins))
+	for h := range pins {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	for _, h := range hosts {
+		if len(pins[h]) == 0 {
+			return fmt.Sprintf("%s: expected at least one pin", h)
+		}
+		for _, p := range pins[h] {
+			raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(p, "sha256/"))
+			if err != nil || len(raw) != sha256.Size {
+				return fmt.Sprintf("%s: pin %q is not a base64 SHA-256 hash", h, p)
+			}
+		}
+	}
+	return ""
+}
+
+// validateCABundle checks the "tls_ca_bundle" config value
+func validateCABundle(v json.RawMessage) string {
+	var path string
+	if err := json.Unmarshal(v, &path); err != nil {
+		return fmt.Sprintf("expected a string, got %s", v)
+	}
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Sprintf("referenced file %q does not exist", path)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+		return fmt.Sprintf("%q contains no PEM certificates", path)
+	}
+	return ""
+}
+
+// UpstreamCheck is the self-test result for one upstream host
+type UpstreamCheck struct {
+	Host  string `json:"host"` // host:port
+	OK    bool   `json:"ok"`
+	Cause string `json:"cause,omitempty"` // dns, connect, timeout, untrusted_certificate, ...
+	Error string `json:"error,omitempty"`
+	Hint  string `json:"hint,omitempty"`
+}
+
+var lastUpstreamChecks struct {
+	sync.Mutex
+	results []UpstreamCheck
+}
+
+// upstreamHosts lists the distinct hosts of every configured remote source
+func upstreamHosts() []*url.URL {
+	sources := append([]string{stationsCSV, mtaStationsCSV, gtfsZipURL, supplementedGTFSURL,
+		stationPhotosCSV, placesCSV, alertsFeedURL, osrmBaseURL}, feedURLs...)
+	sources = append(sources, appConfig.StationsSources...)
+	seen := map[string]bool{}
+	var out []*url.URL
+	for _, src := range sources {
+		if !isRemoteSource(src) {
+			continue
+		}
+		u, err := url.Parse(src)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		key := u.Scheme + "://" + u.Host
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, u)
+	}
+	return out
+}
+
+// checkUpstream dials one host (and handshakes for https) with the upstream TLS config
+func checkUpstream(ctx context.Context, u *url.URL) UpstreamCheck {
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	res := UpstreamCheck{Host: net.JoinHostPort(host, port)}
+	ctx, cancel := context.WithTimeout(ctx, upstreamCheckTimeout)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", res.Host)
+	if err == nil && u.Scheme == "https" {
+		cfg := &tls.Config{}
+		if upstreamTLS != nil {
+			cfg = upstreamTLS.Clone()
+		}
+		cfg.ServerName = host
+		tc := tls.Client(conn, cfg)
+		err = tc.HandshakeContext(ctx)
+		conn = tc
+	}
+	if conn != nil {
+		conn.Close()
+	}
+	if err != nil {
+		res.Cause, res.Hint = classifyUpstreamError(err)
+		res.Error = err.Error()
+		return res
+	}
+	res.OK = true
+	return res
+}
+
+// classifyUpstreamError names why a dial or handshake failed, with a fix when there is one
+func classifyUpstreamError(err error) (cause, hint string) {
+	var dnsErr *net.DNSError
+	var unknownCA x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errPinMismatch):
+		return causePinMismatch, "the host presented a different key than tls_pins expects; a TLS-intercepting proxy or a rotated upstream key"
+	case errors.As(err, &unknownCA):
+		return causeUntrusted, "likely a TLS-intercepting proxy; add its root CA to tls_ca_bundle"
+	case errors.As(err, &hostErr):
+		return causeHostname, "the certificate is for a different host; check proxy settings"
+	case errors.As(err, &invalidErr):
+		return causeInvalidCert, "the certificate is expired or not valid yet; check the system clock"
+	case errors.As(err, &dnsErr):
+		return causeDNS, "the host name does not resolve; check DNS or the configured URL"
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return causeTimeout, "no answer; a firewall may be dropping outbound traffic"
+	}
+	return causeConnect, ""
+}
+
+// checkUpstreams runs the self-test against the hosts concurrently
+func checkUpstreams(ctx context.Context, hosts []*url.URL) []UpstreamCheck {
+	results := make([]UpstreamCheck, len(hosts))
+	var wg sync.WaitGroup
+	for i, u := range hosts {
+		wg.Add(1)
+		go func(i int, u *url.URL) {
+			defer wg.Done()
+			results[i] = checkUpstream(ctx, u)
+		}(i, u)
+	}
+	wg.Wait()
+	lastUpstreamChecks.Lock()
+	lastUpstreamChecks.results = results
+	lastUpstreamChecks.Unlock()
+	return results
+}
+
+// logUpstreamChecks runs the self-test and logs each unreachable upstream
+func logUpstreamChecks(ctx context.Context) {
+	results := checkUpstreams(ctx, upstreamHosts())
+	failed := 0
+	for _, c := range results {
+		if c.OK {
+			continue
+		}
+		failed++
+		log.Printf("Warning: upstream %s unreachable (%s): %s. %s", c.Host, c.Cause, c.Error, c.Hint)
+	}
+	log.Printf("Upstream self-test: %d hosts, %d unreachable", len(results), failed)
+}
+
+func upstreamCheckResults() []UpstreamCheck {
+	lastUpstreamChecks.Lock()
+	defer lastUpstreamChecks.Unlock()
+	return lastUpstreamChecks.results
+}
+
+// runCheckUpstreams implements `nyc-subway check-upstreams [config]` and returns the exit code
+func runCheckUpstreams(args []string, out io.Writer) int {
+	if !setupSubcommand(args, out) {
+		return 2
+	}
+	code := 0
+	for _, c := range checkUpstreams(context.Background(), upstreamHosts()) {
+		if c.OK {
+			fmt.Fprintf(out, "%s: OK\n", c.Host)
+			continue
+		}
+		code = 1
+		fmt.Fprintf(out, "%s: %s: %s\n", c.Host, c.Cause, c.Error)
+		if c.Hint != "" {
+			fmt.Fprintf(out, "  hint: %s\n", c.Hint)
+		}
+	}
+	return code
+}
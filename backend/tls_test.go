@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useUpstreamTLS restores the default upstream TLS settings after the test.
+func useUpstreamTLS(t *testing.T) {
+	t.Helper()
+	originalClient, originalTLS := httpClient, upstreamTLS
+	t.Cleanup(func() { httpClient, upstreamTLS = originalClient, originalTLS })
+}
+
+// writeServerCA writes the test server's certificate as a PEM bundle.
+func writeServerCA(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUpstreamCABundle(t *testing.T) {
+	useUpstreamTLS(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	// Without the bundle the self-signed server looks like an intercepting proxy
+	if c := checkUpstream(context.Background(), u); c.OK || c.Cause != causeUntrusted || !strings.Contains(c.Hint, "tls_ca_bundle") {
+		t.Fatalf("expected untrusted certificate, got %+v", c)
+	}
+
+	if err := configureUpstreamTLS(writeServerCA(t, server), nil); err != nil {
+		t.Fatal(err)
+	}
+	if c := checkUpstream(context.Background(), u); !c.OK {
+		t.Fatalf("expected the bundle to be trusted, got %+v", c)
+	}
+	resp, err := httpClient.Get(server.URL)
+	if err != nil {
+		t.Fatalf("httpClient should trust the bundle: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestUpstreamPins(t *testing.T) {
+	useUpstreamTLS(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	bundle := writeServerCA(t, server)
+	good := "sha256/" + spkiPin(server.Certificate())
+	bad := "sha256/" + strings.Repeat("A", 43) + "="
+
+	if err := configureUpstreamTLS(bundle, map[string][]string{u.Hostname(): {bad, good}}); err != nil {
+		t.Fatal(err)
+	}
+	if c := checkUpstream(context.Background(), u); !c.OK {
+		t.Fatalf("expected a matching pin to pass, got %+v", c)
+	}
+
+	if err := configureUpstreamTLS(bundle, map[string][]string{u.Hostname(): {bad}}); err != nil {
+		t.Fatal(err)
+	}
+	if c := checkUpstream(context.Background(), u); c.OK || c.Cause != causePinMismatch {
+		t.Fatalf("expected pin mismatch, got %+v", c)
+	}
+	if _, err := httpClient.Get(server.URL); err == nil {
+		t.Error("httpClient should refuse a host whose pin does not match")
+	}
+
+	// Other hosts are not pinned
+	if err := configureUpstreamTLS(bundle, map[string][]string{"data.ny.gov": {bad}}); err != nil {
+		t.Fatal(err)
+	}
+	if c := checkUpstream(context.Background(), u); !c.OK {
+		t.Fatalf("expected unpinned host to pass, got %+v", c)
+	}
+}
+
+func TestCheckUpstreamsReportsUnreachableHost(t *testing.T) {
+	useUpstreamTLS(t)
+	up := newTestFeedServer(t)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+	var hosts []*url.URL
+	for _, raw := range []string{up.URL, down.URL} {
+		u, _ := url.Parse(raw)
+		hosts = append(hosts, u)
+	}
+
+	results := checkUpstreams(context.Background(), hosts)
+	byHost := map[string]UpstreamCheck{}
+	for _, c := range results {
+		byHost[c.Host] = c
+	}
+	if c := byHost[strings.TrimPrefix(up.URL, "http://")]; !c.OK {
+		t.Errorf("expected reachable feed host, got %+v", c)
+	}
+	if c := byHost[strings.TrimPrefix(down.URL, "http://")]; c.OK || c.Cause != causeConnect || c.Error == "" {
+		t.Errorf("expected connect failure for closed server, got %+v", c)
+	}
+	if len(results) != 2 || len(upstreamCheckResults()) != 2 {
+		t.Error("expected results to be kept for /readyz")
+	}
+}
+
+func TestValidateTLSConfig(t *testing.T) {
+	bundle := filepath.Join(t.TempDir(), "empty.pem")
+	os.WriteFile(bundle, []byte("not a certificate"), 0o644)
+	errs := validateConfig([]byte(`{"tls_ca_bundle": "` + bundle + `", "tls_pins": {"data.ny.gov": ["sha256/short"]}}`))
+	if len(errs) != 2 || !strings.Contains(errs[0], "no PEM certificates") || !strings.Contains(errs[1], "not a base64 SHA-256") {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	if errs := validateConfig([]byte(`{"tls_pins": {"data.ny.gov": ["sha256/` + strings.Repeat("A", 43) + `="]}}`)); len(errs) != 0 {
+		t.Errorf("expected valid pins, got %v", errs)
+	}
+}
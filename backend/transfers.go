@@ -0,0 +1,114 @@
+package main
+
+// In-system transfers between the platforms of a station complex.
+//
+// GTFS transfers.txt links stops that riders can change between without leaving the
+// system, with min_transfer_time covering the walk (e.g. 8 Av to 7 Av at 14 St). Responses
+// list each linked platform with that walk so clients can tell whether a train on the far
+// side of a hub is still catchable.
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+)
+
+// Transfer is an in-system walk from a station to another platform in its complex
+type Transfer struct {
+	StopID  string   `json:"stop_id"`
+	Name    string   `json:"name,omitempty"`
+	Routes  []string `json:"routes,omitempty"`
+	Seconds int      `json:"seconds"` // min_transfer_time from transfers.txt
+}
+
+// complexTransfers maps parent stop ID -> linked parent stop ID -> walk seconds
+var complexTransfers map[string]map[string]int
+
+// parseTransfers reads transfers.txt, keeping links between distinct stations. Links are
+// made symmetric when the feed only lists one direction.
+func parseTransfers(rd io.Reader) (map[string]map[string]int, error) {
+	r := csv.NewReader(rd)
+	r.FieldsPerRecord = -1
+
+	need := []string{"from_stop_id", "to_stop_id", "min_transfer_time"}
+	idx, err := parseCSVHeaders(r, need, "transfers")
+	if err != nil {
+		return nil, err
+	}
+
+	out := map[string]map[string]int{}
+	add := func(from, to string, secs int) {
+		if out[from] == nil {
+			out[from] = map[string]int{}
+		}
+		out[from][to] = secs
+	}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read transfers row: %w", err)
+		}
+		from, to := parentStopID(row[idx["from_stop_id"]]), parentStopID(row[idx["to_stop_id"]])
+		if from == "" || to == "" || from == to {
+			continue // self-transfers only describe platform dwell
+		}
+		secs, err := strconv.Atoi(row[idx["min_transfer_time"]])
+		if err != nil || secs < 0 {
+			continue
+		}
+		add(from, to, secs)
+		if _, ok := out[to][from]; !ok {
+			add(to, from, secs)
+		}
+	}
+	return out, nil
+}
+
+// transfersForStation lists the other platforms of a station's complex with the walk to
+// each, shortest walk first
+func transfersForStation(s Station) []Transfer {
+	links := complexTransfers[parentStopID(s.StopID)]
+	if len(links) == 0 {
+		return nil
+	}
+	out := make([]Transfer, 0, len(links))
+	for id, secs := range links {
+		t := Transfer{StopID: id, Seconds: secs}
+		for _, st := range data().Stations {
+			if parentStopID(st.StopID) == id {
+				t.Name, t.Routes = st.Name, st.Routes
+				break
+			}
+		}
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Seconds != out[j].Seconds {
+			return out[i].Seconds < out[j].Seconds
+		}
+		return out[i].StopID < out[j].StopID
+	})
+	return out
+}
+
+// loadTransfers indexes transfers.txt from an open GTFS zip
+func loadTransfers(zf *gtfsZip) error {
+	rc, err := zf.openMember("transfers.txt")
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	out, err := parseTransfers(rc)
+	if err != nil {
+		return err
+	}
+	complexTransfers = out
+	log.Printf("Loaded in-system transfers for %d stations", len(out))
+	return nil
+}
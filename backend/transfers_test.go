@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTransfers(t *testing.T) {
+	csvData := `from_stop_id,to_stop_id,transfer_type,min_transfer_time
+635,635,2,0
+635,L03,2,180
+L03,635,2,240
+A31,L01,2,300
+`
+	out, err := parseTransfers(strings.NewReader(csvData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := out["635"]["635"]; ok {
+		t.Error("self-transfers should be skipped")
+	}
+	if out["635"]["L03"] != 180 || out["L03"]["635"] != 240 {
+		t.Errorf("explicit directions should keep their own times, got %v", out)
+	}
+	if out["L01"]["A31"] != 300 {
+		t.Errorf("one-way link should be made symmetric, got %v", out["L01"])
+	}
+}
+
+func TestTransfersForStation(t *testing.T) {
+	keepTestData(t)
+	originalTransfers := complexTransfers
+	defer func() { complexTransfers = originalTransfers }()
+	setTestStations([]Station{
+		{StopID: "635", Name: "14 St - Union Sq", Routes: []string{"4", "5", "6"}},
+		{StopID: "L03", Name: "14 St - Union Sq", Routes: []string{"L"}},
+		{StopID: "R20", Name: "14 St - Union Sq", Routes: []string{"N", "Q", "R", "W"}},
+	})
+	complexTransfers = map[string]map[string]int{"635": {"R20": 240, "L03": 180}}
+
+	got := transfersForStation(Station{StopID: "635N"})
+	if len(got) != 2 || got[0].StopID != "L03" || got[0].Seconds != 180 || got[1].StopID != "R20" {
+		t.Fatalf("unexpected transfers %+v", got)
+	}
+	if got[0].Routes[0] != "L" {
+		t.Errorf("expected routes from the linked station, got %+v", got[0])
+	}
+	if transfersForStation(Station{StopID: "A31"}) != nil {
+		t.Error("station without transfers should return nil")
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+// Localized station names for display boards.
+//
+// Names come from translations.txt in the static GTFS zip where the MTA publishes one
+// (table_name "stops", field_name "stop_name"), and from an optional operator CSV set with
+// station_translations_csv (columns: GTFS Stop ID, Language, Name), which wins over the
+// GTFS names. When Accept-Language prefers Spanish, Chinese, Korean or Russian, JSON
+// responses carry the translated stop_name, display_name and station_name wherever one is
+// known; anything untranslated stays English. official_name is always the English GTFS
+// name, and the Content-Language header says which language was used.
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// translatedLanguages are the languages station names can be localized into
+var translatedLanguages = map[string]bool{"es": true, "zh": true, "ko": true, "ru": true}
+
+var (
+	gtfsStationTranslations     map[string]map[string]string // parent stop ID -> language -> name
+	operatorStationTranslations map[string]map[string]string
+)
+
+// languageTag reduces a language tag to its primary subtag: "zh-Hant-TW" -> "zh"
+func languageTag(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	return tag
+}
+
+// addTranslation records one name, ignoring languages that aren't served
+func addTranslation(out map[string]map[string]string, stopID, lang, name string) {
+	lang = languageTag(lang)
+	name = strings.TrimSpace(name)
+	if stopID == "" || name == "" || !translatedLanguages[lang] {
+		return
+	}
+	base := parentStopID(strings.TrimSpace(stopID))
+	if out[base] == nil {
+		out[base] = map[string]string{}
+	}
+	if _, ok := out[base][lang]; !ok {
+		out[base][lang] = name
+	}
+}
+
+// parseGTFSTranslations reads the stop name rows of a GTFS translations.txt
+func parseGTFSTranslations(rd io.Reader) (map[string]map[string]string, error) {
+	r := csv.NewReader(rd)
+	r.FieldsPerRecord = -1
+
+	need := []string{"table_name", "field_name", "language", "translation", "record_id"}
+	idx, err := parseCSVHeaders(r, need, "translations")
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]map[string]string{}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read translations row: %w", err)
+		}
+		if row[idx["table_name"]] != "stops" || row[idx["field_name"]] != "stop_name" {
+			continue
+		}
+		addTranslation(out, row[idx["record_id"]], row[idx["language"]], row[idx["translation"]])
+	}
+	return out, nil
+}
+
+// parseStationTranslations reads an operator translation CSV
+func parseStationTranslations(rd io.Reader) (map[string]map[string]string, error) {
+	r := csv.NewReader(rd)
+	r.FieldsPerRecord = -1
+
+	need := []string{"gtfsstopid", "language", "name"}
+	idx, err := parseCSVHeaders(r, need, "station-translations")
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]map[string]string{}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read station translations row: %w", err)
+		}
+		addTranslation(out, row[idx["gtfsstopid"]], row[idx["language"]], row[idx["name"]])
+	}
+	return out, nil
+}
+
+// loadGTFSTranslations reads translations.txt from an open GTFS zip, if it has one
+func loadGTFSTranslations(zf *gtfsZip) error {
+	for _, f := range zf.File {
+		if f.Name != "translations.txt" {
+			continue
+		}
+		rc, err := zf.openMember(f.Name)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		names, err := parseGTFSTranslations(rc)
+		if err != nil {
+			return err
+		}
+		gtfsStationTranslations = names
+		log.Printf("Loaded GTFS name translations for %d stations", len(names))
+		return nil
+	}
+	return nil // the MTA zip doesn't always include translations
+}
+
+// loadStationTranslations loads the operator translation CSV
+func loadStationTranslations(ctx context.Context, csvURL string) error {
+	body, err := openDataSource(ctx, csvURL)
+	if err != nil {
+		return fmt.Errorf("download station translations: %w", err)
+	}
+	defer body.Close()
+	names, err := parseStationTranslations(body)
+	if err != nil {
+		return err
+	}
+	operatorStationTranslations = names
+	log.Printf("Loaded station name translations for %d stations", len(names))
+	return nil
+}
+
+// translatedName is a station's name in lang, or "" when none is known
+func translatedName(stopID, lang string) string {
+	base := parentStopID(stopID)
+	if name := operatorStationTranslations[base][lang]; name != "" {
+		return name
+	}
+	return gtfsStationTranslations[base][lang]
+}
+
+// preferredLanguage picks the translated language the client ranks highest in
+// Accept-Language, or "" when English (or nothing served) comes first
+func preferredLanguage(r *http.Request) string {
+	type choice struct {
+		lang string
+		q    float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(part, ";")
+		lang := languageTag(fields[0])
+		if lang == "" {
+			continue
+		}
+		q := 1.0
+		for _, p := range fields[1:] {
+			if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+				if f, err := strconv.ParseFloat(strings.TrimPrefix(p, "q="), 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if q > 0 {
+			choices = append(choices, choice{lang, q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	for _, c := range choices {
+		if c.lang == "en" || c.lang == "*" {
+			return ""
+		}
+		if translatedLanguages[c.lang] {
+			return c.lang
+		}
+	}
+	return ""
+}
+
+// localizeNames rewrites station names in a decoded JSON response: stop_name and
+// display_name on station objects (those with gtfs_stop_id) and station_name next to a
+// station ID
+func localizeNames(v any, lang string) {
+	switch t := v.(type) {
+	case map[string]any:
+		if id, ok := t["gtfs_stop_id"].(string); ok {
+			if name := translatedName(id, lang); name != "" {
+				for _, key := range []string{"stop_name", "display_name"} {
+					if _, ok := t[key]; ok {
+						t[key] = name
+					}
+				}
+			}
+		}
+		if id, ok := t["station"].(string); ok {
+			if name := translatedName(id, lang); name != "" {
+				if _, ok := t["station_name"]; ok {
+					t["station_name"] = name
+				}
+			}
+		}
+		for _, child := range t {
+			localizeNames(child, lang)
+		}
+	case []any:
+		for _, child := range t {
+			localizeNames(child, lang)
+		}
+	}
+}
+
+// withStationLanguage localizes station names in JSON responses per Accept-Language
+func withStationLanguage(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		streaming := r.URL.Path == "/api/departures/stream" || r.URL.Path == "/ws"
+		if streaming || len(gtfsStationTranslations)+len(operatorStationTranslations) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Language")
+		lang := preferredLanguage(r)
+		if lang == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		buf := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		h.ServeHTTP(buf, r)
+		body := buf.body.Bytes()
+		if buf.status == http.StatusOK && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			var v any
+			dec := json.NewDecoder(bytes.NewReader(body))
+			dec.UseNumber()
+			if err := dec.Decode(&v); err == nil {
+				localizeNames(v, lang)
+				if out, err := json.MarshalIndent(v, "", "  "); err == nil {
+					body = append(out, '\n')
+					w.Header().Set("Content-Language", lang)
+				}
+			}
+		}
+		w.WriteHeader(buf.status)
+		w.Write(body)
+	})
+}
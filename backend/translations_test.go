@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useTestTranslations installs operator and GTFS translations for one test
+func useTestTranslations(t *testing.T, operator, gtfs map[string]map[string]string) {
+	t.Helper()
+	originalOperator, originalGTFS := operatorStationTranslations, gtfsStationTranslations
+	t.Cleanup(func() { operatorStationTranslations, gtfsStationTranslations = originalOperator, originalGTFS })
+	operatorStationTranslations, gtfsStationTranslations = operator, gtfs
+}
+
+func TestParseTranslations(t *testing.T) {
+	gtfs, err := parseGTFSTranslations(strings.NewReader(`table_name,field_name,language,translation,record_id
+stops,stop_name,es,Calle 14 - Union Sq,635
+stops,stop_name,zh-Hans,联合广场,635N
+stops,stop_name,fr,Place de l'Union,635
+routes,route_long_name,es,Expreso,6
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gtfs) != 1 || gtfs["635"]["es"] != "Calle 14 - Union Sq" || gtfs["635"]["zh"] != "联合广场" || gtfs["635"]["fr"] != "" {
+		t.Errorf("unexpected GTFS translations %v", gtfs)
+	}
+
+	operator, err := parseStationTranslations(strings.NewReader("GTFS Stop ID,Language,Name\nR14,ko,유니언 스퀘어\nR14,ru,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(operator["R14"]) != 1 || operator["R14"]["ko"] != "유니언 스퀘어" {
+		t.Errorf("unexpected operator translations %v", operator)
+	}
+	if _, err := parseStationTranslations(strings.NewReader("stop,name\n")); err == nil {
+		t.Error("expected an error for missing columns")
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := map[string]string{
+		"":                          "",
+		"es":                        "es",
+		"zh-TW,zh;q=0.9":            "zh",
+		"en-US,en;q=0.9,es;q=0.8":   "",
+		"fr,ru;q=0.5":               "ru",
+		"en;q=0.2, ko":              "ko",
+		"es;q=0, *;q=0.1":           "",
+		"de-DE, de;q=0.9, fr;q=0.8": "",
+	}
+	for header, want := range tests {
+		r := httptest.NewRequest("GET", "/api/stops", nil)
+		r.Header.Set("Accept-Language", header)
+		if got := preferredLanguage(r); got != want {
+			t.Errorf("preferredLanguage(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestWithStationLanguage(t *testing.T) {
+	useTestTranslations(t,
+		map[string]map[string]string{"635": {"es": "Calle 14 - Union Sq"}},
+		map[string]map[string]string{"635": {"es": "ignored"}, "631": {"es": "Grand Central - Calle 42"}},
+	)
+	h := withStationLanguage(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"station":    Station{StopID: "635N", Name: "14 St - Union Sq", OfficialName: "14 St-Union Sq", DisplayName: "14 St - Union Sq"},
+			"departures": []AnyDeparture{{Station: "631", StationName: "Grand Central-42 St"}, {Station: "L01", StationName: "8 Av"}},
+		})
+	}))
+
+	r := httptest.NewRequest("GET", "/api/departures/by-id?id=635", nil)
+	r.Header.Set("Accept-Language", "es-US,en;q=0.5")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var got struct {
+		Station    Station        `json:"station"`
+		Departures []AnyDeparture `json:"departures"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Station.Name != "Calle 14 - Union Sq" || got.Station.DisplayName != "Calle 14 - Union Sq" || got.Station.OfficialName != "14 St-Union Sq" {
+		t.Errorf("unexpected station names %+v", got.Station)
+	}
+	if got.Departures[0].StationName != "Grand Central - Calle 42" || got.Departures[1].StationName != "8 Av" {
+		t.Errorf("unexpected departure station names %+v", got.Departures)
+	}
+	if w.Header().Get("Content-Language") != "es" || w.Header().Get("Vary") != "Accept-Language" {
+		t.Errorf("unexpected headers %v", w.Header())
+	}
+
+	// English clients get the response untouched
+	r = httptest.NewRequest("GET", "/api/departures/by-id?id=635", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), `"stop_name": "14 St - Union Sq"`) || w.Header().Get("Content-Language") != "" {
+		t.Errorf("expected English names, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNearestTravelMode(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "635", Name: "14 St-Union Sq", Lat: 40.734673, Lon: -73.989951}})
+	useTestFeeds(t, newTestFeedServer(t, testTripUpdate("6", "trip6", []string{"635S"}, []int64{300})).URL)
+
+	var mu sync.Mutex
+	var paths []string
+	osrm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		if strings.HasPrefix(r.URL.Path, "/route/v1/car/") {
+			w.WriteHeader(http.StatusBadRequest) // no car profile on this server
+			return
+		}
+		w.Write([]byte(`{"routes": [{"duration": 90, "distance": 400}]}`))
+	}))
+	defer osrm.Close()
+	original := osrmBaseURL
+	osrmBaseURL = osrm.URL
+	defer func() { osrmBaseURL = original }()
+
+	get := func(query string) NearestResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleNearest(w, httptest.NewRequest("GET", "/api/departures/nearest?lat=40.7375&lon=-73.9880"+query, nil))
+		var resp NearestResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return resp
+	}
+
+	if walk := get("&mode=bike").Walking; walk == nil || walk.Mode != "bike" || walk.Seconds != 90 || walk.Estimated {
+		t.Errorf("expected an OSRM bike time, got %+v", walk)
+	}
+	if walk := get("&mode=drive").Walking; walk == nil || walk.Mode != "drive" || !walk.Estimated {
+		t.Errorf("expected an estimated drive time, got %+v", walk)
+	}
+	if walk := get("").Walking; walk == nil || walk.Mode != "" {
+		t.Errorf("expected a walk by default, got %+v", walk)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 3 || !strings.HasPrefix(paths[0], "/route/v1/bike/") || !strings.HasPrefix(paths[2], "/route/v1/foot/") {
+		t.Errorf("expected bike, car and foot profiles, got %v", paths)
+	}
+
+	w := httptest.NewRecorder()
+	handleNearest(w, httptest.NewRequest("GET", "/api/departures/nearest?lat=40.7375&lon=-73.9880&mode=skate", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown mode, got %d", w.Code)
+	}
+}
+
+func TestEstimatedTravelModeSpeeds(t *testing.T) {
+	walk := estimatedWalk(modeWalk, 40.7300, -73.9900, 40.7390, -73.9900)
+	bike := estimatedWalk(modeBike, 40.7300, -73.9900, 40.7390, -73.9900)
+	drive := estimatedWalk(modeDrive, 40.7300, -73.9900, 40.7390, -73.9900)
+	if !(drive.Seconds < bike.Seconds && bike.Seconds < walk.Seconds) || bike.Distance != walk.Distance {
+		t.Errorf("expected drive < bike < walk over the same distance, got %+v %+v %+v", drive, bike, walk)
+	}
+}
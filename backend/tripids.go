@@ -0,0 +1,94 @@
+package main
+
+// NYCT trip IDs.
+//
+// Realtime trip IDs follow the NYCT convention
+//
+//   <origin time>_<route>.<dots><direction><path>      e.g. 048450_1..N03R, 130600_GS.N01R
+//
+// where the origin time is in hundredths of a minute after midnight of the service day,
+// the number of dots varies and the path identifier may be missing. trips.txt IDs end
+// in the same form after a schedule prefix (AFA23GEN-1038-Weekday-00_048450_1..N03R).
+// Realtime trips are matched to static trips on origin time, route and direction; the
+// path only breaks ties, since trips reassigned on the day keep their origin but can
+// change path. IDs outside the convention only match the identical ID.
+
+import (
+	"strconv"
+	"strings"
+)
+
+// nyctTripID is a trip ID split into its NYCT parts
+type nyctTripID struct {
+	Origin    int    // hundredths of a minute after midnight
+	Route     string // e.g. 1, GS
+	Direction string // N or S
+	Path      string // e.g. 03R; may be empty
+}
+
+// parseNYCTTripID splits the NYCT part at the end of a realtime or static trip ID
+func parseNYCTTripID(id string) (nyctTripID, bool) {
+	sep := strings.LastIndexByte(id, '_')
+	if sep < 0 {
+		return nyctTripID{}, false
+	}
+	start := sep
+	for start > 0 && id[start-1] >= '0' && id[start-1] <= '9' {
+		start--
+	}
+	origin, err := strconv.Atoi(id[start:sep])
+	if err != nil {
+		return nyctTripID{}, false
+	}
+	rest := id[sep+1:]
+	dot := strings.IndexByte(rest, '.')
+	if dot <= 0 {
+		return nyctTripID{}, false
+	}
+	route := rest[:dot]
+	rest = strings.TrimLeft(rest[dot:], ".")
+	if rest == "" || (rest[0] != 'N' && rest[0] != 'S') {
+		return nyctTripID{}, false
+	}
+	return nyctTripID{Origin: origin, Route: route, Direction: rest[:1], Path: rest[1:]}, true
+}
+
+// sameTrip reports whether two parsed IDs name the same scheduled trip
+func (a nyctTripID) sameTrip(b nyctTripID) bool {
+	return a.Origin == b.Origin && a.Route == b.Route && a.Direction == b.Direction
+}
+
+// tripIDsMatch reports whether a realtime trip ID names the static trip staticID
+func tripIDsMatch(staticID, rtID string) bool {
+	rt, ok := parseNYCTTripID(rtID)
+	if !ok {
+		return staticID == rtID
+	}
+	st, ok := parseNYCTTripID(staticID)
+	return ok && st.sameTrip(rt)
+}
+
+// matchingTrips lists the static trips a realtime trip ID can be, those on the same path
+// first
+func matchingTrips(list []Trip, rtID string) []Trip {
+	rt, ok := parseNYCTTripID(rtID)
+	var samePath, otherPath []Trip
+	for _, trip := range list {
+		if !ok {
+			if trip.TripID == rtID {
+				samePath = append(samePath, trip)
+			}
+			continue
+		}
+		st, parsed := parseNYCTTripID(trip.TripID)
+		if !parsed || !st.sameTrip(rt) {
+			continue
+		}
+		if st.Path == rt.Path {
+			samePath = append(samePath, trip)
+		} else {
+			otherPath = append(otherPath, trip)
+		}
+	}
+	return append(samePath, otherPath...)
+}
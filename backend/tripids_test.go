@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestParseNYCTTripID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want nyctTripID
+		ok   bool
+	}{
+		{"048450_1..N03R", nyctTripID{48450, "1", "N", "03R"}, true},
+		{"130600_GS.N01R", nyctTripID{130600, "GS", "N", "01R"}, true},
+		{"049550_L..S", nyctTripID{49550, "L", "S", ""}, true},
+		{"AFA23GEN-1038-Weekday-00_048450_1..N03R", nyctTripID{48450, "1", "N", "03R"}, true},
+		{"Weekday-063000_6..S", nyctTripID{63000, "6", "S", ""}, true},
+		{"048450_1..X03R", nyctTripID{}, false},
+		{"048450_1N03R", nyctTripID{}, false},
+		{"trip6", nyctTripID{}, false},
+		{"", nyctTripID{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseNYCTTripID(tt.id)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseNYCTTripID(%q) = %+v, %v; want %+v, %v", tt.id, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMatchingTrips(t *testing.T) {
+	list := []Trip{
+		{TripID: "AFA-048450_1..N03R", TripHeadsign: "Van Cortlandt Park-242 St"},
+		{TripID: "AFA-148450_1..N03R", TripHeadsign: "later origin"}, // contains the realtime ID
+		{TripID: "AFA-048450_1..S03R", TripHeadsign: "South Ferry"},
+		{TripID: "AFA-048450_1..N01R", TripHeadsign: "other path"},
+		{TripID: "SIR-trip"},
+	}
+	got := matchingTrips(list, "048450_1..N01R")
+	if len(got) != 2 || got[0].TripHeadsign != "other path" || got[1].TripHeadsign != "Van Cortlandt Park-242 St" {
+		t.Errorf("expected the same-path trip first then the reassigned one, got %+v", got)
+	}
+	if got := matchingTrips(list, "48450_1..N03R"); len(got) != 2 || got[0].TripHeadsign != "Van Cortlandt Park-242 St" {
+		t.Errorf("expected origin times compared as numbers, got %+v", got)
+	}
+	if got := matchingTrips(list, "SIR-trip"); len(got) != 1 {
+		t.Errorf("expected IDs outside the convention to match exactly, got %+v", got)
+	}
+	if got := matchingTrips(list, "trip"); len(got) != 0 {
+		t.Errorf("expected no substring matches, got %+v", got)
+	}
+
+	if !tripIDsMatch("AFA-048450_1..N03R", "048450_1.N") || tripIDsMatch("AFA-148450_1..N03R", "48450_1..N03R") {
+		t.Error("unexpected tripIDsMatch result")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+// Straight-line walking estimates.
+//
+// When the routing backend fails (OSRM down, slow, or with no route), walking times are
+// estimated instead of dropped: the straight-line distance, stretched by walk_grid_factor
+// because the street grid rarely runs straight at the station, at walking_speed meters per
+// second. Estimates are flagged "estimated": true and aren't cached, so the next request
+// asks OSRM again.
+//
+//   "walking_speed": 1.3,
+//   "walk_grid_factor": 1.3
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+const (
+	defaultWalkingSpeed   = 1.3 // m/s
+	defaultWalkGridFactor = 1.3 // a grid walk at 45 degrees to the streets is about 1.4x
+)
+
+// walkingSpeed is the walking speed estimates use, in m/s
+func walkingSpeed() float64 {
+	if appConfig.WalkingSpeed > 0 {
+		return appConfig.WalkingSpeed
+	}
+	return defaultWalkingSpeed
+}
+
+// walkGridFactor is the ratio of walked to straight-line distance estimates assume
+func walkGridFactor() float64 {
+	if appConfig.WalkGridFactor > 0 {
+		return appConfig.WalkGridFactor
+	}
+	return defaultWalkGridFactor
+}
+
+// estimatedWalk estimates a trip in mode from the straight-line distance between two points
+func estimatedWalk(mode travelMode, fromLat, fromLon, toLat, toLon float64) *WalkResult {
+	meters := haversine(fromLat, fromLon, toLat, toLon) * walkGridFactor()
+	return &WalkResult{Seconds: meters / mode.speed(), Distance: meters, Mode: mode.label(), Estimated: true}
+}
+
+// walkOrEstimate is travelRoute, estimating the trip when the routing backend fails
+func walkOrEstimate(mode travelMode, fromLat, fromLon, toLat, toLon float64, directions bool) *WalkResult {
+	walk, err := travelRoute(mode, fromLat, fromLon, toLat, toLon, directions)
+	if err != nil {
+		log.Printf("walkingTime error: %v (estimating from straight-line distance)", err)
+		return estimatedWalk(mode, fromLat, fromLon, toLat, toLon)
+	}
+	return walk
+}
+
+// validatePositiveNumber checks a config value is a number above zero
+func validatePositiveNumber(v json.RawMessage) string {
+	var f float64
+	if err := json.Unmarshal(v, &f); err != nil {
+		return fmt.Sprintf("expected a number, got %s", v)
+	}
+	if f <= 0 {
+		return fmt.Sprintf("must be positive, got %s", v)
+	}
+	return ""
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEstimatedWalk(t *testing.T) {
+	original := appConfig
+	t.Cleanup(func() { appConfig = original })
+	appConfig.WalkingSpeed, appConfig.WalkGridFactor = 1.5, 1.2
+
+	// About 1 km due north
+	walk := estimatedWalk(modeWalk, 40.7300, -73.9900, 40.7390, -73.9900)
+	straight := haversine(40.7300, -73.9900, 40.7390, -73.9900)
+	if !walk.Estimated || math.Abs(walk.Distance-straight*1.2) > 0.01 || math.Abs(walk.Seconds-straight*1.2/1.5) > 0.01 {
+		t.Errorf("expected %.0fm at 1.5 m/s, got %+v", straight*1.2, walk)
+	}
+}
+
+func TestNearestEstimatesWalkWithoutOSRM(t *testing.T) {
+	initTestCaches()
+	keepTestData(t)
+	setTestStations([]Station{{StopID: "635", Name: "14 St-Union Sq", Lat: 40.734673, Lon: -73.989951}})
+	useTestFeeds(t, newTestFeedServer(t, testTripUpdate("6", "trip6", []string{"635S"}, []int64{300})).URL)
+
+	osrm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer osrm.Close()
+	original := osrmBaseURL
+	osrmBaseURL = osrm.URL
+	defer func() { osrmBaseURL = original }()
+
+	w := httptest.NewRecorder()
+	handleNearest(w, httptest.NewRequest("GET", "/api/departures/nearest?lat=40.7375&lon=-73.9880", nil))
+	body := w.Body.String()
+	var resp NearestResponse
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Walking == nil || !resp.Walking.Estimated || resp.Walking.Seconds <= 0 {
+		t.Fatalf("expected an estimated walk, got %+v", resp.Walking)
+	}
+	if !strings.Contains(body, `"estimated": true`) {
+		t.Errorf("expected the walk flagged estimated, got %s", body)
+	}
+	if _, err := walkCache.Get(makeCacheKey(40.7375, -73.9880, 40.734673, -73.989951)); err == nil {
+		t.Error("expected the estimate not to be cached")
+	}
+}
+
+func TestWalkEstimateConfig(t *testing.T) {
+	if errs := validateConfig([]byte(`{"walking_speed": 1.4, "walk_grid_factor": 1.25}`)); len(errs) != 0 {
+		t.Errorf("expected a valid config, got %v", errs)
+	}
+	for _, cfg := range []string{`{"walking_speed": 0}`, `{"walk_grid_factor": "1.3"}`} {
+		if errs := validateConfig([]byte(cfg)); len(errs) != 1 {
+			t.Errorf("%s: expected one error, got %v", cfg, errs)
+		}
+	}
+}
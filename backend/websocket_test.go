@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestWSAccept(t *testing.T) {
+	// Example from RFC 6455 section 1.3
+	if got := wsAccept("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("wsAccept = %q", got)
+	}
+}
+
+// writeClientFrame writes a masked frame as a browser would
+func writeClientFrame(w io.Writer, fin bool, opcode byte, payload []byte) error {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+// readServerFrame reads one unmasked server frame
+func readServerFrame(r *bufio.Reader) (byte, []byte, error) {
+	var h [2]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(r, ext[:])
+		n = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload := make([]byte, n)
+	_, err := io.ReadFull(r, payload)
+	return h[0] & 0x0f, payload, err
+}
+
+func TestWSConnMessages(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := &wsConn{conn: server, br: bufio.NewReader(server)}
+	clientReader := bufio.NewReader(client)
+
+	go func() {
+		// A ping between the fragments of a message is answered in place
+		writeClientFrame(client, false, wsText, []byte(`{"action":`))
+		writeClientFrame(client, true, wsPing, []byte("hi"))
+		writeClientFrame(client, true, wsContinuation, []byte(`"subscribe"}`))
+		writeClientFrame(client, true, wsClose, []byte{0x03, 0xe8})
+	}()
+	type result struct {
+		msg []byte
+		err error
+	}
+	results := make(chan result, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			msg, err := c.readMessage()
+			results <- result{msg, err}
+		}
+	}()
+
+	if op, payload, err := readServerFrame(clientReader); err != nil || op != wsPong || string(payload) != "hi" {
+		t.Fatalf("expected pong hi, got %d %q %v", op, payload, err)
+	}
+	if r := <-results; r.err != nil || string(r.msg) != `{"action":"subscribe"}` {
+		t.Fatalf("expected the reassembled message, got %q %v", r.msg, r.err)
+	}
+	if op, payload, err := readServerFrame(clientReader); err != nil || op != wsClose || binary.BigEndian.Uint16(payload) != 1000 {
+		t.Fatalf("expected the close echoed, got %d %v %v", op, payload, err)
+	}
+	if r := <-results; r.err != errWSClosed {
+		t.Fatalf("expected errWSClosed, got %v", r.err)
+	}
+
+	// Large server messages use the extended length
+	go c.writeFrame(wsText, make([]byte, 70000))
+	if op, payload, err := readServerFrame(clientReader); err != nil || op != wsText || len(payload) != 70000 {
+		t.Fatalf("expected a 70000 byte message, got %d %d %v", op, len(payload), err)
+	}
+}
+
+func TestWSConnRejectsUnmaskedFrames(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := &wsConn{conn: server, br: bufio.NewReader(server)}
+	go client.Write([]byte{0x81, 0x02, 'h', 'i'})
+	if _, err := c.readMessage(); err == nil {
+		t.Error("expected an error for an unmasked client frame")
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+// WebSocket subscription API.
+//
+//   GET /ws   (WebSocket upgrade)
+//
+// Clients send JSON requests:
+//
+//   {"action": "subscribe", "ids": ["635", "R14"]}
+//   {"action": "unsubscribe", "ids": ["R14"]}
+//
+// and receive JSON messages:
+//
+//   {"type": "subscribed", "ids": [...], "not_found": [...]}
+//   {"type": "departures", "station": "635", "data": <by-id response>}
+//   {"type": "alerts", "station": "635", "alerts": [...]}   when a station's alerts change
+//   {"type": "approaching", "station": "635", "departure": {...}}   once per trip, see approaching.go
+//   {"type": "error", "error": "..."}
+//
+// A single hub recomputes every subscribed station once per feed refresh (sharing feed
+// fetches across stations, as bulk does) and fans the result out to each subscriber.
+// Departures use the default filters. A client that can't keep up loses its oldest
+// queued messages rather than holding up the others (see fanout.go).
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocket limits
+const (
+	maxWSSubscriptions = 20 // stations per connection
+	wsSendBuffer       = 32 // queued messages per client; the oldest is dropped when full
+	wsPingInterval     = 30 * time.Second
+)
+
+// wsRequest is a client message
+type wsRequest struct {
+	Action string   `json:"action"`
+	IDs    []string `json:"ids"`
+}
+
+// wsMessage is a server message
+type wsMessage struct {
+	Type      string           `json:"type"`
+	Station   string           `json:"station,omitempty"`
+	IDs       []string         `json:"ids,omitempty"`
+	NotFound  []string         `json:"not_found,omitempty"`
+	Data      *NearestResponse `json:"data,omitempty"`
+	Alerts    []ServiceAlert   `json:"alerts,omitempty"`
+	Departure *Departure       `json:"departure,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// wsClient is one connection's subscription state
+type wsClient struct {
+	conn     *wsConn
+	send     chan []byte
+	done     chan struct{}
+	once     sync.Once
+	mu       sync.Mutex
+	alerts   map[string]string // station -> alert IDs last sent
+	approach *approachTracker
+}
+
+func (c *wsClient) close() {
+	c.once.Do(func() { close(c.done) })
+}
+
+// enqueue queues a message, dropping the oldest queued one if the buffer is full
+func (c *wsClient) enqueue(m wsMessage) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		log.Printf("ws: marshal %s message: %v", m.Type, err)
+		return
+	}
+	for {
+		select {
+		case c.send <- b:
+			return
+		case <-c.done:
+			return
+		default:
+		}
+		select {
+		case <-c.send:
+			streamStats.drop(transportWS)
+		default: // the writer just made room
+		}
+	}
+}
+
+// sendUpdate sends a station's departures, plus its alerts when they changed
+func (c *wsClient) sendUpdate(stationID string, resp NearestResponse) {
+	c.enqueue(wsMessage{Type: "departures", Station: stationID, Data: &resp})
+	ids := make([]string, len(resp.Alerts))
+	for i, a := range resp.Alerts {
+		ids[i] = a.ID
+	}
+	key := strings.Join(ids, ",")
+	c.mu.Lock()
+	last, seen := c.alerts[stationID]
+	c.alerts[stationID] = key
+	approaching := c.approach.check(stationID, resp.Departures, !seen, time.Now())
+	c.mu.Unlock()
+	if !seen || last != key {
+		c.enqueue(wsMessage{Type: "alerts", Station: stationID, Alerts: append([]ServiceAlert{}, resp.Alerts...)})
+	}
+	for i := range approaching {
+		c.enqueue(wsMessage{Type: "approaching", Station: stationID, Departure: &approaching[i]})
+	}
+}
+
+// wsHub is the subscription registry: station ID -> subscribed clients
+type wsHub struct {
+	mu    sync.Mutex
+	subs  map[string]map[*wsClient]bool
+	start sync.Once
+}
+
+var hub = &wsHub{subs: map[string]map[*wsClient]bool{}}
+
+func (h *wsHub) subscribe(c *wsClient, stationID string) (added bool, full bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[stationID][c] {
+		return false, false
+	}
+	if h.countLocked(c) >= maxWSSubscriptions {
+		return false, true
+	}
+	if h.subs[stationID] == nil {
+		h.subs[stationID] = map[*wsClient]bool{}
+	}
+	h.subs[stationID][c] = true
+	return true, false
+}
+
+func (h *wsHub) unsubscribe(c *wsClient, stationID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs[stationID], c)
+	if len(h.subs[stationID]) == 0 {
+		delete(h.subs, stationID)
+	}
+	c.mu.Lock()
+	delete(c.alerts, stationID)
+	c.mu.Unlock()
+}
+
+// remove drops every subscription of a departing client
+func (h *wsHub) remove(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, clients := range h.subs {
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(h.subs, id)
+		}
+	}
+}
+
+func (h *wsHub) countLocked(c *wsClient) int {
+	n := 0
+	for _, clients := range h.subs {
+		if clients[c] {
+			n++
+		}
+	}
+	return n
+}
+
+// snapshot copies the registry so updates are computed without holding the lock
+func (h *wsHub) snapshot() map[string][]*wsClient {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make(map[string][]*wsClient, len(h.subs))
+	for id, clients := range h.subs {
+		for c := range clients {
+			out[id] = append(out[id], c)
+		}
+	}
+	return out
+}
+
+// publish recomputes every subscribed station once and fans the results out
+func (h *wsHub) publish() {
+	subs := h.snapshot()
+	if len(subs) == 0 {
+		return
+	}
+	memo := newFeedMemo(fetchGTFS)
+	var wg sync.WaitGroup
+	for id, clients := range subs {
+		s, ok := stationByID(id)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(id string, s Station, clients []*wsClient) {
+			defer wg.Done()
+			resp, err := wsStationUpdate(s, memo)
+			for _, c := range clients {
+				if err != nil {
+					c.enqueue(wsMessage{Type: "error", Station: id, Error: err.Error()})
+				} else {
+					c.sendUpdate(id, resp)
+				}
+			}
+		}(id, s, clients)
+	}
+	wg.Wait()
+}
+
+// run publishes after every feed refresh, or every streamRefreshInterval without one
+func (h *wsHub) run() {
+	ticker := time.NewTicker(streamRefreshInterval)
+	defer ticker.Stop()
+	for {
+		h.publish()
+		refreshed := feedRefresh.wait()
+		select {
+		case <-refreshed:
+		case <-ticker.C:
+		}
+	}
+}
+
+// wsStationUpdate builds a station's by-id response with feeds fetched through memo
+func wsStationUpdate(s Station, memo *feedMemo) (NearestResponse, error) {
+	stationUsage.mark(s.StopID)
+	deps, err := departuresFromSource(s, departureFilter{}, memo.get)
+	warnings, err := splitPartial(err)
+	if err != nil {
+		return NearestResponse{}, err
+	}
+	resp := NearestResponse{Station: s, Photos: photosForStation(s), Transfers: transfersForStation(s), Alerts: alertsForStation(s), Cancellations: cancellationsForStation(s, departureFilter{}, memo.get), Partial: len(warnings) > 0, Warnings: warnings, Departures: deps, Meta: departureMeta(departureFilter{}, s)}
+	if cl, closed := closures.active(s.StopID, time.Now()); closed {
+		resp.Closure = &cl
+	}
+	return resp, nil
+}
+
+func handleWS(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: %s %s", r.Method, r.URL.String())
+	if !acquireStream(w, r, transportWS) {
+		return
+	}
+	defer streamStats.release(transportWS, streamAddr(r))
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		log.Printf("ws: upgrade failed: %v", err)
+		return
+	}
+	hub.start.Do(func() { go hub.run() })
+	c := &wsClient{conn: conn, send: make(chan []byte, wsSendBuffer), done: make(chan struct{}), alerts: map[string]string{}, approach: newApproachTracker()}
+	defer hub.remove(c)
+
+	// Writer: queued messages and keep-alive pings
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case b := <-c.send:
+				if err := conn.writeFrame(wsText, b); err != nil {
+					c.close()
+				}
+			case <-ticker.C:
+				if err := conn.writeFrame(wsPing, nil); err != nil {
+					c.close()
+				}
+			case <-quitCh:
+				conn.closeWith(1001, "server shutting down")
+				c.close()
+				return
+			case <-c.done:
+				conn.closeWith(1000, "")
+				return
+			}
+		}
+	}()
+
+	for {
+		msg, err := conn.readMessage()
+		if err != nil {
+			if err != errWSClosed {
+				log.Printf("ws: read: %v", err)
+			}
+			c.close()
+			return
+		}
+		var req wsRequest
+		if err := json.Unmarshal(msg, &req); err != nil {
+			c.enqueue(wsMessage{Type: "error", Error: "invalid JSON request"})
+			continue
+		}
+		switch req.Action {
+		case "subscribe":
+			c.subscribe(req.IDs)
+		case "unsubscribe":
+			for _, id := range req.IDs {
+				if s, ok := stationByID(id); ok {
+					hub.unsubscribe(c, parentStopID(s.StopID))
+				}
+			}
+		default:
+			c.enqueue(wsMessage{Type: "error", Error: `action must be "subscribe" or "unsubscribe"`})
+		}
+	}
+}
+
+// subscribe registers the stations and sends their current departures right away
+func (c *wsClient) subscribe(ids []string) {
+	ack := wsMessage{Type: "subscribed", IDs: []string{}}
+	var added []Station
+	for _, id := range ids {
+		s, ok := stationByID(strings.TrimSpace(id))
+		if !ok {
+			ack.NotFound = append(ack.NotFound, id)
+			continue
+		}
+		key := parentStopID(s.StopID)
+		isNew, full := hub.subscribe(c, key)
+		if full {
+			c.enqueue(wsMessage{Type: "error", Error: fmt.Sprintf("too many subscriptions (max %d)", maxWSSubscriptions)})
+			break
+		}
+		ack.IDs = append(ack.IDs, key)
+		if isNew {
+			added = append(added, s)
+		}
+	}
+	sort.Strings(ack.IDs)
+	c.enqueue(ack)
+	memo := newFeedMemo(fetchGTFS)
+	for _, s := range added {
+		resp, err := wsStationUpdate(s, memo)
+		if err != nil {
+			c.enqueue(wsMessage{Type: "error", Station: parentStopID(s.StopID), Error: err.Error()})
+			continue
+		}
+		c.sendUpdate(parentStopID(s.StopID), resp)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	gtfs_realtime "nyc-subway/gtfs_realtime"
+)
+
+// dialWS opens a WebSocket to the test server's /ws
+func dialWS(t *testing.T, server *httptest.Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake failed: %d %v", resp.StatusCode, resp.Header)
+	}
+	return conn, r
+}
+
+// readWS reads the next server message, answering nothing and failing after a timeout
+func readWS(t *testing.T, conn net.Conn, r *bufio.Reader) wsMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		op, payload, err := readServerFrame(r)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if op != wsText {
+			continue // pings
+		}
+		var m wsMessage
+		if err := json.Unmarshal(payload, &m); err != nil {
+			t.Fatalf("bad message %s: %v", payload, err)
+		}
+		return m
+	}
+}
+
+func TestWebSocketSubscriptions(t *testing.T) {
+	initTestCaches()
+	useTestFeeds(t)
+	keepTestData(t)
+	originalRouteToFeed := routeToFeed
+	t.Cleanup(func() { routeToFeed = originalRouteToFeed })
+	setTestStations([]Station{{StopID: "635", Name: "14 St - Union Sq", Routes: []string{"6"}}})
+	useTestAlerts(t, testAlert("delay", "6 trains delayed", gtfs_realtime.Alert_SIGNIFICANT_DELAYS, nil, [2]string{"6", ""}))
+
+	var version int32
+	var feeds [][]byte
+	for _, trip := range []string{"first", "second"} {
+		b, _ := proto.Marshal(newTestFeed(testTripUpdate("6", trip, []string{"635N"}, []int64{120})))
+		feeds = append(feeds, b)
+	}
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(feeds[atomic.LoadInt32(&version)])
+	}))
+	defer feed.Close()
+	routeToFeed = map[string]string{"6": feed.URL}
+
+	server := httptest.NewServer(newMux())
+	defer server.Close()
+	conn, r := dialWS(t, server)
+
+	writeClientFrame(conn, true, wsText, []byte(`{"action": "subscribe", "ids": ["635N", "nope"]}`))
+	ack := readWS(t, conn, r)
+	if ack.Type != "subscribed" || len(ack.IDs) != 1 || ack.IDs[0] != "635" || len(ack.NotFound) != 1 {
+		t.Fatalf("unexpected ack %+v", ack)
+	}
+	first := readWS(t, conn, r)
+	if first.Type != "departures" || first.Station != "635" || first.Data.Departures[0].TripID != "first" {
+		t.Fatalf("expected initial departures, got %+v", first)
+	}
+	alerts := readWS(t, conn, r)
+	if alerts.Type != "alerts" || len(alerts.Alerts) != 1 || alerts.Alerts[0].ID != "delay" {
+		t.Fatalf("expected the station's alerts, got %+v", alerts)
+	}
+
+	// A feed refresh is pushed; unchanged alerts are not resent
+	atomic.StoreInt32(&version, 1)
+	for {
+		// A publish already in flight may recache the old feed, so refresh until it shows
+		transitFeedCache.Purge()
+		feedRefresh.broadcast()
+		m := readWS(t, conn, r)
+		if m.Type == "alerts" {
+			t.Fatalf("alerts resent without a change: %+v", m)
+		}
+		if m.Type == "departures" && m.Data.Departures[0].TripID == "second" {
+			break
+		}
+	}
+
+	writeClientFrame(conn, true, wsText, []byte(`{"action": "watch"}`))
+	for {
+		m := readWS(t, conn, r)
+		if m.Type == "error" {
+			if !strings.Contains(m.Error, "action must be") {
+				t.Errorf("unexpected error %q", m.Error)
+			}
+			break
+		}
+	}
+
+	// Closing removes the client from the registry
+	writeClientFrame(conn, true, wsClose, []byte{0x03, 0xe8})
+	deadline := time.Now().Add(5 * time.Second)
+	for len(hub.snapshot()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(hub.snapshot()); n != 0 {
+		t.Errorf("expected no subscriptions after close, got %d", n)
+	}
+}
+
+func TestWebSocketRejectsPlainRequests(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleWS(w, httptest.NewRequest("GET", "/ws", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without an upgrade, got %d", w.Code)
+	}
+}